@@ -0,0 +1,144 @@
+// Copyright 2020 Guoyao Wu. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package negotiator
+
+import "strings"
+
+// RegionAffinity maps a language tag to the tags conventionally used as its
+// fallback, in order of preference, e.g. en-CA -> en-US, en-GB.
+type RegionAffinity map[string][]string
+
+// DefaultRegionAffinity is a small table of widely used regional fallbacks.
+var DefaultRegionAffinity = RegionAffinity{
+	"en-CA": {"en-US", "en-GB"},
+	"en-AU": {"en-GB", "en-NZ"},
+	"en-NZ": {"en-AU", "en-GB"},
+	"en-IE": {"en-GB"},
+	"en-IN": {"en-GB"},
+	"en-ZA": {"en-GB"},
+	"en-PH": {"en-US"},
+	"es-AR": {"es-419", "es-MX"},
+	"es-CO": {"es-419", "es-MX"},
+	"es-MX": {"es-419", "es-US"},
+	"es-US": {"es-419", "es-MX"},
+	"pt-AO": {"pt-PT"},
+	"pt-MZ": {"pt-PT"},
+	"fr-BE": {"fr-FR"},
+	"fr-CH": {"fr-FR"},
+	"fr-LU": {"fr-FR"},
+	"de-AT": {"de-DE"},
+	"de-CH": {"de-DE"},
+	"de-LU": {"de-DE"},
+	"zh-HK": {"zh-TW"},
+	"zh-MO": {"zh-HK", "zh-TW"},
+	"zh-SG": {"zh-CN"},
+}
+
+// AffinityRule describes an affinity rule which broke a tie among offers that
+// matched with equal quality and specificity.
+type AffinityRule struct {
+	// Range is the language range of the client which owns the rule.
+	Range string
+	// Offer is the provided language promoted by the rule.
+	Offer string
+}
+
+// get the fallbacks of a tag, the keys are compared case-insensitively.
+func (ra RegionAffinity) get(tag string) []string {
+	if v, ok := ra[tag]; ok {
+		return v
+	}
+	for k, v := range ra {
+		if strings.EqualFold(k, tag) {
+			return v
+		}
+	}
+	return nil
+}
+
+// PreferredLanguagesWithAffinity gets the preferred languages from an
+// Accept-Language header like PreferredLanguages, then breaks the ties among
+// offers matched by the same range with equal quality and specificity with the
+// region affinity table.
+// It returns the affinity rules which fired, so the choice is explainable.
+func PreferredLanguagesWithAffinity(accept string, affinity RegionAffinity, provided ...string) ([]string, []AffinityRule) {
+	if len(provided) == 0 || len(affinity) == 0 {
+		return PreferredLanguages(accept, provided...), nil
+	}
+
+	provided = normalizeOffers(provided)
+
+	acs := parseAcceptLanguage(accept).filter(isAcceptLanguageQuality)
+	acceptLanguageBy(func(ac1, ac2 *acceptLanguage) bool {
+		if ac1.q != ac2.q {
+			return ac1.q > ac2.q
+		}
+		return ac1.i < ac2.i
+	}).sort(acs)
+
+	priorities := getLanguageSpecificities(provided, acs, languageFilter{})
+	filteredPriorities := priorities.filter(isSpecificityQuality)
+	specificityBy(compareSpecs).sort(filteredPriorities)
+
+	results, rules := make([]string, 0, len(filteredPriorities)), []AffinityRule(nil)
+	for start := 0; start < len(filteredPriorities); {
+		end := start + 1
+		for end < len(filteredPriorities) &&
+			filteredPriorities[end].q == filteredPriorities[start].q &&
+			filteredPriorities[end].s == filteredPriorities[start].s &&
+			filteredPriorities[end].o == filteredPriorities[start].o {
+			end++
+		}
+
+		group := make([]string, 0, end-start)
+		for _, v := range filteredPriorities[start:end] {
+			group = append(group, provided[v.i])
+		}
+		if len(group) > 1 {
+			var rule *AffinityRule
+			group, rule = affinity.reorder(group, acs)
+			if rule != nil {
+				rules = append(rules, *rule)
+			}
+		}
+		results = append(results, group...)
+		start = end
+	}
+
+	return results, rules
+}
+
+// Reorder a tie group with the affinity entry of the most preferred client
+// range which has one and promotes at least one offer of the group.
+func (ra RegionAffinity) reorder(group []string, acs acceptLanguages) ([]string, *AffinityRule) {
+	for _, ac := range acs {
+		fallbacks := ra.get(ac.full)
+		if len(fallbacks) == 0 {
+			continue
+		}
+
+		promoted, taken := make([]string, 0, len(group)), make([]bool, len(group))
+		for _, fallback := range fallbacks {
+			for i, v := range group {
+				if !taken[i] && strings.EqualFold(strings.TrimSpace(v), fallback) {
+					taken[i] = true
+					promoted = append(promoted, v)
+				}
+			}
+		}
+		if len(promoted) == 0 {
+			continue
+		}
+
+		for i, v := range group {
+			if !taken[i] {
+				promoted = append(promoted, v)
+			}
+		}
+		return promoted, &AffinityRule{ac.full, promoted[0]}
+	}
+
+	return group, nil
+}
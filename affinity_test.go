@@ -0,0 +1,86 @@
+// Copyright 2020 Guoyao Wu. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package negotiator
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPreferredLanguagesWithAffinity(t *testing.T) {
+	tests := []struct {
+		accept   string
+		affinity RegionAffinity
+		provided []string
+		expected []string
+		rules    []AffinityRule
+	}{
+		{
+			"en-CA, en;q=0.9",
+			DefaultRegionAffinity,
+			[]string{"en-GB", "en-US"},
+			[]string{"en-US", "en-GB"},
+			[]AffinityRule{{"en-CA", "en-US"}},
+		},
+		{
+			"en-CA, en;q=0.9",
+			nil,
+			[]string{"en-GB", "en-US"},
+			[]string{"en-GB", "en-US"},
+			nil,
+		},
+		{
+			"en-ca, en;q=0.9",
+			DefaultRegionAffinity,
+			[]string{"en-GB", "en", "en-us"},
+			[]string{"en", "en-us", "en-GB"},
+			[]AffinityRule{{"en-ca", "en-us"}},
+		},
+		{
+			"en-AU, en;q=0.9",
+			DefaultRegionAffinity,
+			[]string{"en-US", "en-NZ", "en-GB"},
+			[]string{"en-GB", "en-NZ", "en-US"},
+			[]AffinityRule{{"en-AU", "en-GB"}},
+		},
+		{
+			"en-GB, en;q=0.9",
+			DefaultRegionAffinity,
+			[]string{"en-US", "en-CA"},
+			[]string{"en-US", "en-CA"},
+			nil,
+		},
+		{
+			"en-CA",
+			DefaultRegionAffinity,
+			[]string{"en-GB", "en-US"},
+			[]string{},
+			nil,
+		},
+		{
+			"en-US;q=0.9, en-GB",
+			RegionAffinity{"en-CA": {"en-US"}},
+			[]string{"en-GB", "en-US"},
+			[]string{"en-GB", "en-US"},
+			nil,
+		},
+		{
+			"en-CA, fr-CH;q=0.8, en;q=0.5, fr;q=0.5",
+			DefaultRegionAffinity,
+			[]string{"fr-BE", "en-GB", "fr-FR", "en-US"},
+			[]string{"en-US", "en-GB", "fr-FR", "fr-BE"},
+			[]AffinityRule{{"en-CA", "en-US"}, {"fr-CH", "fr-FR"}},
+		},
+	}
+	for _, tt := range tests {
+		got, rules := PreferredLanguagesWithAffinity(tt.accept, tt.affinity, tt.provided...)
+		if !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf(testErrorFormat, got, tt.expected)
+		}
+		if !reflect.DeepEqual(rules, tt.rules) {
+			t.Errorf(testErrorFormat, rules, tt.rules)
+		}
+	}
+}
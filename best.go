@@ -0,0 +1,340 @@
+// Copyright 2020 Guoyao Wu. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package negotiator
+
+import (
+	"strings"
+	"sync"
+)
+
+// The maximum number of entries of a parse cache, the cache is reset when it's
+// full.
+const parseCacheSize = 256
+
+// The maximum length in bytes of a key of a parse cache, longer values are
+// parsed every time, so that huge headers can't pin memory in the caches or
+// evict the headers of common browsers.
+const parseCacheMaxKeyLength = 1024
+
+// parseCache caches parsed headers and offers by their raw value. Browsers send
+// a handful of distinct headers and offers are usually constants, so the hit
+// rate is high and the most preferred value can be chosen without parsing or
+// allocating. Cached values are shared and must not be modified.
+//
+// The generation counts the resets of the cache, a value parsed before a reset,
+// e.g. with a parsing limit which changed since, isn't stored after it.
+type parseCache struct {
+	mu         sync.RWMutex
+	entries    map[string]interface{}
+	generation uint64
+}
+
+func (c *parseCache) load(key string) (interface{}, bool) {
+	c.mu.RLock()
+	v, ok := c.entries[key]
+	c.mu.RUnlock()
+	return v, ok
+}
+
+// Get the generation of the cache, to be read before parsing a value to store.
+func (c *parseCache) gen() uint64 {
+	c.mu.RLock()
+	generation := c.generation
+	c.mu.RUnlock()
+	return generation
+}
+
+// Store a value parsed in the generation gen, it's dropped if the cache was
+// reset since.
+func (c *parseCache) store(key string, v interface{}, gen uint64) {
+	if len(key) > parseCacheMaxKeyLength {
+		return
+	}
+	c.mu.Lock()
+	if c.generation != gen {
+		c.mu.Unlock()
+		return
+	}
+	if c.entries == nil || len(c.entries) >= parseCacheSize {
+		c.entries = make(map[string]interface{}, parseCacheSize)
+	}
+	c.entries[key] = v
+	c.mu.Unlock()
+}
+
+func (c *parseCache) reset() {
+	c.mu.Lock()
+	c.entries = nil
+	c.generation++
+	c.mu.Unlock()
+}
+
+var (
+	charsetCache        parseCache
+	encodingCache       parseCache
+	languageCache       parseCache
+	mediaTypeCache      parseCache
+	languageOfferCache  parseCache
+	mediaTypeOfferCache parseCache
+)
+
+// Drop the parsed values of every cache, e.g. when a parsing limit changes.
+func resetParseCaches() {
+	for _, c := range []*parseCache{&charsetCache, &encodingCache, &languageCache, &mediaTypeCache, &languageOfferCache, &mediaTypeOfferCache} {
+		c.reset()
+	}
+}
+
+func cachedAcceptCharset(accept string) acceptCharsets {
+	if v, ok := charsetCache.load(accept); ok {
+		return v.(acceptCharsets)
+	}
+	gen := charsetCache.gen()
+	acs := parseAcceptCharset(accept)
+	charsetCache.store(accept, acs, gen)
+	return acs
+}
+
+func cachedAcceptEncoding(accept string) acceptEncodings {
+	if v, ok := encodingCache.load(accept); ok {
+		return v.(acceptEncodings)
+	}
+	gen := encodingCache.gen()
+	acs := parseAcceptEncoding(accept)
+	encodingCache.store(accept, acs, gen)
+	return acs
+}
+
+func cachedAcceptLanguage(accept string) acceptLanguages {
+	if v, ok := languageCache.load(accept); ok {
+		return v.(acceptLanguages)
+	}
+	gen := languageCache.gen()
+	acs := parseAcceptLanguage(accept)
+	languageCache.store(accept, acs, gen)
+	return acs
+}
+
+func cachedAcceptMediaType(accept string) acceptMediaTypes {
+	if v, ok := mediaTypeCache.load(accept); ok {
+		return v.(acceptMediaTypes)
+	}
+	gen := mediaTypeCache.gen()
+	acs := parseAcceptMediaType(accept)
+	mediaTypeCache.store(accept, acs, gen)
+	return acs
+}
+
+// Get the parsed Accept header for a filter, only the ranges deduplicated with
+// the default parameter comparison are cached.
+func cachedAcceptMediaTypeFilter(accept string, f mediaTypeFilter) acceptMediaTypes {
+	if len(f.caseSensitive) == 0 {
+		return cachedAcceptMediaType(accept)
+	}
+	return parseAcceptMediaTypeFilter(accept, f)
+}
+
+// Parse a language offer, the index of the result is meaningless.
+func cachedLanguageOffer(language string) *acceptLanguage {
+	if v, ok := languageOfferCache.load(language); ok {
+		return v.(*acceptLanguage)
+	}
+	gen := languageOfferCache.gen()
+	p := parseLanguage(language, 0)
+	languageOfferCache.store(language, p, gen)
+	return p
+}
+
+// Parse a media type offer, the index of the result is meaningless.
+func cachedMediaTypeOffer(mediaType string) *acceptMediaType {
+	if v, ok := mediaTypeOfferCache.load(mediaType); ok {
+		return v.(*acceptMediaType)
+	}
+	gen := mediaTypeOfferCache.gen()
+	p := parseMediaType(mediaType, 0)
+	mediaTypeOfferCache.store(mediaType, p, gen)
+	return p
+}
+
+// Choose the most preferred of provided, which is the first value of
+// PreferredCharsets without sorting all of them, and how it matched.
+func bestCharset(accept string, provided []string) (string, MatchKind) {
+	acs, best, found := cachedAcceptCharset(accept), specificity{}, false
+	for i, charset := range provided {
+		spec := getCharsetPriority(charset, acs, i)
+		if isSpecificityQuality(spec) && (!found || compareSpecs(&spec, &best)) {
+			best, found = spec, true
+		}
+	}
+	return bestOf(provided, best, found, charsetMatchKind)
+}
+
+// Choose the most preferred of provided, which is the first value of
+// PreferredEncodings without sorting all of them, and how it matched.
+func bestEncoding(accept string, provided []string) (string, MatchKind) {
+	acs, best, found := cachedAcceptEncoding(accept), specificity{}, false
+	for i, encoding := range provided {
+		spec := getEncodingPriority(encoding, acs, i)
+		if isSpecificityQuality(spec) && (!found || compareSpecs(&spec, &best)) {
+			best, found = spec, true
+		}
+	}
+	return bestOf(provided, best, found, encodingMatchKind)
+}
+
+// Choose the most preferred of provided, which is the first value of
+// PreferredLanguages without sorting all of them, and how it matched.
+func bestLanguage(accept string, provided []string, f languageFilter) (string, MatchKind) {
+	i, kind := bestLanguageIndex(accept, provided, f)
+	if i == -1 {
+		return "", kind
+	}
+	return provided[i], kind
+}
+
+// Get the index of the most preferred of provided, or -1 if none is
+// acceptable, and how it matched. The languages may be surrounded by OWS, and
+// the blank ones are never acceptable.
+func bestLanguageIndex(accept string, provided []string, f languageFilter) (int, MatchKind) {
+	acs, best, found := cachedAcceptLanguage(accept), specificity{}, false
+	for i, language := range provided {
+		spec := getParsedLanguagePriority(cachedLanguageOffer(language), acs, nil, i, f)
+		if isSpecificityQuality(spec) && (!found || compareSpecs(&spec, &best)) {
+			best, found = spec, true
+		}
+	}
+	if !found {
+		return -1, MatchNone
+	}
+	return best.i, languageMatchKind(best.s)
+}
+
+// Choose the most preferred of provided, which is the first value of
+// PreferredMediaTypes without sorting all of them, and how it matched.
+func bestMediaType(accept string, provided []string, f mediaTypeFilter) (string, MatchKind) {
+	i, kind := bestMediaTypeIndex(accept, provided, f)
+	if i == -1 {
+		return "", kind
+	}
+	return provided[i], kind
+}
+
+// Get the index of the most preferred of provided, or -1 if none is
+// acceptable, and how it matched. The media types may be surrounded by OWS,
+// and the blank ones are never acceptable.
+func bestMediaTypeIndex(accept string, provided []string, f mediaTypeFilter) (int, MatchKind) {
+	if len(provided) > 0 && isAnyMediaRange(accept) && !hasWildcardMediaTypeOffer(provided) {
+		// a lone */* prefers the first offer unless it's malformed or a
+		// following offer of the same type and subtype has fewer parameters
+		if p := cachedMediaTypeOffer(provided[0]); p != nil && len(p.params) == 0 {
+			return 0, MatchFullWildcard
+		}
+	}
+	acs, best, found := cachedAcceptMediaTypeFilter(accept, f), specificity{}, false
+	var keys []mediaTypeTieKey
+	for i, mediaType := range provided {
+		spec := getParsedMediaTypePriority(cachedMediaTypeOffer(mediaType), acs, nil, i, f)
+		if !isSpecificityQuality(spec) {
+			continue
+		}
+		if found && keys == nil && spec.q == best.q && spec.s == best.s && spec.o == best.o {
+			// the keys only break ties, they're computed once on the first one
+			keys = mediaTypeOfferTieKeys(provided)
+		}
+		if !found || keys == nil && compareSpecs(&spec, &best) ||
+			keys != nil && compareMediaTypeSpecs(&spec, &best, keys[i], keys[best.i]) {
+			best, found = spec, true
+		}
+	}
+	if !found {
+		return -1, MatchNone
+	}
+	return best.i, mediaTypeMatchKind(best.s)
+}
+
+func bestOf(provided []string, best specificity, found bool, kind func(s int) MatchKind) (string, MatchKind) {
+	if !found {
+		return "", MatchNone
+	}
+	return provided[best.i], kind(best.s)
+}
+
+// Get the tie keys of the media type offers, see getMediaTypeTieKeys.
+func mediaTypeOfferTieKeys(provided []string) []mediaTypeTieKey {
+	offers := make([]*acceptMediaType, len(provided), len(provided))
+	for i, mediaType := range provided {
+		offers[i] = cachedMediaTypeOffer(mediaType)
+	}
+	return getMediaTypeTieKeys(offers)
+}
+
+// The maximum number of offers ranked without parsing the Accept header when
+// it's a lone */*, see anyMediaRangeOffers. Checking that the offers rank in
+// their order is quadratic.
+const anyMediaRangeMaxOffers = 16
+
+// Reports whether an Accept header is a lone */* range accepting everything,
+// with an optional positive q parameter, e.g. "*/*" or "*/*;q=0.8", as most
+// non-browser clients send. Such a header ranks the offers without being
+// parsed.
+func isAnyMediaRange(accept string) bool {
+	accept = strings.Trim(accept, " \t")
+	if !strings.HasPrefix(accept, "*/*") {
+		return false
+	}
+	rest := strings.TrimLeft(accept[3:], " \t")
+	if rest == "" {
+		return true
+	}
+	if rest[0] != ';' {
+		return false
+	}
+	i := strings.IndexByte(rest, '=')
+	if i == -1 || !strings.EqualFold(strings.Trim(rest[1:i], " \t"), "q") {
+		return false
+	}
+	q, err := parseQuality(strings.Trim(rest[i+1:], " \t"))
+	return err == nil && q > 0
+}
+
+// Get the offers in the order a lone */* ranks them, which is the provided
+// order if every offer is a valid media type without a wildcard and no two
+// offers have the same type and subtype, so that no tie key applies. ok is false otherwise, the
+// header must then be negotiated as usual. The offers must be normalized.
+func anyMediaRangeOffers(provided []string) (offers []string, ok bool) {
+	if len(provided) == 0 || len(provided) > anyMediaRangeMaxOffers {
+		return nil, false
+	}
+	for i, mediaType := range provided {
+		p := cachedMediaTypeOffer(mediaType)
+		if p == nil || isWildcardMediaTypeOffer(p) {
+			return nil, false
+		}
+		for _, other := range provided[:i] {
+			q := cachedMediaTypeOffer(other)
+			if strings.EqualFold(q.mainType, p.mainType) && strings.EqualFold(q.subtype, p.subtype) {
+				return nil, false
+			}
+		}
+	}
+	return append(make([]string, 0, len(provided)), provided...), true
+}
+
+// Reports whether an offer is a wildcard media type, e.g. text/* or */*, which
+// a */* range matches more specifically than the concrete media types, so the
+// provided order doesn't apply.
+func isWildcardMediaTypeOffer(p *acceptMediaType) bool {
+	return p.mainType == "*" || p.subtype == "*"
+}
+
+// Reports whether one of the offers is a wildcard media type.
+func hasWildcardMediaTypeOffer(provided []string) bool {
+	for _, mediaType := range provided {
+		if p := cachedMediaTypeOffer(mediaType); p != nil && isWildcardMediaTypeOffer(p) {
+			return true
+		}
+	}
+	return false
+}
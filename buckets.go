@@ -0,0 +1,65 @@
+// Copyright 2020 Guoyao Wu. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package negotiator
+
+// The number of offers from which accept ranges are bucketed before scoring.
+// Below it a linear scan of all ranges per offer is cheaper than building the
+// buckets.
+var offerBucketThreshold = 16
+
+// rangeBuckets groups the indices of accept ranges by a key, e.g. the main type
+// of a media range, so an offer is only scored against the ranges which could
+// possibly match it. Wildcard ranges are included in every group, and each
+// group is ascending, so a group visits its ranges in header order.
+type rangeBuckets struct {
+	groups    map[string][]int
+	wildcards []int
+}
+
+// Builds the buckets of n ranges, key reports the key of the i-th range or
+// that the range is a wildcard.
+func newRangeBuckets(n int, key func(i int) (k string, wildcard bool)) *rangeBuckets {
+	b := &rangeBuckets{make(map[string][]int), make([]int, 0)}
+	for i := 0; i < n; i++ {
+		k, wildcard := key(i)
+		if wildcard {
+			b.wildcards = append(b.wildcards, i)
+			for g, group := range b.groups {
+				b.groups[g] = append(group, i)
+			}
+			continue
+		}
+		group, ok := b.groups[k]
+		if !ok {
+			group = append(make([]int, 0, len(b.wildcards)+1), b.wildcards...)
+		}
+		b.groups[k] = append(group, i)
+	}
+	return b
+}
+
+// Gets the indices of the ranges which could match an offer with the key.
+func (b *rangeBuckets) get(k string) []int {
+	if group, ok := b.groups[k]; ok {
+		return group
+	}
+	return b.wildcards
+}
+
+// Gets the number of ranges to visit and the index of the j-th, indices nil
+// means all n ranges.
+func rangeIndex(indices []int, j int) int {
+	if indices == nil {
+		return j
+	}
+	return indices[j]
+}
+
+func rangeCount(indices []int, n int) int {
+	if indices == nil {
+		return n
+	}
+	return len(indices)
+}
@@ -0,0 +1,109 @@
+// Copyright 2020 Guoyao Wu. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package negotiator
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestNewRangeBuckets(t *testing.T) {
+	keys := []string{"text", "*", "application", "text", "*", "image"}
+	b := newRangeBuckets(len(keys), func(i int) (string, bool) {
+		return keys[i], keys[i] == "*"
+	})
+	tests := []struct {
+		key      string
+		expected []int
+	}{
+		{"text", []int{0, 1, 3, 4}},
+		{"application", []int{1, 2, 4}},
+		{"image", []int{1, 4, 5}},
+		{"audio", []int{1, 4}},
+	}
+	for _, tt := range tests {
+		if got := b.get(tt.key); !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf(testErrorFormat, got, tt.expected)
+		}
+	}
+
+	empty := newRangeBuckets(0, nil)
+	if got := empty.get("text"); got == nil || len(got) != 0 {
+		t.Errorf(testErrorFormat, got, []int{})
+	}
+}
+
+func TestRangeBucketsEquivalence(t *testing.T) {
+	mediaTypes, languages := manyMediaTypeOffers(300), manyLanguageOffers(300)
+	tests := []struct {
+		preferred func(accept string, provided ...string) []string
+		accept    string
+		provided  []string
+	}{
+		{PreferredMediaTypes, manyRangesAccept, mediaTypes},
+		{PreferredMediaTypes, "text/*;q=0.5, application/json;v=2, */*;q=0.1", mediaTypes},
+		{PreferredMediaTypes, "", mediaTypes},
+		{PreferredLanguages, "en-US, en;q=0.9, fr;q=0.8, *;q=0.1, zh-Hant;q=0.5", languages},
+		{PreferredLanguages, "de-CH, de, x-1", languages},
+	}
+	for _, tt := range tests {
+		bucketed := tt.preferred(tt.accept, tt.provided...)
+		linear := withoutOfferBuckets(func() []string {
+			return tt.preferred(tt.accept, tt.provided...)
+		})
+		if !reflect.DeepEqual(bucketed, linear) {
+			t.Errorf(testErrorFormat, bucketed, linear)
+		}
+	}
+}
+
+const manyRangesAccept = "text/html, application/xhtml+xml, application/xml;q=0.9, image/webp, " +
+	"image/apng, image/*;q=0.8, application/json;q=0.7, text/plain;format=flowed, " +
+	"audio/*;q=0.4, video/mp4;q=0.3, font/woff2;q=0.2, */*;q=0.1"
+
+func manyMediaTypeOffers(n int) []string {
+	types := []string{"text", "application", "image", "audio", "video", "font", "model", "x-custom"}
+	offers := make([]string, n)
+	for i := range offers {
+		offers[i] = fmt.Sprintf("%s/sub-%d", types[i%len(types)], i)
+	}
+	offers[n/2], offers[n/3], offers[n-1] = "text/html", "application/json", "text/plain;format=flowed"
+	return offers
+}
+
+func manyLanguageOffers(n int) []string {
+	prefixes := []string{"en", "fr", "de", "zh", "ja", "es", "pt"}
+	offers := make([]string, n)
+	for i := range offers {
+		offers[i] = fmt.Sprintf("%s-x%d", prefixes[i%len(prefixes)], i)
+	}
+	offers[n/2], offers[n/3], offers[n-1] = "en-US", "fr", "zh-Hant"
+	return offers
+}
+
+func withoutOfferBuckets(f func() []string) []string {
+	threshold := offerBucketThreshold
+	offerBucketThreshold = int(^uint(0) >> 1)
+	defer func() { offerBucketThreshold = threshold }()
+	return f()
+}
+
+func BenchmarkPreferredMediaTypesManyOffers(b *testing.B) {
+	offers := manyMediaTypeOffers(300)
+	b.Run("bucketed", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			PreferredMediaTypes(manyRangesAccept, offers...)
+		}
+	})
+	b.Run("linear", func(b *testing.B) {
+		withoutOfferBuckets(func() []string {
+			for i := 0; i < b.N; i++ {
+				PreferredMediaTypes(manyRangesAccept, offers...)
+			}
+			return nil
+		})
+	})
+}
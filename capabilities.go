@@ -0,0 +1,118 @@
+// Copyright 2020 Guoyao Wu. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package negotiator
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Capabilities describes the representations a service can produce, e.g. to
+// be served at a well-known location, see CapabilitiesHandler. It's safe to
+// encode as JSON, the encoding is stable.
+type Capabilities struct {
+	MediaTypes CapabilityDimension `json:"mediaTypes"`
+	Languages  CapabilityDimension `json:"languages"`
+	Charsets   CapabilityDimension `json:"charsets"`
+	Encodings  CapabilityDimension `json:"encodings"`
+}
+
+// CapabilityDimension describes the values a service can produce in a
+// dimension.
+type CapabilityDimension struct {
+	// Values are the normalized values in order of server preference.
+	Values []string `json:"values"`
+	// Negotiated reports whether the dimension is negotiated, i.e. more than
+	// one value is offered, a dimension with at most one value is fixed.
+	Negotiated bool `json:"negotiated"`
+}
+
+// DescribeCapabilities describes the representations of the offers. Media
+// types are lowercased with their parameters sorted, languages are
+// canonical-cased, charsets and encodings are lowercased. Values which can't
+// be parsed, wildcards and duplicates are left out, so every value can be fed
+// back to the parsers of the package.
+func DescribeCapabilities(offers Offers) Capabilities {
+	return Capabilities{
+		MediaTypes: newCapabilityDimension(offers.MediaTypes, normalizeMediaTypeCapability),
+		Languages:  newCapabilityDimension(offers.Languages, normalizeLanguageCapability),
+		Charsets:   newCapabilityDimension(offers.Charsets, normalizeTokenCapability),
+		Encodings:  newCapabilityDimension(offers.Encodings, normalizeTokenCapability),
+	}
+}
+
+// CapabilitiesHandler serves the capabilities of the offers, with Respond so
+// the document itself is negotiated among the registered marshalers.
+func CapabilitiesHandler(offers Offers) http.HandlerFunc {
+	capabilities := DescribeCapabilities(offers)
+	return func(w http.ResponseWriter, r *http.Request) {
+		Respond(w, r, http.StatusOK, capabilities)
+	}
+}
+
+func newCapabilityDimension(values []string, normalize func(string) (string, bool)) CapabilityDimension {
+	results, seen := make([]string, 0, len(values)), make(map[string]bool, len(values))
+	for _, v := range values {
+		if v, ok := normalize(v); ok && !seen[v] {
+			seen[v] = true
+			results = append(results, v)
+		}
+	}
+	return CapabilityDimension{results, len(results) > 1}
+}
+
+// Normalize a media type offer, parameter values are quoted if needed.
+func normalizeMediaTypeCapability(mediaType string) (string, bool) {
+	p := parseMediaType(strings.TrimSpace(mediaType), 0)
+	if p == nil || p.mainType == "*" || p.subtype == "*" {
+		return "", false
+	}
+
+	keys := make([]string, 0, len(p.params))
+	for k := range p.params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	b.WriteString(strings.ToLower(p.mainType + "/" + p.subtype))
+	for _, k := range keys {
+		b.WriteString(";" + k + "=")
+		if v := p.params[k]; v != "" && isToken(v) {
+			b.WriteString(v)
+		} else {
+			b.WriteString(`"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(v) + `"`)
+		}
+	}
+	return b.String(), true
+}
+
+func normalizeLanguageCapability(language string) (string, bool) {
+	language = strings.TrimSpace(language)
+	if !isWellFormedLanguageTag(language) {
+		return "", false
+	}
+	return CanonicalLanguageTag(language), true
+}
+
+func normalizeTokenCapability(token string) (string, bool) {
+	token = strings.TrimSpace(token)
+	if token == "" || token == "*" || !isToken(token) {
+		return "", false
+	}
+	return strings.ToLower(token), true
+}
+
+// Report whether s is a token of RFC 7230, i.e. it doesn't need quoting.
+func isToken(s string) bool {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c <= ' ' || c >= 0x7f || strings.IndexByte(`"(),/:;<=>?@[\]{}`, c) != -1 {
+			return false
+		}
+	}
+	return s != ""
+}
@@ -0,0 +1,92 @@
+// Copyright 2020 Guoyao Wu. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package negotiator
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var capabilitiesOffers = Offers{
+	MediaTypes: []string{"Text/HTML", "application/json", "text/html;Level=1;charset=utf-8", "text/*", "foo", "text/plain;title=\"a b\""},
+	Languages:  []string{"EN-us", "zh-hant-tw", "en-US", "*", "en_GB"},
+	Charsets:   []string{"UTF-8"},
+	Encodings:  []string{"gzip", " br ", "GZIP", "*"},
+}
+
+func TestDescribeCapabilities(t *testing.T) {
+	expected := Capabilities{
+		MediaTypes: CapabilityDimension{
+			[]string{"text/html", "application/json", "text/html;charset=utf-8;level=1", `text/plain;title="a b"`},
+			true,
+		},
+		Languages: CapabilityDimension{[]string{"en-US", "zh-Hant-TW"}, true},
+		Charsets:  CapabilityDimension{[]string{"utf-8"}, false},
+		Encodings: CapabilityDimension{[]string{"gzip", "br"}, true},
+	}
+	if got := DescribeCapabilities(capabilitiesOffers); !reflect.DeepEqual(got, expected) {
+		t.Errorf(testErrorFormat, got, expected)
+	}
+
+	got, err := json.Marshal(DescribeCapabilities(Offers{Languages: []string{"fr"}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := `{"mediaTypes":{"values":[],"negotiated":false},"languages":{"values":["fr"],"negotiated":false},` +
+		`"charsets":{"values":[],"negotiated":false},"encodings":{"values":[],"negotiated":false}}`
+	if string(got) != text {
+		t.Errorf(testErrorFormat, string(got), text)
+	}
+}
+
+func TestCapabilitiesHandler(t *testing.T) {
+	handler := CapabilitiesHandler(capabilitiesOffers)
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/.well-known/representations", nil)
+	r.Header.Set(HeaderAccept, "application/json")
+	handler(w, r)
+	if got := w.Header().Get("Content-Type"); got != "application/json" {
+		t.Fatalf(testErrorFormat, got, "application/json")
+	}
+
+	var c Capabilities
+	if err := json.NewDecoder(w.Body).Decode(&c); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		preferred func(accept string, provided ...string) []string
+		values    []string
+	}{
+		{PreferredMediaTypes, c.MediaTypes.Values},
+		{PreferredLanguages, c.Languages.Values},
+		{PreferredCharsets, c.Charsets.Values},
+		{PreferredEncodings, c.Encodings.Values},
+	}
+	for _, tt := range tests {
+		if len(tt.values) == 0 {
+			t.Errorf(testErrorFormat, tt.values, "values")
+		}
+		for _, v := range tt.values {
+			if got := tt.preferred(v, v); !reflect.DeepEqual(got, []string{v}) {
+				t.Errorf(testErrorFormat, got, []string{v})
+			}
+		}
+	}
+
+	w = httptest.NewRecorder()
+	r.Header.Set(HeaderAccept, "application/xml")
+	handler(w, r)
+	if got := w.Header().Get("Content-Type"); got != "application/xml" {
+		t.Errorf(testErrorFormat, got, "application/xml")
+	}
+	if !strings.Contains(w.Body.String(), "<Capabilities>") {
+		t.Errorf(testErrorFormat, w.Body.String(), "a capabilities document")
+	}
+}
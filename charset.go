@@ -6,17 +6,12 @@ package negotiator
 
 import (
 	"sort"
-	"strconv"
 	"strings"
-
-	"github.com/dlclark/regexp2"
 )
 
-var simpleCharsetRegExp = regexp2.MustCompile("^\\s*([^\\s;]+)\\s*(?:;(.*))?$", regexp2.None)
-
 type acceptCharset struct {
 	charset string
-	q       float64
+	q       quality
 	i       int
 }
 
@@ -67,10 +62,24 @@ func (s *acceptCharsetSorter) Less(i, j int) bool {
 type specificity struct {
 	i int
 	o int
-	q float64
+	q quality
 	s int
 }
 
+// Reports whether the match of a range governs over the match of priority,
+// the most specific range governs even if its quality is lower, e.g. with
+// "*/*, text/html;q=0" text/html isn't acceptable. Then the higher quality,
+// then the later range governs.
+func (spec specificity) governs(priority specificity) bool {
+	if spec.s != priority.s {
+		return spec.s > priority.s
+	}
+	if spec.q != priority.q {
+		return spec.q > priority.q
+	}
+	return spec.o > priority.o
+}
+
 type specificities []specificity
 
 func (ss specificities) filter(f func(s specificity) bool) specificities {
@@ -83,17 +92,6 @@ func (ss specificities) filter(f func(s specificity) bool) specificities {
 	return result
 }
 
-func (ss specificities) indexOf(s specificity) int {
-	index := -1
-	for i, v := range ss {
-		if v == s {
-			index = i
-			break
-		}
-	}
-	return index
-}
-
 type specificityBy func(s1, s2 *specificity) bool
 
 func (by specificityBy) sort(specs specificities) {
@@ -125,72 +123,156 @@ func PreferredCharsets(accept string, provided ...string) []string {
 
 	if len(provided) == 0 {
 		// sorted list of all charsets
-		filteredAcs := acs.filter(isAcceptCharsetQuality)
-		acceptCharsetBy(func(ac1, ac2 *acceptCharset) bool {
-			if ac1.q != ac2.q {
-				return ac1.q > ac2.q
-			}
-			return ac1.i < ac2.i
-		}).sort(filteredAcs)
-		return filteredAcs.toCharsets()
+		return sortAcceptCharsets(acs).toCharsets()
 	}
 
+	provided = normalizeOffers(provided)
+
 	// sorted list of accepted charsets
-	priorities := getCharsetSpecificities(provided, acs)
-	filteredPriorities := priorities.filter(isSpecificityQuality)
-	specificityBy(compareSpecs).sort(filteredPriorities)
-
-	results := make([]string, 0, len(filteredPriorities))
-	for _, v := range filteredPriorities {
-		i := priorities.indexOf(v)
-		if i >= 0 {
-			results = append(results, provided[i])
+	return getCharsetSpecificities(provided, acs).sorted().values(provided)
+}
+
+// PreferredCharsetsMinQuality gets the preferred charsets like
+// PreferredCharsets, but drops the charsets whose quality is below minQuality,
+// see PreferredLanguagesMinQuality.
+func PreferredCharsetsMinQuality(accept string, minQuality float64, provided ...string) []string {
+	acs := parseAcceptCharset(accept)
+
+	if len(provided) == 0 {
+		return sortAcceptCharsets(acs).filter(func(ac acceptCharset) bool {
+			return ac.q.float() >= minQuality
+		}).toCharsets()
+	}
+
+	provided = normalizeOffers(provided)
+
+	return getCharsetSpecificities(provided, acs).reaching(minQuality).sorted().values(provided)
+}
+
+// WeightedCharsets gets the preferred charsets with their quality and how they
+// matched the header, in the same order as PreferredCharsets. Without provided
+// charsets, the acceptable ranges of the header are listed with MatchNone.
+//
+// Use AppendWeightedCharsets to reuse the result slice.
+func WeightedCharsets(accept string, provided ...string) []Weighted {
+	return AppendWeightedCharsets(nil, accept, provided...)
+}
+
+// AppendWeightedCharsets appends the result of WeightedCharsets to dst and returns
+// the extended slice.
+func AppendWeightedCharsets(dst []Weighted, accept string, provided ...string) []Weighted {
+	acs := cachedAcceptCharset(accept)
+
+	if len(provided) == 0 {
+		dst = growWeighted(dst, len(acs))
+		for _, ac := range sortAcceptCharsets(acs) {
+			dst = append(dst, Weighted{Value: ac.charset, Quality: ac.q.float(), Match: MatchNone})
 		}
+		return dst
 	}
 
-	return results
+	provided = normalizeOffers(provided)
+
+	return getCharsetSpecificities(provided, acs).sorted().appendWeighted(dst, provided, charsetMatchKind)
+}
+
+// Sort the acceptable ranges by quality, then by position.
+func sortAcceptCharsets(acs acceptCharsets) acceptCharsets {
+	filteredAcs := acs.filter(isAcceptCharsetQuality)
+	acceptCharsetBy(func(ac1, ac2 *acceptCharset) bool {
+		if ac1.q != ac2.q {
+			return ac1.q > ac2.q
+		}
+		return ac1.i < ac2.i
+	}).sort(filteredAcs)
+	return filteredAcs
+}
+
+// CharsetRange is a range of an Accept-Charset header.
+type CharsetRange struct {
+	// Charset is the charset, e.g. utf-8, or the wildcard *.
+	Charset string
+	// Quality is the q parameter, 1 if absent.
+	Quality float64
+	// Position is the zero-based position of the member in the header.
+	Position int
+}
+
+// ParseAcceptCharset parses an Accept-Charset header into its ranges in header
+// order, malformed members are dropped like PreferredCharsets does.
+func ParseAcceptCharset(header string) []CharsetRange {
+	acs := parseAcceptCharset(header)
+	ranges := make([]CharsetRange, len(acs), len(acs))
+	for i, ac := range acs {
+		ranges[i] = CharsetRange{ac.charset, ac.q.float(), ac.i}
+	}
+	return ranges
+}
+
+// FormatAcceptCharset formats ranges back into an Accept-Charset header value,
+// the q parameter is omitted when it's 1. ParseAcceptCharset parses the result
+// back into the same ranges.
+func FormatAcceptCharset(ranges []CharsetRange) string {
+	var b strings.Builder
+	for i, r := range ranges {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString(r.Charset)
+		writeQualityParameter(&b, r.Quality)
+	}
+	return b.String()
 }
 
 // Parses the Accept-Charset header to slice with type acceptCharset.
 func parseAcceptCharset(accept string) acceptCharsets {
-	accepts := strings.Split(accept, ",")
+	results, _ := parseAcceptCharsetErrors(accept)
+	return results
+}
+
+// Parses the Accept-Charset header to slice with type acceptCharset, and
+// reports the members which were dropped. Empty members are skipped silently.
+func parseAcceptCharsetErrors(accept string) (acceptCharsets, []*ParseError) {
+	accepts := splitMembers(accept)
 	length := len(accepts)
-	results := make(acceptCharsets, 0, length)
+	results, errs := make(acceptCharsets, 0, length), []*ParseError(nil)
 
 	for i := 0; i < length; i++ {
-		charset := parseCharset(strings.Trim(accepts[i], " "), i)
+		member := strings.Trim(accepts[i], " ")
+		if strings.TrimSpace(member) == "" {
+			continue
+		}
+		charset, err := parseCharsetErr(member, i)
 		if charset != nil {
 			results = append(results, *charset)
+		} else {
+			errs = append(errs, &ParseError{HeaderAcceptCharset, member, i, err, false})
 		}
 	}
 
-	return results
+	return results, errs
 }
 
 // Parse a charset from the Accept-Charset header.
 func parseCharset(s string, i int) *acceptCharset {
-	match, err := simpleCharsetRegExp.FindStringMatch(s)
-	if match == nil || match.GroupCount() == 0 || err != nil {
-		return nil
-	}
-
-	charset, q := match.Groups()[1].String(), 1.0
-	if match.Groups()[2].String() != "" {
-		params := strings.Split(match.Groups()[2].String(), ";")
-		for j := 0; j < len(params); j++ {
-			p := strings.Split(strings.Trim(params[j], " "), "=")
-			if p[0] == "q" {
-				q1, err := strconv.ParseFloat(p[1], 64)
-				if err != nil {
-					return nil
-				}
-				q = q1
-				break
-			}
-		}
+	charset, _ := parseCharsetErr(s, i)
+	return charset
+}
+
+// Parse a charset from the Accept-Charset header, and report why it's
+// malformed.
+func parseCharsetErr(s string, i int) (*acceptCharset, error) {
+	charset, params, ok := scanSimpleRange(s)
+	if !ok {
+		return nil, ErrMalformedRange
+	}
+
+	q, err := parseQualityParameter(params, parseQuality)
+	if err != nil {
+		return nil, err
 	}
 
-	return &acceptCharset{charset, q, i}
+	return &acceptCharset{charset, q, i}, nil
 }
 
 // Get the priority of a charset.
@@ -198,11 +280,9 @@ func getCharsetPriority(charset string, acs acceptCharsets, index int) specifici
 	priority := specificity{o: -1, q: 0, s: 0}
 
 	for i := 0; i < len(acs); i++ {
-		spec := charsetSpecify(charset, acs[i], index)
-		if spec != nil {
-			s, q, o := priority.s-spec.s, priority.q-spec.q, priority.o-spec.o
-			if s < 0 || q < 0 || o < 0 {
-				priority = *spec
+		if spec, ok := charsetSpecificity(charset, acs[i], index); ok {
+			if spec.governs(priority) {
+				priority = spec
 			}
 		}
 	}
@@ -212,15 +292,28 @@ func getCharsetPriority(charset string, acs acceptCharsets, index int) specifici
 
 // Get the specificity of the charset.
 func charsetSpecify(charset string, ac acceptCharset, index int) *specificity {
+	if spec, ok := charsetSpecificity(charset, ac, index); ok {
+		return &spec
+	}
+	return nil
+}
+
+// Get the specificity of the charset, ok is false if the range doesn't match.
+func charsetSpecificity(charset string, ac acceptCharset, index int) (spec specificity, ok bool) {
 	s := 0
-	if strings.ToLower(ac.charset) == strings.ToLower(charset) {
+	if strings.EqualFold(ac.charset, charset) {
 		s |= 1
 	} else if ac.charset != "*" {
-		return nil
+		return spec, false
 	}
-	return &specificity{index, ac.i, ac.q, s}
+	return specificity{index, ac.i, ac.q, s}, true
 }
 
+// Compare the priorities of provided values: by quality, then specificity,
+// then the order of the range in the header, then the provided order. It's a
+// total order as each provided value has its own index, so the unstable sort
+// yields the same order with any algorithm, and equally preferred values keep
+// the provided order.
 func compareSpecs(s1, s2 *specificity) bool {
 	if s1.q != s2.q {
 		return s1.q > s2.q
@@ -5,7 +5,9 @@
 package negotiator
 
 import (
+	"math/rand"
 	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -16,6 +18,11 @@ type testObj struct {
 }
 
 var preferredCharsetTestObjs = []testObj{
+	{
+		"*, utf-8;q=0",
+		[]string{"utf-8", "iso-8859-1"},
+		[]string{"iso-8859-1"},
+	},
 	{
 		"utf-8",
 		nil,
@@ -141,6 +148,11 @@ var preferredCharsetTestObjs = []testObj{
 		[]string{"utf-8"},
 		[]string{"utf-8"},
 	},
+	{
+		"utf-8;Q=0.5, iso-8859-1",
+		[]string{"utf-8", "iso-8859-1"},
+		[]string{"iso-8859-1", "utf-8"},
+	},
 }
 
 func TestPreferredCharsets(t *testing.T) {
@@ -151,18 +163,36 @@ func TestPreferredCharsets(t *testing.T) {
 	}
 }
 
+func TestPreferredCharsetsMinQuality(t *testing.T) {
+	tests := []struct {
+		accept     string
+		minQuality float64
+		provided   []string
+		expected   []string
+	}{
+		{"utf-8, iso-8859-1;q=0.2", 0.5, []string{"iso-8859-1", "utf-8"}, []string{"utf-8"}},
+		{"utf-8;q=0.1, *;q=0.5", 0.5, []string{"utf-8", "utf-16"}, []string{"utf-16"}},
+		{"utf-8, iso-8859-1;q=0.2", 0.5, nil, []string{"utf-8"}},
+	}
+	for _, tt := range tests {
+		if got := PreferredCharsetsMinQuality(tt.accept, tt.minQuality, tt.provided...); !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("%q: "+testErrorFormat, tt.accept, got, tt.expected)
+		}
+	}
+}
+
 func TestParseAcceptCharset(t *testing.T) {
 	tests := []struct {
 		s        string
 		expected acceptCharsets
 	}{
-		{"utf-8", acceptCharsets{{"utf-8", 1, 0}}},
+		{"utf-8", acceptCharsets{{"utf-8", 1000, 0}}},
 		{
 			"utf-8, iso-8859-1;q=0.8, utf-7;q=0.2",
 			acceptCharsets{
-				{"utf-8", 1, 0},
-				{"iso-8859-1", .8, 1},
-				{"utf-7", .2, 2},
+				{"utf-8", 1000, 0},
+				{"iso-8859-1", 800, 1},
+				{"utf-7", 200, 2},
 			},
 		},
 	}
@@ -173,16 +203,34 @@ func TestParseAcceptCharset(t *testing.T) {
 	}
 }
 
+func TestFormatAcceptCharset(t *testing.T) {
+	tests := []struct {
+		s        string
+		expected string
+	}{
+		{"", ""},
+		{"utf-8;q=0.8, , iso-8859-1", "utf-8;q=0.8, iso-8859-1"},
+		{" UTF-8 ;level=1, *;q=0", "UTF-8, *;q=0"},
+		{"utf-8;q=0.0005, latin1;q=2", "utf-8;q=0.001, latin1"},
+	}
+	for _, tt := range tests {
+		if got := FormatAcceptCharset(ParseAcceptCharset(tt.s)); got != tt.expected {
+			t.Errorf(testErrorFormat, got, tt.expected)
+		}
+	}
+}
+
 func TestParseCharset(t *testing.T) {
 	tests := []struct {
 		s        string
 		i        int
 		expected *acceptCharset
 	}{
-		{"utf-8", 0, &acceptCharset{"utf-8", 1, 0}},
-		{"iso-8859-1;q=0.8", 1, &acceptCharset{"iso-8859-1", .8, 1}},
-		{" utf-7 ; q=0.2 ", 2, &acceptCharset{"utf-7", .2, 2}},
+		{"utf-8", 0, &acceptCharset{"utf-8", 1000, 0}},
+		{"iso-8859-1;q=0.8", 1, &acceptCharset{"iso-8859-1", 800, 1}},
+		{" utf-7 ; q=0.2 ", 2, &acceptCharset{"utf-7", 200, 2}},
 		{"utf-16;q=x", 3, nil},
+		{"utf-16;q", 4, nil},
 	}
 	for _, tt := range tests {
 		got := parseCharset(tt.s, tt.i)
@@ -194,9 +242,9 @@ func TestParseCharset(t *testing.T) {
 
 func TestGetCharsetPriority(t *testing.T) {
 	acs := acceptCharsets{
-		{"utf-8", 1, 0},
-		{"iso-8859-1", .8, 1},
-		{"utf-7", .2, 2},
+		{"utf-8", 1000, 0},
+		{"iso-8859-1", 800, 1},
+		{"utf-7", 200, 2},
 	}
 	tests := []struct {
 		charset  string
@@ -205,8 +253,8 @@ func TestGetCharsetPriority(t *testing.T) {
 		expected specificity
 	}{
 		{"utf-8", acceptCharsets{}, 0, specificity{0, -1, 0, 0}},
-		{"iso-8859-1", acs, 1, specificity{1, 1, 0.8, 1}},
-		{"utf-7", acs, 2, specificity{2, 2, 0.2, 1}},
+		{"iso-8859-1", acs, 1, specificity{1, 1, 800, 1}},
+		{"utf-7", acs, 2, specificity{2, 2, 200, 1}},
 	}
 	for _, tt := range tests {
 		got := getCharsetPriority(tt.charset, tt.acs, tt.index)
@@ -225,45 +273,45 @@ func TestCharsetSpecify(t *testing.T) {
 	}{
 		{
 			"utf-8",
-			acceptCharset{"utf-8", 1, 0},
+			acceptCharset{"utf-8", 1000, 0},
 			0,
-			&specificity{0, 0, 1, 1},
+			&specificity{0, 0, 1000, 1},
 		},
 		{
 			"iso-8859-1",
-			acceptCharset{"iso-8859-1", .8, 1},
+			acceptCharset{"iso-8859-1", 800, 1},
 			1,
-			&specificity{1, 1, .8, 1},
+			&specificity{1, 1, 800, 1},
 		},
 		{
 			"utf-7",
-			acceptCharset{"utf-7", .2, 2},
+			acceptCharset{"utf-7", 200, 2},
 			2,
-			&specificity{2, 2, .2, 1},
+			&specificity{2, 2, 200, 1},
 		},
 		{
 			"utf-16",
-			acceptCharset{"utf-32", .3, 3},
+			acceptCharset{"utf-32", 300, 3},
 			3,
 			nil,
 		},
 		{
 			"utf-16",
-			acceptCharset{"*", .4, 4},
+			acceptCharset{"*", 400, 4},
 			4,
-			&specificity{4, 4, .4, 0},
+			&specificity{4, 4, 400, 0},
 		},
 		{
 			"*",
-			acceptCharset{"utf-8", .5, 5},
+			acceptCharset{"utf-8", 500, 5},
 			5,
 			nil,
 		},
 		{
 			"*",
-			acceptCharset{"*", .6, 6},
+			acceptCharset{"*", 600, 6},
 			6,
-			&specificity{6, 6, .6, 1},
+			&specificity{6, 6, 600, 1},
 		},
 	}
 	for i, tt := range tests {
@@ -287,3 +335,75 @@ func acceptCharsetEquals(a, b acceptCharsets) bool {
 
 	return true
 }
+
+func TestCompareSpecs_StrictWeakOrdering(t *testing.T) {
+	var specs specificities
+	for _, q := range []quality{0, 500, maxQuality} {
+		for _, s := range []int{0, 1, 12} {
+			for o := -1; o < 2; o++ {
+				for i := 0; i < 2; i++ {
+					specs = append(specs, specificity{i, o, q, s})
+				}
+			}
+		}
+	}
+	for a := range specs {
+		if compareSpecs(&specs[a], &specs[a]) {
+			t.Errorf("compareSpecs(%v, %v) isn't irreflexive", specs[a], specs[a])
+		}
+		for b := range specs {
+			ab, ba := compareSpecs(&specs[a], &specs[b]), compareSpecs(&specs[b], &specs[a])
+			if ab && ba {
+				t.Errorf("compareSpecs(%v, %v) isn't asymmetric", specs[a], specs[b])
+			}
+			for c := range specs {
+				if ab && compareSpecs(&specs[b], &specs[c]) && !compareSpecs(&specs[a], &specs[c]) {
+					t.Errorf("compareSpecs(%v, %v, %v) isn't transitive", specs[a], specs[b], specs[c])
+				}
+			}
+		}
+	}
+}
+
+func TestSpecificities_SortedIsPermutationInvariant(t *testing.T) {
+	specs := specificities{
+		{0, 2, 500, 1}, {1, 0, maxQuality, 0}, {2, 1, maxQuality, 1}, {3, 1, maxQuality, 1},
+		{4, -1, 0, 0}, {5, 0, 500, 1}, {6, 3, 500, 0}, {7, 1, 900, 0},
+	}
+	expected := append(specificities(nil), specs...).sorted()
+	r := rand.New(rand.NewSource(1))
+	for n := 0; n < 100; n++ {
+		shuffled := append(specificities(nil), specs...)
+		r.Shuffle(len(shuffled), func(i, j int) { shuffled[i], shuffled[j] = shuffled[j], shuffled[i] })
+		if got := shuffled.sorted(); !reflect.DeepEqual(got, expected) {
+			t.Fatalf(testErrorFormat, got, expected)
+		}
+	}
+}
+
+func TestPreferred_PermutedOffersAndRanges(t *testing.T) {
+	tests := []struct {
+		preferred func(accept string, provided ...string) []string
+		ranges    []string
+		provided  []string
+		expected  []string
+	}{
+		{PreferredCharsets, []string{"utf-8", "iso-8859-1;q=0.8", "*;q=0.5", "ascii;q=0"}, []string{"utf-8", "iso-8859-1", "koi8-r", "ascii"}, []string{"utf-8", "iso-8859-1", "koi8-r"}},
+		{PreferredEncodings, []string{"gzip", "br;q=0.9", "*;q=0.1", "deflate;q=0"}, []string{"gzip", "br", "identity", "deflate"}, []string{"gzip", "br", "identity"}},
+		{PreferredLanguages, []string{"en-US", "en;q=0.8", "*;q=0.1", "fr;q=0"}, []string{"en-US", "en", "de", "fr"}, []string{"en-US", "en", "de"}},
+		{PreferredMediaTypes, []string{"text/html", "text/*;q=0.8", "*/*;q=0.1", "image/png;q=0"}, []string{"text/html", "text/plain", "application/json", "image/png"}, []string{"text/html", "text/plain", "application/json"}},
+	}
+	r := rand.New(rand.NewSource(1))
+	for _, tt := range tests {
+		for n := 0; n < 50; n++ {
+			ranges := append([]string(nil), tt.ranges...)
+			r.Shuffle(len(ranges), func(i, j int) { ranges[i], ranges[j] = ranges[j], ranges[i] })
+			provided := append([]string(nil), tt.provided...)
+			r.Shuffle(len(provided), func(i, j int) { provided[i], provided[j] = provided[j], provided[i] })
+			accept := strings.Join(ranges, ", ")
+			if got := tt.preferred(accept, provided...); !reflect.DeepEqual(got, tt.expected) {
+				t.Fatalf("%q %q: "+testErrorFormat, accept, provided, got, tt.expected)
+			}
+		}
+	}
+}
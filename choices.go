@@ -0,0 +1,146 @@
+// Copyright 2020 Guoyao Wu. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package negotiator
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Choice is a representation of a resource addressable at its own URL, an
+// empty field means that the representation doesn't vary in that dimension.
+type Choice struct {
+	URL       string `json:"url"`
+	MediaType string `json:"mediaType,omitempty"`
+	Language  string `json:"language,omitempty"`
+	Encoding  string `json:"encoding,omitempty"`
+}
+
+// RankChoices gets the acceptable choices, most preferred first. Like
+// VariantSet.Choose, the dimensions are negotiated independently and the
+// choices are ranked by media type, then language, then encoding, ties keep
+// the order of choices. A choice without a value in a dimension is acceptable
+// but ranked after the acceptable values.
+func RankChoices(n *Negotiator, choices []Choice) []Choice {
+	mediaTypes := rankChoiceValues(choices, func(c Choice) string { return trimOffer(c.MediaType) }, n.MediaTypes)
+	languages := rankChoiceValues(choices, func(c Choice) string { return trimOffer(c.Language) }, n.Languages)
+	encodings := rankChoiceValues(choices, func(c Choice) string { return trimOffer(c.Encoding) }, n.Encodings)
+
+	type rankedChoice struct {
+		choice Choice
+		ranks  [3]int
+	}
+	ranked := make([]rankedChoice, 0, len(choices))
+	for _, c := range choices {
+		m, ok1 := mediaTypes[trimOffer(c.MediaType)]
+		l, ok2 := languages[trimOffer(c.Language)]
+		e, ok3 := encodings[trimOffer(c.Encoding)]
+		if ok1 && ok2 && ok3 {
+			ranked = append(ranked, rankedChoice{c, [3]int{m, l, e}})
+		}
+	}
+	sort.SliceStable(ranked, func(i, j int) bool {
+		for k := range ranked[i].ranks {
+			if ranked[i].ranks[k] != ranked[j].ranks[k] {
+				return ranked[i].ranks[k] < ranked[j].ranks[k]
+			}
+		}
+		return false
+	})
+
+	results := make([]Choice, len(ranked), len(ranked))
+	for i, r := range ranked {
+		results[i] = r.choice
+	}
+	return results
+}
+
+// MultipleChoices responds with 300 Multiple Choices listing the choices. The
+// most preferred acceptable choice, see RankChoices, is set as Location. Every
+// choice is listed in the Link header, and in the body, which is encoded with
+// the most preferred registered marshaler, or JSONMarshaler if none is
+// acceptable. The request header fields the ranking depends on are added to
+// Vary.
+func MultipleChoices(w http.ResponseWriter, r *http.Request, choices []Choice) {
+	n := negotiatorFor(r)
+	h := w.Header()
+	addVary(h, choicesVary(choices)...)
+	addVary(h, HeaderAccept)
+
+	if ranked := RankChoices(n, choices); len(ranked) > 0 {
+		h.Set("Location", ranked[0].URL)
+	}
+	if len(choices) > 0 {
+		links := make([]string, len(choices), len(choices))
+		for i, c := range choices {
+			links[i] = c.link()
+		}
+		h.Set("Link", strings.Join(links, ", "))
+	}
+
+	m := chooseMarshaler(n, Marshalers())
+	if m == nil {
+		m = JSONMarshaler
+	}
+	h.Set("Content-Type", m.ContentType())
+	w.WriteHeader(http.StatusMultipleChoices)
+	m.Marshal(w, choices)
+}
+
+// Format the choice as an element of the HTTP Link header.
+func (c Choice) link() string {
+	link := "<" + c.URL + `>; rel="alternate"`
+	if c.MediaType != "" {
+		link += `; type="` + c.MediaType + `"`
+	}
+	if c.Language != "" {
+		link += `; hreflang="` + c.Language + `"`
+	}
+	return link
+}
+
+// Rank the distinct values of a dimension of the choices, the unacceptable
+// values are missing. Equally preferred values keep the order of the choices.
+func rankChoiceValues(choices []Choice, value func(Choice) string, preferred func(available ...string) []string) map[string]int {
+	seen, values := make(map[string]bool), []string{""}
+	for _, c := range choices {
+		if v := value(c); !seen[v] {
+			seen[v] = true
+			if v != "" {
+				values = append(values, v)
+			}
+		}
+	}
+	if !seen[""] {
+		values = values[1:]
+	}
+	ranks := make(map[string]int, len(values))
+	for i, v := range rankVariantValues(values, preferred) {
+		ranks[v] = i
+	}
+	return ranks
+}
+
+// Get the request header fields the ranking of the choices depends on.
+func choicesVary(choices []Choice) []string {
+	mediaTypes, languages, encodings := make(map[string]bool), make(map[string]bool), make(map[string]bool)
+	for _, c := range choices {
+		mediaTypes[c.MediaType] = true
+		languages[c.Language] = true
+		encodings[c.Encoding] = true
+	}
+	fields := make([]string, 0, 3)
+	if len(mediaTypes) > 1 {
+		fields = append(fields, HeaderAccept)
+	}
+	if len(languages) > 1 {
+		fields = append(fields, HeaderAcceptLanguage)
+	}
+	if len(encodings) > 1 {
+		fields = append(fields, HeaderAcceptEncoding)
+	}
+	return fields
+}
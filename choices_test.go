@@ -0,0 +1,84 @@
+// Copyright 2020 Guoyao Wu. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package negotiator
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+var testChoices = []Choice{
+	{"/report.pdf", "application/pdf", "", ""},
+	{"/report.html", "text/html", "en", ""},
+	{"/report.de.html", "text/html", "de", ""},
+}
+
+func TestRankChoices(t *testing.T) {
+	tests := []struct {
+		header   http.Header
+		expected []string
+	}{
+		{http.Header{}, []string{"/report.pdf", "/report.html", "/report.de.html"}},
+		{http.Header{HeaderAccept: {"text/html"}}, []string{"/report.html", "/report.de.html"}},
+		{http.Header{HeaderAccept: {"text/html"}, HeaderAcceptLanguage: {"de"}}, []string{"/report.de.html"}},
+		{http.Header{HeaderAccept: {"text/html, application/pdf;q=0.5"}, HeaderAcceptLanguage: {"de, en;q=0.5"}}, []string{"/report.de.html", "/report.html", "/report.pdf"}},
+		{http.Header{HeaderAccept: {"image/png"}}, []string{}},
+	}
+
+	for _, test := range tests {
+		ranked := RankChoices(New(test.header), testChoices)
+		urls := make([]string, len(ranked), len(ranked))
+		for i, c := range ranked {
+			urls[i] = c.URL
+		}
+		if !reflect.DeepEqual(urls, test.expected) {
+			t.Errorf("RankChoices(%v) = %v, expected %v", test.header, urls, test.expected)
+		}
+	}
+}
+
+func TestMultipleChoices(t *testing.T) {
+	tests := []struct {
+		header      http.Header
+		location    string
+		contentType string
+	}{
+		{http.Header{HeaderAccept: {"text/html, application/json"}, HeaderAcceptLanguage: {"de"}}, "/report.de.html", "application/json"},
+		{http.Header{HeaderAccept: {"image/png"}}, "", "application/json"},
+	}
+
+	for _, test := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/report", nil)
+		r.Header = test.header
+		w := httptest.NewRecorder()
+		MultipleChoices(w, r, testChoices)
+
+		if w.Code != http.StatusMultipleChoices {
+			t.Errorf("MultipleChoices(%v) status = %d, expected %d", test.header, w.Code, http.StatusMultipleChoices)
+		}
+		if got := w.Header().Get("Location"); got != test.location {
+			t.Errorf("MultipleChoices(%v) Location = %q, expected %q", test.header, got, test.location)
+		}
+		if got := w.Header().Get("Content-Type"); got != test.contentType {
+			t.Errorf("MultipleChoices(%v) Content-Type = %q, expected %q", test.header, got, test.contentType)
+		}
+		link := `</report.pdf>; rel="alternate"; type="application/pdf", ` +
+			`</report.html>; rel="alternate"; type="text/html"; hreflang="en", ` +
+			`</report.de.html>; rel="alternate"; type="text/html"; hreflang="de"`
+		if got := w.Header().Get("Link"); got != link {
+			t.Errorf("MultipleChoices(%v) Link = %q, expected %q", test.header, got, link)
+		}
+		if got, expected := w.Header()[HeaderVary], []string{"Accept", "Accept-Language"}; !reflect.DeepEqual(got, expected) {
+			t.Errorf("MultipleChoices(%v) Vary = %v, expected %v", test.header, got, expected)
+		}
+		var body []Choice
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || !reflect.DeepEqual(body, testChoices) {
+			t.Errorf("MultipleChoices(%v) body = %s, expected the choices", test.header, w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,95 @@
+// Copyright 2020 Guoyao Wu. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package negotiator
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+)
+
+// Vector is a conformance test vector, the expected output of the Preferred
+// function of a header for an accept value and offers.
+type Vector struct {
+	// Header is the header field name of the dimension, e.g. Accept-Language.
+	Header string `json:"header"`
+	// Accept is the header value.
+	Accept string `json:"accept"`
+	// Offers are the provided values, none means listing the header ranges.
+	Offers []string `json:"offers"`
+	// Expected is the output of the Preferred function.
+	Expected []string `json:"expected"`
+}
+
+// Diff is a conformance vector whose output differs.
+type Diff struct {
+	Vector Vector
+	Got    []string
+}
+
+func (d Diff) String() string {
+	return fmt.Sprintf("%s \"%s\" %q: got %q, expect %q", d.Vector.Header, SanitizeHeaderForLog(d.Vector.Accept), d.Vector.Offers, d.Got, d.Vector.Expected)
+}
+
+// ConformanceVectors gets the conformance test vectors of the headers, or of
+// all headers if none is given. The vectors pin the documented behavior of the
+// package, so the changes of a version show up as changed vectors.
+func ConformanceVectors(headers ...string) []Vector {
+	var all []Vector
+	if err := json.Unmarshal([]byte(conformanceJSON), &all); err != nil {
+		panic("negotiator: invalid conformance vectors: " + err.Error())
+	}
+	if len(headers) == 0 {
+		return all
+	}
+
+	vectors := make([]Vector, 0, len(all))
+	for _, v := range all {
+		for _, h := range headers {
+			if v.Header == h {
+				vectors = append(vectors, v)
+				break
+			}
+		}
+	}
+	return vectors
+}
+
+// RunConformance runs the conformance test vectors of the headers, or of all
+// headers if none is given, against f, and returns the vectors whose output
+// differs. It lets a facade over this package verify that it negotiates like
+// the package, e.g. RunConformance(f, HeaderAccept) for a media type facade.
+func RunConformance(f func(accept string, offers []string) []string, headers ...string) []Diff {
+	var diffs []Diff
+	for _, v := range ConformanceVectors(headers...) {
+		if got := f(v.Accept, v.Offers); !sameValues(got, v.Expected) {
+			diffs = append(diffs, Diff{v, got})
+		}
+	}
+	return diffs
+}
+
+// Get the Preferred function of a header.
+func preferredFunc(header string) func(accept string, provided ...string) []string {
+	switch header {
+	case HeaderAccept:
+		return PreferredMediaTypes
+	case HeaderAcceptCharset:
+		return PreferredCharsets
+	case HeaderAcceptEncoding:
+		return PreferredEncodings
+	case HeaderAcceptLanguage:
+		return PreferredLanguages
+	}
+	return nil
+}
+
+// Compare two lists of values, nil and empty lists are the same.
+func sameValues(a, b []string) bool {
+	if len(a) == 0 && len(b) == 0 {
+		return true
+	}
+	return reflect.DeepEqual(a, b)
+}
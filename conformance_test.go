@@ -0,0 +1,103 @@
+// Copyright 2020 Guoyao Wu. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package negotiator
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"go/format"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "update the conformance vectors with the current outputs")
+
+const conformanceVectorsFile = "conformance_vectors.go"
+
+func TestConformance(t *testing.T) {
+	vectors := ConformanceVectors()
+	if *update {
+		for i, v := range vectors {
+			vectors[i].Expected = preferredFunc(v.Header)(v.Accept, v.Offers...)
+		}
+		if err := writeConformanceVectors(vectors); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	for _, h := range []string{HeaderAccept, HeaderAcceptCharset, HeaderAcceptEncoding, HeaderAcceptLanguage} {
+		preferred := preferredFunc(h)
+		if len(ConformanceVectors(h)) == 0 {
+			t.Errorf("no conformance vectors of %s", h)
+		}
+		diffs := RunConformance(func(accept string, offers []string) []string {
+			return preferred(accept, offers...)
+		}, h)
+		for _, d := range diffs {
+			t.Error(d)
+		}
+	}
+}
+
+func TestRunConformance(t *testing.T) {
+	diffs := RunConformance(func(accept string, offers []string) []string {
+		return offers
+	}, HeaderAcceptCharset)
+	if len(diffs) == 0 {
+		t.Fatal("expect diffs of a facade which ignores the header")
+	}
+	if d := diffs[0]; d.Vector.Header != HeaderAcceptCharset || !sameValues(d.Got, d.Vector.Offers) {
+		t.Errorf(testErrorFormat, d, d.Vector)
+	}
+	if total, n := len(ConformanceVectors()), len(ConformanceVectors(HeaderAccept, HeaderAcceptCharset)); n >= total {
+		t.Errorf(testErrorFormat, n, total)
+	}
+}
+
+// Write the vectors to the generated file, one vector per line so that the
+// changes of the outputs are easy to review.
+func writeConformanceVectors(vectors []Vector) error {
+	var b bytes.Buffer
+	b.WriteString("// Copyright 2020 Guoyao Wu. All rights reserved.\n" +
+		"// Use of this source code is governed by a MIT style\n" +
+		"// license that can be found in the LICENSE file.\n\n")
+	b.WriteString("// Conformance test vectors, see ConformanceVectors. To add a vector, append\n" +
+		"// it with any expected output and run go test -run TestConformance -update,\n" +
+		"// which rewrites the expected outputs with the current ones.\n\n")
+	b.WriteString("package negotiator\n\nconst conformanceJSON = `[\n")
+	for i, v := range vectors {
+		if v.Offers == nil {
+			v.Offers = []string{}
+		}
+		if v.Expected == nil {
+			v.Expected = []string{}
+		}
+		var line bytes.Buffer
+		enc := json.NewEncoder(&line)
+		enc.SetEscapeHTML(false)
+		if err := enc.Encode(v); err != nil {
+			return err
+		}
+		if bytes.IndexByte(line.Bytes(), '`') >= 0 {
+			return fmt.Errorf("conformance vector %d contains a backquote", i)
+		}
+		b.WriteString(strings.TrimSuffix(line.String(), "\n"))
+		if i < len(vectors)-1 {
+			b.WriteString(",")
+		}
+		b.WriteString("\n")
+	}
+	b.WriteString("]`\n")
+
+	src, err := format.Source(b.Bytes())
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(conformanceVectorsFile, src, 0644)
+}
@@ -0,0 +1,130 @@
+// Copyright 2020 Guoyao Wu. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+// Conformance test vectors, see ConformanceVectors. To add a vector, append
+// it with any expected output and run go test -run TestConformance -update,
+// which rewrites the expected outputs with the current ones.
+
+package negotiator
+
+const conformanceJSON = `[
+{"header":"Accept","accept":"text/html","offers":["text/html;level=1","text/html"],"expected":["text/html","text/html;level=1"]},
+{"header":"Accept","accept":"text/html;level=1","offers":["text/html","text/html;level=1"],"expected":["text/html;level=1"]},
+{"header":"Accept","accept":"text/html;level=1","offers":["text/html;level=1;charset=utf-8","text/html;level=1"],"expected":["text/html;level=1","text/html;level=1;charset=utf-8"]},
+{"header":"Accept","accept":"text/html;level=1","offers":["text/html;level=2","text/html"],"expected":[]},
+{"header":"Accept","accept":"*/*","offers":["text/html;level=1","application/json","text/html"],"expected":["text/html","text/html;level=1","application/json"]},
+{"header":"Accept","accept":"text/html; q =0.3, application/json;q=0.5","offers":["text/html","application/json"],"expected":["application/json","text/html"]},
+{"header":"Accept","accept":"text/html; level = 1","offers":["text/html;level=1"],"expected":["text/html;level=1"]},
+{"header":"Accept","accept":"text/html","offers":[],"expected":["text/html"]},
+{"header":"Accept","accept":"text/html, text/*","offers":[],"expected":["text/html","text/*"]},
+{"header":"Accept","accept":"text/html, text/plain;q=0.8","offers":[],"expected":["text/html","text/plain"]},
+{"header":"Accept","accept":"text/html, application/*;q=0.2, image/jpeg;q=0.8","offers":[],"expected":["text/html","image/jpeg","application/*"]},
+{"header":"Accept","accept":"text/html","offers":["text/*"],"expected":[]},
+{"header":"Accept","accept":"text/*, image/*","offers":["text/html","image/*"],"expected":["image/*","text/html"]},
+{"header":"Accept","accept":"text/*, image/*","offers":["text/*"],"expected":["text/*"]},
+{"header":"Accept","accept":"text/html, image/jpeg;q=0.8","offers":["*/*"],"expected":[]},
+{"header":"Accept","accept":"text/html;q=0.6, image/jpeg;q=0.8","offers":["*/*"],"expected":[]},
+{"header":"Accept","accept":"text/*;q=0.1, image/*;q=0.1, application/*;q=0.2","offers":["text/*","image/*","application/*"],"expected":["application/*","text/*","image/*"]},
+{"header":"Accept","accept":"text/*;q=0.1, image/*;q=0.1, application/*;q=0.2","offers":["text/*","image/*","application/json"],"expected":["application/json","text/*","image/*"]},
+{"header":"Accept","accept":"text/*, image/*;q=0.8, application/*;q=0.2","offers":["text/plain","application/*"],"expected":["text/plain","application/*"]},
+{"header":"Accept","accept":"text/*, image/*;q=0.8, application/*;q=0.2","offers":["text/plain","application/json"],"expected":["text/plain","application/json"]},
+{"header":"Accept","accept":"","offers":["text/*","image/*"],"expected":[]},
+{"header":"Accept","accept":"text/*, image/*;q=0.8, application/json;q=0.2","offers":[],"expected":["text/*","image/*","application/json"]},
+{"header":"Accept","accept":"text/*, image/*;q=0.1, application/json;q=0.2","offers":[],"expected":["text/*","application/json","image/*"]},
+{"header":"Accept","accept":"*/*","offers":[],"expected":["*/*"]},
+{"header":"Accept","accept":"*/*","offers":["text/html"],"expected":["text/html"]},
+{"header":"Accept","accept":"*/*, text/*","offers":[],"expected":["*/*","text/*"]},
+{"header":"Accept","accept":"*/*;q=0.5, text/*","offers":[],"expected":["text/*","*/*"]},
+{"header":"Accept","accept":"*/*, text/*;q=x","offers":[],"expected":["*/*"]},
+{"header":"Accept","accept":"*/*, text/*;q=x","offers":["text/html"],"expected":["text/html"]},
+{"header":"Accept","accept":"text/*, application/json","offers":["application/json","text/plain"],"expected":["application/json","text/plain"]},
+{"header":"Accept","accept":"*/*, text/html;q=0","offers":["text/html","application/json"],"expected":["application/json"]},
+{"header":"Accept","accept":"text/*;q=0, */*","offers":["text/html","application/json"],"expected":["application/json"]},
+{"header":"Accept","accept":"*/*, text/*, text/html, text/html;level=1","offers":["image/png","text/plain","text/html","text/html;level=1"],"expected":["text/html;level=1","text/html","text/plain","image/png"]},
+{"header":"Accept","accept":"text/*;q=0.3, text/plain;q=0.7, text/plain;format=flowed, text/plain;format=fixed;q=0.4, */*;q=0.5","offers":["text/html;level=3","text/plain;format=fixed","image/jpeg","text/html","text/plain","text/plain;format=flowed"],"expected":["text/plain;format=flowed","text/plain","image/jpeg","text/plain;format=fixed","text/html","text/html;level=3"]},
+{"header":"Accept-Charset","accept":"utf-8","offers":[],"expected":["utf-8"]},
+{"header":"Accept-Charset","accept":"utf-8, iso-8859-1","offers":[],"expected":["utf-8","iso-8859-1"]},
+{"header":"Accept-Charset","accept":"utf-8, iso-8859-1;q=0.8","offers":[],"expected":["utf-8","iso-8859-1"]},
+{"header":"Accept-Charset","accept":"utf-8, iso-8859-1;q=0.8, utf-7;q=0.2","offers":[],"expected":["utf-8","iso-8859-1","utf-7"]},
+{"header":"Accept-Charset","accept":"utf-8, iso-8859-1;q=0.8, utf-7;q=0.9","offers":[],"expected":["utf-8","utf-7","iso-8859-1"]},
+{"header":"Accept-Charset","accept":"utf-8","offers":["utf-8","iso-8859-1"],"expected":["utf-8"]},
+{"header":"Accept-Charset","accept":"utf-8, iso-8859-1","offers":["utf-8","iso-8859-1"],"expected":["utf-8","iso-8859-1"]},
+{"header":"Accept-Charset","accept":"utf-8, iso-8859-1","offers":["utf-8"],"expected":["utf-8"]},
+{"header":"Accept-Charset","accept":"utf-8, iso-8859-1;q=0.8","offers":["utf-8","iso-8859-1"],"expected":["utf-8","iso-8859-1"]},
+{"header":"Accept-Charset","accept":"utf-8, iso-8859-2;q=0.8","offers":["utf-8","iso-8859-1"],"expected":["utf-8"]},
+{"header":"Accept-Charset","accept":"utf-8, iso-8859-1;q=0.8, utf-7;q=0.2","offers":["utf-8","iso-8859-1"],"expected":["utf-8","iso-8859-1"]},
+{"header":"Accept-Charset","accept":"utf-8, iso-8859-1;q=0.8, utf-7;q=0.2","offers":["utf-8","iso-8859-1","utf-7"],"expected":["utf-8","iso-8859-1","utf-7"]},
+{"header":"Accept-Charset","accept":"utf-8;q=0.1, iso-8859-1;q=0.1, utf-7;q=0.2","offers":["utf-8","iso-8859-1","utf-7"],"expected":["utf-7","utf-8","iso-8859-1"]},
+{"header":"Accept-Charset","accept":"utf-8;q=0.1, iso-8859-1;q=0.2, utf-7;q=0.3","offers":["utf-8","iso-8859-1","utf-7"],"expected":["utf-7","iso-8859-1","utf-8"]},
+{"header":"Accept-Charset","accept":"utf-8;q=0.1, iso-8859-1;q=0.2, utf-7;q=0.2","offers":["utf-8","iso-8859-1","utf-7"],"expected":["iso-8859-1","utf-7","utf-8"]},
+{"header":"Accept-Charset","accept":"utf-8;q=0.1, iso-8859-1;q=x, utf-7;q=0.2","offers":["utf-8","iso-8859-1","utf-7"],"expected":["utf-7","utf-8"]},
+{"header":"Accept-Charset","accept":"utf-8, iso-8859-1;q=0.8, utf-7;q=0.2","offers":["iso-8859-12","utf-7"],"expected":["utf-7"]},
+{"header":"Accept-Charset","accept":"","offers":["utf-8","iso-8859-1","utf-7"],"expected":[]},
+{"header":"Accept-Charset","accept":"utf-8, iso-8859-1;q=0.8, utf-7;q=0.2","offers":[],"expected":["utf-8","iso-8859-1","utf-7"]},
+{"header":"Accept-Charset","accept":"*","offers":[],"expected":["*"]},
+{"header":"Accept-Charset","accept":"*","offers":["utf-8"],"expected":["utf-8"]},
+{"header":"Accept-Charset","accept":"*","offers":["utf-8","iso-8859-1","utf-7"],"expected":["utf-8","iso-8859-1","utf-7"]},
+{"header":"Accept-Charset","accept":"*, utf-8","offers":[],"expected":["*","utf-8"]},
+{"header":"Accept-Charset","accept":"*, utf-8;q=x","offers":[],"expected":["*"]},
+{"header":"Accept-Charset","accept":"*, utf-8;q=x","offers":["utf-8"],"expected":["utf-8"]},
+{"header":"Accept-Charset","accept":"*, utf-8;q=0","offers":["utf-8","iso-8859-1"],"expected":["iso-8859-1"]},
+{"header":"Accept-Encoding","accept":"gzip","offers":[],"expected":["gzip","identity"]},
+{"header":"Accept-Encoding","accept":"gzip, compress","offers":[],"expected":["gzip","compress","identity"]},
+{"header":"Accept-Encoding","accept":"gzip, compress;q=0.8","offers":[],"expected":["gzip","compress","identity"]},
+{"header":"Accept-Encoding","accept":"gzip, compress;q=0.8, identity;q=0.2","offers":[],"expected":["gzip","compress","identity"]},
+{"header":"Accept-Encoding","accept":"gzip, compress;q=0.8, identity;q=0.9","offers":[],"expected":["gzip","identity","compress"]},
+{"header":"Accept-Encoding","accept":"gzip","offers":["gzip","compress"],"expected":["gzip"]},
+{"header":"Accept-Encoding","accept":"gzip, compress","offers":["gzip","compress"],"expected":["gzip","compress"]},
+{"header":"Accept-Encoding","accept":"gzip, compress","offers":["gzip"],"expected":["gzip"]},
+{"header":"Accept-Encoding","accept":"gzip, compress;q=0.8","offers":["gzip","compress"],"expected":["gzip","compress"]},
+{"header":"Accept-Encoding","accept":"gzip, iso-8859-2;q=0.8","offers":["gzip","compress"],"expected":["gzip"]},
+{"header":"Accept-Encoding","accept":"gzip, compress;q=0.8, identity;q=0.2","offers":["gzip","compress"],"expected":["gzip","compress"]},
+{"header":"Accept-Encoding","accept":"gzip, compress;q=0.8, identity;q=0.2","offers":["gzip","compress","identity"],"expected":["gzip","compress","identity"]},
+{"header":"Accept-Encoding","accept":"gzip;q=0.1, compress;q=0.1, identity;q=0.2","offers":["gzip","compress","identity"],"expected":["identity","gzip","compress"]},
+{"header":"Accept-Encoding","accept":"gzip;q=0.1, compress;q=0.2, identity;q=0.3","offers":["gzip","compress","identity"],"expected":["identity","compress","gzip"]},
+{"header":"Accept-Encoding","accept":"gzip;q=0.1, compress;q=0.2, identity;q=0.2","offers":["gzip","compress","identity"],"expected":["compress","identity","gzip"]},
+{"header":"Accept-Encoding","accept":"gzip;q=0.1, compress;q=x, identity;q=0.2","offers":["gzip","compress","identity"],"expected":["identity","gzip"]},
+{"header":"Accept-Encoding","accept":"gzip, compress;q=0.8, identity;q=0.2","offers":["compress2","identity"],"expected":["identity"]},
+{"header":"Accept-Encoding","accept":"","offers":["gzip","compress","identity"],"expected":["identity"]},
+{"header":"Accept-Encoding","accept":"gzip, compress;q=0.8, identity;q=0.2","offers":[],"expected":["gzip","compress","identity"]},
+{"header":"Accept-Encoding","accept":"*","offers":[],"expected":["*"]},
+{"header":"Accept-Encoding","accept":"*","offers":["gzip"],"expected":["gzip"]},
+{"header":"Accept-Encoding","accept":"*","offers":["gzip","compress","identity"],"expected":["gzip","compress","identity"]},
+{"header":"Accept-Encoding","accept":"*, gzip","offers":[],"expected":["*","gzip"]},
+{"header":"Accept-Encoding","accept":"*, gzip;q=x","offers":[],"expected":["*"]},
+{"header":"Accept-Encoding","accept":"*, gzip;q=x","offers":["gzip"],"expected":["gzip"]},
+{"header":"Accept-Language","accept":"zh","offers":[],"expected":["zh"]},
+{"header":"Accept-Language","accept":"zh, en","offers":[],"expected":["zh","en"]},
+{"header":"Accept-Language","accept":"zh, en;q=0.8","offers":[],"expected":["zh","en"]},
+{"header":"Accept-Language","accept":"zh, en;q=0.8, fr;q=0.2","offers":[],"expected":["zh","en","fr"]},
+{"header":"Accept-Language","accept":"zh, en;q=0.8, fr;q=0.9","offers":[],"expected":["zh","fr","en"]},
+{"header":"Accept-Language","accept":"zh","offers":["zh","en"],"expected":["zh"]},
+{"header":"Accept-Language","accept":"zh, en","offers":["zh","en"],"expected":["zh","en"]},
+{"header":"Accept-Language","accept":"zh, en","offers":["zh"],"expected":["zh"]},
+{"header":"Accept-Language","accept":"zh, en;q=0.8","offers":["zh","en"],"expected":["zh","en"]},
+{"header":"Accept-Language","accept":"zh, iso-8859-2;q=0.8","offers":["zh","en"],"expected":["zh"]},
+{"header":"Accept-Language","accept":"zh, en;q=0.8, fr;q=0.2","offers":["zh","en"],"expected":["zh","en"]},
+{"header":"Accept-Language","accept":"zh, en;q=0.8, fr;q=0.2","offers":["zh","en","fr"],"expected":["zh","en","fr"]},
+{"header":"Accept-Language","accept":"zh;q=0.1, en;q=0.1, fr;q=0.2","offers":["zh","en","fr"],"expected":["fr","zh","en"]},
+{"header":"Accept-Language","accept":"zh;q=0.1, en;q=0.2, fr;q=0.3","offers":["zh","en","fr"],"expected":["fr","en","zh"]},
+{"header":"Accept-Language","accept":"zh;q=0.1, en;q=0.2, fr;q=0.2","offers":["zh","en","fr"],"expected":["en","fr","zh"]},
+{"header":"Accept-Language","accept":"zh;q=0.1, en;q=x, fr;q=0.2","offers":["zh","en","fr"],"expected":["fr","zh"]},
+{"header":"Accept-Language","accept":"zh, en;q=0.8, fr;q=0.2","offers":["en2","fr"],"expected":["fr"]},
+{"header":"Accept-Language","accept":"","offers":["zh","en","fr"],"expected":[]},
+{"header":"Accept-Language","accept":"zh, en;q=0.8, fr;q=0.2","offers":[],"expected":["zh","en","fr"]},
+{"header":"Accept-Language","accept":"*","offers":[],"expected":["*"]},
+{"header":"Accept-Language","accept":"*","offers":["zh"],"expected":["zh"]},
+{"header":"Accept-Language","accept":"*","offers":["zh","en","fr"],"expected":["zh","en","fr"]},
+{"header":"Accept-Language","accept":"*, zh","offers":[],"expected":["*","zh"]},
+{"header":"Accept-Language","accept":"*, zh;q=x","offers":[],"expected":["*"]},
+{"header":"Accept-Language","accept":"*, zh;q=x","offers":["zh"],"expected":["zh"]},
+{"header":"Accept-Language","accept":"zh-yue","offers":["zh","yue-Hant-HK"],"expected":["yue-Hant-HK"]},
+{"header":"Accept-Language","accept":"yue","offers":["zh","zh-yue"],"expected":["zh-yue"]},
+{"header":"Accept-Language","accept":"zh","offers":["yue-Hant-HK","zh-yue","zh-CN"],"expected":["zh-CN"]},
+{"header":"Accept-Language","accept":"zh-cmn-Hans-CN, zh;q=0.5","offers":["zh-TW","cmn-Hans-CN"],"expected":["cmn-Hans-CN","zh-TW"]},
+{"header":"Accept-Language","accept":"cmn","offers":["zh-cmn","zh-Hans"],"expected":["zh-cmn"]},
+{"header":"Accept-Language","accept":"ar-afb, ar;q=0.8","offers":["ar","afb"],"expected":["afb","ar"]},
+{"header":"Accept-Language","accept":"ar","offers":["ar-afb"],"expected":[]},
+{"header":"Accept-Language","accept":"zh-yue-HK, ar-afb;q=0.8","offers":[],"expected":["yue-HK","afb"]}
+]`
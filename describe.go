@@ -0,0 +1,194 @@
+// Copyright 2020 Guoyao Wu. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package negotiator
+
+import (
+	"sort"
+	"strings"
+)
+
+// AcceptDescription is a structured, offer independent description of an
+// Accept header. It's safe to encode as JSON, the encoding is stable.
+type AcceptDescription struct {
+	// Header is the field name, e.g. Accept-Language.
+	Header string `json:"header"`
+	// Value is the raw header value.
+	Value string `json:"value"`
+	// Ranges are the parsed ranges in preference order, ranges refused with
+	// q=0 come last.
+	Ranges []DescribedRange `json:"ranges"`
+	// Dropped are the members which couldn't be parsed, in header order.
+	Dropped []DroppedMember `json:"dropped"`
+	// Stats summarizes the header.
+	Stats AcceptStats `json:"stats"`
+	// Warnings are the members which were kept despite being malformed, e.g.
+	// with an unbalanced quote, in header order.
+	Warnings []DroppedMember `json:"warnings,omitempty"`
+}
+
+// DescribedRange is a parsed range of an Accept header.
+type DescribedRange struct {
+	// Value is the range without parameters, e.g. text/html or en-US.
+	Value string `json:"value"`
+	// Quality is the q parameter, 1 if absent.
+	Quality float64 `json:"q"`
+	// Params are the media type parameters other than q.
+	Params map[string]string `json:"params,omitempty"`
+	// Position is the zero-based position of the member in the header.
+	Position int `json:"position"`
+}
+
+// DroppedMember is a member of an Accept header which couldn't be parsed.
+type DroppedMember struct {
+	// Member is the raw member.
+	Member string `json:"member"`
+	// Position is the zero-based position of the member in the header.
+	Position int `json:"position"`
+	// Reason describes why the member was dropped.
+	Reason string `json:"reason"`
+}
+
+// AcceptStats summarizes an Accept header.
+type AcceptStats struct {
+	// Members is the number of non-empty members.
+	Members int `json:"members"`
+	// Ranges is the number of parsed ranges.
+	Ranges int `json:"ranges"`
+	// Dropped is the number of members which couldn't be parsed.
+	Dropped int `json:"dropped"`
+	// Refused is the number of ranges with q=0.
+	Refused int `json:"refused"`
+	// Wildcards is the number of ranges containing a wildcard.
+	Wildcards int `json:"wildcards"`
+}
+
+// DescribeAccept describes an Accept header.
+func DescribeAccept(header string) AcceptDescription {
+	acs, errs := parseAcceptMediaTypeErrors(header, false)
+	ranges := make([]DescribedRange, len(acs))
+	for i, ac := range acs {
+		var params map[string]string
+		if len(ac.params) > 0 {
+			params = make(map[string]string, len(ac.params))
+			for k, v := range ac.params {
+				params[k] = v
+			}
+		}
+		ranges[i] = DescribedRange{ac.mainType + "/" + ac.subtype, ac.q.float(), params, ac.i}
+	}
+	return newAcceptDescription(HeaderAccept, header, ranges, errs)
+}
+
+// DescribeAcceptCharset describes an Accept-Charset header.
+func DescribeAcceptCharset(header string) AcceptDescription {
+	acs, errs := parseAcceptCharsetErrors(header)
+	ranges := make([]DescribedRange, len(acs))
+	for i, ac := range acs {
+		ranges[i] = DescribedRange{Value: ac.charset, Quality: ac.q.float(), Position: ac.i}
+	}
+	return newAcceptDescription(HeaderAcceptCharset, header, ranges, errs)
+}
+
+// DescribeAcceptEncoding describes an Accept-Encoding header. The identity
+// encoding which is implicitly acceptable isn't listed.
+func DescribeAcceptEncoding(header string) AcceptDescription {
+	acs, _, errs := parseAcceptEncodingErrors(header)
+	ranges := make([]DescribedRange, len(acs))
+	for i, ac := range acs {
+		ranges[i] = DescribedRange{Value: ac.encoding, Quality: ac.q.float(), Position: ac.i}
+	}
+	return newAcceptDescription(HeaderAcceptEncoding, header, ranges, errs)
+}
+
+// DescribeAcceptLanguage describes an Accept-Language header.
+func DescribeAcceptLanguage(header string) AcceptDescription {
+	acs, errs := parseAcceptLanguageErrors(header, false)
+	ranges := make([]DescribedRange, len(acs))
+	for i, ac := range acs {
+		ranges[i] = DescribedRange{Value: ac.full, Quality: ac.q.float(), Position: ac.i}
+	}
+	return newAcceptDescription(HeaderAcceptLanguage, header, ranges, errs)
+}
+
+func newAcceptDescription(name, header string, ranges []DescribedRange, errs []*ParseError) AcceptDescription {
+	sort.SliceStable(ranges, func(i, j int) bool {
+		return ranges[i].Quality > ranges[j].Quality
+	})
+
+	dropped, warnings := make([]DroppedMember, 0, len(errs)), []DroppedMember(nil)
+	for _, err := range errs {
+		member := DroppedMember{err.Member, err.Position, err.Err.Error()}
+		if err.Recovered {
+			warnings = append(warnings, member)
+		} else {
+			dropped = append(dropped, member)
+		}
+	}
+
+	stats := AcceptStats{Ranges: len(ranges), Dropped: len(dropped)}
+	stats.Members = stats.Ranges + stats.Dropped
+	for _, r := range ranges {
+		if r.Quality <= 0 {
+			stats.Refused++
+		}
+		if strings.Contains(r.Value, "*") {
+			stats.Wildcards++
+		}
+	}
+
+	return AcceptDescription{name, header, ranges, dropped, stats, warnings}
+}
+
+// MediaTypeExplanation explains how a provided media type was negotiated
+// against an Accept header.
+type MediaTypeExplanation struct {
+	// Value is the provided media type.
+	Value string `json:"value"`
+	// Acceptable reports whether the media type is acceptable.
+	Acceptable bool `json:"acceptable"`
+	// Range is the member of the header governing the media type as it was
+	// sent, empty if no range matched.
+	Range string `json:"range,omitempty"`
+	// Position is the zero-based position of Range in the header, -1 if no
+	// range matched.
+	Position int `json:"position"`
+	// Quality is the q parameter of Range, 0 if no range matched.
+	Quality float64 `json:"q"`
+	// Specificity are the bits of the match: 8 for the type, 4 for the
+	// subtype, 2 for the structured syntax suffix and 1 for the parameters.
+	Specificity int `json:"specificity"`
+	// Match is how Range matched, MatchNone if no range matched.
+	Match MatchKind `json:"match"`
+	// Reason describes why the media type isn't acceptable.
+	Reason string `json:"reason,omitempty"`
+}
+
+// ExplainMediaTypes explains the negotiation of each provided media type in
+// the order they're provided, the media types are trimmed of OWS and the empty
+// ones are dropped. Sort the acceptable ones with PreferredMediaTypes.
+func ExplainMediaTypes(accept string, provided ...string) []MediaTypeExplanation {
+	acs, provided := parseAcceptMediaType(accept), normalizeOffers(provided)
+	members, _ := splitQuotedN(accept, ',', getMaxAcceptRanges())
+
+	results := make([]MediaTypeExplanation, len(provided), len(provided))
+	for i, v := range provided {
+		p := cachedMediaTypeOffer(v)
+		spec := getParsedMediaTypePriority(p, acs, nil, i, mediaTypeFilter{})
+		e := MediaTypeExplanation{Value: v, Position: spec.o, Quality: spec.q.float(), Specificity: spec.s}
+		switch {
+		case p == nil:
+			e.Reason = "malformed media type"
+		case spec.o == -1:
+			e.Reason = "no range matches"
+		default:
+			e.Range, e.Match = strings.Trim(members[spec.o], " "), mediaTypeMatchKind(spec.s)
+			if e.Acceptable = isSpecificityQuality(spec); !e.Acceptable {
+				e.Reason = "refused with q=0"
+			}
+		}
+		results[i] = e
+	}
+	return results
+}
@@ -0,0 +1,157 @@
+// Copyright 2020 Guoyao Wu. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package negotiator
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestDescribeAccept(t *testing.T) {
+	tests := []struct {
+		header   string
+		expected AcceptDescription
+	}{
+		{
+			"",
+			AcceptDescription{HeaderAccept, "", []DescribedRange{}, []DroppedMember{}, AcceptStats{}, nil},
+		},
+		{
+			"text/plain;q=0.5, text/html;level=1, foo, */*;q=0",
+			AcceptDescription{
+				HeaderAccept,
+				"text/plain;q=0.5, text/html;level=1, foo, */*;q=0",
+				[]DescribedRange{
+					{"text/html", 1, map[string]string{"level": "1"}, 1},
+					{"text/plain", .5, nil, 0},
+					{"*/*", 0, nil, 3},
+				},
+				[]DroppedMember{{"foo", 2, ErrMissingSlash.Error()}},
+				AcceptStats{Members: 4, Ranges: 3, Dropped: 1, Refused: 1, Wildcards: 1},
+				nil,
+			},
+		},
+		{
+			"text/html;q=x, application/json",
+			AcceptDescription{
+				HeaderAccept,
+				"text/html;q=x, application/json",
+				[]DescribedRange{{"application/json", 1, nil, 1}},
+				[]DroppedMember{{"text/html;q=x", 0, ErrInvalidQuality.Error()}},
+				AcceptStats{Members: 2, Ranges: 1, Dropped: 1},
+				nil,
+			},
+		},
+		{
+			`text/html;title="a"b", application/json`,
+			AcceptDescription{
+				HeaderAccept,
+				`text/html;title="a"b", application/json`,
+				[]DescribedRange{
+					{"text/html", 1, map[string]string{"title": `a"b`}, 0},
+					{"application/json", 1, nil, 1},
+				},
+				[]DroppedMember{},
+				AcceptStats{Members: 2, Ranges: 2},
+				[]DroppedMember{{`text/html;title="a"b"`, 0, ErrUnbalancedQuote.Error()}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		if got := DescribeAccept(tt.header); !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf(testErrorFormat, got, tt.expected)
+		}
+	}
+}
+
+func TestDescribeAcceptSiblings(t *testing.T) {
+	tests := []struct {
+		describe func(string) AcceptDescription
+		header   string
+		expected AcceptDescription
+	}{
+		{
+			DescribeAcceptCharset,
+			"utf-8;q=0.5, *, utf-7;q=x",
+			AcceptDescription{
+				HeaderAcceptCharset,
+				"utf-8;q=0.5, *, utf-7;q=x",
+				[]DescribedRange{{"*", 1, nil, 1}, {"utf-8", .5, nil, 0}},
+				[]DroppedMember{{"utf-7;q=x", 2, ErrInvalidQuality.Error()}},
+				AcceptStats{Members: 3, Ranges: 2, Dropped: 1, Wildcards: 1},
+				nil,
+			},
+		},
+		{
+			DescribeAcceptEncoding,
+			"gzip, br;q=0",
+			AcceptDescription{
+				HeaderAcceptEncoding,
+				"gzip, br;q=0",
+				[]DescribedRange{{"gzip", 1, nil, 0}, {"br", 0, nil, 1}},
+				[]DroppedMember{},
+				AcceptStats{Members: 2, Ranges: 2, Refused: 1},
+				nil,
+			},
+		},
+		{
+			DescribeAcceptLanguage,
+			"en;q=0.8, zh-CN, en US",
+			AcceptDescription{
+				HeaderAcceptLanguage,
+				"en;q=0.8, zh-CN, en US",
+				[]DescribedRange{{"zh-CN", 1, nil, 1}, {"en", .8, nil, 0}},
+				[]DroppedMember{{"en US", 2, ErrMalformedRange.Error()}},
+				AcceptStats{Members: 3, Ranges: 2, Dropped: 1},
+				nil,
+			},
+		},
+	}
+	for _, tt := range tests {
+		if got := tt.describe(tt.header); !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf(testErrorFormat, got, tt.expected)
+		}
+	}
+}
+
+func TestAcceptDescriptionJSON(t *testing.T) {
+	d := DescribeAccept("text/html;level=1;charset=utf-8, foo")
+	got, err := json.Marshal(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"header":"Accept","value":"text/html;level=1;charset=utf-8, foo",` +
+		`"ranges":[{"value":"text/html","q":1,"params":{"charset":"utf-8","level":"1"},"position":0}],` +
+		`"dropped":[{"member":"foo","position":1,"reason":"missing slash in media range"}],` +
+		`"stats":{"members":2,"ranges":1,"dropped":1,"refused":0,"wildcards":0}}`
+	if string(got) != expected {
+		t.Errorf(testErrorFormat, string(got), expected)
+	}
+}
+
+func TestExplainMediaTypes(t *testing.T) {
+	accept := "text/html;level=1, text/*;q=0.5, application/json;q=0, */*;q=0.1"
+	provided := []string{"text/html;level=1", "text/plain", "application/json", "image/png", " bogus ", ""}
+	expected := []MediaTypeExplanation{
+		{"text/html;level=1", true, "text/html;level=1", 0, 1, 13, MatchExact, ""},
+		{"text/plain", true, "text/*;q=0.5", 1, 0.5, 8, MatchSubtypeWildcard, ""},
+		{"application/json", false, "application/json;q=0", 2, 0, 12, MatchExact, "refused with q=0"},
+		{"image/png", true, "*/*;q=0.1", 3, 0.1, 0, MatchFullWildcard, ""},
+		{"bogus", false, "", -1, 0, 0, MatchNone, "malformed media type"},
+	}
+	if got := ExplainMediaTypes(accept, provided...); !reflect.DeepEqual(got, expected) {
+		t.Errorf(testErrorFormat, got, expected)
+	}
+
+	expected = []MediaTypeExplanation{{"image/png", false, "", -1, 0, 0, MatchNone, "no range matches"}}
+	if got := ExplainMediaTypes("text/html", "image/png"); !reflect.DeepEqual(got, expected) {
+		t.Errorf(testErrorFormat, got, expected)
+	}
+
+	if got := ExplainMediaTypes("text/html"); len(got) != 0 {
+		t.Errorf(testErrorFormat, got, []MediaTypeExplanation{})
+	}
+}
@@ -5,19 +5,13 @@
 package negotiator
 
 import (
-	"math"
 	"sort"
-	"strconv"
 	"strings"
-
-	"github.com/dlclark/regexp2"
 )
 
-var simpleEncodingRegExp = regexp2.MustCompile("^\\s*([^\\s;]+)\\s*(?:;(.*))?$", regexp2.None)
-
 type acceptEncoding struct {
 	encoding string
-	q        float64
+	q        quality
 	i        int
 }
 
@@ -71,79 +65,155 @@ func PreferredEncodings(accept string, provided ...string) []string {
 
 	if len(provided) == 0 {
 		// sorted list of all encodings
-		filteredAcs := acs.filter(isAcceptEncodingQuality)
-		acceptEncodingBy(func(ac1, ac2 *acceptEncoding) bool {
-			if ac1.q != ac2.q {
-				return ac1.q > ac2.q
-			}
-			return ac1.i < ac2.i
-		}).sort(filteredAcs)
-		return filteredAcs.toEncodings()
+		return sortAcceptEncodings(acs).toEncodings()
 	}
 
-	// sorted list of accepted charsets
-	priorities := getEncodingSpecificities(provided, acs)
-	filteredPriorities := priorities.filter(isSpecificityQuality)
-	specificityBy(compareSpecs).sort(filteredPriorities)
+	provided = normalizeOffers(provided)
+
+	// sorted list of accepted encodings
+	return getEncodingSpecificities(provided, acs).sorted().values(provided)
+}
+
+// PreferredEncodingsMinQuality gets the preferred encodings like
+// PreferredEncodings, but drops the encodings whose quality is below
+// minQuality, see PreferredLanguagesMinQuality.
+func PreferredEncodingsMinQuality(accept string, minQuality float64, provided ...string) []string {
+	acs := parseAcceptEncoding(accept)
+
+	if len(provided) == 0 {
+		return sortAcceptEncodings(acs).filter(func(ac acceptEncoding) bool {
+			return ac.q.float() >= minQuality
+		}).toEncodings()
+	}
+
+	provided = normalizeOffers(provided)
+
+	return getEncodingSpecificities(provided, acs).reaching(minQuality).sorted().values(provided)
+}
+
+// WeightedEncodings gets the preferred encodings with their quality and how
+// they matched the header, in the same order as PreferredEncodings. Without
+// provided encodings, the acceptable ranges of the header are listed with
+// MatchNone.
+//
+// The identity encoding is acceptable unless the header refuses it, when it's
+// only admitted because the header doesn't mention it, Implicit is set.
+//
+// Use AppendWeightedEncodings to reuse the result slice.
+func WeightedEncodings(accept string, provided ...string) []Weighted {
+	return AppendWeightedEncodings(nil, accept, provided...)
+}
 
-	results := make([]string, 0, len(filteredPriorities))
-	for _, v := range filteredPriorities {
-		i := priorities.indexOf(v)
-		if i >= 0 {
-			results = append(results, provided[i])
+// AppendWeightedEncodings appends the result of WeightedEncodings to dst and returns
+// the extended slice.
+func AppendWeightedEncodings(dst []Weighted, accept string, provided ...string) []Weighted {
+	acs, implicit := parseAcceptEncodingImplicit(accept)
+	if implicit != -1 {
+		implicit = acs[implicit].i
+	}
+
+	if len(provided) == 0 {
+		dst = growWeighted(dst, len(acs))
+		for _, ac := range sortAcceptEncodings(acs) {
+			dst = append(dst, Weighted{Value: ac.encoding, Quality: ac.q.float(), Match: MatchNone, Implicit: ac.i == implicit})
 		}
+		return dst
 	}
 
-	return results
+	provided = normalizeOffers(provided)
+
+	priorities, start := getEncodingSpecificities(provided, acs).sorted(), len(dst)
+	dst = priorities.appendWeighted(dst, provided, encodingMatchKind)
+	for i, v := range priorities {
+		dst[start+i].Implicit = v.o == implicit
+	}
+	return dst
+}
+
+// Sort the acceptable ranges by quality, then by position.
+func sortAcceptEncodings(acs acceptEncodings) acceptEncodings {
+	filteredAcs := acs.filter(isAcceptEncodingQuality)
+	acceptEncodingBy(func(ac1, ac2 *acceptEncoding) bool {
+		if ac1.q != ac2.q {
+			return ac1.q > ac2.q
+		}
+		return ac1.i < ac2.i
+	}).sort(filteredAcs)
+	return filteredAcs
 }
 
-// Parses the Accept-Encoding header to slice with type acceptEncoding.
+// Parses the Accept-Encoding header to slice with type acceptEncoding, an
+// identity entry is appended unless the header covers identity.
 func parseAcceptEncoding(accept string) acceptEncodings {
-	accepts, hasIdentity, minQuality := strings.Split(accept, ","), false, 1.0
+	results, _ := parseAcceptEncodingImplicit(accept)
+	return results
+}
+
+// Parses the Accept-Encoding header to slice with type acceptEncoding, and
+// reports the index of the implicit identity entry, or -1 if the header covers
+// identity. The implicit entry is positioned after all members of the header.
+func parseAcceptEncodingImplicit(accept string) (acceptEncodings, int) {
+	results, length, _ := parseAcceptEncodingErrors(accept)
+	hasIdentity, minQuality := false, maxQuality
+
+	for _, encoding := range results {
+		_, ok := encodingSpecificity("identity", encoding, 0)
+		hasIdentity = hasIdentity || ok
+		if encoding.q < minQuality {
+			minQuality = encoding.q
+		}
+	}
+
+	if hasIdentity {
+		return results, -1
+	}
+	return append(results, acceptEncoding{"identity", minQuality, length}), len(results)
+}
+
+// Parses the Accept-Encoding header to slice with type acceptEncoding without
+// the implicit identity entry, and reports the number of members and the
+// members which were dropped. Empty members are skipped silently.
+func parseAcceptEncodingErrors(accept string) (acceptEncodings, int, []*ParseError) {
+	accepts := splitMembers(accept)
 	length := len(accepts)
-	results := make(acceptEncodings, 0, length+1)
+	results, errs := make(acceptEncodings, 0, length+1), []*ParseError(nil)
 
 	for i := 0; i < length; i++ {
-		encoding := parseEncoding(strings.Trim(accepts[i], " "), i)
+		member := strings.Trim(accepts[i], " ")
+		if strings.TrimSpace(member) == "" {
+			continue
+		}
+		encoding, err := parseEncodingErr(member, i)
 		if encoding != nil {
 			results = append(results, *encoding)
-			spec := encodingSpecify("identity", *encoding, 0)
-			hasIdentity = hasIdentity || spec != nil
-			minQuality = math.Min(minQuality, encoding.q)
+		} else {
+			errs = append(errs, &ParseError{HeaderAcceptEncoding, member, i, err, false})
 		}
 	}
 
-	if !hasIdentity {
-		results = append(results, acceptEncoding{"identity", minQuality, length})
-	}
-
-	return results
+	return results, length, errs
 }
 
 // Parse an encoding from the Accept-Encoding header.
 func parseEncoding(s string, i int) *acceptEncoding {
-	match, err := simpleEncodingRegExp.FindStringMatch(s)
-	if match == nil || match.GroupCount() == 0 || err != nil {
-		return nil
-	}
-
-	encoding, q := match.Groups()[1].String(), 1.0
-	if match.Groups()[2].String() != "" {
-		params := strings.Split(match.Groups()[2].String(), ";")
-		for j := 0; j < len(params); j++ {
-			p := strings.Split(strings.Trim(params[j], " "), "=")
-			if p[0] == "q" {
-				q1, err := strconv.ParseFloat(p[1], 64)
-				if err != nil {
-					return nil
-				}
-				q = q1
-				break
-			}
-		}
+	encoding, _ := parseEncodingErr(s, i)
+	return encoding
+}
+
+// Parse an encoding from the Accept-Encoding header, and report why it's
+// malformed.
+func parseEncodingErr(s string, i int) (*acceptEncoding, error) {
+	encoding, params, ok := scanSimpleRange(s)
+	if !ok {
+		return nil, ErrMalformedRange
+	}
+
+	q, err := parseQualityParameter(params, parseQuality)
+	if err != nil {
+		return nil, err
 	}
 
-	return &acceptEncoding{encoding, q, i}
+	return &acceptEncoding{encoding, q, i}, nil
 }
 
 // Get the priority of an encoding.
@@ -151,11 +221,9 @@ func getEncodingPriority(encoding string, acs acceptEncodings, index int) specif
 	priority := specificity{o: -1, q: 0, s: 0}
 
 	for i := 0; i < len(acs); i++ {
-		spec := encodingSpecify(encoding, acs[i], index)
-		if spec != nil {
-			s, q, o := priority.s-spec.s, priority.q-spec.q, priority.o-spec.o
-			if s < 0 || q < 0 || o < 0 {
-				priority = *spec
+		if spec, ok := encodingSpecificity(encoding, acs[i], index); ok {
+			if spec.governs(priority) {
+				priority = spec
 			}
 		}
 	}
@@ -165,13 +233,21 @@ func getEncodingPriority(encoding string, acs acceptEncodings, index int) specif
 
 // Get the specificity of the encoding.
 func encodingSpecify(encoding string, ac acceptEncoding, index int) *specificity {
+	if spec, ok := encodingSpecificity(encoding, ac, index); ok {
+		return &spec
+	}
+	return nil
+}
+
+// Get the specificity of the encoding, ok is false if the range doesn't match.
+func encodingSpecificity(encoding string, ac acceptEncoding, index int) (spec specificity, ok bool) {
 	s := 0
-	if strings.ToLower(ac.encoding) == strings.ToLower(encoding) {
+	if strings.EqualFold(ac.encoding, encoding) {
 		s |= 1
 	} else if ac.encoding != "*" {
-		return nil
+		return spec, false
 	}
-	return &specificity{index, ac.i, ac.q, s}
+	return specificity{index, ac.i, ac.q, s}, true
 }
 
 func isAcceptEncodingQuality(ac acceptEncoding) bool {
@@ -185,3 +261,47 @@ func getEncodingSpecificities(types []string, acs acceptEncodings) specificities
 	}
 	return result
 }
+
+// EncodingRange is a range of an Accept-Encoding header.
+type EncodingRange struct {
+	// Encoding is the content coding, e.g. gzip, or the wildcard *.
+	Encoding string
+	// Quality is the q parameter, 1 if absent.
+	Quality float64
+	// Position is the zero-based position of the member in the header.
+	Position int
+	// Implicit reports whether the range wasn't sent by the client, but is the
+	// identity encoding which is acceptable unless the header refuses it.
+	Implicit bool
+}
+
+// ParseAcceptEncoding parses an Accept-Encoding header into its ranges in
+// header order, malformed members are dropped. Unless the header covers the
+// identity encoding, an implicit identity range with the lowest quality of the
+// header is appended, its position is after all members of the header.
+func ParseAcceptEncoding(header string) []EncodingRange {
+	acs, implicit := parseAcceptEncodingImplicit(header)
+	ranges := make([]EncodingRange, len(acs), len(acs))
+	for i, ac := range acs {
+		ranges[i] = EncodingRange{ac.encoding, ac.q.float(), ac.i, i == implicit}
+	}
+	return ranges
+}
+
+// FormatAcceptEncoding formats ranges back into an Accept-Encoding header
+// value, the q parameter is omitted when it's 1. Implicit ranges are skipped,
+// so a parsed header is rendered as the client sent it.
+func FormatAcceptEncoding(ranges []EncodingRange) string {
+	var b strings.Builder
+	for _, r := range ranges {
+		if r.Implicit {
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString(r.Encoding)
+		writeQualityParameter(&b, r.Quality)
+	}
+	return b.String()
+}
@@ -135,6 +135,11 @@ var preferredEncodingTestObjs = []testObj{
 		[]string{"gzip"},
 		[]string{"gzip"},
 	},
+	{
+		"gzip;Q=0.5, br",
+		[]string{"gzip", "br"},
+		[]string{"br", "gzip"},
+	},
 }
 
 func TestPreferredEncodings(t *testing.T) {
@@ -145,21 +150,39 @@ func TestPreferredEncodings(t *testing.T) {
 	}
 }
 
+func TestPreferredEncodingsMinQuality(t *testing.T) {
+	tests := []struct {
+		accept     string
+		minQuality float64
+		provided   []string
+		expected   []string
+	}{
+		{"gzip, br;q=0.2", 0.5, []string{"br", "gzip"}, []string{"gzip"}},
+		{"gzip;q=0.1, *;q=0.5", 0.5, []string{"gzip", "br"}, []string{"br"}},
+		{"gzip, br;q=0.2", 0.5, nil, []string{"gzip"}},
+	}
+	for _, tt := range tests {
+		if got := PreferredEncodingsMinQuality(tt.accept, tt.minQuality, tt.provided...); !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("%q: "+testErrorFormat, tt.accept, got, tt.expected)
+		}
+	}
+}
+
 func TestParseAcceptEncoding(t *testing.T) {
 	tests := []struct {
 		s        string
 		expected acceptEncodings
 	}{
 		{"gzip", acceptEncodings{
-			{"gzip", 1, 0},
-			{"identity", 1, 1},
+			{"gzip", 1000, 0},
+			{"identity", 1000, 1},
 		}},
 		{
 			"gzip, compress;q=0.8, identity;q=0.2",
 			acceptEncodings{
-				{"gzip", 1, 0},
-				{"compress", .8, 1},
-				{"identity", .2, 2},
+				{"gzip", 1000, 0},
+				{"compress", 800, 1},
+				{"identity", 200, 2},
 			},
 		},
 	}
@@ -170,16 +193,58 @@ func TestParseAcceptEncoding(t *testing.T) {
 	}
 }
 
+func TestParseAcceptEncoding_Ranges(t *testing.T) {
+	tests := []struct {
+		s        string
+		expected []EncodingRange
+	}{
+		{"", []EncodingRange{{"identity", 1, 1, true}}},
+		{
+			"gzip;q=0.8, , br, x;q=y",
+			[]EncodingRange{{"gzip", .8, 0, false}, {"br", 1, 2, false}, {"identity", .8, 4, true}},
+		},
+		{"gzip, identity;q=0", []EncodingRange{{"gzip", 1, 0, false}, {"identity", 0, 1, false}}},
+		{"*;q=0.5", []EncodingRange{{"*", .5, 0, false}}},
+	}
+	for _, tt := range tests {
+		if got := ParseAcceptEncoding(tt.s); !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf(testErrorFormat, got, tt.expected)
+		}
+	}
+}
+
+func TestFormatAcceptEncoding(t *testing.T) {
+	tests := []struct {
+		s        string
+		expected string
+	}{
+		{"", ""},
+		{"gzip;q=0.8, , br", "gzip;q=0.8, br"},
+		{"gzip,identity;q=0", "gzip, identity;q=0"},
+		{"br;q=0.125, *;q=0.5", "br;q=0.125, *;q=0.5"},
+	}
+	for _, tt := range tests {
+		if got := FormatAcceptEncoding(ParseAcceptEncoding(tt.s)); got != tt.expected {
+			t.Errorf(testErrorFormat, got, tt.expected)
+		}
+	}
+}
+
 func TestParseEncoding(t *testing.T) {
 	tests := []struct {
 		s        string
 		i        int
 		expected *acceptEncoding
 	}{
-		{"gzip", 0, &acceptEncoding{"gzip", 1, 0}},
-		{"compress;q=0.2", 1, &acceptEncoding{"compress", .2, 1}},
-		{" compress ; q=0.2 ", 2, &acceptEncoding{"compress", .2, 2}},
+		{"gzip", 0, &acceptEncoding{"gzip", 1000, 0}},
+		{"compress;q=0.2", 1, &acceptEncoding{"compress", 200, 1}},
+		{" compress ; q=0.2 ", 2, &acceptEncoding{"compress", 200, 2}},
 		{"gzip;q=x", 3, nil},
+		{"gzip;q", 4, nil},
+		{"\u00a0gzip\t;q=0.5\n", 5, &acceptEncoding{"gzip", 500, 5}},
+		{"gzip br", 6, nil},
+		{"gzip;a\nq=0", 7, nil},
+		{" ;q=0.5", 8, nil},
 	}
 	for _, tt := range tests {
 		got := parseEncoding(tt.s, tt.i)
@@ -191,9 +256,9 @@ func TestParseEncoding(t *testing.T) {
 
 func TestGetEncodingPriority(t *testing.T) {
 	acs := acceptEncodings{
-		{"gzip", 1, 0},
-		{"compress", .2, 1},
-		{"identity", .5, 2},
+		{"gzip", 1000, 0},
+		{"compress", 200, 1},
+		{"identity", 500, 2},
 	}
 	tests := []struct {
 		charset  string
@@ -202,8 +267,8 @@ func TestGetEncodingPriority(t *testing.T) {
 		expected specificity
 	}{
 		{"gzip", acceptEncodings{}, 0, specificity{0, -1, 0, 0}},
-		{"compress", acs, 1, specificity{1, 1, 0.2, 1}},
-		{"identity", acs, 2, specificity{2, 2, 0.5, 1}},
+		{"compress", acs, 1, specificity{1, 1, 200, 1}},
+		{"identity", acs, 2, specificity{2, 2, 500, 1}},
 	}
 	for _, tt := range tests {
 		got := getEncodingPriority(tt.charset, tt.acs, tt.index)
@@ -222,45 +287,45 @@ func TestEncodingSpecify(t *testing.T) {
 	}{
 		{
 			"gzip",
-			acceptEncoding{"gzip", 1, 0},
+			acceptEncoding{"gzip", 1000, 0},
 			0,
-			&specificity{0, 0, 1, 1},
+			&specificity{0, 0, 1000, 1},
 		},
 		{
 			"compress",
-			acceptEncoding{"compress", .8, 1},
+			acceptEncoding{"compress", 800, 1},
 			1,
-			&specificity{1, 1, .8, 1},
+			&specificity{1, 1, 800, 1},
 		},
 		{
 			"identity",
-			acceptEncoding{"identity", .2, 2},
+			acceptEncoding{"identity", 200, 2},
 			2,
-			&specificity{2, 2, .2, 1},
+			&specificity{2, 2, 200, 1},
 		},
 		{
 			"utf-16",
-			acceptEncoding{"utf-32", .3, 3},
+			acceptEncoding{"utf-32", 300, 3},
 			3,
 			nil,
 		},
 		{
 			"utf-16",
-			acceptEncoding{"*", .4, 4},
+			acceptEncoding{"*", 400, 4},
 			4,
-			&specificity{4, 4, .4, 0},
+			&specificity{4, 4, 400, 0},
 		},
 		{
 			"*",
-			acceptEncoding{"gzip", .5, 5},
+			acceptEncoding{"gzip", 500, 5},
 			5,
 			nil,
 		},
 		{
 			"*",
-			acceptEncoding{"*", .6, 6},
+			acceptEncoding{"*", 600, 6},
 			6,
-			&specificity{6, 6, .6, 1},
+			&specificity{6, 6, 600, 1},
 		},
 	}
 	for i, tt := range tests {
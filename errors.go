@@ -0,0 +1,60 @@
+// Copyright 2020 Guoyao Wu. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package negotiator
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrMalformedRange is the reason of a member which isn't a valid range.
+	ErrMalformedRange = errors.New("malformed range")
+	// ErrMissingSlash is the reason of a media range without a subtype.
+	ErrMissingSlash = errors.New("missing slash in media range")
+	// ErrWildcardType is the reason of a media range with a wildcard type and a
+	// concrete subtype, e.g. */json, which is dropped in strict mode.
+	ErrWildcardType = errors.New("wildcard type with a concrete subtype")
+	// ErrEmptyLanguageTag is the reason of a member of Accept-Language with
+	// parameters but no language range, e.g. ";q=0.5".
+	ErrEmptyLanguageTag = errors.New("empty language tag")
+	// ErrMalformedLanguageTag is the reason of a language range which isn't a
+	// well-formed language tag, e.g. 123-456, which is dropped in strict mode.
+	ErrMalformedLanguageTag = errors.New("malformed language tag")
+	// ErrInvalidQuality is the reason of a member with an invalid q parameter.
+	ErrInvalidQuality = errors.New("invalid quality value")
+	// ErrUnbalancedQuote is the reason of a member with a stray quote, or with a
+	// quoted string which isn't terminated.
+	ErrUnbalancedQuote = errors.New("unbalanced quote")
+)
+
+// ParseError describes a member of an Accept header which was dropped by the
+// parser, or which was recovered, Err is the reason.
+type ParseError struct {
+	// Header is the field name, e.g. Accept-Language.
+	Header string
+	// Member is the raw comma-separated member.
+	Member string
+	// Position is the zero-based position of the member in the header.
+	Position int
+	// Err is the reason why the member was dropped.
+	Err error
+	// Recovered reports whether the member was kept anyway, e.g. a member with
+	// an unterminated quoted string which was closed at the end of the member.
+	Recovered bool
+}
+
+func (e *ParseError) Error() string {
+	outcome := "dropped"
+	if e.Recovered {
+		outcome = "recovered"
+	}
+	return fmt.Sprintf("negotiator: %s member %d \"%s\" %s: %v", e.Header, e.Position, SanitizeHeaderForLog(e.Member), outcome, e.Err)
+}
+
+// Unwrap returns the reason why the member was dropped or recovered.
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
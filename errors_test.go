@@ -0,0 +1,61 @@
+// Copyright 2020 Guoyao Wu. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package negotiator
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestParseError(t *testing.T) {
+	_, errs := parseAcceptMediaTypeErrors("text/html, , foo, text/plain;q=x", false)
+	expected := []*ParseError{
+		{HeaderAccept, "foo", 2, ErrMissingSlash, false},
+		{HeaderAccept, "text/plain;q=x", 3, ErrInvalidQuality, false},
+	}
+	if !reflect.DeepEqual(errs, expected) {
+		t.Fatalf(testErrorFormat, errs, expected)
+	}
+
+	err := error(errs[0])
+	if !errors.Is(err, ErrMissingSlash) {
+		t.Errorf("errors.Is(%v, ErrMissingSlash) = false", err)
+	}
+	msg := `negotiator: Accept member 2 "foo" dropped: missing slash in media range`
+	if err.Error() != msg {
+		t.Errorf(testErrorFormat, err.Error(), msg)
+	}
+}
+
+func TestParseError_Recovered(t *testing.T) {
+	_, errs := parseAcceptMediaTypeErrors(`text/html;p="x, application/json, foo`, false)
+	expected := []*ParseError{
+		{HeaderAccept, `text/html;p="x`, 0, ErrUnbalancedQuote, true},
+		{HeaderAccept, "foo", 2, ErrMissingSlash, false},
+	}
+	if !reflect.DeepEqual(errs, expected) {
+		t.Fatalf(testErrorFormat, errs, expected)
+	}
+
+	msg := `negotiator: Accept member 0 "text/html;p=\"x" recovered: unbalanced quote`
+	if got := errs[0].Error(); got != msg {
+		t.Errorf(testErrorFormat, got, msg)
+	}
+}
+
+func TestParseError_Sanitized(t *testing.T) {
+	_, errs := parseAcceptMediaTypeErrors("foo\r\nSet-Cookie: a=b, text/html", false)
+	if len(errs) != 1 {
+		t.Fatalf(testErrorFormat, errs, "one error")
+	}
+	msg := `negotiator: Accept member 0 "fooSet-Cookie: a=b" dropped: missing slash in media range`
+	if got := errs[0].Error(); got != msg {
+		t.Errorf(testErrorFormat, got, msg)
+	}
+	if errs[0].Member != "foo\r\nSet-Cookie: a=b" {
+		t.Errorf(testErrorFormat, errs[0].Member, "the raw member")
+	}
+}
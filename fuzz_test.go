@@ -0,0 +1,203 @@
+// Copyright 2020 Guoyao Wu. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+//go:build go1.18
+// +build go1.18
+
+package negotiator
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// FuzzAcceptEncodingRoundTrip checks that formatting parsed ranges yields a
+// header the parser accepts, with the same ranges and the same preferences.
+func FuzzAcceptEncodingRoundTrip(f *testing.F) {
+	for _, v := range preferredEncodingTestObjs {
+		f.Add(v.accept, strings.Join(v.provided, ","))
+	}
+	f.Fuzz(func(t *testing.T, accept, offers string) {
+		ranges := ParseAcceptEncoding(accept)
+		formatted := FormatAcceptEncoding(ranges)
+		reparsed := ParseAcceptEncoding(formatted)
+
+		if got, expected := encodingRangeValues(reparsed), encodingRangeValues(ranges); !reflect.DeepEqual(got, expected) {
+			t.Fatalf("%q formatted as %q: got %v, expect %v", accept, formatted, got, expected)
+		}
+		if again := FormatAcceptEncoding(reparsed); again != formatted {
+			t.Fatalf("%q formatted as %q, then as %q", accept, formatted, again)
+		}
+
+		provided := strings.Split(offers, ",")
+		if got, expected := PreferredEncodings(formatted, provided...), PreferredEncodings(accept, provided...); !reflect.DeepEqual(got, expected) {
+			t.Fatalf("%q formatted as %q: got %q, expect %q", accept, formatted, got, expected)
+		}
+	})
+}
+
+// Get the ranges without their positions, which change when empty members
+// are dropped.
+func encodingRangeValues(ranges []EncodingRange) []EncodingRange {
+	results := make([]EncodingRange, len(ranges), len(ranges))
+	for i, r := range ranges {
+		results[i] = EncodingRange{Encoding: r.Encoding, Quality: r.Quality, Implicit: r.Implicit}
+	}
+	return results
+}
+
+// FuzzAcceptRoundTrip checks that formatting parsed media ranges yields a
+// header the parser accepts, with the same ranges and the same preferences.
+func FuzzAcceptRoundTrip(f *testing.F) {
+	for _, v := range preferredMediaTypeTestObjs {
+		f.Add(v.accept, strings.Join(v.provided, ","))
+	}
+	f.Fuzz(func(t *testing.T, accept, offers string) {
+		ranges := ParseAccept(accept)
+		formatted := FormatAccept(ranges)
+		reparsed := ParseAccept(formatted)
+
+		if got, expected := mediaRangeValues(reparsed), mediaRangeValues(ranges); !reflect.DeepEqual(got, expected) {
+			t.Fatalf("%q formatted as %q: got %v, expect %v", accept, formatted, got, expected)
+		}
+		if again := FormatAccept(reparsed); again != formatted {
+			t.Fatalf("%q formatted as %q, then as %q", accept, formatted, again)
+		}
+
+		provided := strings.Split(offers, ",")
+		if got, expected := PreferredMediaTypes(formatted, provided...), PreferredMediaTypes(accept, provided...); !reflect.DeepEqual(got, expected) {
+			t.Fatalf("%q formatted as %q: got %q, expect %q", accept, formatted, got, expected)
+		}
+	})
+}
+
+// Get the ranges without their positions, which change when empty members
+// are dropped.
+func mediaRangeValues(ranges []MediaType) []MediaType {
+	results := make([]MediaType, len(ranges), len(ranges))
+	for i, r := range ranges {
+		results[i] = MediaType{Type: r.Type, Subtype: r.Subtype, Params: r.Params, Quality: r.Quality}
+	}
+	return results
+}
+
+// FuzzAcceptCharsetRoundTrip checks that formatting parsed charset ranges
+// yields a header the parser accepts, with the same ranges and the same
+// preferences.
+func FuzzAcceptCharsetRoundTrip(f *testing.F) {
+	for _, v := range preferredCharsetTestObjs {
+		f.Add(v.accept, strings.Join(v.provided, ","))
+	}
+	f.Fuzz(func(t *testing.T, accept, offers string) {
+		ranges := ParseAcceptCharset(accept)
+		formatted := FormatAcceptCharset(ranges)
+		reparsed := ParseAcceptCharset(formatted)
+
+		if got, expected := charsetRangeValues(reparsed), charsetRangeValues(ranges); !reflect.DeepEqual(got, expected) {
+			t.Fatalf("%q formatted as %q: got %v, expect %v", accept, formatted, got, expected)
+		}
+		if again := FormatAcceptCharset(reparsed); again != formatted {
+			t.Fatalf("%q formatted as %q, then as %q", accept, formatted, again)
+		}
+
+		provided := strings.Split(offers, ",")
+		if got, expected := PreferredCharsets(formatted, provided...), PreferredCharsets(accept, provided...); !reflect.DeepEqual(got, expected) {
+			t.Fatalf("%q formatted as %q: got %q, expect %q", accept, formatted, got, expected)
+		}
+	})
+}
+
+// Get the ranges without their positions, which change when empty members
+// are dropped.
+func charsetRangeValues(ranges []CharsetRange) []CharsetRange {
+	results := make([]CharsetRange, len(ranges), len(ranges))
+	for i, r := range ranges {
+		results[i] = CharsetRange{Charset: r.Charset, Quality: r.Quality}
+	}
+	return results
+}
+
+// FuzzAcceptLanguageRoundTrip checks that formatting parsed language ranges
+// yields a header the parser accepts, with the same ranges and the same
+// preferences.
+func FuzzAcceptLanguageRoundTrip(f *testing.F) {
+	for _, v := range preferredLanguageTestObjs {
+		f.Add(v.accept, strings.Join(v.provided, ","))
+	}
+	f.Fuzz(func(t *testing.T, accept, offers string) {
+		ranges, _ := ParseAcceptLanguages(accept)
+		formatted := FormatAcceptLanguage(ranges)
+		reparsed, errs := ParseAcceptLanguages(formatted)
+
+		if len(errs) > 0 {
+			t.Fatalf("%q formatted as %q: %v", accept, formatted, errs)
+		}
+		if got, expected := languageRangeValues(reparsed), languageRangeValues(ranges); !reflect.DeepEqual(got, expected) {
+			t.Fatalf("%q formatted as %q: got %v, expect %v", accept, formatted, got, expected)
+		}
+		if again := FormatAcceptLanguage(reparsed); again != formatted {
+			t.Fatalf("%q formatted as %q, then as %q", accept, formatted, again)
+		}
+
+		provided := strings.Split(offers, ",")
+		if got, expected := PreferredLanguages(formatted, provided...), PreferredLanguages(accept, provided...); !reflect.DeepEqual(got, expected) {
+			t.Fatalf("%q formatted as %q: got %q, expect %q", accept, formatted, got, expected)
+		}
+	})
+}
+
+// Get the ranges without their positions, which change when empty members
+// are dropped.
+func languageRangeValues(ranges []LanguageRange) []LanguageRange {
+	results := make([]LanguageRange, len(ranges), len(ranges))
+	for i, r := range ranges {
+		results[i] = LanguageRange{Tag: r.Tag, Quality: r.Quality}
+	}
+	return results
+}
+
+// FuzzDescribeAccept checks that the parsers of the four headers don't panic,
+// and that every parsed range is parsed back as itself on its own.
+func FuzzDescribeAccept(f *testing.F) {
+	for _, objs := range [][]testObj{preferredCharsetTestObjs, preferredEncodingTestObjs, preferredLanguageTestObjs, preferredMediaTypeTestObjs} {
+		for _, v := range objs {
+			f.Add(v.accept)
+		}
+	}
+	describes := []func(header string) AcceptDescription{DescribeAccept, DescribeAcceptCharset, DescribeAcceptEncoding, DescribeAcceptLanguage}
+	f.Fuzz(func(t *testing.T, accept string) {
+		for _, describe := range describes {
+			for _, r := range describe(accept).Ranges {
+				d := describe(r.Value)
+				if len(d.Ranges) != 1 || d.Ranges[0].Value != r.Value {
+					t.Fatalf("%s range %q of %q is parsed as %v", d.Header, r.Value, accept, d.Ranges)
+				}
+			}
+		}
+	})
+}
+
+// FuzzPreferredMediaTypes checks that the media type parser doesn't panic, and
+// that the preferred media types are a subset of the provided ones.
+func FuzzPreferredMediaTypes(f *testing.F) {
+	for _, v := range preferredMediaTypeTestObjs {
+		f.Add(v.accept, strings.Join(v.provided, ","))
+	}
+	f.Add(`text/html;p="`, `text/html;p=""`)
+	f.Add(`text/*;q=0.5;p="a\"b";x="\`, "text/plain")
+	f.Fuzz(func(t *testing.T, accept, offers string) {
+		parseAcceptMediaType(accept)
+		provided := strings.Split(offers, ",")
+		counts := make(map[string]int, len(provided))
+		for _, v := range normalizeOffers(provided) {
+			counts[v]++
+		}
+		for _, v := range PreferredMediaTypes(accept, provided...) {
+			if counts[v]--; counts[v] < 0 {
+				t.Fatalf("%q with %q: %q isn't provided", accept, provided, v)
+			}
+		}
+	})
+}
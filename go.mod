@@ -2,4 +2,4 @@ module github.com/soongo/negotiator
 
 go 1.14
 
-require github.com/dlclark/regexp2 v1.2.0
+require golang.org/x/text v0.3.8
@@ -0,0 +1,92 @@
+// Copyright 2020 Guoyao Wu. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package negotiator
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// HrefLangDefault is the hreflang value of the fallback alternate link.
+const HrefLangDefault = "x-default"
+
+// LangPlaceholder is replaced with the language tag in the base url of
+// AlternateLanguageLinks.
+const LangPlaceholder = "{lang}"
+
+// ErrInvalidLanguageTag is returned when a language tag is a wildcard or is
+// not well-formed.
+var ErrInvalidLanguageTag = errors.New("negotiator: invalid language tag")
+
+// LinkEntry is an alternate link of a language variant.
+type LinkEntry struct {
+	URL      string
+	HrefLang string
+}
+
+// String formats the entry as an element of the HTTP Link header.
+func (l LinkEntry) String() string {
+	return fmt.Sprintf("<%s>; rel=\"alternate\"; hreflang=\"%s\"", l.URL, l.HrefLang)
+}
+
+// AlternateLanguageLinks generates the alternate links of every offered
+// language, with canonical-cased hreflang values, followed by an x-default
+// entry pointing to the variant of def if def is not empty.
+//
+// The url of a variant is baseURL with LangPlaceholder replaced by the tag, or
+// baseURL with a `lang` query parameter if it has no placeholder.
+func AlternateLanguageLinks(baseURL string, offered []string, def string) ([]LinkEntry, error) {
+	results := make([]LinkEntry, 0, len(offered)+1)
+	for _, v := range offered {
+		link, err := newLinkEntry(baseURL, v)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, link)
+	}
+
+	if def != "" {
+		link, err := newLinkEntry(baseURL, def)
+		if err != nil {
+			return nil, err
+		}
+		link.HrefLang = HrefLangDefault
+		results = append(results, link)
+	}
+
+	return results, nil
+}
+
+// FormatLinkHeader formats the alternate links as a HTTP Link header value.
+func FormatLinkHeader(links []LinkEntry) string {
+	values := make([]string, len(links), len(links))
+	for i, v := range links {
+		values[i] = v.String()
+	}
+	return strings.Join(values, ", ")
+}
+
+func newLinkEntry(baseURL, language string) (LinkEntry, error) {
+	language = strings.TrimSpace(language)
+	if !isWellFormedLanguageTag(language) {
+		return LinkEntry{}, fmt.Errorf("%w: %q", ErrInvalidLanguageTag, language)
+	}
+
+	tag := CanonicalLanguageTag(language)
+	if strings.Contains(baseURL, LangPlaceholder) {
+		return LinkEntry{strings.Replace(baseURL, LangPlaceholder, tag, -1), tag}, nil
+	}
+
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return LinkEntry{}, err
+	}
+	query := u.Query()
+	query.Set("lang", tag)
+	u.RawQuery = query.Encode()
+	return LinkEntry{u.String(), tag}, nil
+}
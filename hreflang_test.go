@@ -0,0 +1,83 @@
+// Copyright 2020 Guoyao Wu. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package negotiator
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestAlternateLanguageLinks(t *testing.T) {
+	tests := []struct {
+		baseURL  string
+		offered  []string
+		def      string
+		expected []LinkEntry
+		err      error
+	}{
+		{
+			"https://example.com/{lang}/page",
+			[]string{"en-us", "DE", "zh-hant-tw"},
+			"en-US",
+			[]LinkEntry{
+				{"https://example.com/en-US/page", "en-US"},
+				{"https://example.com/de/page", "de"},
+				{"https://example.com/zh-Hant-TW/page", "zh-Hant-TW"},
+				{"https://example.com/en-US/page", "x-default"},
+			},
+			nil,
+		},
+		{
+			"https://example.com/page?id=1",
+			[]string{" fr ", "sr-latn-rs", "en-x-Corp"},
+			"",
+			[]LinkEntry{
+				{"https://example.com/page?id=1&lang=fr", "fr"},
+				{"https://example.com/page?id=1&lang=sr-Latn-RS", "sr-Latn-RS"},
+				{"https://example.com/page?id=1&lang=en-x-corp", "en-x-corp"},
+			},
+			nil,
+		},
+		{"https://example.com/{lang}", []string{"en", "*"}, "en", nil, ErrInvalidLanguageTag},
+		{"https://example.com/{lang}", []string{"en", "en_US"}, "en", nil, ErrInvalidLanguageTag},
+		{"https://example.com/{lang}", []string{"en", "en-"}, "en", nil, ErrInvalidLanguageTag},
+		{"https://example.com/{lang}", []string{"en", "123"}, "en", nil, ErrInvalidLanguageTag},
+		{"https://example.com/{lang}", []string{"en", "en-a"}, "en", nil, ErrInvalidLanguageTag},
+		{"https://example.com/{lang}", []string{"en"}, "*", nil, ErrInvalidLanguageTag},
+	}
+	for _, tt := range tests {
+		got, err := AlternateLanguageLinks(tt.baseURL, tt.offered, tt.def)
+		if !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf(testErrorFormat, got, tt.expected)
+		}
+		if !errors.Is(err, tt.err) {
+			t.Errorf(testErrorFormat, err, tt.err)
+		}
+	}
+}
+
+func TestFormatLinkHeader(t *testing.T) {
+	tests := []struct {
+		links    []LinkEntry
+		expected string
+	}{
+		{nil, ""},
+		{
+			[]LinkEntry{{"https://example.com/en", "en"}},
+			`<https://example.com/en>; rel="alternate"; hreflang="en"`,
+		},
+		{
+			[]LinkEntry{{"https://example.com/en", "en"}, {"https://example.com/", "x-default"}},
+			`<https://example.com/en>; rel="alternate"; hreflang="en", ` +
+				`<https://example.com/>; rel="alternate"; hreflang="x-default"`,
+		},
+	}
+	for _, tt := range tests {
+		if got := FormatLinkHeader(tt.links); got != tt.expected {
+			t.Errorf(testErrorFormat, got, tt.expected)
+		}
+	}
+}
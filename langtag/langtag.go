@@ -0,0 +1,51 @@
+// Copyright 2020 Guoyao Wu. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+// Package langtag negotiates the Accept-Language header of a Negotiator with
+// the language tags of golang.org/x/text/language, so that the result feeds an
+// i18n stack built on them without being parsed again. It's a separate package
+// to keep the negotiator package free of the dependency.
+package langtag
+
+import (
+	"github.com/soongo/negotiator"
+	"golang.org/x/text/language"
+)
+
+// LanguageTag gets the most preferred of the supported tags, and its index in
+// supported, ranked like Negotiator.Language ranks their string forms. It's
+// language.Und and -1 if none is acceptable.
+func LanguageTag(n *negotiator.Negotiator, supported ...language.Tag) (language.Tag, int) {
+	available := make([]string, len(supported), len(supported))
+	for i, tag := range supported {
+		available[i] = tag.String()
+	}
+	if winner := n.Language(available...); winner != "" {
+		for i, v := range available {
+			if v == winner {
+				return supported[i], i
+			}
+		}
+	}
+	return language.Und, -1
+}
+
+// MatchLanguageTag gets the supported tag of the matcher best fitting the
+// acceptable ranges of n, ranked by the matcher instead of the negotiator,
+// and its index in the tags the matcher was created with. The ranges are the
+// ones of Negotiator.Languages, so a forced result takes precedence, the
+// wildcard and the malformed ranges are ignored. As ever with a
+// language.Matcher, the tag may carry -u extensions, e.g. en-u-rg-gbzzzz, and
+// the first supported tag is returned if nothing matches.
+func MatchLanguageTag(n *negotiator.Negotiator, m language.Matcher) (language.Tag, int) {
+	ranges := n.Languages()
+	tags := make([]language.Tag, 0, len(ranges))
+	for _, v := range ranges {
+		if tag, err := language.Parse(v); err == nil {
+			tags = append(tags, tag)
+		}
+	}
+	tag, i, _ := m.Match(tags...)
+	return tag, i
+}
@@ -0,0 +1,69 @@
+// Copyright 2020 Guoyao Wu. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package langtag
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/soongo/negotiator"
+	"golang.org/x/text/language"
+)
+
+var testErrorFormat = "got `%v`, expect `%v`"
+
+func newNegotiator(acceptLanguage string) *negotiator.Negotiator {
+	header := http.Header{}
+	if acceptLanguage != "" {
+		header.Set(negotiator.HeaderAcceptLanguage, acceptLanguage)
+	}
+	return negotiator.New(header)
+}
+
+func TestLanguageTag(t *testing.T) {
+	supported := []language.Tag{language.English, language.French, language.MustParse("zh-Hant")}
+	tests := []struct {
+		accept   string
+		expected language.Tag
+		index    int
+	}{
+		{"fr, en;q=0.8", language.French, 1},
+		{"zh-Hant-TW, en;q=0.5", language.MustParse("zh-Hant"), 2},
+		{"EN-us", language.English, 0},
+		{"", language.English, 0},
+		{"de", language.Und, -1},
+		{"*, en;q=0, fr;q=0", language.MustParse("zh-Hant"), 2},
+	}
+	for _, tt := range tests {
+		tag, i := LanguageTag(newNegotiator(tt.accept), supported...)
+		if tag != tt.expected || i != tt.index {
+			t.Errorf("%q: "+testErrorFormat, tt.accept, []interface{}{tag, i}, []interface{}{tt.expected, tt.index})
+		}
+	}
+	if tag, i := LanguageTag(newNegotiator("en")); tag != language.Und || i != -1 {
+		t.Errorf(testErrorFormat, []interface{}{tag, i}, []interface{}{language.Und, -1})
+	}
+}
+
+func TestMatchLanguageTag(t *testing.T) {
+	m := language.NewMatcher([]language.Tag{language.English, language.French, language.German})
+	tests := []struct {
+		accept   string
+		expected language.Base
+		index    int
+	}{
+		{"fr-CA, en;q=0.8", language.MustParseBase("fr"), 1},
+		{"de-CH", language.MustParseBase("de"), 2},
+		{"ja", language.MustParseBase("en"), 0},
+		{"", language.MustParseBase("en"), 0},
+		{"x;y, *, fr;q=0.5", language.MustParseBase("fr"), 1},
+	}
+	for _, tt := range tests {
+		tag, i := MatchLanguageTag(newNegotiator(tt.accept), m)
+		if base, _ := tag.Base(); base != tt.expected || i != tt.index {
+			t.Errorf("%q: "+testErrorFormat, tt.accept, []interface{}{tag, i}, []interface{}{tt.expected, tt.index})
+		}
+	}
+}
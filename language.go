@@ -8,18 +8,56 @@ import (
 	"sort"
 	"strconv"
 	"strings"
-
-	"github.com/dlclark/regexp2"
+	"unicode"
 )
 
-var simpleLanguageRegExp = regexp2.MustCompile("^\\s*([^\\s\\-;]+)(?:-([^\\s;]+))?\\s*(?:;(.*))?$", regexp2.None)
+// The extended language subtags and the primary language subtag each of them
+// must follow. The IANA registry gives every extlang a preferred value equal to
+// the extlang itself, so `zh-yue` is canonicalized to `yue`.
+var extlangPrefixes = map[string]string{
+	// Chinese
+	"cdo": "zh", "cjy": "zh", "cmn": "zh", "cpx": "zh", "czh": "zh",
+	"czo": "zh", "gan": "zh", "hak": "zh", "hsn": "zh", "lzh": "zh",
+	"mnp": "zh", "nan": "zh", "wuu": "zh", "yue": "zh",
+	// Arabic
+	"aao": "ar", "abh": "ar", "abv": "ar", "acm": "ar", "acq": "ar",
+	"acw": "ar", "acx": "ar", "acy": "ar", "adf": "ar", "aeb": "ar",
+	"aec": "ar", "afb": "ar", "ajp": "ar", "apc": "ar", "apd": "ar",
+	"arb": "ar", "arq": "ar", "ars": "ar", "ary": "ar", "arz": "ar",
+	"auz": "ar", "avl": "ar", "ayh": "ar", "ayl": "ar", "ayn": "ar",
+	"ayp": "ar", "pga": "ar", "shu": "ar", "ssh": "ar",
+	// Malay
+	"zlm": "ms", "zsm": "ms", "min": "ms", "mfa": "ms", "jax": "ms",
+	// Swahili
+	"swc": "sw", "swh": "sw",
+	// Uzbek
+	"uzn": "uz", "uzs": "uz",
+}
+
+// The grandfathered tags of the IANA registry, which don't follow the syntax
+// of the other tags, and their preferred value. A tag without a preferred
+// value is atomic: it only matches itself.
+var grandfatheredTags = map[string]string{
+	// irregular
+	"en-gb-oed": "en-GB-oxendict", "i-ami": "ami", "i-bnn": "bnn",
+	"i-default": "", "i-enochian": "", "i-hak": "hak", "i-klingon": "tlh",
+	"i-lux": "lb", "i-mingo": "", "i-navajo": "nv", "i-pwn": "pwn",
+	"i-tao": "tao", "i-tay": "tay", "i-tsu": "tsu", "sgn-be-fr": "sfb",
+	"sgn-be-nl": "vgt", "sgn-ch-de": "sgg",
+	// regular
+	"art-lojban": "jbo", "cel-gaulish": "", "no-bok": "nb", "no-nyn": "nn",
+	"zh-guoyu": "cmn", "zh-hakka": "hak", "zh-min": "", "zh-min-nan": "nan",
+	"zh-xiang": "hsn",
+}
 
 type acceptLanguage struct {
 	prefix string
 	suffix string
 	full   string
-	q      float64
-	i      int
+	// subtags are the subtags of full in order, e.g. sr, Latn and RS.
+	subtags []string
+	q       quality
+	i       int
 }
 
 type acceptLanguages []acceptLanguage
@@ -68,93 +106,644 @@ func (s *acceptLanguageSorter) Less(i, j int) bool {
 
 // PreferredLanguages gets the preferred languages from an Accept-Language header.
 // RFC 2616 sec 14.2: no header = *, so you should pass * if no Accept-Language field in header.
+//
+// The extended language forms, e.g. zh-yue, and the grandfathered tags with a
+// preferred value, e.g. i-klingon, are matched and listed as their preferred
+// value, e.g. yue and tlh. The other grandfathered tags, e.g. i-default, only
+// match themselves.
+//
+// A range matches the provided languages it's equal to, ignoring case, and
+// the ones it's a prefix of at a subtag boundary, the basic filtering of RFC
+// 4647 section 3.3.1, e.g. zh-Hans matches zh-Hans-CN but not zh-Hant. A
+// provided language which is such a prefix of a range matches it too, e.g. de
+// for de-CH, WithPrefixDirection restricts the direction. The most specific
+// range governs a provided language: an equal one, then the longest of its
+// prefixes, then a range it's a prefix of. The quality of the governing range
+// is authoritative, so a range of q=0 refuses the languages it governs even
+// with a wildcard, e.g. "*, de;q=0" refuses de and de-CH, but a range of q=0
+// doesn't refuse its prefixes, e.g. en-US;q=0 doesn't refuse en.
+//
+// The languages are sorted by quality, then by how specifically their range
+// matched them, then in the order of their range in the header, then in the
+// provided order, e.g. with "en;q=0.5, fr;q=0.5" and the provided languages fr
+// and en, en is listed first. The order is total, so it doesn't depend on the
+// order of the provided languages otherwise. Use Negotiate with
+// WithServerPreferenceOrder to break the ties of quality by the provided
+// order instead.
+//
+// WeightedLanguages lists the same languages with the quality of the range
+// governing each of them, the one of * for a language matched by the
+// wildcard, e.g. to fall back to machine translation below some quality.
 func PreferredLanguages(accept string, provided ...string) []string {
+	return preferredLanguages(parseAcceptLanguage(accept), provided)
+}
+
+// PreferredLanguageIndex gets the index in provided of the most preferred
+// language, the first one of PreferredLanguages, or -1 if none is acceptable.
+// Use it to pick from a slice parallel to provided, e.g. of message catalogs,
+// whatever the form of the languages, e.g. " EN-us".
+func PreferredLanguageIndex(accept string, provided ...string) int {
+	i, _ := bestLanguageIndex(accept, provided, languageFilter{})
+	return i
+}
+
+// PreferredLanguagesStrict gets the preferred languages like
+// PreferredLanguages, but drops the ranges which aren't well-formed language
+// tags of BCP 47, e.g. "123-456" or "en_US": the subtags must be 1 to 8
+// letters or digits, the first one 2 to 8 letters unless it's a singleton,
+// e.g. x of x-pirate, and a singleton must be followed by a subtag. The
+// wildcard is kept.
+// Like PreferredMediaTypesStrict, the q parameters must be valid qvalues of
+// RFC 9110 too.
+func PreferredLanguagesStrict(accept string, provided ...string) []string {
+	acs, _ := parseAcceptLanguageErrors(accept, true)
+	return preferredLanguages(acs, provided)
+}
+
+// PreferredLanguagesMinQuality gets the preferred languages like
+// PreferredLanguages, but drops the languages whose quality is below
+// minQuality, e.g. with "en-US, en;q=0.9, fr;q=0.2" and a minimum of 0.5, fr
+// isn't listed. Without provided languages, the ranges below minQuality are
+// dropped. Use Negotiate with WithMinimumQuality and WithDefaultLanguage to
+// fall back to a default language when none reaches the minimum.
+func PreferredLanguagesMinQuality(accept string, minQuality float64, provided ...string) []string {
 	acs := parseAcceptLanguage(accept)
 
+	if len(provided) == 0 {
+		return sortAcceptLanguages(acs).filter(func(ac acceptLanguage) bool {
+			return ac.q.float() >= minQuality
+		}).toLanguages()
+	}
+
+	provided = normalizeOffers(provided)
+
+	return getLanguageSpecificities(provided, acs, languageFilter{}).reaching(minQuality).sorted().values(provided)
+}
+
+func preferredLanguages(acs acceptLanguages, provided []string) []string {
 	if len(provided) == 0 {
 		// sorted list of all languages
-		filteredAcs := acs.filter(isAcceptLanguageQuality)
-		acceptLanguageBy(func(ac1, ac2 *acceptLanguage) bool {
-			if ac1.q != ac2.q {
-				return ac1.q > ac2.q
-			}
-			return ac1.i < ac2.i
-		}).sort(filteredAcs)
-		return filteredAcs.toLanguages()
+		return sortAcceptLanguages(acs).toLanguages()
 	}
 
+	provided = normalizeOffers(provided)
+
 	// sorted list of accepted languages
-	priorities := getLanguageSpecificities(provided, acs)
-	filteredPriorities := priorities.filter(isSpecificityQuality)
-	specificityBy(compareSpecs).sort(filteredPriorities)
+	return getLanguageSpecificities(provided, acs, languageFilter{}).sorted().values(provided)
+}
+
+// PreferredLanguagesWithQuality gets the preferred languages like
+// PreferredLanguages, paired with their quality, see WeightedLanguages, e.g. to
+// fall back to machine translation when the best match is below q=0.3.
+func PreferredLanguagesWithQuality(accept string, provided ...string) []Weighted {
+	return WeightedLanguages(accept, provided...)
+}
+
+// WeightedLanguages gets the preferred languages with their quality and how
+// they matched the header, in the same order as PreferredLanguages. Without
+// provided languages, the acceptable ranges of the header are listed with
+// MatchNone.
+//
+// Use AppendWeightedLanguages to reuse the result slice.
+func WeightedLanguages(accept string, provided ...string) []Weighted {
+	return AppendWeightedLanguages(nil, accept, provided...)
+}
+
+// AppendWeightedLanguages appends the result of WeightedLanguages to dst and returns
+// the extended slice.
+func AppendWeightedLanguages(dst []Weighted, accept string, provided ...string) []Weighted {
+	return appendWeightedLanguages(dst, accept, provided, languageFilter{})
+}
+
+func appendWeightedLanguages(dst []Weighted, accept string, provided []string, f languageFilter) []Weighted {
+	acs := cachedAcceptLanguage(accept)
 
-	results := make([]string, 0, len(filteredPriorities))
-	for _, v := range filteredPriorities {
-		i := priorities.indexOf(v)
-		if i >= 0 {
-			results = append(results, provided[i])
+	if len(provided) == 0 {
+		dst = growWeighted(dst, len(acs))
+		for _, ac := range sortAcceptLanguages(acs) {
+			dst = append(dst, Weighted{Value: ac.full, Quality: ac.q.float(), Match: MatchNone})
+		}
+		return dst
+	}
+
+	provided = normalizeOffers(provided)
+
+	return getLanguageSpecificities(provided, acs, f).sorted().appendWeighted(dst, provided, languageMatchKind)
+}
+
+// LanguageSpecificity tells how a language range matched a language, the
+// classes are finer than the MatchKind of languages.
+type LanguageSpecificity int
+
+const (
+	// LanguageNoMatch means that the language wasn't matched against the header.
+	LanguageNoMatch LanguageSpecificity = iota
+	// LanguageExact means that the range is the language, e.g. en-GB for en-GB.
+	LanguageExact
+	// LanguageRangePrefix means that the range is a prefix of the language,
+	// e.g. en for en-GB, or matched it with the extended filtering, see
+	// WithExtendedFiltering.
+	LanguageRangePrefix
+	// LanguageTagPrefix means that the language is a prefix of the range, e.g.
+	// en for en-GB.
+	LanguageTagPrefix
+	// LanguageWildcard means that only the wildcard * admitted the language.
+	LanguageWildcard
+)
+
+var languageSpecificityNames = []string{"none", "exact", "range-prefix", "tag-prefix", "wildcard"}
+
+func (s LanguageSpecificity) String() string {
+	if s < 0 || int(s) >= len(languageSpecificityNames) {
+		return "LanguageSpecificity(" + strconv.Itoa(int(s)) + ")"
+	}
+	return languageSpecificityNames[s]
+}
+
+// MarshalText encodes the specificity by its name.
+func (s LanguageSpecificity) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// Get the specificity class of the specificity bits of a language, see
+// parsedLanguageSpecificity.
+func languageSpecificityClass(s int) LanguageSpecificity {
+	switch {
+	case s&4 != 0:
+		return LanguageExact
+	case s&2 != 0:
+		return LanguageTagPrefix
+	case s&1 != 0:
+		return LanguageRangePrefix
+	}
+	return LanguageWildcard
+}
+
+// DetailedLanguage is a preferred language with the Accept-Language range it
+// matched.
+type DetailedLanguage struct {
+	Value   string    `json:"value"`
+	Quality float64   `json:"q"`
+	Match   MatchKind `json:"match"`
+	// Range is the matched range, in the form it's matched in, e.g. yue for
+	// zh-yue, see PreferredLanguages.
+	Range       string              `json:"range"`
+	Specificity LanguageSpecificity `json:"specificity"`
+}
+
+// PreferredLanguagesDetailed gets the preferred languages in the same order as
+// PreferredLanguages, with the Accept-Language range governing each one and
+// how it matched, e.g. that en was chosen because en-GB;q=0.9 matched it
+// rather than *. Without provided languages, the acceptable ranges of the
+// header are listed with MatchNone and LanguageNoMatch.
+func PreferredLanguagesDetailed(accept string, provided ...string) []DetailedLanguage {
+	acs := parseAcceptLanguage(accept)
+
+	if len(provided) == 0 {
+		sorted := sortAcceptLanguages(acs)
+		results := make([]DetailedLanguage, len(sorted), len(sorted))
+		for i, ac := range sorted {
+			results[i] = DetailedLanguage{ac.full, ac.q.float(), MatchNone, ac.full, LanguageNoMatch}
 		}
+		return results
+	}
+
+	provided = normalizeOffers(provided)
+
+	sorted := getLanguageSpecificities(provided, acs, languageFilter{}).sorted()
+	results := make([]DetailedLanguage, len(sorted), len(sorted))
+	for i, spec := range sorted {
+		results[i] = DetailedLanguage{provided[spec.i], spec.q.float(), languageMatchKind(spec.s), "", languageSpecificityClass(spec.s)}
+		for _, ac := range acs {
+			if ac.i == spec.o {
+				results[i].Range = ac.full
+				break
+			}
+		}
+	}
+	return results
+}
+
+// Sort the acceptable ranges by quality, then by position.
+func sortAcceptLanguages(acs acceptLanguages) acceptLanguages {
+	filteredAcs := acs.filter(isAcceptLanguageQuality)
+	acceptLanguageBy(func(ac1, ac2 *acceptLanguage) bool {
+		if ac1.q != ac2.q {
+			return ac1.q > ac2.q
+		}
+		return ac1.i < ac2.i
+	}).sort(filteredAcs)
+	return filteredAcs
+}
+
+// LanguageChain gets the gettext-style lookup chain of catalogs from an
+// Accept-Language header. Every acceptable language range is expanded with its
+// truncation fallbacks (de-CH-1996 -> de-CH -> de) in order of quality, each
+// candidate is resolved against the available catalogs, and the chain is
+// terminated with def. Catalogs appear at most once, and a catalog equal to a
+// range of q=0 is never a candidate, e.g. en of "en-US, en;q=0". The catalogs
+// are trimmed of OWS and the empty ones are dropped.
+func LanguageChain(accept string, available []string, def string) []string {
+	parsed := parseAcceptLanguage(accept)
+	acs := sortAcceptLanguages(parsed)
+	available = normalizeOffers(available)
+
+	tags := make([]string, len(available), len(available))
+	for i, v := range available {
+		tags[i] = strings.ToLower(v)
+		if p := parseLanguage(v, i); p != nil {
+			tags[i] = strings.ToLower(p.full)
+		}
+	}
+
+	results, seen := make([]string, 0, len(available)+1), make(map[int]bool)
+	for _, ac := range parsed {
+		if ac.q > 0 {
+			continue
+		}
+		for i, tag := range tags {
+			if tag == strings.ToLower(ac.full) {
+				seen[i] = true
+			}
+		}
+	}
+	for _, ac := range acs {
+		if ac.full == "*" {
+			continue
+		}
+		for _, candidate := range ac.fallbacks() {
+			for i, tag := range tags {
+				if !seen[i] && tag == strings.ToLower(candidate) {
+					seen[i] = true
+					results = append(results, available[i])
+				}
+			}
+		}
+	}
+
+	if def != "" {
+		for _, v := range results {
+			if strings.EqualFold(v, def) {
+				return results
+			}
+		}
+		results = append(results, def)
 	}
 
 	return results
 }
 
+// LookupLanguage gets the single provided language best fitting an
+// Accept-Language header with the lookup scheme of RFC 4647 section 3.4. The
+// ranges are tried in order of quality, each one progressively truncated
+// (zh-Hant-TW -> zh-Hant -> zh) until it equals a provided language, ignoring
+// case, which is returned. The wildcard is skipped, and a provided language
+// equal to a range of q=0 is never returned. defaultTag is returned if no
+// provided language is found.
+func LookupLanguage(accept string, provided []string, defaultTag string) string {
+	acs := cachedAcceptLanguage(accept)
+
+	tags := make([]string, len(provided), len(provided))
+	for i, v := range provided {
+		tags[i] = trimOffer(v)
+		if p := cachedLanguageOffer(tags[i]); p != nil {
+			tags[i] = p.full
+		}
+		for _, ac := range acs {
+			if ac.q == 0 && strings.EqualFold(ac.full, tags[i]) {
+				tags[i] = ""
+				break
+			}
+		}
+	}
+
+	for _, ac := range sortAcceptLanguages(acs) {
+		if ac.full == "*" {
+			continue
+		}
+		for _, candidate := range ac.fallbacks() {
+			for i, tag := range tags {
+				if tag != "" && strings.EqualFold(tag, candidate) {
+					return provided[i]
+				}
+			}
+		}
+	}
+
+	return defaultTag
+}
+
+// Get the truncation fallbacks of a range, the range itself included. A
+// grandfathered tag without a preferred value has none.
+func (ac *acceptLanguage) fallbacks() []string {
+	if len(ac.subtags) == 1 {
+		return []string{ac.full}
+	}
+	return languageFallbacks(ac.full)
+}
+
+// Get the truncation fallbacks of a language tag, the tag itself included.
+// A singleton left at the end by the truncation is removed as well.
+func languageFallbacks(tag string) []string {
+	results := []string{tag}
+	for {
+		index := strings.LastIndex(tag, "-")
+		if index < 0 {
+			return results
+		}
+		tag = tag[:index]
+		if index = strings.LastIndex(tag, "-"); index >= 0 && len(tag)-index == 2 {
+			tag = tag[:index]
+		}
+		results = append(results, tag)
+	}
+}
+
+// LanguageRange is a range of an Accept-Language header.
+type LanguageRange struct {
+	// Tag is the language range in the form it's matched in, e.g. yue for
+	// zh-yue, or the wildcard *.
+	Tag string
+	// Quality is the q parameter, 1 if absent.
+	Quality float64
+	// Position is the zero-based position of the member in the header.
+	Position int
+}
+
+// ParseAcceptLanguages parses an Accept-Language header into its ranges in
+// header order, and reports why members were rejected, e.g. a bad q as in
+// "en;q=", or an empty tag as in ";q=0.5", so that broken clients can be
+// logged. The errors are *ParseError values in header order, empty members
+// aren't reported. PreferredLanguages drops the same members silently.
+func ParseAcceptLanguages(header string) ([]LanguageRange, []error) {
+	return parseAcceptLanguageRanges(header, false)
+}
+
+// ParseAcceptLanguagesStrict parses an Accept-Language header like
+// ParseAcceptLanguages, and rejects the members which PreferredLanguagesStrict
+// drops too, e.g. "en_US" with ErrMalformedLanguageTag or "en;q=2" with
+// ErrInvalidQuality.
+func ParseAcceptLanguagesStrict(header string) ([]LanguageRange, []error) {
+	return parseAcceptLanguageRanges(header, true)
+}
+
+// FormatAcceptLanguage formats ranges back into an Accept-Language header
+// value, the q parameter is omitted when it's 1. ParseAcceptLanguages parses
+// the result back into the same ranges.
+func FormatAcceptLanguage(ranges []LanguageRange) string {
+	var b strings.Builder
+	for i, r := range ranges {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString(r.Tag)
+		writeQualityParameter(&b, r.Quality)
+	}
+	return b.String()
+}
+
+func parseAcceptLanguageRanges(header string, strict bool) ([]LanguageRange, []error) {
+	acs, errs := parseAcceptLanguageErrors(header, strict)
+	ranges := make([]LanguageRange, len(acs), len(acs))
+	for i, ac := range acs {
+		ranges[i] = LanguageRange{ac.full, ac.q.float(), ac.i}
+	}
+	var results []error
+	for _, err := range errs {
+		results = append(results, err)
+	}
+	return ranges, results
+}
+
 // Parses the Accept-Language header to slice with type acceptLanguage.
 func parseAcceptLanguage(accept string) acceptLanguages {
-	accepts := strings.Split(accept, ",")
+	results, _ := parseAcceptLanguageErrors(accept, false)
+	return results
+}
+
+// Parses the Accept-Language header to slice with type acceptLanguage, and
+// reports the members which were dropped. Empty members are skipped silently.
+func parseAcceptLanguageErrors(accept string, strict bool) (acceptLanguages, []*ParseError) {
+	accepts := splitMembers(accept)
 	length := len(accepts)
-	results := make(acceptLanguages, 0, length)
+	results, errs := make(acceptLanguages, 0, length), []*ParseError(nil)
 
 	for i := 0; i < length; i++ {
-		language := parseLanguage(strings.Trim(accepts[i], " "), i)
+		member := strings.Trim(accepts[i], " ")
+		if strings.TrimSpace(member) == "" {
+			continue
+		}
+		language, err := parseLanguageErr(member, i, strict)
 		if language != nil {
 			results = append(results, *language)
+		} else {
+			errs = append(errs, &ParseError{HeaderAcceptLanguage, member, i, err, false})
 		}
 	}
 
-	return results
+	return results, errs
 }
 
 // Parse a language from the Accept-Language header.
 func parseLanguage(s string, i int) *acceptLanguage {
-	match, err := simpleLanguageRegExp.FindStringMatch(s)
-	if match == nil || match.GroupCount() == 0 || err != nil {
-		return nil
+	language, _ := parseLanguageErr(s, i, false)
+	return language
+}
+
+// Parse a language from the Accept-Language header, and report why it's
+// malformed. With strict, the q parameter must be a valid qvalue, see
+// parseStrictQuality, and the range must be a well-formed language tag or the
+// wildcard, see isWellFormedLanguageTag.
+func parseLanguageErr(s string, i int, strict bool) (*acceptLanguage, error) {
+	prefix, suffix, params, ok := scanLanguage(s)
+	if !ok {
+		if strings.HasPrefix(strings.TrimLeftFunc(s, unicode.IsSpace), ";") {
+			return nil, ErrEmptyLanguageTag
+		}
+		return nil, ErrMalformedRange
 	}
 
-	prefix, suffix, q := match.Groups()[1].String(), match.Groups()[2].String(), 1.0
+	var subtags []string
+	if preferred, ok := grandfatheredTags[strings.ToLower(prefix+"-"+suffix)]; ok {
+		if preferred == "" {
+			prefix, suffix = prefix+"-"+suffix, ""
+			subtags = []string{prefix}
+		} else if index := strings.Index(preferred, "-"); index >= 0 {
+			prefix, suffix = preferred[:index], preferred[index+1:]
+		} else {
+			prefix, suffix = preferred, ""
+		}
+	}
+	prefix, suffix = canonicalizeExtlang(prefix, suffix)
 	full := prefix
 	if suffix != "" {
 		full += "-" + suffix
 	}
-	if match.Groups()[3].String() != "" {
-		params := strings.Split(match.Groups()[3].String(), ";")
-		for j := 0; j < len(params); j++ {
-			p := strings.Split(strings.Trim(params[j], " "), "=")
-			if p[0] == "q" {
-				q1, err := strconv.ParseFloat(p[1], 64)
-				if err != nil {
-					return nil
-				}
-				q = q1
-				break
-			}
+	if subtags == nil {
+		subtags = strings.Split(full, "-")
+	}
+	if strict && full != "*" && !isWellFormedLanguageTag(full) {
+		return nil, ErrMalformedLanguageTag
+	}
+	parse := parseQuality
+	if strict {
+		parse = parseStrictQuality
+	}
+	q, err := parseQualityParameter(params, parse)
+	if err != nil {
+		return nil, err
+	}
+
+	return &acceptLanguage{prefix, suffix, full, subtags, q, i}, nil
+}
+
+// Scan a member of the Accept-Language header: the primary subtag, the other
+// subtags after the first -, and the parameters after the first ;, e.g. en,
+// US-x-a and q=0.5 for "en-US-x-a;q=0.5". The tag may be surrounded by
+// whitespace but not contain any, and neither the primary subtag nor the
+// other subtags may be empty.
+func scanLanguage(s string) (prefix, suffix, params string, ok bool) {
+	s = strings.TrimLeftFunc(s, unicode.IsSpace)
+	end := strings.IndexFunc(s, isLanguageTagEnd)
+	if end < 0 {
+		end = len(s)
+	}
+	tag, rest := s[:end], strings.TrimLeftFunc(s[end:], unicode.IsSpace)
+	if rest != "" && rest[0] != ';' {
+		return "", "", "", false
+	}
+	if rest != "" {
+		params = rest[1:]
+	}
+
+	prefix = tag
+	if index := strings.IndexByte(tag, '-'); index >= 0 {
+		prefix, suffix = tag[:index], tag[index+1:]
+		if suffix == "" {
+			return "", "", "", false
 		}
 	}
+	if prefix == "" {
+		return "", "", "", false
+	}
+	return prefix, suffix, params, true
+}
+
+func isLanguageTagEnd(r rune) bool {
+	return r == ';' || unicode.IsSpace(r)
+}
+
+// Replace an extended language form such as `zh-yue-HK` with its preferred
+// value `yue-HK`, so that matching only sees the canonical primary subtag.
+func canonicalizeExtlang(prefix, suffix string) (string, string) {
+	if suffix == "" {
+		return prefix, suffix
+	}
 
-	return &acceptLanguage{prefix, suffix, full, q, i}
+	extlang, rest := suffix, ""
+	if index := strings.Index(suffix, "-"); index >= 0 {
+		extlang, rest = suffix[:index], suffix[index+1:]
+	}
+
+	p, ok := extlangPrefixes[strings.ToLower(extlang)]
+	if !ok || p != strings.ToLower(prefix) {
+		return prefix, suffix
+	}
+
+	return extlang, rest
+}
+
+// CanonicalLanguageTag formats a language tag with the casing conventions of
+// BCP 47: lowercase language, title-case script, uppercase region, and
+// lowercase everything after a singleton, e.g. zh-hant-tw is zh-Hant-TW. The
+// languages are matched ignoring case, so use it to normalize the results for
+// logs or the Content-Language header.
+func CanonicalLanguageTag(tag string) string {
+	subtags := strings.Split(strings.ToLower(tag), "-")
+	for i := 1; i < len(subtags); i++ {
+		v := subtags[i]
+		if isSingleton(v) {
+			break
+		}
+		if len(v) == 2 && isAlpha(v) {
+			subtags[i] = strings.ToUpper(v)
+		} else if len(v) == 4 && isAlpha(v) {
+			subtags[i] = strings.ToUpper(v[:1]) + v[1:]
+		}
+	}
+	return strings.Join(subtags, "-")
+}
+
+// Report whether a language tag is well-formed: subtags of 1 to 8
+// alphanumeric characters, an alphabetic primary subtag, and a singleton
+// always followed by another subtag.
+func isWellFormedLanguageTag(tag string) bool {
+	subtags := strings.Split(tag, "-")
+	if !isAlpha(subtags[0]) || len(subtags[0]) == 0 || len(subtags[0]) > 8 {
+		return false
+	}
+	for i, v := range subtags {
+		if len(v) == 0 || len(v) > 8 || !isAlphanumeric(v) {
+			return false
+		}
+		if isSingleton(v) && i == len(subtags)-1 {
+			return false
+		}
+	}
+	return len(subtags[0]) > 1 || len(subtags) > 1 && isSingleton(subtags[0])
+}
+
+func isSingleton(s string) bool {
+	return len(s) == 1
+}
+
+func isAlpha(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if c := s[i] | 0x20; c < 'a' || c > 'z' {
+			return false
+		}
+	}
+	return true
+}
+
+func isAlphanumeric(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; (c < '0' || c > '9') && !isAlpha(s[i:i+1]) {
+			return false
+		}
+	}
+	return true
 }
 
 // Get the priority of a language.
 func getLanguagePriority(language string, acs acceptLanguages, index int) specificity {
+	return getParsedLanguagePriority(cachedLanguageOffer(language), acs, nil, index, languageFilter{})
+}
+
+// Get the priority of a parsed language over the ranges at indices, or over all
+// ranges if indices is nil.
+func getParsedLanguagePriority(p *acceptLanguage, acs acceptLanguages, indices []int, index int, f languageFilter) specificity {
 	priority := specificity{o: -1, q: 0, s: 0}
+	if p == nil {
+		return priority
+	}
 
-	for i := 0; i < len(acs); i++ {
-		spec := languageSpecify(language, acs[i], index)
-		if spec != nil {
-			s, q, o := priority.s-spec.s, priority.q-spec.q, priority.o-spec.o
-			if s < 0 || q < 0 || o < 0 {
-				priority = *spec
+	prefixLen := 0
+	for j, n := 0, rangeCount(indices, len(acs)); j < n; j++ {
+		ac := &acs[rangeIndex(indices, j)]
+		if spec, ok := parsedLanguageSpecificity(p, *ac, index, f); ok {
+			if spec.s == 2 && spec.q == 0 {
+				// refusing a more specific tag doesn't refuse the language,
+				// e.g. en-US;q=0 refuses en-US but not en
+				continue
+			}
+			if spec.s == 1 && priority.s == 1 && len(ac.subtags) != prefixLen {
+				// the longest prefix of the language is the most specific
+				if len(ac.subtags) > prefixLen {
+					priority, prefixLen = spec, len(ac.subtags)
+				}
+			} else if spec.governs(priority) {
+				priority, prefixLen = spec, len(ac.subtags)
 			}
 		}
 	}
@@ -168,28 +757,136 @@ func languageSpecify(language string, ac acceptLanguage, index int) *specificity
 	if p == nil {
 		return nil
 	}
+	if spec, ok := parsedLanguageSpecificity(p, ac, index, languageFilter{}); ok {
+		return &spec
+	}
+	return nil
+}
 
-	s := 0
-	if strings.ToLower(ac.full) == strings.ToLower(p.full) {
+// Get the specificity of the parsed language, ok is false if the range doesn't
+// match. The subtags are compared in order, ignoring case, and the specificity
+// bits are 4 if they're all equal, 2 if the subtags of the language are the
+// leading subtags of the range and 1 if the subtags of the range are the
+// leading subtags of the language. A private-use sequence, following the x
+// singleton, is compared as a whole, so en-x-a is a prefix of neither
+// en-x-a-b nor en-x-a-b of it, while en is a prefix of en-x-a.
+func parsedLanguageSpecificity(p *acceptLanguage, ac acceptLanguage, index int, f languageFilter) (spec specificity, ok bool) {
+	s, n := 0, commonSubtags(ac.subtags, p.subtags)
+	switch {
+	case n == len(ac.subtags) && n == len(p.subtags):
 		s |= 4
-	} else if strings.ToLower(ac.prefix) == strings.ToLower(p.full) {
+	case n == len(p.subtags) && !hasPrivateUse(p.subtags) && f.direction != PrefixAcceptOnly:
 		s |= 2
-	} else if strings.ToLower(ac.full) == strings.ToLower(p.prefix) {
+	case n == len(ac.subtags) && !hasPrivateUse(ac.subtags) && f.direction != PrefixProvidedOnly:
 		s |= 1
-	} else if ac.full != "*" {
-		return nil
+	case len(ac.subtags) > 1 && f.extended && !hasPrivateUse(ac.subtags) &&
+		f.direction != PrefixProvidedOnly && extendedMatch(ac.subtags, p.subtags):
+		s |= 1
+	case ac.full != "*":
+		return spec, false
 	}
-	return &specificity{index, ac.i, ac.q, s}
+	return specificity{index, ac.i, ac.q, s}, true
+}
+
+// PrefixDirection selects which of the prefix matches between a language
+// range and a provided language are allowed, see WithPrefixDirection. An equal
+// range and the wildcard always match.
+type PrefixDirection int
+
+const (
+	// PrefixBoth allows a range to match the provided languages it's a prefix
+	// of, and the ones which are a prefix of it, e.g. en matches en-US and
+	// en-US matches en. It's the default.
+	PrefixBoth PrefixDirection = iota
+	// PrefixAcceptOnly only allows a range to match the provided languages it's
+	// a prefix of, e.g. en matches en-US but en-US doesn't match en, which is
+	// the basic filtering of RFC 4647.
+	PrefixAcceptOnly
+	// PrefixProvidedOnly only allows a range to match the provided languages
+	// which are a prefix of it, e.g. en-US matches en but en doesn't match
+	// en-US, like a lookup falling back to a more general language.
+	PrefixProvidedOnly
+)
+
+// languageFilter is how the language ranges match the provided languages, the
+// zero value is the default of the package functions.
+type languageFilter struct {
+	direction PrefixDirection
+	extended  bool
+}
+
+// Reports whether the subtags of an extended language range match the subtags
+// of a language with the algorithm of RFC 4647 section 3.3.2: the first
+// subtags must be equal, then each subtag of the range other than a wildcard
+// must be found in order in the language, without skipping a singleton.
+func extendedMatch(subtags, language []string) bool {
+	if len(language) == 0 || subtags[0] != "*" && !strings.EqualFold(subtags[0], language[0]) {
+		return false
+	}
+	i := 1
+	for _, v := range subtags[1:] {
+		if v == "*" {
+			continue
+		}
+		for {
+			if i == len(language) {
+				return false
+			}
+			if strings.EqualFold(v, language[i]) {
+				i++
+				break
+			}
+			if isSingleton(language[i]) {
+				return false
+			}
+			i++
+		}
+	}
+	return true
+}
+
+// Reports whether the subtags have a private-use sequence, i.e. the x
+// singleton.
+func hasPrivateUse(subtags []string) bool {
+	for _, v := range subtags {
+		if v == "x" || v == "X" {
+			return true
+		}
+	}
+	return false
+}
+
+// Get the number of leading subtags which are equal, ignoring case.
+func commonSubtags(subtags1, subtags2 []string) int {
+	n := 0
+	for n < len(subtags1) && n < len(subtags2) && strings.EqualFold(subtags1[n], subtags2[n]) {
+		n++
+	}
+	return n
 }
 
 func isAcceptLanguageQuality(ac acceptLanguage) bool {
 	return ac.q > 0
 }
 
-func getLanguageSpecificities(types []string, acs acceptLanguages) specificities {
+func getLanguageSpecificities(types []string, acs acceptLanguages, f languageFilter) specificities {
 	result := make(specificities, len(types), len(types))
+	if len(types) < offerBucketThreshold {
+		for i, v := range types {
+			result[i] = getParsedLanguagePriority(cachedLanguageOffer(v), acs, nil, i, f)
+		}
+		return result
+	}
+
+	buckets := newRangeBuckets(len(acs), func(i int) (string, bool) {
+		return strings.ToLower(acs[i].prefix), acs[i].prefix == "*"
+	})
 	for i, v := range types {
-		result[i] = getLanguagePriority(v, acs, i)
+		p, indices := cachedLanguageOffer(v), []int(nil)
+		if p != nil {
+			indices = buckets.get(strings.ToLower(p.prefix))
+		}
+		result[i] = getParsedLanguagePriority(p, acs, indices, i, f)
 	}
 	return result
 }
@@ -5,11 +5,20 @@
 package negotiator
 
 import (
+	"encoding/json"
+	"net/http"
 	"reflect"
+	"strconv"
+	"strings"
 	"testing"
 )
 
 var preferredLanguageTestObjs = []testObj{
+	{
+		"*, en;q=0",
+		[]string{"en", "de"},
+		[]string{"de"},
+	},
 	{
 		"zh",
 		nil,
@@ -135,6 +144,141 @@ var preferredLanguageTestObjs = []testObj{
 		[]string{"zh"},
 		[]string{"zh"},
 	},
+	{
+		"zh-yue",
+		[]string{"zh", "yue-Hant-HK"},
+		[]string{"yue-Hant-HK"},
+	},
+	{
+		"yue",
+		[]string{"zh", "zh-yue"},
+		[]string{"zh-yue"},
+	},
+	{
+		"zh",
+		[]string{"yue-Hant-HK", "zh-yue", "zh-CN"},
+		[]string{"zh-CN"},
+	},
+	{
+		"zh-cmn-Hans-CN, zh;q=0.5",
+		[]string{"zh-TW", "cmn-Hans-CN"},
+		[]string{"cmn-Hans-CN", "zh-TW"},
+	},
+	{
+		"cmn",
+		[]string{"zh-cmn", "zh-Hans"},
+		[]string{"zh-cmn"},
+	},
+	{
+		"ar-afb, ar;q=0.8",
+		[]string{"ar", "afb"},
+		[]string{"afb", "ar"},
+	},
+	{
+		"ar",
+		[]string{"ar-afb"},
+		[]string{},
+	},
+	{
+		"zh-yue-HK, ar-afb;q=0.8",
+		nil,
+		[]string{"yue-HK", "afb"},
+	},
+	{
+		"fr;Q=0.5, en",
+		[]string{"fr", "en"},
+		[]string{"en", "fr"},
+	},
+	{
+		"i-default",
+		[]string{"i-whatever", "i-default", "i"},
+		[]string{"i-default"},
+	},
+	{
+		"i",
+		[]string{"i-default", "i-klingon"},
+		[]string{},
+	},
+	{
+		"i-klingon, tlh-x-a;q=0.5",
+		[]string{"tlh"},
+		[]string{"tlh"},
+	},
+	{
+		"tlh",
+		[]string{"i-default", "i-klingon"},
+		[]string{"i-klingon"},
+	},
+	{
+		"zh",
+		[]string{"zh-min", "zh-min-nan", "zh-guoyu", "zh-TW"},
+		[]string{"zh-TW"},
+	},
+	{
+		"i-klingon, zh-min",
+		nil,
+		[]string{"tlh", "zh-min"},
+	},
+	{
+		"x-pirate, en;q=0.5",
+		[]string{"en-x-corporate", "x-pirate-arr", "x-pirate"},
+		[]string{"x-pirate", "en-x-corporate"},
+	},
+	{
+		"*, de;q=0",
+		[]string{"de", "en"},
+		[]string{"en"},
+	},
+	{
+		"*, de;q=0",
+		[]string{"de-CH", "en", "de"},
+		[]string{"en"},
+	},
+	{
+		"*, en-US;q=0",
+		[]string{"en-US", "en"},
+		[]string{"en"},
+	},
+	{
+		"en-US;q=0, en",
+		[]string{"en-US", "en", "en-GB"},
+		[]string{"en", "en-GB"},
+	},
+	{
+		"*;q=0.5, en-US;q=0, en",
+		[]string{"fr", "en-US", "en"},
+		[]string{"en", "fr"},
+	},
+	{
+		"zh-Hans",
+		[]string{"zh-Hant-TW", "zh-Hans-CN", "zh"},
+		[]string{"zh", "zh-Hans-CN"},
+	},
+	{
+		"de-CH",
+		[]string{"de-CH-1996", "de-CHX", "de"},
+		[]string{"de", "de-CH-1996"},
+	},
+	{
+		"zh-Hans-CN",
+		[]string{"zh-Hans", "zh-Hant", "zh"},
+		[]string{"zh-Hans", "zh"},
+	},
+	{
+		"zh;q=0.5, zh-Hans;q=0.8",
+		[]string{"zh-TW", "zh-Hans-CN"},
+		[]string{"zh-Hans-CN", "zh-TW"},
+	},
+	{
+		"zh-Hans;q=0, zh",
+		[]string{"zh-Hans-CN", "zh-Hant-TW"},
+		[]string{"zh-Hant-TW"},
+	},
+	{
+		"zh, zh-Hans;q=0.2",
+		[]string{"zh-Hans-CN", "zh-Hant-TW"},
+		[]string{"zh-Hant-TW", "zh-Hans-CN"},
+	},
 }
 
 func TestPreferredLanguages(t *testing.T) {
@@ -145,26 +289,138 @@ func TestPreferredLanguages(t *testing.T) {
 	}
 }
 
+func TestFormatAcceptLanguage(t *testing.T) {
+	tests := []struct {
+		s        string
+		expected string
+	}{
+		{"", ""},
+		{"en;q=0.8, , de-CH", "en;q=0.8, de-CH"},
+		{"zh-yue, *;q=0", "yue, *;q=0"},
+		{";q=0.5, fr;q=0.0004", "fr;q=0"},
+	}
+	for _, tt := range tests {
+		ranges, _ := ParseAcceptLanguages(tt.s)
+		if got := FormatAcceptLanguage(ranges); got != tt.expected {
+			t.Errorf(testErrorFormat, got, tt.expected)
+		}
+	}
+}
+
+func TestLanguageChain(t *testing.T) {
+	tests := []struct {
+		accept    string
+		available []string
+		def       string
+		expected  []string
+	}{
+		{"de-CH", []string{"en", "de", "de-CH"}, "en", []string{"de-CH", "de", "en"}},
+		{"de-CH", []string{"en", "de"}, "en", []string{"de", "en"}},
+		{"de-CH, fr;q=0.8", []string{"fr", "de"}, "en", []string{"de", "fr", "en"}},
+		{"fr;q=0.8, de-CH", []string{"fr", "de"}, "", []string{"de", "fr"}},
+		{"de-CH, de;q=0.5", []string{"de"}, "de", []string{"de"}},
+		{"DE-ch", []string{"de-CH", "De"}, "en", []string{"de-CH", "De", "en"}},
+		{"zh-Hant-TW", []string{"zh", "zh-Hant"}, "en", []string{"zh-Hant", "zh", "en"}},
+		{"en-a-bbb-x-private", []string{"en-a-bbb", "en"}, "", []string{"en-a-bbb", "en"}},
+		{"zh-yue-HK", []string{"yue", "zh"}, "en", []string{"yue", "en"}},
+		{"de;q=0, fr", []string{"de", "fr"}, "en", []string{"fr", "en"}},
+		{"en-US, en;q=0", []string{"en", "en-US"}, "", []string{"en-US"}},
+		{"en;q=0, en-GB-oxendict, fr;q=0.5", []string{"fr", "en", "en-GB"}, "en", []string{"en-GB", "fr", "en"}},
+		{"de-CH-1996, de-CH;q=0", []string{"de", "de-CH", "DE-ch-1996"}, "", []string{"DE-ch-1996", "de"}},
+		{"de-CH", []string{" de", "en", ""}, "en", []string{"de", "en"}},
+		{"*", []string{"de", "fr"}, "en", []string{"en"}},
+		{"", []string{"de", "fr"}, "en", []string{"en"}},
+		{"", []string{"de", "fr"}, "", []string{}},
+	}
+	for _, tt := range tests {
+		if got := LanguageChain(tt.accept, tt.available, tt.def); !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf(testErrorFormat, got, tt.expected)
+		}
+	}
+}
+
+func TestPreferredLanguagesStrict(t *testing.T) {
+	tests := []struct {
+		accept   string
+		provided []string
+		expected []string
+	}{
+		{"123-456, en;q=0.5", []string{"en", "123-456"}, []string{"en"}},
+		{"th1s-isnt-a-language!!, fr", []string{"fr"}, []string{"fr"}},
+		{"en_US, de-CH-1996;q=0.8", []string{"de", "en_US"}, []string{"de"}},
+		{"verylonglanguage, x-pirate, i-default;q=0.5", nil, []string{"x-pirate", "i-default"}},
+		{"en-, en--US, en-x, e", nil, []string{}},
+		{"zh-yue-HK, *;q=0.1", []string{"fr", "yue-HK"}, []string{"yue-HK", "fr"}},
+		{"fr;q=2, en;q=0.5", []string{"fr", "en"}, []string{"en"}},
+		{"fr;q=0.87654, en;q=0.877", []string{"fr", "en"}, []string{"en", "fr"}},
+	}
+	for _, tt := range tests {
+		if got := PreferredLanguagesStrict(tt.accept, tt.provided...); !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("%q: "+testErrorFormat, tt.accept, got, tt.expected)
+		}
+	}
+
+	lenient := PreferredLanguages("123-456, en;q=0.5")
+	if expected := []string{"123-456", "en"}; !reflect.DeepEqual(lenient, expected) {
+		t.Errorf(testErrorFormat, lenient, expected)
+	}
+	_, errs := parseAcceptLanguageErrors("en, 123, fr;q=2", true)
+	if len(errs) != 2 || errs[0].Err != ErrMalformedLanguageTag || errs[0].Position != 1 || errs[1].Err != ErrInvalidQuality {
+		t.Errorf(testErrorFormat, errs, []error{ErrMalformedLanguageTag, ErrInvalidQuality})
+	}
+}
+
+func TestLookupLanguage(t *testing.T) {
+	tests := []struct {
+		accept     string
+		provided   []string
+		defaultTag string
+		expected   string
+	}{
+		{"fr-CA", []string{"fr", "en"}, "en", "fr"},
+		{"zh-Hant-TW", []string{"zh", "zh-Hant"}, "en", "zh-Hant"},
+		{"zh-Hant-TW", []string{"zh-Hans", "zh"}, "en", "zh"},
+		{"de-CH-1996", []string{"de-CH", "de"}, "", "de-CH"},
+		{"en-a-bbb-x-private", []string{"en", "en-a-bbb"}, "", "en-a-bbb"},
+		{"fr-CA, en;q=0.9", []string{"en", "fr"}, "", "fr"},
+		{"fr-CA;q=0.5, en", []string{"fr", "en-US"}, "", "fr"},
+		{"en-US", []string{"en-GB"}, "de", "de"},
+		{"DE-ch", []string{" de "}, "", " de "},
+		{"zh-yue-HK", []string{"zh", "yue"}, "", "yue"},
+		{"de;q=0, de-CH", []string{"de", "fr"}, "fr", "fr"},
+		{"*", []string{"de", "fr"}, "en", "en"},
+		{"", []string{"de", "fr"}, "en", "en"},
+		{"de", nil, "", ""},
+		{"i-klingon", []string{"en", "tlh"}, "en", "tlh"},
+		{"i-default", []string{"i", "en"}, "en", "en"},
+	}
+	for _, tt := range tests {
+		if got := LookupLanguage(tt.accept, tt.provided, tt.defaultTag); got != tt.expected {
+			t.Errorf("%q: "+testErrorFormat, tt.accept, got, tt.expected)
+		}
+	}
+}
+
 func TestParseAcceptLanguage(t *testing.T) {
 	tests := []struct {
 		s        string
 		expected acceptLanguages
 	}{
-		{"zh", acceptLanguages{{"zh", "", "zh", 1, 0}}},
+		{"zh", acceptLanguages{{"zh", "", "zh", []string{"zh"}, 1000, 0}}},
 		{
 			"zh, en;q=0.8, fr;q=0.6",
 			acceptLanguages{
-				{"zh", "", "zh", 1, 0},
-				{"en", "", "en", .8, 1},
-				{"fr", "", "fr", .6, 2},
+				{"zh", "", "zh", []string{"zh"}, 1000, 0},
+				{"en", "", "en", []string{"en"}, 800, 1},
+				{"fr", "", "fr", []string{"fr"}, 600, 2},
 			},
 		},
 		{
 			"zh-CN, en-US;q=0.8, fr;q=0.6",
 			acceptLanguages{
-				{"zh", "CN", "zh-CN", 1, 0},
-				{"en", "US", "en-US", .8, 1},
-				{"fr", "", "fr", .6, 2},
+				{"zh", "CN", "zh-CN", []string{"zh", "CN"}, 1000, 0},
+				{"en", "US", "en-US", []string{"en", "US"}, 800, 1},
+				{"fr", "", "fr", []string{"fr"}, 600, 2},
 			},
 		},
 	}
@@ -181,12 +437,32 @@ func TestParseLanguage(t *testing.T) {
 		i        int
 		expected *acceptLanguage
 	}{
-		{"zh", 0, &acceptLanguage{"zh", "", "zh", 1, 0}},
-		{"zh-CN", 1, &acceptLanguage{"zh", "CN", "zh-CN", 1, 1}},
-		{"zh-CN;q=0.8", 2, &acceptLanguage{"zh", "CN", "zh-CN", .8, 2}},
-		{"en;q=0.8", 3, &acceptLanguage{"en", "", "en", .8, 3}},
-		{" en ; q=0.2 ", 4, &acceptLanguage{"en", "", "en", .2, 4}},
+		{"zh", 0, &acceptLanguage{"zh", "", "zh", []string{"zh"}, 1000, 0}},
+		{"zh-CN", 1, &acceptLanguage{"zh", "CN", "zh-CN", []string{"zh", "CN"}, 1000, 1}},
+		{"zh-CN;q=0.8", 2, &acceptLanguage{"zh", "CN", "zh-CN", []string{"zh", "CN"}, 800, 2}},
+		{"en;q=0.8", 3, &acceptLanguage{"en", "", "en", []string{"en"}, 800, 3}},
+		{" en ; q=0.2 ", 4, &acceptLanguage{"en", "", "en", []string{"en"}, 200, 4}},
 		{"en;q=x", 5, nil},
+		{"zh-yue", 6, &acceptLanguage{"yue", "", "yue", []string{"yue"}, 1000, 6}},
+		{"zh-yue-HK;q=0.5", 7, &acceptLanguage{"yue", "HK", "yue-HK", []string{"yue", "HK"}, 500, 7}},
+		{"ZH-Cmn-Hans", 8, &acceptLanguage{"Cmn", "Hans", "Cmn-Hans", []string{"Cmn", "Hans"}, 1000, 8}},
+		{"ar-afb", 9, &acceptLanguage{"afb", "", "afb", []string{"afb"}, 1000, 9}},
+		{"en-yue", 10, &acceptLanguage{"en", "yue", "en-yue", []string{"en", "yue"}, 1000, 10}},
+		{"en;q", 11, nil},
+		{"i-klingon", 12, &acceptLanguage{"tlh", "", "tlh", []string{"tlh"}, 1000, 12}},
+		{"I-Default;q=0.5", 13, &acceptLanguage{"I-Default", "", "I-Default", []string{"I-Default"}, 500, 13}},
+		{"en-GB-oed", 14, &acceptLanguage{"en", "GB-oxendict", "en-GB-oxendict", []string{"en", "GB", "oxendict"}, 1000, 14}},
+		{"zh-min-nan", 15, &acceptLanguage{"nan", "", "nan", []string{"nan"}, 1000, 15}},
+		{"zh-min", 16, &acceptLanguage{"zh-min", "", "zh-min", []string{"zh-min"}, 1000, 16}},
+		{"\ten-US\t;level=1;Q=0.5", 17, &acceptLanguage{"en", "US", "en-US", []string{"en", "US"}, 500, 17}},
+		{"en-US-", 18, &acceptLanguage{"en", "US-", "en-US-", []string{"en", "US", ""}, 1000, 18}},
+		{"en;", 19, &acceptLanguage{"en", "", "en", []string{"en"}, 1000, 19}},
+		{"en-", 20, nil},
+		{"-en", 21, nil},
+		{"en US", 22, nil},
+		{"en-US x;q=1", 23, nil},
+		{";q=1", 24, nil},
+		{"", 25, nil},
 	}
 	for _, tt := range tests {
 		got := parseLanguage(tt.s, tt.i)
@@ -198,12 +474,12 @@ func TestParseLanguage(t *testing.T) {
 
 func TestGetLanguagePriority(t *testing.T) {
 	acs := acceptLanguages{
-		{"zh", "", "zh", 1, 0},
-		{"en", "", "en", .8, 1},
+		{"zh", "", "zh", []string{"zh"}, 1000, 0},
+		{"en", "", "en", []string{"en"}, 800, 1},
 	}
 	acs2 := acceptLanguages{
-		{"zh", "CN", "zh-CN", 1, 0},
-		{"en", "US", "en-US", .8, 1},
+		{"zh", "CN", "zh-CN", []string{"zh", "CN"}, 1000, 0},
+		{"en", "US", "en-US", []string{"en", "US"}, 800, 1},
 	}
 	tests := []struct {
 		language string
@@ -212,13 +488,13 @@ func TestGetLanguagePriority(t *testing.T) {
 		expected specificity
 	}{
 		{"zh", acceptLanguages{}, 0, specificity{0, -1, 0, 0}},
-		{"en", acs, 1, specificity{1, 1, 0.8, 4}},
-		{"zh-CN", acs, 2, specificity{2, 0, 1, 1}},
-		{"en-US", acs, 3, specificity{3, 1, 0.8, 1}},
-		{"zh", acs2, 0, specificity{0, 0, 1, 2}},
-		{"en", acs2, 1, specificity{1, 1, 0.8, 2}},
-		{"zh-CN", acs2, 2, specificity{2, 0, 1, 4}},
-		{"en-US", acs2, 3, specificity{3, 1, 0.8, 4}},
+		{"en", acs, 1, specificity{1, 1, 800, 4}},
+		{"zh-CN", acs, 2, specificity{2, 0, 1000, 1}},
+		{"en-US", acs, 3, specificity{3, 1, 800, 1}},
+		{"zh", acs2, 0, specificity{0, 0, 1000, 2}},
+		{"en", acs2, 1, specificity{1, 1, 800, 2}},
+		{"zh-CN", acs2, 2, specificity{2, 0, 1000, 4}},
+		{"en-US", acs2, 3, specificity{3, 1, 800, 4}},
 	}
 	for _, tt := range tests {
 		got := getLanguagePriority(tt.language, tt.acs, tt.index)
@@ -237,49 +513,49 @@ func TestLanguageSpecify(t *testing.T) {
 	}{
 		{
 			"zh",
-			acceptLanguage{"zh", "", "zh", 1, 0},
+			acceptLanguage{"zh", "", "zh", []string{"zh"}, 1000, 0},
 			0,
-			&specificity{0, 0, 1, 4},
+			&specificity{0, 0, 1000, 4},
 		},
 		{
 			"zh-CN",
-			acceptLanguage{"zh", "CN", "zh-CN", .8, 1},
+			acceptLanguage{"zh", "CN", "zh-CN", []string{"zh", "CN"}, 800, 1},
 			1,
-			&specificity{1, 1, .8, 4},
+			&specificity{1, 1, 800, 4},
 		},
 		{
 			"en",
-			acceptLanguage{"en", "", "en", .2, 2},
+			acceptLanguage{"en", "", "en", []string{"en"}, 200, 2},
 			2,
-			&specificity{2, 2, .2, 4},
+			&specificity{2, 2, 200, 4},
 		},
 		{
 			"en-US",
-			acceptLanguage{"en", "US", "en-US", .3, 3},
+			acceptLanguage{"en", "US", "en-US", []string{"en", "US"}, 300, 3},
 			3,
-			&specificity{3, 3, .3, 4},
+			&specificity{3, 3, 300, 4},
 		},
 		{
 			"fr",
-			acceptLanguage{"*", "", "*", .4, 4},
+			acceptLanguage{"*", "", "*", []string{"*"}, 400, 4},
 			4,
-			&specificity{4, 4, .4, 0},
+			&specificity{4, 4, 400, 0},
 		},
 		{
 			"*",
-			acceptLanguage{"fr", "", "fr", .5, 5},
+			acceptLanguage{"fr", "", "fr", []string{"fr"}, 500, 5},
 			5,
 			nil,
 		},
 		{
 			"*",
-			acceptLanguage{"*", "", "*", .6, 6},
+			acceptLanguage{"*", "", "*", []string{"*"}, 600, 6},
 			6,
-			&specificity{6, 6, .6, 4},
+			&specificity{6, 6, 600, 4},
 		},
 		{
 			"",
-			acceptLanguage{"*", "", "*", .6, 6},
+			acceptLanguage{"*", "", "*", []string{"*"}, 600, 6},
 			7,
 			nil,
 		},
@@ -292,6 +568,469 @@ func TestLanguageSpecify(t *testing.T) {
 	}
 }
 
+func TestLanguageSpecify_Subtags(t *testing.T) {
+	tests := []struct {
+		accept   string
+		language string
+		expected int
+	}{
+		// script only
+		{"sr-Latn", "sr-Latn", 4},
+		{"sr-Latn", "sr-LATN", 4},
+		{"sr-Latn", "sr", 2},
+		{"sr", "sr-Latn", 1},
+		{"sr-Latn", "sr-Cyrl", -1},
+		{"sr-Lat", "sr-Latn", -1},
+		// region only
+		{"sr-RS", "sr-RS", 4},
+		{"sr-RS", "sr", 2},
+		{"sr", "sr-RS", 1},
+		{"sr-RS", "sr-ME", -1},
+		{"es-419", "es-419", 4},
+		{"es", "es-419", 1},
+		// script and region
+		{"sr-Latn-RS", "sr-Latn-RS", 4},
+		{"sr-Latn-RS", "sr-Latn", 2},
+		{"sr-Latn-RS", "sr", 2},
+		{"sr-Latn", "sr-Latn-RS", 1},
+		{"sr", "sr-Latn-RS", 1},
+		{"sr-Latn-RS", "sr-RS", -1},
+		{"sr-RS", "sr-Latn-RS", -1},
+		{"sr-Latn-RS", "sr-Cyrl-RS", -1},
+		// variants and extensions
+		{"de-CH-1996", "de-CH", 2},
+		{"de-CH", "de-CH-1996", 1},
+		{"de-1996", "de-CH-1996", -1},
+		{"en-a-bbb", "en-a-bbb-x-private", 1},
+		// private use
+		{"x-pirate", "x-pirate", 4},
+		{"X-Pirate", "x-pirate", 4},
+		{"x-pirate", "x-pirate-arr", -1},
+		{"x-pirate-arr", "x-pirate", -1},
+		{"x-pirate", "x-ninja", -1},
+		{"en", "en-x-corporate", 1},
+		{"en-x-corporate", "en", 2},
+		{"en-x-corporate", "en-x-corporate", 4},
+		{"en-x-corporate", "en-x-corporate-legal", -1},
+		{"en-x-corporate-legal", "en-x-corporate", -1},
+		{"en-US", "en-US-x-corporate", 1},
+		{"en-x-corporate", "en-US-x-corporate", -1},
+		// the wildcard
+		{"*", "sr-Latn-RS", 0},
+		{"*", "x-pirate", 0},
+	}
+	for _, tt := range tests {
+		ac := parseLanguage(tt.accept, 0)
+		got := -1
+		if spec := languageSpecify(tt.language, *ac, 0); spec != nil {
+			got = spec.s
+		}
+		if got != tt.expected {
+			t.Errorf("%q for %q: "+testErrorFormat, tt.accept, tt.language, got, tt.expected)
+		}
+	}
+}
+
+func TestGetLanguagePriority_LongestPrefix(t *testing.T) {
+	acs := parseAcceptLanguage("sr;q=0.2, sr-Latn;q=0.8, sr-Latn-RS-u-ca;q=0.5")
+	tests := []struct {
+		language string
+		expected specificity
+	}{
+		{"sr-Latn-ME", specificity{0, 1, 800, 1}},
+		{"sr-Cyrl-RS", specificity{0, 0, 200, 1}},
+		{"sr-Latn", specificity{0, 1, 800, 4}},
+		{"sr-Latn-RS-u-ca-buddhist", specificity{0, 2, 500, 1}},
+		{"sr-Latn-RS-x-a", specificity{0, 1, 800, 1}},
+	}
+	for _, tt := range tests {
+		if got := getLanguagePriority(tt.language, acs, 0); got != tt.expected {
+			t.Errorf("%q: "+testErrorFormat, tt.language, got, tt.expected)
+		}
+	}
+}
+
+func TestWithPrefixDirection(t *testing.T) {
+	provided := []string{"en", "en-US", "en-GB"}
+	tests := []struct {
+		direction PrefixDirection
+		accept    string
+		expected  []string
+	}{
+		{PrefixBoth, "en", []string{"en", "en-US", "en-GB"}},
+		{PrefixBoth, "en-US", []string{"en-US", "en"}},
+		{PrefixBoth, "en-GB;q=0.5, en-US", []string{"en-US", "en", "en-GB"}},
+		{PrefixAcceptOnly, "en", []string{"en", "en-US", "en-GB"}},
+		{PrefixAcceptOnly, "en-US", []string{"en-US"}},
+		{PrefixAcceptOnly, "en-GB;q=0.5, en-US", []string{"en-US", "en-GB"}},
+		{PrefixProvidedOnly, "en", []string{"en"}},
+		{PrefixProvidedOnly, "en-US", []string{"en-US", "en"}},
+		{PrefixProvidedOnly, "en-GB;q=0.5, en-US", []string{"en-US", "en", "en-GB"}},
+		{PrefixAcceptOnly, "*;q=0.1, en-US", []string{"en-US", "en", "en-GB"}},
+	}
+	for _, tt := range tests {
+		header := http.Header{HeaderAcceptLanguage: {tt.accept}}
+		n := New(header, WithPrefixDirection(tt.direction))
+		if got := n.Languages(provided...); !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("%d %q: "+testErrorFormat, tt.direction, tt.accept, got, tt.expected)
+		}
+		if got := n.Language(provided...); got != tt.expected[0] {
+			t.Errorf("%d %q: "+testErrorFormat, tt.direction, tt.accept, got, tt.expected[0])
+		}
+		if got := New(header).Negotiate(Offers{Languages: provided}, WithPrefixDirection(tt.direction)).Language; got != tt.expected[0] {
+			t.Errorf("%d %q: "+testErrorFormat, tt.direction, tt.accept, got, tt.expected[0])
+		}
+	}
+
+	// the negotiators and the package functions don't share the direction
+	n := New(http.Header{HeaderAcceptLanguage: {"en-US"}}, WithPrefixDirection(PrefixAcceptOnly))
+	if got := n.LanguageIndex("en"); got != -1 {
+		t.Errorf(testErrorFormat, got, -1)
+	}
+	if got := New(n.Header).Language("en"); got != "en" {
+		t.Errorf(testErrorFormat, got, "en")
+	}
+	if got := PreferredLanguages("en-US", "en"); !reflect.DeepEqual(got, []string{"en"}) {
+		t.Errorf(testErrorFormat, got, []string{"en"})
+	}
+	if got := n.Negotiate(Offers{Languages: []string{"en"}}, WithPrefixDirection(PrefixBoth)).Language; got != "en" {
+		t.Errorf(testErrorFormat, got, "en")
+	}
+}
+
+func TestWithExtendedFiltering(t *testing.T) {
+	languages := func(accept string, opts []Option, offers ...string) []string {
+		return New(http.Header{HeaderAcceptLanguage: {accept}}, opts...).Languages(offers...)
+	}
+	tests := []struct {
+		accept   string
+		language string
+		basic    bool
+		extended bool
+	}{
+		// the examples of RFC 4647 section 3.3.2
+		{"de-*-DE", "de-DE", false, true},
+		{"de-*-DE", "de-de", false, true},
+		{"de-*-DE", "de-Latn-DE", false, true},
+		{"de-*-DE", "de-Latf-DE", false, true},
+		{"de-*-DE", "de-DE-x-goethe", false, true},
+		{"de-*-DE", "de-Latn-DE-1996", false, true},
+		{"de-*-DE", "de-Deva-DE", false, true},
+		{"de-*-DE", "de", false, false},
+		{"de-*-DE", "de-x-DE", false, false},
+		{"de-*-DE", "de-Deva", false, false},
+		// DE is found after skipping CH and 1996
+		{"de-*-DE", "de-CH-1996-DE", false, true},
+		{"de-DE", "de-Latn-DE", false, true},
+		{"de-DE", "de-DE-1996", true, true},
+		{"*-DE", "de-DE", false, true},
+		{"*-DE", "fr-CH", false, false},
+		{"de-*", "de-CH", false, true},
+		{"de-DE", "de-a-DE", false, false},
+		{"de-x-DE", "de-Latn-x-DE", false, false},
+		{"*", "de-DE", true, true},
+	}
+	for _, tt := range tests {
+		for _, extended := range []bool{false, true} {
+			opts, expected := []Option{WithPrefixDirection(PrefixAcceptOnly)}, tt.basic
+			if extended {
+				opts, expected = append(opts, WithExtendedFiltering()), tt.extended
+			}
+			if got := len(languages(tt.accept, opts, tt.language)) == 1; got != expected {
+				t.Errorf("%q for %q extended=%v: "+testErrorFormat, tt.accept, tt.language, extended, got, expected)
+			}
+		}
+	}
+
+	provided := []string{"fr-CH", "de-Latn-DE", "de-CH", "de"}
+	for _, n := range []int{1, offerBucketThreshold} {
+		offers := provided
+		for len(offers) < n {
+			offers = append(offers, "it")
+		}
+		got := languages("*-CH;q=0.5, de-*-DE", []Option{WithExtendedFiltering()}, offers...)
+		if expected := []string{"de", "de-Latn-DE", "fr-CH", "de-CH"}; !reflect.DeepEqual(got, expected) {
+			t.Errorf(testErrorFormat, got, expected)
+		}
+	}
+
+	n := New(http.Header{HeaderAcceptLanguage: {"de-*-DE"}})
+	if got := n.Negotiate(Offers{Languages: []string{"de-Latn-DE"}}, WithExtendedFiltering()).Language; got != "de-Latn-DE" {
+		t.Errorf(testErrorFormat, got, "de-Latn-DE")
+	}
+	if got := n.Language("de-Latn-DE"); got != "" {
+		t.Errorf(testErrorFormat, got, "")
+	}
+}
+
+func TestPreferredLanguageIndex(t *testing.T) {
+	for _, tt := range preferredLanguageTestObjs {
+		if len(tt.provided) == 0 {
+			continue
+		}
+		i := PreferredLanguageIndex(tt.accept, tt.provided...)
+		if len(tt.expected) == 0 && i != -1 || len(tt.expected) > 0 && (i == -1 || tt.provided[i] != tt.expected[0]) {
+			t.Errorf("PreferredLanguageIndex(%q, %q) = %d, expect the index of %q", tt.accept, tt.provided, i, tt.expected)
+		}
+	}
+
+	tests := []struct {
+		accept   string
+		provided []string
+		expected int
+	}{
+		{"fr", []string{"en", "fr", "fr"}, 1},
+		{"fr", []string{" ", "en", " FR-fr "}, 2},
+		{"en;q=0.5, fr", []string{"EN", "en", "de"}, 0},
+		{"zh-yue", []string{"zh", "yue-HK"}, 1},
+		{"ja", []string{"en"}, -1},
+		{"*", []string{}, -1},
+	}
+	for _, tt := range tests {
+		if got := PreferredLanguageIndex(tt.accept, tt.provided...); got != tt.expected {
+			t.Errorf(testErrorFormat, got, tt.expected)
+		}
+		n := New(http.Header{HeaderAcceptLanguage: {tt.accept}})
+		if got := n.LanguageIndex(tt.provided...); got != tt.expected {
+			t.Errorf(testErrorFormat, got, tt.expected)
+		}
+	}
+
+	n := New(http.Header{HeaderAcceptLanguage: {"en"}})
+	ForceResult(n, Result{Language: "fr"})
+	if got := n.LanguageIndex("en", " FR"); got != 1 {
+		t.Errorf(testErrorFormat, got, 1)
+	}
+
+	n = New(http.Header{HeaderAcceptLanguage: {"en"}})
+	n.Register(HeaderAcceptLanguage, MatcherFunc(func(accept string, offers []string) []Weighted {
+		return []Weighted{{offers[len(offers)-1], 1, MatchExact, false}}
+	}))
+	if got := n.LanguageIndex("en", "", "fr"); got != 2 {
+		t.Errorf(testErrorFormat, got, 2)
+	}
+}
+
+func TestPreferredLanguages_EqualQuality(t *testing.T) {
+	ranges := []string{"en;q=0.5", "fr;q=0.5", "de-CH;q=0.5"}
+	for _, order := range permutations(ranges) {
+		accept := strings.Join(order, ", ")
+		expected := make([]string, len(order))
+		for i, r := range order {
+			expected[i] = strings.TrimSuffix(r, ";q=0.5")
+		}
+		expected = append(expected, "de")
+		for _, provided := range permutations([]string{"fr", "de-CH", "en", "de"}) {
+			if got := PreferredLanguages(accept, provided...); !reflect.DeepEqual(got, expected) {
+				t.Errorf("%q %q: "+testErrorFormat, accept, provided, got, expected)
+			}
+
+			n := New(http.Header{HeaderAcceptLanguage: {accept}})
+			if got := n.Negotiate(Offers{Languages: provided}).Language; got != expected[0] {
+				t.Errorf("%q %q: "+testErrorFormat, accept, provided, got, expected[0])
+			}
+			if got := n.Negotiate(Offers{Languages: provided}, WithServerPreferenceOrder()).Language; got != provided[0] {
+				t.Errorf("%q %q: "+testErrorFormat, accept, provided, got, provided[0])
+			}
+		}
+	}
+}
+
+// Get all the orders of values.
+func permutations(values []string) [][]string {
+	if len(values) <= 1 {
+		return [][]string{append([]string(nil), values...)}
+	}
+	var results [][]string
+	for i := range values {
+		rest := make([]string, 0, len(values)-1)
+		rest = append(append(rest, values[:i]...), values[i+1:]...)
+		for _, p := range permutations(rest) {
+			results = append(results, append([]string{values[i]}, p...))
+		}
+	}
+	return results
+}
+
+func TestParseAcceptLanguages(t *testing.T) {
+	header := "en-US, en;q=, ;q=0.5, , zh-yue;q=0.8, en_GB, fr;q=2"
+	ranges, errs := ParseAcceptLanguages(header)
+	expected := []LanguageRange{{"en-US", 1, 0}, {"yue", .8, 4}, {"en_GB", 1, 5}, {"fr", 1, 6}}
+	if !reflect.DeepEqual(ranges, expected) {
+		t.Errorf(testErrorFormat, ranges, expected)
+	}
+	expectedErrs := []error{
+		&ParseError{HeaderAcceptLanguage, "en;q=", 1, ErrInvalidQuality, false},
+		&ParseError{HeaderAcceptLanguage, ";q=0.5", 2, ErrEmptyLanguageTag, false},
+	}
+	if !reflect.DeepEqual(errs, expectedErrs) {
+		t.Errorf(testErrorFormat, errs, expectedErrs)
+	}
+
+	ranges, errs = ParseAcceptLanguagesStrict(header)
+	expected = []LanguageRange{{"en-US", 1, 0}, {"yue", .8, 4}}
+	if !reflect.DeepEqual(ranges, expected) {
+		t.Errorf(testErrorFormat, ranges, expected)
+	}
+	expectedErrs = append(expectedErrs,
+		&ParseError{HeaderAcceptLanguage, "en_GB", 5, ErrMalformedLanguageTag, false},
+		&ParseError{HeaderAcceptLanguage, "fr;q=2", 6, ErrInvalidQuality, false},
+	)
+	if !reflect.DeepEqual(errs, expectedErrs) {
+		t.Errorf(testErrorFormat, errs, expectedErrs)
+	}
+
+	if ranges, errs := ParseAcceptLanguages("en, fr;q=0.5"); len(ranges) != 2 || errs != nil {
+		t.Errorf(testErrorFormat, errs, nil)
+	}
+	if got := PreferredLanguages(header); !reflect.DeepEqual(got, []string{"en-US", "en_GB", "fr", "yue"}) {
+		t.Errorf(testErrorFormat, got, []string{"en-US", "en_GB", "fr", "yue"})
+	}
+}
+
+func TestPreferredLanguagesMinQuality(t *testing.T) {
+	tests := []struct {
+		accept     string
+		minQuality float64
+		provided   []string
+		expected   []string
+	}{
+		{"en-US, en;q=0.9, fr;q=0.2", 0.5, []string{"fr", "en"}, []string{"en"}},
+		{"en-US, en;q=0.9, fr;q=0.2", 0.2, []string{"fr", "en"}, []string{"en", "fr"}},
+		{"en-US, en;q=0.9, fr;q=0.2", 0.95, []string{"fr", "en"}, []string{}},
+		{"fr;q=0.2, *;q=0.8", 0.5, []string{"fr", "de"}, []string{"de"}},
+		{"en-US, en;q=0.9, fr;q=0.2", 0.5, nil, []string{"en-US", "en"}},
+		{"fr;q=0", 0, []string{"fr"}, []string{}},
+	}
+	for _, tt := range tests {
+		if got := PreferredLanguagesMinQuality(tt.accept, tt.minQuality, tt.provided...); !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("%q: "+testErrorFormat, tt.accept, got, tt.expected)
+		}
+	}
+}
+
+func TestPreferredLanguages_ManyRanges(t *testing.T) {
+	members := make([]string, 50000)
+	for i := range members {
+		members[i] = "x-" + strconv.Itoa(i)
+	}
+	huge := strings.Join(members, ", ")
+	kept := strings.Join(members[:getMaxAcceptRanges()], ", ")
+
+	if got := PreferredLanguages(huge+", en", "en"); len(got) != 0 {
+		t.Errorf(testErrorFormat, got, []string{})
+	}
+	if got := PreferredLanguages("en, "+huge, "en"); !reflect.DeepEqual(got, []string{"en"}) {
+		t.Errorf(testErrorFormat, got, []string{"en"})
+	}
+
+	provided := []string{"en", "fr", "de", "ja"}
+	hugeAllocs := testing.AllocsPerRun(10, func() { PreferredLanguages(huge, provided...) })
+	keptAllocs := testing.AllocsPerRun(10, func() { PreferredLanguages(kept, provided...) })
+	if hugeAllocs > keptAllocs {
+		t.Errorf("negotiating 50000 language ranges allocates %v times, expect at most %v", hugeAllocs, keptAllocs)
+	}
+}
+
+func TestPreferredLanguagesDetailed(t *testing.T) {
+	tests := []struct {
+		accept   string
+		provided []string
+		expected []DetailedLanguage
+	}{
+		{
+			"en-GB;q=0.9, fr, *;q=0.1",
+			[]string{"de", "en", "fr-CA", "en-GB"},
+			[]DetailedLanguage{
+				{"fr-CA", 1, MatchPrefix, "fr", LanguageRangePrefix},
+				{"en-GB", .9, MatchExact, "en-GB", LanguageExact},
+				{"en", .9, MatchPrefix, "en-GB", LanguageTagPrefix},
+				{"de", .1, MatchFullWildcard, "*", LanguageWildcard},
+			},
+		},
+		{
+			"zh-yue-HK;q=0.5, de;q=0",
+			[]string{"yue", "de"},
+			[]DetailedLanguage{{"yue", .5, MatchPrefix, "yue-HK", LanguageTagPrefix}},
+		},
+		{
+			"fr;q=0.5, en",
+			nil,
+			[]DetailedLanguage{{"en", 1, MatchNone, "en", LanguageNoMatch}, {"fr", .5, MatchNone, "fr", LanguageNoMatch}},
+		},
+		{
+			"fr",
+			[]string{"de"},
+			[]DetailedLanguage{},
+		},
+	}
+	for _, tt := range tests {
+		if got := PreferredLanguagesDetailed(tt.accept, tt.provided...); !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("%q: "+testErrorFormat, tt.accept, got, tt.expected)
+		}
+	}
+
+	got, _ := json.Marshal(DetailedLanguage{"en", .9, MatchPrefix, "en-GB", LanguageTagPrefix})
+	if expected := `{"value":"en","q":0.9,"match":"partial","range":"en-GB","specificity":"tag-prefix"}`; string(got) != expected {
+		t.Errorf(testErrorFormat, string(got), expected)
+	}
+	if got := LanguageSpecificity(9).String(); got != "LanguageSpecificity(9)" {
+		t.Errorf(testErrorFormat, got, "LanguageSpecificity(9)")
+	}
+}
+
+func TestCanonicalLanguageTag(t *testing.T) {
+	tests := []struct {
+		tag      string
+		expected string
+	}{
+		{"en", "en"},
+		{"EN-us", "en-US"},
+		{"zh-hant-tw", "zh-Hant-TW"},
+		{"SR-LATN", "sr-Latn"},
+		{"es-419", "es-419"},
+		{"de-ch-1996", "de-CH-1996"},
+		{"en-US-x-TWAIN", "en-US-x-twain"},
+		{"en-a-BC-de", "en-a-bc-de"},
+		{"I-Klingon", "i-klingon"},
+		{"Sgn-be-FR", "sgn-BE-FR"},
+		{"*", "*"},
+	}
+	for _, tt := range tests {
+		if got := CanonicalLanguageTag(tt.tag); got != tt.expected {
+			t.Errorf(testErrorFormat, got, tt.expected)
+		}
+	}
+}
+
+func TestIsWellFormedLanguageTag(t *testing.T) {
+	tests := []struct {
+		tag      string
+		expected bool
+	}{
+		{"en", true},
+		{"en-US", true},
+		{"zh-Hant-TW", true},
+		{"de-CH-1996", true},
+		{"x-pirate", true},
+		{"i-default", true},
+		{"", false},
+		{"*", false},
+		{"e", false},
+		{"123", false},
+		{"en-", false},
+		{"en--US", false},
+		{"en-x", false},
+		{"en_US", false},
+		{"verylonglanguage", false},
+	}
+	for _, tt := range tests {
+		if got := isWellFormedLanguageTag(tt.tag); got != tt.expected {
+			t.Errorf(testErrorFormat+" for %q", got, tt.expected, tt.tag)
+		}
+	}
+}
+
 func acceptLanguageEquals(a, b acceptLanguages) bool {
 	if len(a) != len(b) {
 		return false
@@ -305,3 +1044,20 @@ func acceptLanguageEquals(a, b acceptLanguages) bool {
 
 	return true
 }
+
+func BenchmarkPreferredLanguagesManyRanges(b *testing.B) {
+	accept := strings.Repeat("x-pirate;q=0.5, ", 50000) + "en"
+	provided := []string{"en", "fr", "de", "ja"}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		PreferredLanguages(accept, provided...)
+	}
+}
+
+func BenchmarkParseLanguage(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		parseLanguage("zh-Hant-TW;q=0.8", 0)
+	}
+}
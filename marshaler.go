@@ -0,0 +1,164 @@
+// Copyright 2020 Guoyao Wu. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package negotiator
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// ErrNotAcceptable is returned when no representation is acceptable.
+var ErrNotAcceptable = errors.New("negotiator: not acceptable")
+
+// ErrNoMarshaler is returned by Respond when an offer has no registered
+// marshaler.
+var ErrNoMarshaler = errors.New("negotiator: no marshaler registered")
+
+// Marshaler encodes values into a media type. It writes to w rather than
+// returning bytes, so streaming formats such as server-sent events or ndjson
+// are expressible.
+type Marshaler interface {
+	ContentType() string
+	Marshal(w io.Writer, v interface{}) error
+}
+
+type marshalerFunc struct {
+	contentType string
+	marshal     func(w io.Writer, v interface{}) error
+}
+
+func (m marshalerFunc) ContentType() string {
+	return m.contentType
+}
+
+func (m marshalerFunc) Marshal(w io.Writer, v interface{}) error {
+	return m.marshal(w, v)
+}
+
+// NewMarshaler creates a Marshaler from a content type and an encode function.
+func NewMarshaler(contentType string, marshal func(w io.Writer, v interface{}) error) Marshaler {
+	return marshalerFunc{contentType, marshal}
+}
+
+// JSONMarshaler encodes values with encoding/json as application/json.
+var JSONMarshaler = NewMarshaler("application/json", func(w io.Writer, v interface{}) error {
+	return json.NewEncoder(w).Encode(v)
+})
+
+// XMLMarshaler encodes values with encoding/xml as application/xml.
+var XMLMarshaler = NewMarshaler("application/xml", func(w io.Writer, v interface{}) error {
+	return xml.NewEncoder(w).Encode(v)
+})
+
+type marshalerRegistry struct {
+	mu         sync.RWMutex
+	marshalers []Marshaler
+}
+
+var marshalers = &marshalerRegistry{marshalers: []Marshaler{JSONMarshaler, XMLMarshaler}}
+
+func (r *marshalerRegistry) register(m Marshaler) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := normalizeMediaTypeKey(m.ContentType())
+	for i, v := range r.marshalers {
+		if normalizeMediaTypeKey(v.ContentType()) == key {
+			r.marshalers[i] = m
+			return
+		}
+	}
+	r.marshalers = append(r.marshalers, m)
+}
+
+func (r *marshalerRegistry) list() []Marshaler {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return append([]Marshaler{}, r.marshalers...)
+}
+
+// RegisterMarshaler registers a marshaler in the package's registry, which
+// JSONMarshaler and XMLMarshaler are registered in by default. The order of
+// registration is the order of server preference, a marshaler registered for
+// the media type of a registered one replaces it in place. It's safe for
+// concurrent use.
+func RegisterMarshaler(m Marshaler) {
+	marshalers.register(m)
+}
+
+// Marshalers gets the registered marshalers in order of server preference.
+func Marshalers() []Marshaler {
+	return marshalers.list()
+}
+
+// Respond negotiates the media type of the response among the registered
+// marshalers, or the ones whose content type is listed in offers if any, and
+// writes v with the chosen one. It responds with 406 Not Acceptable and
+// returns ErrNotAcceptable if no marshaler is acceptable. If an offer has no
+// registered marshaler, nothing is written and an error wrapping
+// ErrNoMarshaler is returned.
+func Respond(w http.ResponseWriter, r *http.Request, status int, v interface{}, offers ...string) error {
+	candidates := Marshalers()
+	if len(offers) > 0 {
+		filtered := make([]Marshaler, 0, len(offers))
+		for _, offer := range offers {
+			m := findMarshaler(candidates, offer)
+			if m == nil {
+				return fmt.Errorf("%w for %q", ErrNoMarshaler, offer)
+			}
+			filtered = append(filtered, m)
+		}
+		candidates = filtered
+	}
+
+	h := w.Header()
+	addVary(h, HeaderAccept)
+
+	m := chooseMarshaler(negotiatorFor(r), candidates)
+	if m == nil {
+		http.Error(w, http.StatusText(http.StatusNotAcceptable), http.StatusNotAcceptable)
+		return ErrNotAcceptable
+	}
+
+	h.Set("Content-Type", m.ContentType())
+	w.WriteHeader(status)
+	return m.Marshal(w, v)
+}
+
+// Get the marshaler of a media type among the marshalers, or nil if there's
+// none, the media types are compared ignoring case and parameters.
+func findMarshaler(marshalers []Marshaler, mediaType string) Marshaler {
+	key := normalizeMediaTypeKey(mediaType)
+	for _, m := range marshalers {
+		if normalizeMediaTypeKey(m.ContentType()) == key {
+			return m
+		}
+	}
+	return nil
+}
+
+func chooseMarshaler(n *Negotiator, candidates []Marshaler) Marshaler {
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	contentTypes := make([]string, len(candidates), len(candidates))
+	for i, m := range candidates {
+		contentTypes[i] = m.ContentType()
+	}
+	mediaType := n.MediaType(contentTypes...)
+	for i, v := range contentTypes {
+		if mediaType != "" && strings.EqualFold(v, mediaType) {
+			return candidates[i]
+		}
+	}
+	return nil
+}
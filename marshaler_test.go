@@ -0,0 +1,119 @@
+// Copyright 2020 Guoyao Wu. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package negotiator
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var ndjsonMarshaler = NewMarshaler("application/x-ndjson", func(w io.Writer, v interface{}) error {
+	enc := json.NewEncoder(w)
+	for _, item := range v.([]string) {
+		if err := enc.Encode(item); err != nil {
+			return err
+		}
+	}
+	return nil
+})
+
+var textMarshaler = NewMarshaler("text/plain; charset=utf-8", func(w io.Writer, v interface{}) error {
+	_, err := fmt.Fprint(w, v)
+	return err
+})
+
+func snapshotMarshalers() func() {
+	s := Marshalers()
+	return func() {
+		marshalers.mu.Lock()
+		marshalers.marshalers = s
+		marshalers.mu.Unlock()
+	}
+}
+
+func TestRegisterMarshaler(t *testing.T) {
+	defer snapshotMarshalers()()
+
+	RegisterMarshaler(ndjsonMarshaler)
+	RegisterMarshaler(textMarshaler)
+	replaced := NewMarshaler("Application/JSON", JSONMarshaler.Marshal)
+	RegisterMarshaler(replaced)
+
+	expected := []string{"Application/JSON", "application/xml", "application/x-ndjson", "text/plain; charset=utf-8"}
+	got := make([]string, 0, len(expected))
+	for _, m := range Marshalers() {
+		got = append(got, m.ContentType())
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf(testErrorFormat, got, expected)
+	}
+}
+
+func TestRespond(t *testing.T) {
+	defer snapshotMarshalers()()
+	RegisterMarshaler(ndjsonMarshaler)
+	RegisterMarshaler(textMarshaler)
+
+	tests := []struct {
+		accept      string
+		offers      []string
+		status      int
+		contentType string
+		body        string
+		err         error
+	}{
+		{"", nil, http.StatusCreated, "application/json", "[\"a\",\"b\"]\n", nil},
+		{"*/*", nil, http.StatusCreated, "application/json", "[\"a\",\"b\"]\n", nil},
+		{"application/xml, text/plain;q=0.1", nil, http.StatusCreated, "application/xml", "<string>a</string><string>b</string>", nil},
+		{"application/x-ndjson", nil, http.StatusCreated, "application/x-ndjson", "\"a\"\n\"b\"\n", nil},
+		{"text/plain", nil, http.StatusCreated, "text/plain; charset=utf-8", "[a b]", nil},
+		{"*/*", []string{"text/plain", "application/json"}, http.StatusCreated, "text/plain; charset=utf-8", "[a b]", nil},
+		{"application/json", []string{"text/plain"}, http.StatusNotAcceptable, "text/plain; charset=utf-8", "Not Acceptable\n", ErrNotAcceptable},
+		{"image/png", nil, http.StatusNotAcceptable, "text/plain; charset=utf-8", "Not Acceptable\n", ErrNotAcceptable},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if tt.accept != "" {
+			r.Header.Set(HeaderAccept, tt.accept)
+		}
+		err := Respond(w, r, http.StatusCreated, []string{"a", "b"}, tt.offers...)
+		if err != tt.err {
+			t.Errorf(testErrorFormat, err, tt.err)
+		}
+		if w.Code != tt.status {
+			t.Errorf(testErrorFormat, w.Code, tt.status)
+		}
+		if got := w.Header().Get("Content-Type"); got != tt.contentType {
+			t.Errorf(testErrorFormat, got, tt.contentType)
+		}
+		if got := w.Header().Get(HeaderVary); got != HeaderAccept {
+			t.Errorf(testErrorFormat, got, HeaderAccept)
+		}
+		if got := w.Body.String(); got != tt.body {
+			t.Errorf(testErrorFormat, got, tt.body)
+		}
+	}
+
+	// an offer without a marshaler is an error of the caller, not a refusal
+	for _, offers := range [][]string{{"image/png"}, {"application/json", "image/png"}} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		err := Respond(w, r, http.StatusCreated, []string{"a", "b"}, offers...)
+		if !errors.Is(err, ErrNoMarshaler) || !strings.Contains(err.Error(), `"image/png"`) {
+			t.Errorf(testErrorFormat, err, ErrNoMarshaler)
+		}
+		if len(w.Header()) != 0 || w.Body.Len() != 0 {
+			t.Errorf(testErrorFormat, w.Header(), http.Header{})
+		}
+	}
+}
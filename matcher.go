@@ -0,0 +1,174 @@
+// Copyright 2020 Guoyao Wu. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package negotiator
+
+import "net/textproto"
+
+// Matcher negotiates the values of a header dimension.
+type Matcher interface {
+	// Match gets the acceptable offers, most preferred first, with their
+	// quality and how they matched the header value accept. Without offers it
+	// lists the acceptable ranges of accept.
+	Match(accept string, offers []string) []Weighted
+}
+
+// MatcherFunc adapts a function to a Matcher.
+type MatcherFunc func(accept string, offers []string) []Weighted
+
+// Match calls f(accept, offers).
+func (f MatcherFunc) Match(accept string, offers []string) []Weighted {
+	return f(accept, offers)
+}
+
+// AcceptDefaulter is implemented by the matchers which treat a missing header
+// like a header value, e.g. `*` for Accept-Charset. The header value of other
+// matchers is empty when the header is missing.
+type AcceptDefaulter interface {
+	DefaultAccept() string
+}
+
+type defaultMatcher struct {
+	Matcher
+	defaultAccept string
+}
+
+func (m defaultMatcher) DefaultAccept() string {
+	return m.defaultAccept
+}
+
+// TokenMatcher matches case-insensitive tokens with an optional q parameter
+// and the `*` wildcard, like Accept-Charset does. It suits simple custom
+// headers, e.g. `X-Accept-Profile: compact, full;q=0.5`, a missing header
+// accepts every offer.
+var TokenMatcher Matcher = defaultMatcher{MatcherFunc(func(accept string, offers []string) []Weighted {
+	return WeightedCharsets(accept, offers...)
+}), "*"}
+
+// The matchers of the built-in dimensions, RFC 2616 sec 14.2: no header = *
+// or */* for Accept.
+var builtinMatchers = map[string]Matcher{
+	HeaderAccept: defaultMatcher{MatcherFunc(func(accept string, offers []string) []Weighted {
+		return WeightedMediaTypes(accept, offers...)
+	}), "*/*"},
+	HeaderAcceptCharset: TokenMatcher,
+	HeaderAcceptEncoding: defaultMatcher{MatcherFunc(func(accept string, offers []string) []Weighted {
+		return WeightedEncodings(accept, offers...)
+	}), "*"},
+	HeaderAcceptLanguage: defaultMatcher{MatcherFunc(func(accept string, offers []string) []Weighted {
+		return WeightedLanguages(accept, offers...)
+	}), "*"},
+}
+
+// Register registers the matcher of a header dimension, so that Preferred and
+// Weighted negotiate it like the built-in ones. Registering a built-in header
+// replaces its matcher for the methods of n, e.g. Register(HeaderAcceptLanguage, m)
+// changes Languages and Language. Register isn't safe for concurrent use with
+// the other methods, register before negotiating.
+func (n *Negotiator) Register(header string, m Matcher) {
+	if n.matchers == nil {
+		n.matchers = make(map[string]Matcher)
+	}
+	n.matchers[textproto.CanonicalMIMEHeaderKey(header)] = m
+}
+
+// Has reports whether the request has the header.
+func (n *Negotiator) Has(header string) bool {
+	return getHeaderValues(n.Header, header) != nil
+}
+
+// Preferred gets the preferred offers of a header dimension, see Weighted.
+func (n *Negotiator) Preferred(header string, offers ...string) []string {
+	weighted := n.Weighted(header, offers...)
+	if weighted == nil {
+		return nil
+	}
+	results := make([]string, len(weighted), len(weighted))
+	for i, w := range weighted {
+		results[i] = w.Value
+	}
+	return results
+}
+
+// Weighted gets the preferred offers of a header dimension with their quality
+// and how they matched, with the matcher registered for the header or the
+// built-in one. The offers are trimmed of OWS and the empty ones are dropped
+// before matching, and the offers below the quality of WithMinimumQuality are
+// dropped after. It returns nil for a header without a matcher.
+func (n *Negotiator) Weighted(header string, offers ...string) []Weighted {
+	header = textproto.CanonicalMIMEHeaderKey(header)
+	m := n.matcher(header)
+	if m == nil {
+		return nil
+	}
+
+	if len(offers) > 0 {
+		if offers = normalizeOffers(offers); len(offers) == 0 {
+			return []Weighted{}
+		}
+	}
+
+	defaultAccept := ""
+	if d, ok := m.(AcceptDefaulter); ok {
+		defaultAccept = d.DefaultAccept()
+	}
+	weighted := m.Match(getAccept(n.Header, header, defaultAccept), offers)
+	if min := n.minQuality(); min > 0 && len(offers) > 0 {
+		return reachingQuality(weighted, min)
+	}
+	return weighted
+}
+
+// Get the weighted offers whose quality reaches minQuality, in order.
+func reachingQuality(weighted []Weighted, minQuality float64) []Weighted {
+	results := make([]Weighted, 0, len(weighted))
+	for _, w := range weighted {
+		if w.Quality >= minQuality {
+			results = append(results, w)
+		}
+	}
+	return results
+}
+
+// Negotiate the most preferred offer of a header dimension and how it matched
+// with its registered matcher.
+func (n *Negotiator) negotiateRegistered(header string, available []string) (string, MatchKind) {
+	w := n.Weighted(header, available...)
+	if len(w) == 0 {
+		return "", MatchNone
+	}
+	return w[0].Value, w[0].Match
+}
+
+// Get the matcher of a canonical header name.
+func (n *Negotiator) matcher(header string) Matcher {
+	if m, ok := n.matchers[header]; ok {
+		return m
+	}
+	if f := n.languageFilter(); header == HeaderAcceptLanguage && f != (languageFilter{}) {
+		return defaultMatcher{MatcherFunc(func(accept string, offers []string) []Weighted {
+			return appendWeightedLanguages(nil, accept, offers, f)
+		}), "*"}
+	}
+	if f := n.mediaTypeFilter(); header == HeaderAccept && !f.isDefault() {
+		return defaultMatcher{MatcherFunc(func(accept string, offers []string) []Weighted {
+			return appendWeightedMediaTypes(nil, accept, offers, f)
+		}), "*/*"}
+	}
+	return builtinMatchers[header]
+}
+
+// Reports whether the built-in matcher of a canonical header name was
+// replaced, the allocation-free paths only apply to the built-in matchers.
+func (n *Negotiator) overridden(header string) bool {
+	_, ok := n.matchers[header]
+	return ok
+}
+
+// Reports whether the offers of a header dimension are ranked with Weighted,
+// i.e. its built-in matcher was replaced or WithMinimumQuality drops the
+// offers below the threshold, which the allocation-free paths don't.
+func (n *Negotiator) ranked(header string) bool {
+	return n.overridden(header) || n.minQuality() > 0
+}
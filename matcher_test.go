@@ -0,0 +1,97 @@
+// Copyright 2020 Guoyao Wu. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package negotiator
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNegotiator_Register(t *testing.T) {
+	n := New(http.Header{
+		"Accept-Query":      {"compact;q=0.5, full"},
+		HeaderAcceptCharset: {"utf-8"},
+	})
+	n.Register("accept-query", TokenMatcher)
+
+	if got, expected := n.Preferred("Accept-Query", "compact", "full", "raw"), []string{"full", "compact"}; !reflect.DeepEqual(got, expected) {
+		t.Errorf(testErrorFormat, got, expected)
+	}
+	expected := []Weighted{{"full", 1, MatchExact, false}, {"compact", .5, MatchExact, false}}
+	if got := n.Weighted("Accept-Query", "compact", "full"); !reflect.DeepEqual(got, expected) {
+		t.Errorf(testErrorFormat, got, expected)
+	}
+	if got := n.Preferred("X-Accept-Unknown", "a"); got != nil {
+		t.Errorf(testErrorFormat, got, nil)
+	}
+
+	// a missing header accepts every offer with the token matcher
+	if got, expected := n.Preferred("X-Accept-Profile", "a", "b"), []string(nil); !reflect.DeepEqual(got, expected) {
+		t.Errorf(testErrorFormat, got, expected)
+	}
+	n.Register("X-Accept-Profile", TokenMatcher)
+	if got, expected := n.Preferred("X-Accept-Profile", "a", "b"), []string{"a", "b"}; !reflect.DeepEqual(got, expected) {
+		t.Errorf(testErrorFormat, got, expected)
+	}
+}
+
+func TestNegotiator_RegisterBuiltin(t *testing.T) {
+	n := New(http.Header{HeaderAcceptLanguage: {"en"}})
+	if got := n.Language("fr", "en"); got != "en" {
+		t.Errorf(testErrorFormat, got, "en")
+	}
+
+	// a matcher which prefers the longest offer
+	n.Register(HeaderAcceptLanguage, MatcherFunc(func(accept string, offers []string) []Weighted {
+		best := ""
+		for _, o := range offers {
+			if len(o) > len(best) {
+				best = o
+			}
+		}
+		return []Weighted{{best, 1, MatchExact, false}}
+	}))
+	if got := n.Language("fr", "en-GB", "en"); got != "en-GB" {
+		t.Errorf(testErrorFormat, got, "en-GB")
+	}
+	if got := n.Languages("fr", "en-GB"); !reflect.DeepEqual(got, []string{"en-GB"}) {
+		t.Errorf(testErrorFormat, got, []string{"en-GB"})
+	}
+	res := n.Negotiate(Offers{Languages: []string{"de", "pt-BR"}})
+	if res.Language != "pt-BR" || res.LanguageMatch != MatchExact {
+		t.Errorf(testErrorFormat, res, "pt-BR")
+	}
+}
+
+func TestNegotiator_BuiltinMatchers(t *testing.T) {
+	for _, h := range []string{HeaderAccept, HeaderAcceptCharset, HeaderAcceptEncoding, HeaderAcceptLanguage} {
+		n, preferred := New(http.Header{}), preferredFunc(h)
+		for _, v := range ConformanceVectors(h) {
+			n.Header.Set(h, v.Accept)
+			if got := n.Preferred(strings.ToLower(h), v.Offers...); !sameValues(got, preferred(v.Accept, v.Offers...)) {
+				t.Errorf(testErrorFormat, got, v.Expected)
+			}
+		}
+	}
+}
+
+func TestNegotiator_Has(t *testing.T) {
+	n := New(http.Header{HeaderAccept: {"text/html"}, "accept-query": {"full"}})
+	tests := []struct {
+		header   string
+		expected bool
+	}{
+		{"Accept", true},
+		{"Accept-Query", true},
+		{"accept-language", false},
+	}
+	for _, tt := range tests {
+		if got := n.Has(tt.header); got != tt.expected {
+			t.Errorf(testErrorFormat, got, tt.expected)
+		}
+	}
+}
@@ -5,21 +5,17 @@
 package negotiator
 
 import (
-	"math"
 	"sort"
-	"strconv"
 	"strings"
-
-	"github.com/dlclark/regexp2"
+	"unicode"
+	"unicode/utf8"
 )
 
-var simpleMediaTypeRegExp = regexp2.MustCompile("^\\s*([^\\s\\/;]+)\\/([^;\\s]+)\\s*(?:;(.*))?$", regexp2.None)
-
 type acceptMediaType struct {
 	mainType string
 	subtype  string
 	params   map[string]string
-	q        float64
+	q        quality
 	i        int
 }
 
@@ -69,99 +65,712 @@ func (s *acceptMediaTypeSorter) Less(i, j int) bool {
 
 // PreferredMediaTypes gets the preferred media types from an Accept header.
 // RFC 2616 sec 14.2: no header = */*, so you should pass */* if no Accept field in header.
+//
+// The provided media types may carry parameters, e.g. application/json;version=2.
+// A range with parameters only matches the provided media types with the same
+// values, ignoring case, of each parameter of the range, except for the values
+// "*" which match any value; other parameters of the provided media type are
+// ignored. The values of the profile parameter, lists of URIs, match if they
+// have a URI in common. A range with matching parameters is more specific than
+// the range without, so application/json;version=2;q=0.5 governs
+// application/json for application/json;version=2, and the provided media
+// types which only differ by their parameters rank by how they matched, then
+// in the provided order. Otherwise, the provided media types which are equally
+// preferred, i.e. of the same quality matched as specifically by the same
+// range, keep the provided order.
+//
+// A range with a wildcard type and a concrete subtype, e.g. */json, isn't
+// allowed by the grammar but is kept: it matches the media types of any type
+// with the subtype, as specifically as a range like application/*. Use
+// PreferredMediaTypesStrict to drop such ranges.
+//
+// A lone */* range, e.g. "*/*" or "*/*;q=0.5", isn't parsed: the provided
+// media types are returned in order if they're valid, have distinct types and
+// subtypes, and none of them is a wildcard, e.g. text/*.
 func PreferredMediaTypes(accept string, provided ...string) []string {
+	if isAnyMediaRange(accept) {
+		if offers, ok := anyMediaRangeOffers(normalizeOffers(provided)); ok {
+			return offers
+		}
+	}
+	return preferredMediaTypes(parseAcceptMediaType(accept), provided)
+}
+
+// AcceptsMediaType reports whether an Accept header accepts the media type,
+// like checking that PreferredMediaTypes(accept, mediaType) isn't empty but
+// without sorting or allocating once the header and the media type have been
+// seen, parsed values are cached.
+func AcceptsMediaType(accept string, mediaType string) bool {
+	return acceptsMediaType(accept, mediaType, mediaTypeFilter{})
+}
+
+func acceptsMediaType(accept string, mediaType string, f mediaTypeFilter) bool {
+	p := cachedMediaTypeOffer(trimOffer(mediaType))
+	return isSpecificityQuality(getParsedMediaTypePriority(p, cachedAcceptMediaTypeFilter(accept, f), nil, 0, f))
+}
+
+// FilterAcceptableMediaTypes gets the provided media types which the Accept
+// header accepts, in the provided order, e.g. when the order encodes the
+// preference of the server. They're the media types of PreferredMediaTypes,
+// which are matched the same way, without sorting them.
+func FilterAcceptableMediaTypes(accept string, provided ...string) []string {
+	acs, results := cachedAcceptMediaType(accept), make([]string, 0, len(provided))
+	for _, mediaType := range normalizeOffers(provided) {
+		p := cachedMediaTypeOffer(mediaType)
+		if isSpecificityQuality(getParsedMediaTypePriority(p, acs, nil, 0, mediaTypeFilter{})) {
+			results = append(results, mediaType)
+		}
+	}
+	return results
+}
+
+// PreferredMediaTypeIndex gets the index in provided of the most preferred
+// media type, the first one of PreferredMediaTypes, or -1 if none is
+// acceptable. Use it to pick from a slice parallel to provided, e.g. of
+// encoders, even if several media types are equal.
+func PreferredMediaTypeIndex(accept string, provided ...string) int {
+	i, _ := bestMediaTypeIndex(accept, provided, mediaTypeFilter{})
+	return i
+}
+
+// PreferredMediaTypeMatch gets the most preferred media type, the first one of
+// PreferredMediaTypes, and how the range it matched asked for it: MatchExact
+// for a range naming it, e.g. application/json, MatchSubtypeWildcard for a
+// range like application/* and MatchFullWildcard for */*. It's "" and
+// MatchNone if none is acceptable.
+func PreferredMediaTypeMatch(accept string, provided ...string) (string, MatchKind) {
+	return bestMediaType(accept, normalizeOffers(provided), mediaTypeFilter{})
+}
+
+// NegotiatedContentType gets the most preferred offer like
+// PreferredMediaTypeIndex, as a Content-Type header value: the parameters of
+// the offer are kept as written and in order, with the canonical spacing, e.g.
+// "application/json;charset=utf-8 ;version=2" is
+// "application/json; charset=utf-8; version=2". ok is false if no offer is
+// acceptable.
+func NegotiatedContentType(accept string, offers ...string) (contentType string, ok bool) {
+	i, _ := bestMediaTypeIndex(accept, offers, mediaTypeFilter{})
+	if i == -1 {
+		return "", false
+	}
+	return formatContentType(offers[i]), true
+}
+
+// PreferredMediaRanges gets the acceptable ranges of an Accept header like
+// PreferredMediaTypes without provided media types, but with their parameters
+// other than q, e.g. text/html;level=1, so that they can be forwarded. The
+// parameters are sorted by name, and the values which aren't tokens are
+// quoted.
+func PreferredMediaRanges(accept string) []string {
+	sorted := sortAcceptMediaTypes(parseAcceptMediaType(accept))
+	results := make([]string, len(sorted), len(sorted))
+	for i, ac := range sorted {
+		results[i] = formatMediaType(ac.mainType, ac.subtype, ac.params)
+	}
+	return results
+}
+
+// PreferredMediaTypesStrict gets the preferred media types like
+// PreferredMediaTypes, but the q parameters must be valid qvalues of RFC 9110,
+// 0 to 1 with at most 3 decimal digits. The members with an out of range q,
+// e.g. q=2, are dropped, and excess decimal digits are truncated, so a client
+// can't jump the queue with q=9999. The ranges with a wildcard type and a
+// concrete subtype, e.g. */json, which the grammar doesn't allow, are dropped
+// too.
+func PreferredMediaTypesStrict(accept string, provided ...string) []string {
+	acs, _ := parseAcceptMediaTypeErrors(accept, true)
+	return preferredMediaTypes(dedupeMediaRanges(acs, mediaTypeFilter{}), provided)
+}
+
+// PreferredMediaTypesLenient gets the preferred media types like
+// PreferredMediaTypes, but tolerates the headers of some old clients. A member
+// which is a bare top-level type, e.g. "text" or "image;q=0.5", is read as the
+// range of all its subtypes, e.g. text/*. Then an Accept header of which every
+// member is malformed, e.g. "undefined" or "\"text/html\"", is treated as "*/*"
+// instead of refusing everything. An empty header is negotiated as usual.
+func PreferredMediaTypesLenient(accept string, provided ...string) []string {
+	acs, errs := recoverBareTypes(parseAcceptMediaTypeErrors(accept, false))
+	if len(acs) == 0 && len(errs) > 0 {
+		acs = parseAcceptMediaType("*/*")
+	}
+	return preferredMediaTypes(dedupeMediaRanges(acs, mediaTypeFilter{}), provided)
+}
+
+// PreferredMediaTypesMinQuality gets the preferred media types like
+// PreferredMediaTypes, but drops the media types whose quality is below
+// minQuality, e.g. with "text/html, application/json;q=0.2" and a minimum of
+// 0.5, application/json isn't listed. Without provided media types, the ranges
+// below minQuality are dropped, see PreferredLanguagesMinQuality.
+func PreferredMediaTypesMinQuality(accept string, minQuality float64, provided ...string) []string {
 	acs := parseAcceptMediaType(accept)
 
 	if len(provided) == 0 {
-		// sorted list of all media types
-		filteredAcs := acs.filter(isAcceptMediaTypeQuality)
-		acceptMediaTypeBy(func(ac1, ac2 *acceptMediaType) bool {
-			if ac1.q != ac2.q {
-				return ac1.q > ac2.q
+		return sortAcceptMediaTypes(acs).filter(func(ac acceptMediaType) bool {
+			return ac.q.float() >= minQuality
+		}).toMediaTypes()
+	}
+
+	provided = normalizeOffers(provided)
+
+	priorities, keys := getMediaTypeSpecificities(provided, acs, mediaTypeFilter{})
+	return priorities.reaching(minQuality).sortedBy(mediaTypeSpecsBy(keys)).values(provided)
+}
+
+// The top-level media types of the IANA registry.
+var topLevelMediaTypes = map[string]bool{
+	"application": true, "audio": true, "example": true, "font": true,
+	"haptics": true, "image": true, "message": true, "model": true,
+	"multipart": true, "text": true, "video": true,
+}
+
+// Recover the dropped members which are a bare top-level type as the range of
+// all its subtypes, e.g. "text;q=0.5" is text/*;q=0.5. The ranges are kept in
+// header order, and the recovered members are removed from errs.
+func recoverBareTypes(acs acceptMediaTypes, errs []*ParseError) (acceptMediaTypes, []*ParseError) {
+	kept, recovered := errs[:0], false
+	for _, err := range errs {
+		if err.Err == ErrMissingSlash {
+			mainType, params := err.Member, ""
+			if i := strings.IndexByte(mainType, ';'); i != -1 {
+				mainType, params = mainType[:i], mainType[i:]
+			}
+			if mainType = strings.TrimSpace(mainType); topLevelMediaTypes[strings.ToLower(mainType)] {
+				if ac := parseMediaType(mainType+"/*"+params, err.Position); ac != nil {
+					acs, recovered = append(acs, *ac), true
+					continue
+				}
 			}
-			return ac1.i < ac2.i
-		}).sort(filteredAcs)
-		return filteredAcs.toMediaTypes()
+		}
+		kept = append(kept, err)
+	}
+	if recovered {
+		sort.SliceStable(acs, func(i, j int) bool {
+			return acs[i].i < acs[j].i
+		})
+	}
+	return acs, kept
+}
+
+func preferredMediaTypes(acs acceptMediaTypes, provided []string) []string {
+	if len(provided) == 0 {
+		// sorted list of all media types
+		return sortAcceptMediaTypes(acs).toMediaTypes()
+	}
+
+	provided = normalizeOffers(provided)
+
+	// sorted list of accepted media types
+	priorities, keys := getMediaTypeSpecificities(provided, acs, mediaTypeFilter{})
+	return priorities.sortedBy(mediaTypeSpecsBy(keys)).values(provided)
+}
+
+// WeightedMediaTypes gets the preferred media types with their quality and how
+// they matched the header, in the same order as PreferredMediaTypes. Without
+// provided media types, the acceptable ranges of the header are listed with
+// MatchNone.
+//
+// Use AppendWeightedMediaTypes to reuse the result slice.
+func WeightedMediaTypes(accept string, provided ...string) []Weighted {
+	return AppendWeightedMediaTypes(nil, accept, provided...)
+}
+
+// AppendWeightedMediaTypes appends the result of WeightedMediaTypes to dst and returns
+// the extended slice.
+func AppendWeightedMediaTypes(dst []Weighted, accept string, provided ...string) []Weighted {
+	return appendWeightedMediaTypes(dst, accept, provided, mediaTypeFilter{})
+}
+
+func appendWeightedMediaTypes(dst []Weighted, accept string, provided []string, f mediaTypeFilter) []Weighted {
+	acs := cachedAcceptMediaTypeFilter(accept, f)
+
+	if len(provided) == 0 {
+		dst = growWeighted(dst, len(acs))
+		for _, ac := range sortAcceptMediaTypes(acs) {
+			dst = append(dst, Weighted{Value: ac.mainType + "/" + ac.subtype, Quality: ac.q.float(), Match: MatchNone})
+		}
+		return dst
+	}
+
+	provided = normalizeOffers(provided)
+
+	priorities, keys := getMediaTypeSpecificities(provided, acs, f)
+	return priorities.sortedBy(mediaTypeSpecsBy(keys)).appendWeighted(dst, provided, mediaTypeMatchKind)
+}
+
+// MediaType is a parsed range of an Accept header.
+type MediaType struct {
+	Type    string
+	Subtype string
+	// Params are the parameters other than q.
+	Params map[string]string
+	// Quality is the q parameter, 1 if absent.
+	Quality float64
+	// Order is the zero-based position of the member in the header.
+	Order int
+}
+
+// ParseAccept parses an Accept header into its ranges in header order,
+// malformed members are dropped like PreferredMediaTypes does.
+func ParseAccept(header string) []MediaType {
+	return parseAcceptMediaType(header).toMediaTypeRanges()
+}
+
+// ParseAcceptMediaTypes parses an Accept header like ParseAccept, and reports
+// why members were rejected, e.g. a bad q, a missing slash or an unbalanced
+// quote. The errors are *ParseError values in header order, including the
+// members with an unbalanced quote which were recovered, see
+// ParseError.Recovered. Empty members aren't reported.
+func ParseAcceptMediaTypes(accept string) ([]MediaType, []error) {
+	acs, errs := parseAcceptMediaTypeErrors(accept, false)
+	var results []error
+	for _, err := range errs {
+		results = append(results, err)
+	}
+	return acs.toMediaTypeRanges(), results
+}
+
+// String formats the range without its q parameter, see PreferredMediaRanges.
+func (mt MediaType) String() string {
+	return formatMediaType(mt.Type, mt.Subtype, mt.Params)
+}
+
+// FormatAccept formats ranges back into an Accept header value, the
+// parameters of each range are sorted by name and its q parameter is omitted
+// when it's 1. ParseAccept parses the result back into the same ranges.
+func FormatAccept(ranges []MediaType) string {
+	var b strings.Builder
+	for i, r := range ranges {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString(r.String())
+		writeQualityParameter(&b, r.Quality)
+	}
+	return b.String()
+}
+
+func (acs acceptMediaTypes) toMediaTypeRanges() []MediaType {
+	results := make([]MediaType, len(acs), len(acs))
+	for i, ac := range acs {
+		results[i] = MediaType{ac.mainType, ac.subtype, ac.params, ac.q.float(), ac.i}
+	}
+	return results
+}
+
+// DetailedMediaType is a preferred media type with the quality and the
+// parameters of the Accept range it matched.
+type DetailedMediaType struct {
+	Value   string    `json:"value"`
+	Quality float64   `json:"q"`
+	Match   MatchKind `json:"match"`
+	// Params are the parameters of the matched range other than q.
+	Params map[string]string `json:"params,omitempty"`
+}
+
+// PreferredMediaTypesDetailed gets the preferred media types in the same order
+// as PreferredMediaTypes, with the parameters of the Accept range each one
+// matched, e.g. level=1 of text/html;level=1. Without provided media types,
+// the acceptable ranges of the header are listed with MatchNone.
+func PreferredMediaTypesDetailed(accept string, provided ...string) []DetailedMediaType {
+	acs := parseAcceptMediaType(accept)
+
+	if len(provided) == 0 {
+		sorted := sortAcceptMediaTypes(acs)
+		results := make([]DetailedMediaType, len(sorted), len(sorted))
+		for i, ac := range sorted {
+			results[i] = DetailedMediaType{ac.mainType + "/" + ac.subtype, ac.q.float(), MatchNone, copyParams(ac.params)}
+		}
+		return results
 	}
 
-	priorities := getMediaTypeSpecificities(provided, acs)
-	filteredPriorities := priorities.filter(isSpecificityQuality)
-	specificityBy(compareSpecs).sort(filteredPriorities)
+	provided = normalizeOffers(provided)
 
-	results := make([]string, 0, len(filteredPriorities))
-	for _, v := range filteredPriorities {
-		i := priorities.indexOf(v)
-		if i >= 0 {
-			results = append(results, provided[i])
+	priorities, keys := getMediaTypeSpecificities(provided, acs, mediaTypeFilter{})
+	sorted := priorities.sortedBy(mediaTypeSpecsBy(keys))
+	results := make([]DetailedMediaType, len(sorted), len(sorted))
+	for i, spec := range sorted {
+		results[i] = DetailedMediaType{provided[spec.i], spec.q.float(), mediaTypeMatchKind(spec.s), nil}
+		for _, ac := range acs {
+			if ac.i == spec.o {
+				results[i].Params = copyParams(ac.params)
+				break
+			}
 		}
 	}
+	return results
+}
 
+// Copy the parameters of a range, so each result owns its map, or nil if
+// there are none.
+func copyParams(params map[string]string) map[string]string {
+	if len(params) == 0 {
+		return nil
+	}
+	results := make(map[string]string, len(params))
+	for k, v := range params {
+		results[k] = v
+	}
 	return results
 }
 
-// Parses the Accept header to slice with type acceptMediaType.
+// Sort the acceptable ranges by quality, then by position.
+func sortAcceptMediaTypes(acs acceptMediaTypes) acceptMediaTypes {
+	filteredAcs := acs.filter(isAcceptMediaTypeQuality)
+	acceptMediaTypeBy(func(ac1, ac2 *acceptMediaType) bool {
+		if ac1.q != ac2.q {
+			return ac1.q > ac2.q
+		}
+		return ac1.i < ac2.i
+	}).sort(filteredAcs)
+	return filteredAcs
+}
+
+// Parses the Accept header to slice with type acceptMediaType, the duplicates
+// of a range are dropped, see dedupeMediaRanges.
 func parseAcceptMediaType(accept string) acceptMediaTypes {
-	accepts := splitMediaTypes(accept)
+	return parseAcceptMediaTypeFilter(accept, mediaTypeFilter{})
+}
+
+// Parses the Accept header like parseAcceptMediaType, the duplicates are found
+// with the parameter comparison of the filter.
+func parseAcceptMediaTypeFilter(accept string, f mediaTypeFilter) acceptMediaTypes {
+	results, _ := parseAcceptMediaTypeErrors(accept, false)
+	return dedupeMediaRanges(results, f)
+}
+
+// Drop the duplicates of the ranges, i.e. the ranges of the same type, subtype
+// and parameters, e.g. of "application/json;q=0.5, application/json" as a
+// proxy may append its own Accept. The range of highest quality is kept, the
+// first one on a tie. A range governs the media types it matches with its
+// highest quality anyway, so only the listing of the ranges is affected. acs is
+// returned as is if it has no duplicates.
+func dedupeMediaRanges(acs acceptMediaTypes, f mediaTypeFilter) acceptMediaTypes {
+	best := bestMediaRanges(acs, f)
+	var results acceptMediaTypes
+	for i := range acs {
+		if best[i] == i {
+			if results != nil {
+				results = append(results, acs[i])
+			}
+			continue
+		}
+		if results == nil {
+			results = append(make(acceptMediaTypes, 0, len(acs)-1), acs[:i]...)
+		}
+	}
+	if results == nil {
+		return acs
+	}
+	return results
+}
+
+// The number of ranges up to which the ranges are compared pairwise to find the
+// duplicates, past it they're compared by a key, so that a huge header isn't
+// deduplicated in quadratic time.
+const dedupePairwiseThreshold = 16
+
+// Get the index of the range kept for each range, see dedupeMediaRanges.
+func bestMediaRanges(acs acceptMediaTypes, f mediaTypeFilter) []int {
+	best := make([]int, len(acs), len(acs))
+	if len(acs) <= dedupePairwiseThreshold {
+		for i := range acs {
+			best[i] = i
+			for j := 0; j < i; j++ {
+				if best[j] == j && sameMediaRange(&acs[i], &acs[j], f) {
+					if acs[i].q > acs[j].q {
+						best[j] = i
+					} else {
+						best[i] = j
+					}
+					break
+				}
+			}
+		}
+	} else {
+		keys := make(map[string]int, len(acs))
+		for i := range acs {
+			best[i] = i
+			key := mediaRangeKey(&acs[i], f)
+			if j, ok := keys[key]; ok {
+				if acs[i].q > acs[j].q {
+					best[j], keys[key] = i, i
+				} else {
+					best[i] = j
+				}
+			} else {
+				keys[key] = i
+			}
+		}
+	}
+	return best
+}
+
+// Get a key of a range, equal for the ranges which are the same, see
+// sameMediaRange.
+func mediaRangeKey(ac *acceptMediaType, f mediaTypeFilter) string {
+	params := make(map[string]string, len(ac.params))
+	for k, v := range ac.params {
+		if !f.caseSensitive[k] {
+			v = strings.ToLower(v)
+		}
+		params[k] = v
+	}
+	return formatMediaType(strings.ToLower(ac.mainType), strings.ToLower(ac.subtype), params)
+}
+
+// Reports whether two ranges have the same type, subtype and parameters,
+// ignoring case except for the values of the case-sensitive parameters.
+func sameMediaRange(ac1, ac2 *acceptMediaType, f mediaTypeFilter) bool {
+	if !strings.EqualFold(ac1.mainType, ac2.mainType) || !strings.EqualFold(ac1.subtype, ac2.subtype) || len(ac1.params) != len(ac2.params) {
+		return false
+	}
+	m := f.caseSensitive
+	for k, v1 := range ac1.params {
+		v2, ok := ac2.params[k]
+		if !ok || m[k] && v1 != v2 || !m[k] && !strings.EqualFold(v1, v2) {
+			return false
+		}
+	}
+	return true
+}
+
+// Parses the Accept header to slice with type acceptMediaType, and reports the
+// members which were dropped, and the members with an unbalanced quote which
+// were recovered. Empty members are skipped silently.
+func parseAcceptMediaTypeErrors(accept string, strict bool) (acceptMediaTypes, []*ParseError) {
+	accepts, flagged := splitQuotedN(accept, ',', getMaxAcceptRanges())
 	length := len(accepts)
-	results := make(acceptMediaTypes, 0, length)
+	results, errs := make(acceptMediaTypes, 0, length), []*ParseError(nil)
 
 	for i := 0; i < length; i++ {
-		mediaType := parseMediaType(strings.Trim(accepts[i], " "), i)
+		member := strings.Trim(accepts[i], " ")
+		if strings.TrimSpace(member) == "" {
+			continue
+		}
+		mediaType, err := parseMediaTypeErr(member, i, strict)
 		if mediaType != nil {
 			results = append(results, *mediaType)
+			if len(flagged) > 0 && flagged[0] == i {
+				errs = append(errs, &ParseError{HeaderAccept, member, i, ErrUnbalancedQuote, true})
+			}
+		} else {
+			errs = append(errs, &ParseError{HeaderAccept, member, i, err, false})
+		}
+		if len(flagged) > 0 && flagged[0] == i {
+			flagged = flagged[1:]
 		}
 	}
 
-	return results
+	return results, errs
+}
+
+// Get the preferred media type and how it matched when the charset is
+// negotiated separately, the charset parameters of the ranges are ignored to
+// match the media types. The charset parameter of the range the preferred
+// media type matched is returned too, "" if it has none.
+func preferredMediaTypeCharset(accept string, provided []string, f mediaTypeFilter) (string, MatchKind, string) {
+	acs, charsets := parseAcceptMediaTypeFilter(accept, f), map[int]string(nil)
+	for j, ac := range acs {
+		charset, ok := ac.params["charset"]
+		if !ok {
+			continue
+		}
+		if charsets == nil {
+			charsets = make(map[int]string)
+		}
+		charsets[ac.i] = charset
+		params := make(map[string]string, len(ac.params)-1)
+		for k, v := range ac.params {
+			if k != "charset" {
+				params[k] = v
+			}
+		}
+		acs[j].params = params
+	}
+	if charsets == nil {
+		mediaType, kind := bestMediaType(accept, provided, f)
+		return mediaType, kind, ""
+	}
+
+	priorities, keys := getMediaTypeSpecificities(provided, acs, f)
+	priorities = priorities.sortedBy(mediaTypeSpecsBy(keys))
+	if len(priorities) == 0 {
+		return "", MatchNone, ""
+	}
+	best := priorities[0]
+	return provided[best.i], mediaTypeMatchKind(best.s), charsets[best.o]
 }
 
 // Parse a media type from the Accept header.
 func parseMediaType(s string, i int) *acceptMediaType {
-	match, err := simpleMediaTypeRegExp.FindStringMatch(s)
-	if match == nil || match.GroupCount() == 0 || err != nil {
-		return nil
+	mediaType, _ := parseMediaTypeErr(s, i, false)
+	return mediaType
+}
+
+// Parse a media type from the Accept header, and report why it's malformed.
+// With strict, the q parameter must be a valid qvalue, see parseStrictQuality,
+// and the type can't be a wildcard unless the subtype is one too.
+func parseMediaTypeErr(s string, i int, strict bool) (*acceptMediaType, error) {
+	mainType, subType, rawParams, ok := scanMediaRange(s)
+	if !ok {
+		if !strings.Contains(s, "/") {
+			return nil, ErrMissingSlash
+		}
+		return nil, ErrMalformedRange
+	}
+	if strict && mainType == "*" && subType != "*" {
+		return nil, ErrWildcardType
 	}
 
 	params := make(map[string]string)
-	mainType, subType, q := match.Groups()[1].String(), match.Groups()[2].String(), 1.0
-	if match.Groups()[3].String() != "" {
-		kvps := splitParameters(match.Groups()[3].String())
-		arr := make([][]string, len(kvps), len(kvps))
-		for i, v := range kvps {
-			arr[i] = splitKeyValuePair(v)
-		}
-
-		for j := 0; j < len(arr); j++ {
-			pair := arr[j]
-			key, val := strings.ToLower(pair[0]), pair[1]
-			if val != "" && val[0] == '"' && val[len(val)-1] == '"' {
-				val = val[1:int(math.Max(float64(len(val)-1), 1))]
+	q, max := maxQuality, getMaxMediaRangeParameters()
+	for rest, more := rawParams, rawParams != ""; more; {
+		var param string
+		param, rest, more = cutQuoted(rest, ';')
+		key, val := cutKeyValuePair(param)
+		val = unquoteParameter(val)
+		if key == "" && val == "" {
+			// an empty parameter, e.g. of "text/html;" or "text/html;;q=1"
+			continue
+		}
+		if strings.EqualFold(key, "q") {
+			parse := parseQuality
+			if strict {
+				parse = parseStrictQuality
 			}
-			if key == "q" {
-				q1, err := strconv.ParseFloat(val, 64)
-				if err != nil {
-					return nil
-				}
-				q = q1
-				break
+			q1, err := parse(val)
+			if err != nil {
+				return nil, err
 			}
-			params[key] = val
+			q = q1
+			break
+		}
+		// the parameters past the limit are scanned for q without being kept
+		if len(params) < max || max <= 0 {
+			params[strings.ToLower(key)] = val
 		}
 	}
 
-	return &acceptMediaType{mainType, subType, params, q, i}
+	return &acceptMediaType{mainType, subType, params, q, i}, nil
+}
+
+// Scan a media range, the type and the subtype separated by a slash, then the
+// parameters following a semicolon, e.g. "text/html;level=1" is text, html and
+// level=1. Whitespace may surround the range, but can't be within the type or
+// the subtype, nor can a semicolon or a quote, and the parameters can't span
+// lines. ok is false if s isn't a media range.
+func scanMediaRange(s string) (mainType, subtype, params string, ok bool) {
+	i := skipSpace(s, 0)
+	start := i
+	for i < len(s) && s[i] != '/' && s[i] != ';' && s[i] != '"' && spaceWidth(s, i) == 0 {
+		i++
+	}
+	if i == start || i == len(s) || s[i] != '/' {
+		return "", "", "", false
+	}
+	mainType, i = s[start:i], i+1
+
+	start = i
+	for i < len(s) && s[i] != ';' && s[i] != '"' && spaceWidth(s, i) == 0 {
+		i++
+	}
+	if i == start {
+		return "", "", "", false
+	}
+	subtype, i = s[start:i], skipSpace(s, i)
+
+	if i < len(s) {
+		if s[i] != ';' {
+			return "", "", "", false
+		}
+		params = s[i+1:]
+		if n := strings.IndexByte(params, '\n'); n != -1 {
+			if n != len(params)-1 {
+				return "", "", "", false
+			}
+			params = params[:n]
+		}
+	}
+	return mainType, subtype, params, true
+}
+
+// Scan a range of the Accept-Charset or Accept-Encoding header, a value then
+// the parameters following a semicolon, e.g. "gzip;q=0.5" is gzip and q=0.5.
+// Whitespace may surround the value, but can't be within it, nor can a
+// semicolon, and the parameters can't span lines. ok is false if s isn't such
+// a range.
+func scanSimpleRange(s string) (value, params string, ok bool) {
+	i := skipSpace(s, 0)
+	start := i
+	for i < len(s) && s[i] != ';' && spaceWidth(s, i) == 0 {
+		i++
+	}
+	if i == start {
+		return "", "", false
+	}
+	value, i = s[start:i], skipSpace(s, i)
+
+	if i < len(s) {
+		if s[i] != ';' {
+			return "", "", false
+		}
+		params = s[i+1:]
+		if n := strings.IndexByte(params, '\n'); n != -1 {
+			if n != len(params)-1 {
+				return "", "", false
+			}
+			params = params[:n]
+		}
+	}
+	return value, params, true
+}
+
+// Get the index of the first character following the whitespace at index i of
+// s.
+func skipSpace(s string, i int) int {
+	for i < len(s) {
+		n := spaceWidth(s, i)
+		if n == 0 {
+			break
+		}
+		i += n
+	}
+	return i
+}
+
+// Get the width in bytes of the whitespace character at index i of s, or 0 if
+// it isn't whitespace. Besides ASCII whitespace, Unicode space separators,
+// e.g. U+00A0 no-break space, are whitespace.
+func spaceWidth(s string, i int) int {
+	if c := s[i]; c < utf8.RuneSelf {
+		if c == ' ' || '\t' <= c && c <= '\r' {
+			return 1
+		}
+		return 0
+	}
+	if r, n := utf8.DecodeRuneInString(s[i:]); unicode.IsSpace(r) {
+		return n
+	}
+	return 0
 }
 
 // Get the priority of a media type.
 func getMediaTypePriority(mediaType string, acs acceptMediaTypes, index int) specificity {
+	return getParsedMediaTypePriority(cachedMediaTypeOffer(mediaType), acs, nil, index, mediaTypeFilter{})
+}
+
+// Get the priority of a parsed media type over the ranges at indices, or over
+// all ranges if indices is nil.
+func getParsedMediaTypePriority(p *acceptMediaType, acs acceptMediaTypes, indices []int, index int, f mediaTypeFilter) specificity {
 	priority := specificity{o: -1, q: 0, s: 0}
+	if p == nil {
+		return priority
+	}
 
-	for i := 0; i < len(acs); i++ {
-		spec := mediaTypeSpecify(mediaType, acs[i], index)
-		if spec != nil {
-			s, q, o := priority.s-spec.s, priority.q-spec.q, priority.o-spec.o
-			if s < 0 || q < 0 || o < 0 {
-				priority = *spec
+	for j, n := 0, rangeCount(indices, len(acs)); j < n; j++ {
+		if spec, ok := parsedMediaTypeSpecificity(p, acs[rangeIndex(indices, j)], index, f); ok {
+			if spec.governs(priority) {
+				priority = spec
 			}
 		}
 	}
@@ -175,125 +784,435 @@ func mediaTypeSpecify(mediaType string, ac acceptMediaType, index int) *specific
 	if p == nil {
 		return nil
 	}
+	if spec, ok := parsedMediaTypeSpecificity(p, ac, index, mediaTypeFilter{}); ok {
+		return &spec
+	}
+	return nil
+}
 
+// Get the specificity of the parsed media type, ok is false if the range
+// doesn't match. The specificity bits are 8 for the type, 4 for the subtype,
+// 2 for the structured syntax suffix of a range like application/*+json, see
+// RFC 6839, and 1 for the parameters.
+func parsedMediaTypeSpecificity(p *acceptMediaType, ac acceptMediaType, index int, f mediaTypeFilter) (spec specificity, ok bool) {
 	s := 0
-	if strings.ToLower(ac.mainType) == strings.ToLower(p.mainType) {
-		s |= 4
+	if strings.EqualFold(ac.mainType, p.mainType) {
+		s |= 8
 	} else if ac.mainType != "*" {
-		return nil
+		return spec, false
 	}
 
-	if strings.ToLower(ac.subtype) == strings.ToLower(p.subtype) {
+	if strings.EqualFold(ac.subtype, p.subtype) {
+		s |= 4
+	} else if hasSuffix(p.subtype, ac.subtype) || s&8 != 0 && f.suffixFallback && isSuffixFallback(p.subtype, ac.subtype) {
 		s |= 2
 	} else if ac.subtype != "*" {
-		return nil
+		return spec, false
 	}
 
-	keys := getMapKeys(ac.params)
-	if len(keys) > 0 {
-		if every(keys, func(k string) bool {
-			return ac.params[k] == "*" || strings.ToLower(ac.params[k]) == strings.ToLower(p.params[k])
-		}) {
-			s |= 1
-		} else {
-			return nil
+	if len(ac.params) > 0 {
+		for k, v := range ac.params {
+			if v != "*" && !parameterValuesEqual(k, v, p.params[k], f) {
+				return spec, false
+			}
+		}
+		s |= 1
+	}
+
+	return specificity{index, ac.i, ac.q, s}, true
+}
+
+// Reports whether the subtype has the structured syntax suffix named by the
+// range subtype, e.g. vnd.api+json and json, see WithSuffixFallback.
+func isSuffixFallback(subtype, rangeSubtype string) bool {
+	i := strings.LastIndexByte(subtype, '+')
+	return i > 0 && strings.EqualFold(subtype[i+1:], rangeSubtype)
+}
+
+// mediaTypeFilter is how the ranges match the provided media types, the zero
+// value is the default of the package functions.
+type mediaTypeFilter struct {
+	// the names of the parameters whose values are compared case-sensitively,
+	// see WithCaseSensitiveParameters
+	caseSensitive map[string]bool
+	// whether a media type satisfies the range named by its structured syntax
+	// suffix, see WithSuffixFallback
+	suffixFallback bool
+}
+
+// Reports whether the filter is the default of the package functions.
+func (f mediaTypeFilter) isDefault() bool {
+	return len(f.caseSensitive) == 0 && !f.suffixFallback
+}
+
+// Reports whether two values of the parameter name are equal. The values of
+// the profile parameter, whitespace-separated lists of URIs, see RFC 6906, are
+// equal if they have a URI in common.
+func parameterValuesEqual(name, v1, v2 string, f mediaTypeFilter) bool {
+	equal := strings.EqualFold
+	if f.caseSensitive[name] {
+		equal = stringsEqual
+	}
+	if name == "profile" {
+		return profilesOverlap(v1, v2, equal)
+	}
+	return equal(v1, v2)
+}
+
+func stringsEqual(s1, s2 string) bool {
+	return s1 == s2
+}
+
+// Reports whether two lists of profile URIs have a URI in common.
+func profilesOverlap(v1, v2 string, equal func(s1, s2 string) bool) bool {
+	for rest1 := v1; ; {
+		var uri1 string
+		if uri1, rest1 = nextProfile(rest1); uri1 == "" {
+			return false
+		}
+		for rest2 := v2; ; {
+			var uri2 string
+			if uri2, rest2 = nextProfile(rest2); uri2 == "" {
+				break
+			}
+			if equal(uri1, uri2) {
+				return true
+			}
 		}
 	}
+}
 
-	return &specificity{index, ac.i, ac.q, s}
+// Get the first URI of a list of profile URIs and the rest of the list, the
+// URI is empty at the end of the list.
+func nextProfile(s string) (uri, rest string) {
+	start := 0
+	for start < len(s) && isOWS(s[start]) {
+		start++
+	}
+	end := start
+	for end < len(s) && !isOWS(s[end]) {
+		end++
+	}
+	return s[start:end], s[end:]
+}
+
+// Reports whether the subtype has the structured syntax suffix of a range
+// subtype like *+json, e.g. vnd.api+json.
+func hasSuffix(subtype, rangeSubtype string) bool {
+	if len(rangeSubtype) < 3 || rangeSubtype[0] != '*' || rangeSubtype[1] != '+' {
+		return false
+	}
+	suffix := rangeSubtype[1:]
+	return len(subtype) > len(suffix) && strings.EqualFold(subtype[len(subtype)-len(suffix):], suffix)
 }
 
 func isAcceptMediaTypeQuality(ac acceptMediaType) bool {
 	return ac.q > 0
 }
 
-func getMediaTypeSpecificities(types []string, acs acceptMediaTypes) specificities {
-	result := make(specificities, len(types), len(types))
+// Get the priorities of the media types, and their keys to break ties.
+func getMediaTypeSpecificities(types []string, acs acceptMediaTypes, f mediaTypeFilter) (specificities, []mediaTypeTieKey) {
+	offers := make([]*acceptMediaType, len(types), len(types))
 	for i, v := range types {
-		result[i] = getMediaTypePriority(v, acs, i)
+		offers[i] = cachedMediaTypeOffer(v)
+	}
+	return getParsedMediaTypeSpecificities(offers, acs, f), getMediaTypeTieKeys(offers)
+}
+
+// Get the priorities of the parsed media types, a nil media type isn't
+// acceptable.
+func getParsedMediaTypeSpecificities(offers []*acceptMediaType, acs acceptMediaTypes, f mediaTypeFilter) specificities {
+	result := make(specificities, len(offers), len(offers))
+	var buckets *rangeBuckets
+	if len(offers) >= offerBucketThreshold {
+		buckets = newRangeBuckets(len(acs), func(i int) (string, bool) {
+			return strings.ToLower(acs[i].mainType), acs[i].mainType == "*"
+		})
+	}
+
+	for i, p := range offers {
+		var indices []int
+		if p != nil && buckets != nil {
+			indices = buckets.get(strings.ToLower(p.mainType))
+		}
+		result[i] = getParsedMediaTypePriority(p, acs, indices, i, f)
 	}
 	return result
 }
 
-// Count the number of quotes in a string.
-func quoteCount(s string) int {
-	return strings.Count(s, "\"")
+// Get the keys of the parsed media types to break ties, see mediaTypeTieKey.
+func getMediaTypeTieKeys(offers []*acceptMediaType) []mediaTypeTieKey {
+	keys, groups := make([]mediaTypeTieKey, len(offers), len(offers)), make(map[string]int, len(offers))
+	for i, p := range offers {
+		keys[i].group = i
+		if p == nil {
+			continue
+		}
+		bare := strings.ToLower(p.mainType + "/" + p.subtype)
+		if group, ok := groups[bare]; ok {
+			keys[i].group = group
+		} else {
+			groups[bare] = i
+		}
+		keys[i].params = len(p.params)
+	}
+	return keys
 }
 
-// Split a key value pair.
-func splitKeyValuePair(s string) []string {
-	key, val, index := "", "", strings.Index(s, "=")
+// mediaTypeTieKey ranks media type offers which tie otherwise, e.g. text/html
+// and text/html;level=1 for `Accept: text/html`. Offers of the same type and
+// subtype form a group at the position of the first one, and within a group
+// the offer with the fewest parameters wins, whatever the order of the offers.
+type mediaTypeTieKey struct {
+	group  int
+	params int
+}
 
-	if index == -1 {
-		key = s
-	} else {
-		key, val = s[0:index], s[index+1:]
+// Compare the priorities of media types, ties are broken by their keys.
+func compareMediaTypeSpecs(s1, s2 *specificity, k1, k2 mediaTypeTieKey) bool {
+	if s1.q != s2.q || s1.s != s2.s || s1.o != s2.o || k1 == k2 {
+		return compareSpecs(s1, s2)
+	}
+	if k1.group != k2.group {
+		return k1.group < k2.group
 	}
+	return k1.params < k2.params
+}
 
-	return []string{key, val}
+func mediaTypeSpecsBy(keys []mediaTypeTieKey) specificityBy {
+	return func(s1, s2 *specificity) bool {
+		return compareMediaTypeSpecs(s1, s2, keys[s1.i], keys[s2.i])
+	}
 }
 
-// Split an Accept header into media types.
-func splitMediaTypes(accept string) []string {
-	accepts := strings.Split(accept, ",")
-	length := len(accepts)
-	i, j := 1, 0
+// Split a string by sep outside of quoted strings. A quoted string is opened by
+// a quote following `=`, and a backslash escapes the next character within it.
+// The indices of the parts with a stray quote, or with a quoted string which
+// isn't terminated, are reported too. An unterminated quoted string is closed
+// at the end of its part, so it never swallows the parts following it.
+func splitQuoted(s string, sep byte) ([]string, []int) {
+	return splitQuotedN(s, sep, 0)
+}
 
-	for ; i < length; i++ {
-		if quoteCount(accepts[j])%2 == 0 {
-			j++
-			accepts[j] = accepts[i]
-		} else {
-			accepts[j] += "," + accepts[i]
-		}
+// Split a string like splitQuoted into at most n parts, the remainder is
+// dropped. The number of parts isn't limited if n isn't positive.
+func splitQuotedN(s string, sep byte, n int) ([]string, []int) {
+	size := strings.Count(s, string(sep)) + 1
+	if n > 0 && size > n {
+		size = n
 	}
+	parts, flagged := make([]string, 0, size), []int(nil)
+	start, prev, stray := 0, byte(0), false
 
-	accepts = accepts[0 : j+1]
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == sep:
+			if stray {
+				flagged = append(flagged, len(parts))
+			}
+			parts = append(parts, s[start:i])
+			if len(parts) == n {
+				return parts, flagged
+			}
+			start, prev, stray = i+1, 0, false
+			continue
+		case c == '"' && prev == '=':
+			if end := quotedStringEnd(s, i); end != -1 {
+				i = end
+			} else {
+				stray = true
+			}
+		case c == '"':
+			stray = true
+		}
+		if c != ' ' && c != '\t' {
+			prev = c
+		}
+	}
 
-	return accepts
+	if stray {
+		flagged = append(flagged, len(parts))
+	}
+	return append(parts, s[start:]), flagged
 }
 
-// Split a string of parameters.
-func splitParameters(str string) []string {
-	parameters := strings.Split(str, ";")
-	length := len(parameters)
-	i, j := 1, 0
+// Cut s around the first sep which isn't in a quoted string, like the first
+// part of splitQuoted, without allocating. found is false if there's none.
+func cutQuoted(s string, sep byte) (before, after string, found bool) {
+	prev := byte(0)
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == sep:
+			return s[:i], s[i+1:], true
+		case c == '"' && prev == '=':
+			if end := quotedStringEnd(s, i); end != -1 {
+				i = end
+			}
+		}
+		if c != ' ' && c != '\t' {
+			prev = c
+		}
+	}
+	return s, "", false
+}
 
-	for ; i < length; i++ {
-		if quoteCount(parameters[j])%2 == 0 {
+// Get the index of the quote closing the quoted string opened at i, or -1 if
+// it isn't terminated.
+func quotedStringEnd(s string, i int) int {
+	for j := i + 1; j < len(s); j++ {
+		switch s[j] {
+		case '\\':
 			j++
-			parameters[j] = parameters[i]
-		} else {
-			parameters[j] += ";" + parameters[i]
+		case '"':
+			return j
 		}
 	}
+	return -1
+}
 
-	// trim parameters
-	parameters = parameters[0 : j+1]
-	length = len(parameters)
+// Unquote a parameter value, the quoted-pairs of a quoted string, see RFC 9110
+// section 5.6.4, are unescaped, e.g. "say \"hi\"" is say "hi". A value which
+// isn't a single quoted string, e.g. "a"b", is only stripped of its surrounding
+// quotes, and an unterminated quoted string isn't unquoted.
+func unquoteParameter(val string) string {
+	if val == "" || val[0] != '"' {
+		return val
+	}
+	end := quotedStringEnd(val, 0)
+	if end != len(val)-1 {
+		if val[len(val)-1] != '"' {
+			return val
+		}
+		if len(val) == 1 {
+			return ""
+		}
+		return val[1 : len(val)-1]
+	}
+	if strings.IndexByte(val[1:end], '\\') == -1 {
+		return val[1:end]
+	}
 
-	for i = 0; i < length; i++ {
-		parameters[i] = strings.Trim(parameters[i], " ")
+	var b strings.Builder
+	for j := 1; j < end; j++ {
+		if val[j] == '\\' {
+			j++
+		}
+		b.WriteByte(val[j])
 	}
+	return b.String()
+}
 
-	return parameters
+// LowercaseMediaType lowercases the type and the subtype of a media type, which
+// are case-insensitive, the parameters are kept as is, e.g. Text/HTML;Level=A
+// is text/html;Level=A.
+func LowercaseMediaType(mediaType string) string {
+	end := strings.IndexByte(mediaType, ';')
+	if end == -1 {
+		end = len(mediaType)
+	}
+	if lower := strings.ToLower(mediaType[:end]); lower != mediaType[:end] {
+		return lower + mediaType[end:]
+	}
+	return mediaType
 }
 
-func getMapKeys(m map[string]string) []string {
-	i, length := 0, len(m)
-	keys := make([]string, length, length)
-	for k := range m {
-		keys[i] = k
-		i++
+// Format a media type with the spacing of a Content-Type header value: no OWS
+// around the media type and the `=` of the parameters, and "; " before each
+// parameter. The parameters are kept in order with their values as written,
+// the empty ones are dropped.
+func formatContentType(mediaType string) string {
+	parts := splitParameters(mediaType)
+	var b strings.Builder
+	b.WriteString(trimOffer(parts[0]))
+	for _, param := range parts[1:] {
+		kv := splitKeyValuePair(param)
+		if kv[0] == "" && kv[1] == "" {
+			continue
+		}
+		b.WriteString("; " + kv[0])
+		if strings.IndexByte(param, '=') != -1 {
+			b.WriteString("=" + kv[1])
+		}
 	}
-	return keys
+	return b.String()
 }
 
-func every(arr []string, f func(s string) bool) bool {
-	for _, v := range arr {
-		if !f(v) {
-			return false
+// Format a media type with its parameters sorted by name, the values which
+// aren't tokens are quoted.
+func formatMediaType(mainType, subtype string, params map[string]string) string {
+	if len(params) == 0 {
+		return mainType + "/" + subtype
+	}
+	keys := make([]string, 0, len(params))
+	for k := range params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	b.WriteString(mainType + "/" + subtype)
+	for _, k := range keys {
+		b.WriteString(";" + k + "=")
+		if v := params[k]; isToken(v) {
+			b.WriteString(v)
+		} else {
+			b.WriteString(quoteParameter(v))
 		}
 	}
-	return true
+	return b.String()
+}
+
+// Quote a parameter value as a quoted string, quotes and backslashes are
+// escaped.
+func quoteParameter(val string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(val); i++ {
+		if val[i] == '"' || val[i] == '\\' {
+			b.WriteByte('\\')
+		}
+		b.WriteByte(val[i])
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// Cut a parameter into its key and value trimmed of OWS, like
+// splitKeyValuePair without allocating.
+func cutKeyValuePair(s string) (key, val string) {
+	if index := strings.IndexByte(s, '='); index != -1 {
+		return strings.Trim(s[:index], " \t"), strings.Trim(s[index+1:], " \t")
+	}
+	return strings.Trim(s, " \t"), ""
+}
+
+// Split a key value pair, the optional whitespace around the key and the value
+// is trimmed, e.g. "q = 0.8" is q and 0.8.
+func splitKeyValuePair(s string) []string {
+	key, val, index := "", "", strings.Index(s, "=")
+
+	if index == -1 {
+		key = s
+	} else {
+		key, val = s[0:index], s[index+1:]
+	}
+
+	return []string{strings.Trim(key, " \t"), strings.Trim(val, " \t")}
+}
+
+// Split an Accept header into media types.
+func splitMediaTypes(accept string) []string {
+	accepts, _ := splitQuoted(accept, ',')
+	return accepts
+}
+
+// Split a string of parameters.
+func splitParameters(str string) []string {
+	parameters, _ := splitQuoted(str, ';')
+	for i := range parameters {
+		parameters[i] = strings.Trim(parameters[i], " ")
+	}
+	return parameters
 }
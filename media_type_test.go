@@ -5,11 +5,49 @@
 package negotiator
 
 import (
+	"net/http"
 	"reflect"
+	"strconv"
+	"strings"
 	"testing"
 )
 
 var preferredMediaTypeTestObjs = []testObj{
+	{
+		"text/html",
+		[]string{"text/html;level=1", "text/html"},
+		[]string{"text/html", "text/html;level=1"},
+	},
+	{
+		"text/html;level=1",
+		[]string{"text/html", "text/html;level=1"},
+		[]string{"text/html;level=1"},
+	},
+	{
+		"text/html;level=1",
+		[]string{"text/html;level=1;charset=utf-8", "text/html;level=1"},
+		[]string{"text/html;level=1", "text/html;level=1;charset=utf-8"},
+	},
+	{
+		"text/html;level=1",
+		[]string{"text/html;level=2", "text/html"},
+		[]string{},
+	},
+	{
+		"*/*",
+		[]string{"text/html;level=1", "application/json", "text/html"},
+		[]string{"text/html", "text/html;level=1", "application/json"},
+	},
+	{
+		"text/html; q =0.3, application/json;q=0.5",
+		[]string{"text/html", "application/json"},
+		[]string{"application/json", "text/html"},
+	},
+	{
+		"text/html; level = 1",
+		[]string{"text/html;level=1"},
+		[]string{"text/html;level=1"},
+	},
 	{
 		"text/html",
 		nil,
@@ -125,6 +163,66 @@ var preferredMediaTypeTestObjs = []testObj{
 		[]string{"application/json", "text/plain"},
 		[]string{"application/json", "text/plain"},
 	},
+	{
+		"*/*, text/*, text/html, text/html;level=1",
+		[]string{"image/png", "text/plain", "text/html", "text/html;level=1"},
+		[]string{"text/html;level=1", "text/html", "text/plain", "image/png"},
+	},
+	{
+		"text/*;q=0.3, text/plain;q=0.7, text/plain;format=flowed, text/plain;format=fixed;q=0.4, */*;q=0.5",
+		[]string{"text/html;level=3", "text/plain;format=fixed", "image/jpeg", "text/html", "text/plain", "text/plain;format=flowed"},
+		[]string{"text/plain;format=flowed", "text/plain", "image/jpeg", "text/plain;format=fixed", "text/html", "text/html;level=3"},
+	},
+	{
+		"*/*, text/html;q=0",
+		[]string{"text/html", "application/json"},
+		[]string{"application/json"},
+	},
+	{
+		"text/*;q=0, */*",
+		[]string{"text/html", "text/plain", "application/json"},
+		[]string{"application/json"},
+	},
+	{
+		"text/*;q=0, text/html, */*;q=0.5",
+		[]string{"text/plain", "application/json", "text/html"},
+		[]string{"text/html", "application/json"},
+	},
+	{
+		`text/plain;title="say \"hi\"";q=0.5, application/json`,
+		[]string{`text/plain;title="say \"hi\""`, "application/json"},
+		[]string{"application/json", `text/plain;title="say \"hi\""`},
+	},
+	{
+		`text/plain;path="a\\", application/json;q=0.5`,
+		[]string{"application/json", `text/plain;path="a\\"`, `text/plain;path="a"`},
+		[]string{`text/plain;path="a\\"`, "application/json"},
+	},
+	{
+		"application/*+json",
+		[]string{"application/json", "application/vnd.myapi+json", "application/problem+json", "text/x+json"},
+		[]string{"application/vnd.myapi+json", "application/problem+json"},
+	},
+	{
+		"application/*+json;q=0.5, application/problem+json",
+		[]string{"application/vnd.myapi+json", "application/problem+json"},
+		[]string{"application/problem+json", "application/vnd.myapi+json"},
+	},
+	{
+		"application/*;q=0.2, application/*+JSON",
+		[]string{"application/xml", "application/vnd.myapi+json"},
+		[]string{"application/vnd.myapi+json", "application/xml"},
+	},
+	{
+		"*/*+json",
+		[]string{"text/html", "text/x+json", "+json/+json"},
+		[]string{"text/x+json"},
+	},
+	{
+		"text/html;Q=0.5, application/json",
+		[]string{"text/html", "application/json"},
+		[]string{"application/json", "text/html"},
+	},
 }
 
 func TestPreferredMediaTypes(t *testing.T) {
@@ -135,50 +233,157 @@ func TestPreferredMediaTypes(t *testing.T) {
 	}
 }
 
+func TestPreferredMediaTypesMinQuality(t *testing.T) {
+	tests := []struct {
+		accept     string
+		minQuality float64
+		provided   []string
+		expected   []string
+	}{
+		{"text/html, application/json;q=0.2", 0.5, []string{"application/json", "text/html"}, []string{"text/html"}},
+		{"text/html, application/json;q=0.2", 0.2, []string{"application/json", "text/html"}, []string{"text/html", "application/json"}},
+		{"text/html;q=0.1, */*;q=0.5", 0.5, []string{"text/html", "image/png"}, []string{"image/png"}},
+		{"text/html, application/json;q=0.2", 0.5, nil, []string{"text/html"}},
+		{"text/html;q=0", 0, []string{"text/html"}, []string{}},
+	}
+	for _, tt := range tests {
+		if got := PreferredMediaTypesMinQuality(tt.accept, tt.minQuality, tt.provided...); !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("%q: "+testErrorFormat, tt.accept, got, tt.expected)
+		}
+	}
+}
+
 func TestParseAcceptMediaType(t *testing.T) {
 	tests := []struct {
 		s        string
 		expected acceptMediaTypes
 	}{
-		{"text/html", acceptMediaTypes{{"text", "html", map[string]string{}, 1, 0}}},
+		{"text/html", acceptMediaTypes{{"text", "html", map[string]string{}, 1000, 0}}},
 		{
 			"text/html, application/*;q=0.2, image/jpeg;q=0.8",
 			acceptMediaTypes{
-				{"text", "html", map[string]string{}, 1, 0},
-				{"application", "*", map[string]string{}, .2, 1},
-				{"image", "jpeg", map[string]string{}, .8, 2},
+				{"text", "html", map[string]string{}, 1000, 0},
+				{"application", "*", map[string]string{}, 200, 1},
+				{"image", "jpeg", map[string]string{}, 800, 2},
 			},
 		},
 		{
 			"\"text/html, application/*;q=0.2, image/jpeg;q=0.8\"",
-			acceptMediaTypes{},
+			acceptMediaTypes{{"application", "*", map[string]string{}, 200, 1}},
 		},
 	}
 	for _, tt := range tests {
 		if got := parseAcceptMediaType(tt.s); !acceptMediaTypeEquals(got, tt.expected) {
 			t.Errorf(testErrorFormat, got, tt.expected)
 		}
+		if got := ParseAccept(tt.s); !reflect.DeepEqual(got, toMediaTypes(tt.expected)) {
+			t.Errorf(testErrorFormat, got, toMediaTypes(tt.expected))
+		}
 	}
 }
 
+func TestParseAccept(t *testing.T) {
+	for _, tt := range preferredMediaTypeTestObjs {
+		if got, expected := ParseAccept(tt.accept), toMediaTypes(parseAcceptMediaType(tt.accept)); !reflect.DeepEqual(got, expected) {
+			t.Errorf(testErrorFormat, got, expected)
+		}
+	}
+
+	tests := []struct {
+		s        string
+		expected []MediaType
+	}{
+		{"", []MediaType{}},
+		{
+			"text/html;level=1;q=0.5, bogus, */*;q=0.1",
+			[]MediaType{
+				{"text", "html", map[string]string{"level": "1"}, 0.5, 0},
+				{"*", "*", map[string]string{}, 0.1, 2},
+			},
+		},
+	}
+	for _, tt := range tests {
+		if got := ParseAccept(tt.s); !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf(testErrorFormat, got, tt.expected)
+		}
+	}
+
+	if got, expected := ParseAccept(`text/html;title="a b";level=1;q=0.5`)[0].String(), `text/html;level=1;title="a b"`; got != expected {
+		t.Errorf(testErrorFormat, got, expected)
+	}
+}
+
+func TestParseAcceptMediaTypes(t *testing.T) {
+	tests := []struct {
+		s        string
+		expected []MediaType
+		errs     []error
+	}{
+		{"text/html, application/json;q=0.5", ParseAccept("text/html, application/json;q=0.5"), nil},
+		{
+			"application/json; q=.x, text/html",
+			[]MediaType{{"text", "html", map[string]string{}, 1, 1}},
+			[]error{&ParseError{HeaderAccept, "application/json; q=.x", 0, ErrInvalidQuality, false}},
+		},
+		{
+			`json, text/html;title="a, , image/png;q=0.5`,
+			[]MediaType{
+				{"text", "html", map[string]string{"title": `"a`}, 1, 1},
+				{"image", "png", map[string]string{}, 0.5, 3},
+			},
+			[]error{
+				&ParseError{HeaderAccept, "json", 0, ErrMissingSlash, false},
+				&ParseError{HeaderAccept, `text/html;title="a`, 1, ErrUnbalancedQuote, true},
+			},
+		},
+	}
+	for _, tt := range tests {
+		got, errs := ParseAcceptMediaTypes(tt.s)
+		if !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf(testErrorFormat, got, tt.expected)
+		}
+		if !reflect.DeepEqual(errs, tt.errs) {
+			t.Errorf(testErrorFormat, errs, tt.errs)
+		}
+		if preferred := PreferredMediaTypes(tt.s); len(preferred) != len(got) {
+			t.Errorf(testErrorFormat, preferred, got)
+		}
+	}
+}
+
+func toMediaTypes(acs acceptMediaTypes) []MediaType {
+	results := make([]MediaType, len(acs), len(acs))
+	for i, ac := range acs {
+		results[i] = MediaType{ac.mainType, ac.subtype, ac.params, ac.q.float(), ac.i}
+	}
+	return results
+}
+
 func TestParseMediaType(t *testing.T) {
 	tests := []struct {
 		s        string
 		i        int
 		expected *acceptMediaType
 	}{
-		{"text/html", 0, &acceptMediaType{"text", "html", map[string]string{}, 1, 0}},
-		{"text/html;q=0.8", 1, &acceptMediaType{"text", "html", map[string]string{}, .8, 1}},
-		{"text/*", 2, &acceptMediaType{"text", "*", map[string]string{}, 1, 2}},
-		{"text/*;q=.8", 3, &acceptMediaType{"text", "*", map[string]string{}, .8, 3}},
-		{"*/*;q=0.8", 4, &acceptMediaType{"*", "*", map[string]string{}, .8, 4}},
-		{"text/*;p=0.8", 5, &acceptMediaType{"text", "*", map[string]string{"p": "0.8"}, 1, 5}},
-		{"text/*;p=\"", 6, &acceptMediaType{"text", "*", map[string]string{"p": ""}, 1, 6}},
-		{"text/*;p=\"0.8", 7, &acceptMediaType{"text", "*", map[string]string{"p": "\"0.8"}, 1, 7}},
-		{"text/*;p=\"0.8\"", 8, &acceptMediaType{"text", "*", map[string]string{"p": "0.8"}, 1, 8}},
-		{"text/*;q=\"0.8\"", 9, &acceptMediaType{"text", "*", map[string]string{}, .8, 9}},
-		{"text/html ; q=0.8", 10, &acceptMediaType{"text", "html", map[string]string{}, .8, 10}},
+		{"text/html", 0, &acceptMediaType{"text", "html", map[string]string{}, 1000, 0}},
+		{"text/html;q=0.8", 1, &acceptMediaType{"text", "html", map[string]string{}, 800, 1}},
+		{"text/*", 2, &acceptMediaType{"text", "*", map[string]string{}, 1000, 2}},
+		{"text/*;q=.8", 3, &acceptMediaType{"text", "*", map[string]string{}, 800, 3}},
+		{"*/*;q=0.8", 4, &acceptMediaType{"*", "*", map[string]string{}, 800, 4}},
+		{"text/*;p=0.8", 5, &acceptMediaType{"text", "*", map[string]string{"p": "0.8"}, 1000, 5}},
+		{"text/*;p=\"", 6, &acceptMediaType{"text", "*", map[string]string{"p": ""}, 1000, 6}},
+		{"text/*;p=\"0.8", 7, &acceptMediaType{"text", "*", map[string]string{"p": "\"0.8"}, 1000, 7}},
+		{"text/*;p=\"0.8\"", 8, &acceptMediaType{"text", "*", map[string]string{"p": "0.8"}, 1000, 8}},
+		{"text/*;q=\"0.8\"", 9, &acceptMediaType{"text", "*", map[string]string{}, 800, 9}},
+		{"text/html ; q=0.8", 10, &acceptMediaType{"text", "html", map[string]string{}, 800, 10}},
 		{"text/html;q=x", 11, nil},
+		{"text/html; q =0.3", 12, &acceptMediaType{"text", "html", map[string]string{}, 300, 12}},
+		{"text/html; Q\t= 0.3", 13, &acceptMediaType{"text", "html", map[string]string{}, 300, 13}},
+		{"text/html; level = 1 ;q=0.5", 14, &acceptMediaType{"text", "html", map[string]string{"level": "1"}, 500, 14}},
+		{`text/plain;title="say \"hi\""`, 15, &acceptMediaType{"text", "plain", map[string]string{"title": `say "hi"`}, 1000, 15}},
+		{`text/plain;path="a\\b";q=0.5`, 16, &acceptMediaType{"text", "plain", map[string]string{"path": `a\b`}, 500, 16}},
+		{`text/plain;title="a;b=\"c\"";q=0.5`, 17, &acceptMediaType{"text", "plain", map[string]string{"title": `a;b="c"`}, 500, 17}},
+		{`text/plain;title="say \"hi`, 18, &acceptMediaType{"text", "plain", map[string]string{"title": `"say \"hi`}, 1000, 18}},
 	}
 	for _, tt := range tests {
 		got := parseMediaType(tt.s, tt.i)
@@ -190,8 +395,8 @@ func TestParseMediaType(t *testing.T) {
 
 func TestGetMediaTypePriority(t *testing.T) {
 	acs := acceptMediaTypes{
-		{"text", "html", map[string]string{}, 1, 0},
-		{"text", "*", map[string]string{}, .8, 1},
+		{"text", "html", map[string]string{}, 1000, 0},
+		{"text", "*", map[string]string{}, 800, 1},
 	}
 	tests := []struct {
 		mediaType string
@@ -200,9 +405,9 @@ func TestGetMediaTypePriority(t *testing.T) {
 		expected  specificity
 	}{
 		{"text/html", acceptMediaTypes{}, 0, specificity{0, -1, 0, 0}},
-		{"text/html", acs, 1, specificity{1, 1, 0.8, 4}},
-		{"text/*", acs, 2, specificity{2, 1, .8, 6}},
-		{"text/plain", acs, 3, specificity{3, 1, .8, 4}},
+		{"text/html", acs, 1, specificity{1, 0, 1000, 12}},
+		{"text/*", acs, 2, specificity{2, 1, 800, 12}},
+		{"text/plain", acs, 3, specificity{3, 1, 800, 8}},
 		{"image/png", acs, 4, specificity{0, -1, 0, 0}},
 		{"image/*", acs, 5, specificity{0, -1, 0, 0}},
 		{"*/*", acs, 6, specificity{0, -1, 0, 0}},
@@ -224,93 +429,105 @@ func TestMediaTypeSpecify(t *testing.T) {
 	}{
 		{
 			"text/html",
-			acceptMediaType{"text", "html", map[string]string{}, 1, 0},
+			acceptMediaType{"text", "html", map[string]string{}, 1000, 0},
 			0,
-			&specificity{0, 0, 1, 6},
+			&specificity{0, 0, 1000, 12},
 		},
 		{
 			"text/html;q=0.8",
-			acceptMediaType{"text", "html", map[string]string{}, .8, 1},
+			acceptMediaType{"text", "html", map[string]string{}, 800, 1},
 			1,
-			&specificity{1, 1, .8, 6},
+			&specificity{1, 1, 800, 12},
 		},
 		{
 			"text/*",
-			acceptMediaType{"text", "*", map[string]string{}, 1, 2},
+			acceptMediaType{"text", "*", map[string]string{}, 1000, 2},
 			2,
-			&specificity{2, 2, 1, 6},
+			&specificity{2, 2, 1000, 12},
 		},
 		{
 			"text/*;q=0.8",
-			acceptMediaType{"text", "*", map[string]string{}, .8, 3},
+			acceptMediaType{"text", "*", map[string]string{}, 800, 3},
 			3,
-			&specificity{3, 3, .8, 6},
+			&specificity{3, 3, 800, 12},
 		},
 		{
 			"text/html;p=0.8",
-			acceptMediaType{"text", "html", map[string]string{}, .8, 4},
+			acceptMediaType{"text", "html", map[string]string{}, 800, 4},
 			4,
-			&specificity{4, 4, .8, 6},
+			&specificity{4, 4, 800, 12},
 		},
 		{
 			"text/html;p=\"",
-			acceptMediaType{"text", "html", map[string]string{}, .8, 5},
+			acceptMediaType{"text", "html", map[string]string{}, 800, 5},
 			5,
-			&specificity{5, 5, .8, 6},
+			&specificity{5, 5, 800, 12},
 		},
 		{
 			"text/html;p=\"0.8\"",
-			acceptMediaType{"text", "html", map[string]string{}, .8, 6},
+			acceptMediaType{"text", "html", map[string]string{}, 800, 6},
 			6,
-			&specificity{6, 6, .8, 6},
+			&specificity{6, 6, 800, 12},
 		},
 		{
 			"text/html;q=\"0.8\"",
-			acceptMediaType{"text", "html", map[string]string{}, .8, 7},
+			acceptMediaType{"text", "html", map[string]string{}, 800, 7},
 			7,
-			&specificity{7, 7, .8, 6},
+			&specificity{7, 7, 800, 12},
 		},
 		{
 			"text/html",
-			acceptMediaType{"text", "*", map[string]string{}, 1, 8},
+			acceptMediaType{"text", "*", map[string]string{}, 1000, 8},
 			8,
-			&specificity{8, 8, 1, 4},
+			&specificity{8, 8, 1000, 8},
 		},
 		{
 			"text/*",
-			acceptMediaType{"text", "html", map[string]string{}, 1, 9},
+			acceptMediaType{"text", "html", map[string]string{}, 1000, 9},
 			9,
 			nil,
 		},
 		{
 			"text/*",
-			acceptMediaType{"image", "*", map[string]string{}, 1, 10},
+			acceptMediaType{"image", "*", map[string]string{}, 1000, 10},
 			10,
 			nil,
 		},
 		{
 			"text/*",
-			acceptMediaType{"*", "*", map[string]string{}, 1, 11},
+			acceptMediaType{"*", "*", map[string]string{}, 1000, 11},
 			11,
-			&specificity{11, 11, 1, 2},
+			&specificity{11, 11, 1000, 4},
 		},
 		{
 			"",
-			acceptMediaType{"*", "*", map[string]string{}, 1, 12},
+			acceptMediaType{"*", "*", map[string]string{}, 1000, 12},
 			12,
 			nil,
 		},
 		{
 			"text/html",
-			acceptMediaType{"*", "*", map[string]string{"foo": "bar"}, 1, 13},
+			acceptMediaType{"*", "*", map[string]string{"foo": "bar"}, 1000, 13},
 			13,
 			nil,
 		},
 		{
 			"text/html",
-			acceptMediaType{"*", "*", map[string]string{"foo": "*"}, 1, 14},
+			acceptMediaType{"*", "*", map[string]string{"foo": "*"}, 1000, 14},
 			14,
-			&specificity{14, 14, 1, 1},
+			&specificity{14, 14, 1000, 1},
+		},
+		{
+			"application/vnd.api+json",
+			acceptMediaType{"application", "*+json", map[string]string{}, 1000, 15},
+			15,
+			&specificity{15, 15, 1000, 10},
+		},
+		{
+			"application/json",
+			acceptMediaType{"application", "*+json", map[string]string{}, 1000, 16},
+			16,
+			nil,
 		},
 	}
 	for i, tt := range tests {
@@ -321,17 +538,662 @@ func TestMediaTypeSpecify(t *testing.T) {
 	}
 }
 
-func TestQuoteCount(t *testing.T) {
+func TestSplitQuoted(t *testing.T) {
+	tests := []struct {
+		s        string
+		sep      byte
+		expected []string
+		flagged  []int
+	}{
+		{"", ',', []string{""}, nil},
+		{"a, b", ',', []string{"a", " b"}, nil},
+		{`a;p="x,y", b`, ',', []string{`a;p="x,y"`, " b"}, nil},
+		{`a;p = "x,y", b`, ',', []string{`a;p = "x,y"`, " b"}, nil},
+		{`a;p="x\",y", b`, ',', []string{`a;p="x\",y"`, " b"}, nil},
+		{`a;p="x\\", b`, ',', []string{`a;p="x\\"`, " b"}, nil},
+		{`a;p="x"y", b`, ',', []string{`a;p="x"y"`, " b"}, []int{0}},
+		{`a;p="x, b, c`, ',', []string{`a;p="x`, " b", " c"}, []int{0}},
+		{`a, b;p="x\`, ',', []string{"a", ` b;p="x\`}, []int{1}},
+		{`"a, b"`, ',', []string{`"a`, ` b"`}, []int{0, 1}},
+		{`p="x;y";q=1`, ';', []string{`p="x;y"`, "q=1"}, nil},
+	}
+	for _, tt := range tests {
+		got, flagged := splitQuoted(tt.s, tt.sep)
+		if !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf(testErrorFormat, got, tt.expected)
+		}
+		if !reflect.DeepEqual(flagged, tt.flagged) {
+			t.Errorf(testErrorFormat, flagged, tt.flagged)
+		}
+	}
+}
+
+func TestPreferredMediaTypes_StrayQuote(t *testing.T) {
+	tests := []struct {
+		accept   string
+		provided []string
+		expected []string
+	}{
+		{
+			`text/html;title="a"b", application/json`,
+			[]string{"application/json"},
+			[]string{"application/json"},
+		},
+		{
+			`text/html;title="a, application/json;q=0.5`,
+			[]string{"text/html", "application/json"},
+			[]string{"application/json"},
+		},
+		{
+			`application/json;q=0.5, text/html;p="x, image/png`,
+			[]string{"application/json", "image/png"},
+			[]string{"image/png", "application/json"},
+		},
+		{
+			`text/html;title="a,b", application/json;q=0.5`,
+			[]string{"application/json", `text/html;title="a,b"`},
+			[]string{`text/html;title="a,b"`, "application/json"},
+		},
+	}
+	for _, tt := range tests {
+		if got := PreferredMediaTypes(tt.accept, tt.provided...); !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf(testErrorFormat, got, tt.expected)
+		}
+		n := New(http.Header{HeaderAccept: {tt.accept}})
+		if got := n.MediaType(tt.provided...); got != tt.expected[0] {
+			t.Errorf(testErrorFormat, got, tt.expected[0])
+		}
+	}
+}
+
+func TestPreferredMediaTypes_ProvidedParams(t *testing.T) {
+	provided := []string{"application/json;version=1", "application/json;version=2", "application/json"}
+	tests := []struct {
+		accept   string
+		expected []string
+	}{
+		{"application/json;version=2", []string{"application/json;version=2"}},
+		{"application/json;VERSION=\"2\"", []string{"application/json;version=2"}},
+		{"application/json;version=3", []string{}},
+		{"application/*;version=1", []string{"application/json;version=1"}},
+		{"application/json;version=1;q=0.5, application/json;version=2", []string{"application/json;version=2", "application/json;version=1"}},
+		{"application/json;version=2;q=0.5, application/json;q=0.8", []string{"application/json", "application/json;version=1", "application/json;version=2"}},
+		{"application/json;version=2, application/json;q=0.8", []string{"application/json;version=2", "application/json", "application/json;version=1"}},
+		{"application/json", []string{"application/json", "application/json;version=1", "application/json;version=2"}},
+		{"application/json;version=*", []string{"application/json", "application/json;version=1", "application/json;version=2"}},
+	}
+	for _, tt := range tests {
+		if got := PreferredMediaTypes(tt.accept, provided...); !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf(testErrorFormat, got, tt.expected)
+		}
+		n := New(http.Header{HeaderAccept: {tt.accept}})
+		if got, expected := n.MediaType(provided...), getMostPreferred(tt.expected); got != expected {
+			t.Errorf(testErrorFormat, got, expected)
+		}
+	}
+}
+
+func TestPreferredMediaTypes_QuotedSeparators(t *testing.T) {
+	provided := []string{`text/html;title="a;b"`, `text/html;title="a,b"`, `text/html;title=a;b`, "application/json"}
+	tests := []struct {
+		accept   string
+		expected []string
+	}{
+		{`text/html;title="a;b"`, []string{`text/html;title="a;b"`}},
+		{`text/html;title="a,b", application/json;q=0.5`, []string{`text/html;title="a,b"`, "application/json"}},
+		{`text/html;title=a`, []string{`text/html;title=a;b`}},
+		{`text/html;TITLE="a\;b";q=0.5, application/json`, []string{"application/json", `text/html;title="a;b"`}},
+		{`text/html;title="a;b;q=0.5", application/json;q=0.1`, []string{"application/json"}},
+	}
+	for _, tt := range tests {
+		if got := PreferredMediaTypes(tt.accept, provided...); !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("%q: "+testErrorFormat, tt.accept, got, tt.expected)
+		}
+	}
+}
+
+func TestMediaTypeString_QuotedSeparatorsRoundTrip(t *testing.T) {
+	for _, accept := range []string{`text/html;title="a;b"`, `text/html;title="a,b";q=0.5`, `text/html;title="say \"a, b; c\""`, `text/html;a="x;y";b="1,2"`} {
+		for _, mt := range ParseAccept(accept) {
+			formatted := mt.String()
+			reparsed := ParseAccept(formatted)
+			if len(reparsed) != 1 || !reflect.DeepEqual(reparsed[0].Params, mt.Params) {
+				t.Errorf("%q formatted as %q: "+testErrorFormat, accept, formatted, reparsed, mt)
+			}
+			if got := PreferredMediaTypes(accept, formatted); !reflect.DeepEqual(got, []string{formatted}) {
+				t.Errorf("%q: "+testErrorFormat, accept, got, []string{formatted})
+			}
+		}
+	}
+}
+
+func TestPreferredMediaTypes_Profile(t *testing.T) {
+	const jsonld = "http://www.w3.org/ns/json-ld#"
+	provided := []string{
+		`application/ld+json;profile="` + jsonld + `expanded"`,
+		`application/ld+json;profile="` + jsonld + `compacted"`,
+		`application/ld+json;profile="` + jsonld + `flattened ` + jsonld + `compacted"`,
+		"application/ld+json",
+	}
+	tests := []struct {
+		accept   string
+		expected []string
+	}{
+		{`application/ld+json;profile="` + jsonld + `expanded"`, []string{provided[0]}},
+		{`application/ld+json;profile="` + jsonld + `compacted"`, []string{provided[1], provided[2]}},
+		{`application/ld+json;profile="` + jsonld + `flattened"`, []string{provided[2]}},
+		{`application/ld+json;profile="  ` + jsonld + `expanded	` + jsonld + `flattened "`, []string{provided[0], provided[2]}},
+		{`application/ld+json;profile="http://example.com/other"`, []string{}},
+		{`application/ld+json;profile=""`, []string{}},
+		{`application/ld+json;profile="` + jsonld + `compacted";q=0.5, application/ld+json`, []string{provided[3], provided[0], provided[1], provided[2]}},
+	}
+	for _, tt := range tests {
+		if got := PreferredMediaTypes(tt.accept, provided...); !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("%q: "+testErrorFormat, tt.accept, got, tt.expected)
+		}
+	}
+}
+
+func TestProfilesOverlap(t *testing.T) {
+	tests := []struct {
+		v1, v2   string
+		expected bool
+	}{
+		{"a", "a", true},
+		{"a b", "c b", true},
+		{" a\tb ", "b", true},
+		{"a b", "c d", false},
+		{"A", "a", true},
+		{"", "", false},
+		{"a", "", false},
+	}
+	for _, tt := range tests {
+		if got := profilesOverlap(tt.v1, tt.v2, strings.EqualFold); got != tt.expected {
+			t.Errorf("%q %q: "+testErrorFormat, tt.v1, tt.v2, got, tt.expected)
+		}
+	}
+	if profilesOverlap("A", "a", stringsEqual) {
+		t.Errorf(testErrorFormat, true, false)
+	}
+}
+
+func TestFormatAccept(t *testing.T) {
 	tests := []struct {
 		s        string
+		expected string
+	}{
+		{"", ""},
+		{"text/html;q=0.8, , application/json", "text/html;q=0.8, application/json"},
+		{"text/html;Level=1;charset=utf-8;q=0.5", "text/html;charset=utf-8;level=1;q=0.5"},
+		{`text/plain;x="a,b";y=;z="\\"`, `text/plain;x="a,b";y="";z="\\"`},
+	}
+	for _, tt := range tests {
+		if got := FormatAccept(ParseAccept(tt.s)); got != tt.expected {
+			t.Errorf(testErrorFormat, got, tt.expected)
+		}
+	}
+}
+
+func TestMaxMediaRangeParameters(t *testing.T) {
+	params := func(n int) string {
+		var b strings.Builder
+		for i := 0; i < n; i++ {
+			b.WriteString(";p")
+			b.WriteString(strconv.Itoa(i))
+			b.WriteString("=v")
+		}
+		return b.String()
+	}
+
+	if p := parseMediaType("text/html"+params(10000), 0); len(p.params) != getMaxMediaRangeParameters() {
+		t.Errorf(testErrorFormat, len(p.params), getMaxMediaRangeParameters())
+	}
+	bounded := testing.AllocsPerRun(10, func() { parseMediaType("text/html"+params(20), 0) })
+	huge := "text/html" + params(10000)
+	if allocs := testing.AllocsPerRun(10, func() { parseMediaType(huge, 0) }); allocs > bounded {
+		t.Errorf("parsing 10000 parameters allocates %v times, expect at most %v", allocs, bounded)
+	}
+
+	tests := []struct {
+		accept   string
+		provided string
+		expected []string
+	}{
+		{"text/html" + params(16) + ";q=0.5, */*;q=0.1", "text/html" + params(16), []string{"text/html" + params(16)}},
+		{"text/html" + params(16) + ";p16=x", "text/html" + params(16), []string{"text/html" + params(16)}},
+		{"text/html" + params(17) + ";q=0", "text/html" + params(17), []string{}},
+		{"text/html" + params(10000) + ";q=0, */*", "text/html" + params(16), []string{}},
+		{"text/html;;;;;;;;;;;;;;;;;;" + params(16) + ";q=0.5, text/*;q=0.1", "text/html" + params(16), []string{"text/html" + params(16)}},
+	}
+	for _, tt := range tests {
+		if got := PreferredMediaTypes(tt.accept, tt.provided); !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("%q: "+testErrorFormat, tt.accept, got, tt.expected)
+		}
+	}
+	if got := WeightedMediaTypes("text/html"+params(16)+";q=0.5", "text/html"+params(16)); len(got) != 1 || got[0].Quality != 0.5 {
+		t.Errorf(testErrorFormat, got, 0.5)
+	}
+	if got := WeightedMediaTypes("text/html"+params(40)+";q=0.5", "text/html"+params(16)); len(got) != 1 || got[0].Quality != 0.5 {
+		t.Errorf(testErrorFormat, got, 0.5)
+	}
+
+	defer SetMaxMediaRangeParameters(getMaxMediaRangeParameters())
+	SetMaxMediaRangeParameters(0)
+	if p := parseMediaType("text/html"+params(100), 0); len(p.params) != 100 {
+		t.Errorf(testErrorFormat, len(p.params), 100)
+	}
+
+	// the ranges and the offers parsed with the previous limit aren't served
+	// from the caches
+	accept, offer := "text/html"+params(2)+";p2=x", "text/html"+params(3)
+	if got := PreferredMediaTypes(accept, offer); !reflect.DeepEqual(got, []string{}) {
+		t.Errorf(testErrorFormat, got, []string{})
+	}
+	SetMaxMediaRangeParameters(2)
+	if got := PreferredMediaTypes(accept, offer); !reflect.DeepEqual(got, []string{offer}) {
+		t.Errorf(testErrorFormat, got, []string{offer})
+	}
+}
+
+func TestPreferredMediaTypes_WildcardType(t *testing.T) {
+	provided := []string{"text/html", "application/json", "text/json"}
+	tests := []struct {
+		accept   string
+		expected []string
+		strict   []string
+	}{
+		{"*/json", []string{"application/json", "text/json"}, []string{}},
+		{"*/JSON;q=0.5, application/*", []string{"application/json", "text/json"}, []string{"application/json"}},
+		{"*/json;q=0.5, text/*", []string{"text/html", "text/json", "application/json"}, []string{"text/html", "text/json"}},
+		{"*/*+json", []string{}, []string{}},
+		{"*/*", provided, provided},
+	}
+	for _, tt := range tests {
+		if got := PreferredMediaTypes(tt.accept, provided...); !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("%q: "+testErrorFormat, tt.accept, got, tt.expected)
+		}
+		if got := PreferredMediaTypesStrict(tt.accept, provided...); !reflect.DeepEqual(got, tt.strict) {
+			t.Errorf("%q strict: "+testErrorFormat, tt.accept, got, tt.strict)
+		}
+	}
+
+	if got := WeightedMediaTypes("*/json", "application/json"); len(got) != 1 || got[0].Match != MatchSubtypeWildcard {
+		t.Errorf(testErrorFormat, got, MatchSubtypeWildcard)
+	}
+	_, errs := parseAcceptMediaTypeErrors("*/json, text/html", true)
+	if len(errs) != 1 || errs[0].Err != ErrWildcardType || errs[0].Position != 0 {
+		t.Errorf(testErrorFormat, errs, ErrWildcardType)
+	}
+}
+
+func TestAcceptsMediaType(t *testing.T) {
+	for _, tt := range preferredMediaTypeTestObjs {
+		for _, mediaType := range tt.provided {
+			expected := len(PreferredMediaTypes(tt.accept, mediaType)) > 0
+			if got := AcceptsMediaType(tt.accept, mediaType); got != expected {
+				t.Errorf("AcceptsMediaType(%q, %q) = %v, expect %v", tt.accept, mediaType, got, expected)
+			}
+		}
+	}
+
+	tests := []struct {
+		accept    string
+		mediaType string
+		expected  bool
+	}{
+		{"text/event-stream", "text/event-stream", true},
+		{"text/html, */*;q=0.1", " text/event-stream ", true},
+		{"*/*, text/event-stream;q=0", "text/event-stream", false},
+		{"text/html", "text/event-stream", false},
+		{"text/html", "", false},
+	}
+	for _, tt := range tests {
+		if got := AcceptsMediaType(tt.accept, tt.mediaType); got != tt.expected {
+			t.Errorf("AcceptsMediaType(%q, %q) = %v, expect %v", tt.accept, tt.mediaType, got, tt.expected)
+		}
+		n := New(http.Header{HeaderAccept: {tt.accept}})
+		if got := n.AcceptsMediaType(tt.mediaType); got != tt.expected {
+			t.Errorf("Negotiator.AcceptsMediaType(%q, %q) = %v, expect %v", tt.accept, tt.mediaType, got, tt.expected)
+		}
+	}
+
+	accept := "text/html, application/xhtml+xml, application/xml;q=0.9, */*;q=0.8"
+	if allocs := testing.AllocsPerRun(100, func() { AcceptsMediaType(accept, "text/event-stream") }); allocs != 0 {
+		t.Errorf("AcceptsMediaType allocates %v times per call, expect 0", allocs)
+	}
+}
+
+func TestPreferredMediaRanges(t *testing.T) {
+	tests := []struct {
+		accept   string
+		expected []string
+	}{
+		{"text/html;level=1, text/plain;q=0.5", []string{"text/html;level=1", "text/plain"}},
+		{"text/plain;q=0.5, text/html;Level=1;charset=utf-8", []string{"text/html;charset=utf-8;level=1", "text/plain"}},
+		{`text/plain;title="say \"hi\"";q=0.8, */*;q=0`, []string{`text/plain;title="say \"hi\""`}},
+		{`text/plain;title="a b", text/html;title="a;b"`, []string{`text/plain;title="a b"`, `text/html;title="a;b"`}},
+		{`text/plain;path="a\\b"`, []string{`text/plain;path="a\\b"`}},
+		{`text/plain;title=""`, []string{`text/plain;title=""`}},
+		{"", []string{}},
+	}
+	for _, tt := range tests {
+		got := PreferredMediaRanges(tt.accept)
+		if !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf(testErrorFormat, got, tt.expected)
+		}
+		for _, r := range got {
+			if reparsed := PreferredMediaRanges(r); !reflect.DeepEqual(reparsed, []string{r}) {
+				t.Errorf(testErrorFormat, reparsed, []string{r})
+			}
+		}
+	}
+	if got := PreferredMediaTypes("text/html;level=1, text/plain;q=0.5"); !reflect.DeepEqual(got, []string{"text/html", "text/plain"}) {
+		t.Errorf(testErrorFormat, got, []string{"text/html", "text/plain"})
+	}
+}
+
+func TestWithCaseSensitiveParameters(t *testing.T) {
+	provided := []string{`application/json;profile="http://example.com/schema"`, "application/json;version=V2"}
+	tests := []struct {
+		names    []string
+		accept   string
+		expected []string
+	}{
+		{nil, `application/json;profile="http://example.com/Schema"`, provided[:1]},
+		{[]string{"Profile "}, `application/json;profile="http://example.com/Schema"`, []string{}},
+		{[]string{"profile"}, `application/json;profile="http://example.com/schema"`, provided[:1]},
+		{[]string{"profile"}, "application/json;version=v2", provided[1:]},
+		{[]string{"profile", "version"}, "application/json;version=v2", []string{}},
+		{[]string{"version"}, "application/json;version=*", provided},
+		{[]string{"version"}, "application/json;version=V2;q=0.5, application/json;version=v2", provided[1:]},
+	}
+	for _, tt := range tests {
+		header := http.Header{HeaderAccept: {tt.accept}}
+		n := New(header, WithCaseSensitiveParameters(tt.names...))
+		if got := n.MediaTypes(provided...); !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("%q %q: "+testErrorFormat, tt.names, tt.accept, got, tt.expected)
+		}
+		if got := n.MediaType(provided...); got != getMostPreferred(tt.expected) {
+			t.Errorf("%q %q: "+testErrorFormat, tt.names, tt.accept, got, getMostPreferred(tt.expected))
+		}
+		if got := n.AcceptsMediaType(provided[0]); got != (len(tt.expected) > 0 && tt.expected[0] == provided[0]) {
+			t.Errorf("%q %q: "+testErrorFormat, tt.names, tt.accept, got, !got)
+		}
+		res := New(header).Negotiate(Offers{MediaTypes: provided, Charsets: []string{"utf-8"}}, WithCaseSensitiveParameters(tt.names...))
+		if res.MediaType != getMostPreferred(tt.expected) {
+			t.Errorf("%q %q: "+testErrorFormat, tt.names, tt.accept, res.MediaType, getMostPreferred(tt.expected))
+		}
+	}
+
+	// the negotiators and the package functions don't share the names
+	accept := "application/json;version=v2"
+	n := New(http.Header{HeaderAccept: {accept}}, WithCaseSensitiveParameters("version"))
+	if got := n.MediaTypeIndex(provided...); got != -1 {
+		t.Errorf(testErrorFormat, got, -1)
+	}
+	if got := New(n.Header).MediaTypeIndex(provided...); got != 1 {
+		t.Errorf(testErrorFormat, got, 1)
+	}
+	if got := PreferredMediaTypes(accept, provided...); !reflect.DeepEqual(got, provided[1:]) {
+		t.Errorf(testErrorFormat, got, provided[1:])
+	}
+}
+
+func TestWithSuffixFallback(t *testing.T) {
+	tests := []struct {
+		fallback bool
+		accept   string
+		provided []string
+		expected []string
+	}{
+		{false, "application/json", []string{"application/vnd.api+json"}, []string{}},
+		{true, "application/json", []string{"application/vnd.api+json"}, []string{"application/vnd.api+json"}},
+		{true, "application/json", []string{"application/vnd.api+json", "application/json"}, []string{"application/json", "application/vnd.api+json"}},
+		{true, "application/json;q=0.5, application/vnd.api+json", []string{"application/json", "application/vnd.api+json"}, []string{"application/vnd.api+json", "application/json"}},
+		{true, "application/vnd.api+json, application/json;q=0.9", []string{"application/json", "application/vnd.api+json"}, []string{"application/vnd.api+json", "application/json"}},
+		{true, "application/*, application/json", []string{"application/xml", "application/vnd.api+json"}, []string{"application/vnd.api+json", "application/xml"}},
+		{true, "application/json, application/vnd.api+json;q=0", []string{"application/vnd.api+json"}, []string{}},
+		{true, "text/xml", []string{"text/vnd.x+xml", "application/atom+xml"}, []string{"text/vnd.x+xml"}},
+		{true, "*/json", []string{"application/vnd.api+json"}, []string{}},
+		{true, "application/json", []string{"application/jsonx", "application/+json"}, []string{}},
+	}
+	for _, tt := range tests {
+		var opts []Option
+		if tt.fallback {
+			opts = append(opts, WithSuffixFallback())
+		}
+		header := http.Header{HeaderAccept: {tt.accept}}
+		n := New(header, opts...)
+		if got := n.MediaTypes(tt.provided...); !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf(testErrorFormat, got, tt.expected)
+		}
+		if got := n.MediaType(tt.provided...); got != getMostPreferred(tt.expected) {
+			t.Errorf(testErrorFormat, got, getMostPreferred(tt.expected))
+		}
+		if got := New(header).Negotiate(Offers{MediaTypes: tt.provided}, opts...).MediaType; got != getMostPreferred(tt.expected) {
+			t.Errorf(testErrorFormat, got, getMostPreferred(tt.expected))
+		}
+	}
+
+	n := New(http.Header{HeaderAccept: {"application/json"}}, WithSuffixFallback())
+	w := n.Weighted(HeaderAccept, "application/vnd.api+json")
+	if len(w) != 1 || w[0].Match != MatchPartial {
+		t.Errorf(testErrorFormat, w, "a partial match")
+	}
+
+	// the negotiators and the package functions don't share the fallback
+	if got := New(n.Header).MediaType("application/vnd.api+json"); got != "" {
+		t.Errorf(testErrorFormat, got, "")
+	}
+	if got := PreferredMediaTypes("application/json", "application/vnd.api+json"); !reflect.DeepEqual(got, []string{}) {
+		t.Errorf(testErrorFormat, got, []string{})
+	}
+}
+
+func TestPreferredMediaTypeIndex(t *testing.T) {
+	for _, tt := range preferredMediaTypeTestObjs {
+		if len(tt.provided) == 0 {
+			continue
+		}
+		i := PreferredMediaTypeIndex(tt.accept, tt.provided...)
+		if len(tt.expected) == 0 && i != -1 || len(tt.expected) > 0 && (i == -1 || tt.provided[i] != tt.expected[0]) {
+			t.Errorf("PreferredMediaTypeIndex(%q, %q) = %d, expect the index of %q", tt.accept, tt.provided, i, tt.expected)
+		}
+	}
+
+	tests := []struct {
+		accept   string
+		provided []string
 		expected int
 	}{
-		{"\"", 1},
-		{"\"foo\"", 2},
-		{"\"foo\": \"bar\"", 4},
+		{"application/json", []string{"text/html", "application/json", "application/json"}, 1},
+		{"application/json", []string{" ", "text/html", " application/json "}, 2},
+		{"text/html;q=0.5, application/json", []string{"TEXT/HTML", "text/html", "application/xml"}, 0},
+		{"image/png", []string{"text/html"}, -1},
+		{"*/*", []string{}, -1},
+	}
+	for _, tt := range tests {
+		if got := PreferredMediaTypeIndex(tt.accept, tt.provided...); got != tt.expected {
+			t.Errorf(testErrorFormat, got, tt.expected)
+		}
+		n := New(http.Header{HeaderAccept: {tt.accept}})
+		if got := n.MediaTypeIndex(tt.provided...); got != tt.expected {
+			t.Errorf(testErrorFormat, got, tt.expected)
+		}
+	}
+
+	n := New(http.Header{HeaderAccept: {"application/json"}})
+	ForceResult(n, Result{MediaType: "text/html"})
+	if got := n.MediaTypeIndex("application/json", " Text/HTML"); got != 1 {
+		t.Errorf(testErrorFormat, got, 1)
+	}
+
+	n = New(http.Header{HeaderAccept: {"application/json"}})
+	n.Register(HeaderAccept, MatcherFunc(func(accept string, offers []string) []Weighted {
+		return []Weighted{{offers[len(offers)-1], 1, MatchExact, false}}
+	}))
+	if got := n.MediaTypeIndex("application/json", "text/html"); got != 1 {
+		t.Errorf(testErrorFormat, got, 1)
+	}
+}
+
+func TestPreferredMediaTypesStrict(t *testing.T) {
+	tests := []struct {
+		accept   string
+		provided []string
+		expected []string
+	}{
+		{"text/html;q=1.000, application/json;q=0.5", []string{"application/json", "text/html"}, []string{"text/html", "application/json"}},
+		{"text/html;q=0.0009, application/json;q=0.5", []string{"text/html", "application/json"}, []string{"application/json"}},
+		{"text/html;q=2, application/json;q=0.5", []string{"text/html", "application/json"}, []string{"application/json"}},
+		{"text/html;q=9999, application/json", []string{"text/html", "application/json"}, []string{"application/json"}},
+		{"text/html;q=-1, */*;q=0.1", []string{"text/html"}, []string{"text/html"}},
+		{"text/html;q=0.87654, application/json;q=0.877", []string{"text/html", "application/json"}, []string{"application/json", "text/html"}},
+		{"text/html;q=2, application/json;q=0.5", nil, []string{"application/json"}},
 	}
 	for _, tt := range tests {
-		if got := quoteCount(tt.s); got != tt.expected {
+		if got := PreferredMediaTypesStrict(tt.accept, tt.provided...); !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf(testErrorFormat, got, tt.expected)
+		}
+	}
+
+	lenient := PreferredMediaTypes("text/html;q=2, application/json;q=0.5", "application/json", "text/html")
+	if expected := []string{"text/html", "application/json"}; !reflect.DeepEqual(lenient, expected) {
+		t.Errorf(testErrorFormat, lenient, expected)
+	}
+}
+
+func TestPreferredMediaTypesLenient(t *testing.T) {
+	tests := []struct {
+		accept   string
+		provided []string
+		expected []string
+	}{
+		{"undefined", []string{"text/html", "application/json"}, []string{"text/html", "application/json"}},
+		{"\"text/html\"", []string{"application/json", "text/html"}, []string{"application/json", "text/html"}},
+		{"text/html;q=oops, undefined", []string{"text/html"}, []string{"text/html"}},
+		{"undefined", nil, []string{"*/*"}},
+		{"", []string{"text/html"}, []string{}},
+		{" , ", []string{"text/html"}, []string{}},
+		{"undefined, application/json", []string{"text/html", "application/json"}, []string{"application/json"}},
+		{"text/html;q=0", []string{"text/html"}, []string{}},
+		{"text, application/json", []string{"text/plain", "application/json", "image/png"}, []string{"application/json", "text/plain"}},
+		{"application/json, TEXT;q=0.5", []string{"text/plain", "application/json"}, []string{"application/json", "text/plain"}},
+		{"text;level=1", []string{"text/plain", "text/html;level=1"}, []string{"text/html;level=1"}},
+		{"image;q=0, */*", []string{"image/png", "text/plain"}, []string{"text/plain"}},
+		{"undefined, text", []string{"text/plain", "application/json"}, []string{"text/plain"}},
+		{"text", nil, []string{"text/*"}},
+	}
+	for _, tt := range tests {
+		if got := PreferredMediaTypesLenient(tt.accept, tt.provided...); !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf(testErrorFormat, got, tt.expected)
+		}
+		if len(tt.provided) > 0 && tt.accept == "undefined" {
+			if got := PreferredMediaTypes(tt.accept, tt.provided...); len(got) != 0 {
+				t.Errorf(testErrorFormat, got, []string{})
+			}
+		}
+	}
+
+	for _, preferred := range []func(accept string, provided ...string) []string{PreferredMediaTypes, PreferredMediaTypesStrict} {
+		if got, expected := preferred("text, application/json", "text/plain", "application/json"), []string{"application/json"}; !reflect.DeepEqual(got, expected) {
+			t.Errorf(testErrorFormat, got, expected)
+		}
+	}
+}
+
+func TestPreferredMediaTypesDetailed(t *testing.T) {
+	tests := []struct {
+		accept   string
+		provided []string
+		expected []DetailedMediaType
+	}{
+		{
+			"text/html;level=1, text/html;q=0.7",
+			[]string{"text/html"},
+			[]DetailedMediaType{{"text/html", 0.7, MatchExact, nil}},
+		},
+		{
+			"text/html;q=0.7, text/html;level=1",
+			[]string{"text/html;level=1", "text/html"},
+			[]DetailedMediaType{
+				{"text/html;level=1", 1, MatchExact, map[string]string{"level": "1"}},
+				{"text/html", 0.7, MatchExact, nil},
+			},
+		},
+		{
+			"application/*;version=2;q=0.8, image/png",
+			[]string{"application/json;version=2", "image/png", "text/plain"},
+			[]DetailedMediaType{
+				{"image/png", 1, MatchExact, nil},
+				{"application/json;version=2", 0.8, MatchSubtypeWildcard, map[string]string{"version": "2"}},
+			},
+		},
+		{
+			"text/html;level=1;q=0.5, application/json",
+			nil,
+			[]DetailedMediaType{
+				{"application/json", 1, MatchNone, nil},
+				{"text/html", 0.5, MatchNone, map[string]string{"level": "1"}},
+			},
+		},
+		{
+			"text/html",
+			[]string{"image/png"},
+			[]DetailedMediaType{},
+		},
+	}
+	for _, tt := range tests {
+		if got := PreferredMediaTypesDetailed(tt.accept, tt.provided...); !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf(testErrorFormat, got, tt.expected)
+		}
+	}
+}
+
+func TestScanMediaRange(t *testing.T) {
+	tests := []struct {
+		s        string
+		expected []string
+		ok       bool
+	}{
+		{"text/html", []string{"text", "html", ""}, true},
+		{" \ttext/html;level=1 ", []string{"text", "html", "level=1 "}, true},
+		{"text/html ; q=0.5", []string{"text", "html", " q=0.5"}, true},
+		{"application/vnd.api+json;", []string{"application", "vnd.api+json", ""}, true},
+		{"text/html/x", []string{"text", "html/x", ""}, true},
+		{"text/html;a=b\n", []string{"text", "html", "a=b"}, true},
+		{"\u00a0text/html\u00a0", []string{"text", "html", ""}, true},
+		{"text/html;a\nb", nil, false},
+		{"text/ html", nil, false},
+		{"text /html", nil, false},
+		{"text/html x", nil, false},
+		{"\"text/html\"", nil, false},
+		{"text/", nil, false},
+		{"/html", nil, false},
+		{"text", nil, false},
+		{"", nil, false},
+	}
+	for _, tt := range tests {
+		mainType, subtype, params, ok := scanMediaRange(tt.s)
+		if got := []string{mainType, subtype, params}; ok != tt.ok || ok && !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("%q: "+testErrorFormat, tt.s, got, tt.expected)
+		}
+	}
+}
+
+func TestLowercaseMediaType(t *testing.T) {
+	tests := []struct {
+		mediaType string
+		expected  string
+	}{
+		{"text/html", "text/html"},
+		{"Application/JSON", "application/json"},
+		{"Text/HTML;Level=A;charset=\"UTF-8\"", "text/html;Level=A;charset=\"UTF-8\""},
+		{"TEXT/*", "text/*"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := LowercaseMediaType(tt.mediaType); got != tt.expected {
 			t.Errorf(testErrorFormat, got, tt.expected)
 		}
 	}
@@ -344,6 +1206,10 @@ func TestSplitKeyValuePair(t *testing.T) {
 	}{
 		{"foo", []string{"foo", ""}},
 		{"foo=bar", []string{"foo", "bar"}},
+		{"q =0.8", []string{"q", "0.8"}},
+		{"q= 0.8", []string{"q", "0.8"}},
+		{" level \t=\t1 ", []string{"level", "1"}},
+		{"p= \" a \" ", []string{"p", "\" a \""}},
 	}
 	for _, tt := range tests {
 		if got := splitKeyValuePair(tt.s); !reflect.DeepEqual(got, tt.expected) {
@@ -361,9 +1227,13 @@ func TestSplitMediaTypes(t *testing.T) {
 			"text/html, application/*;q=0.2, image/jpeg;q=0.8",
 			[]string{"text/html", " application/*;q=0.2", " image/jpeg;q=0.8"},
 		},
+		{
+			"text/html;title=\"a, b\", application/*;q=0.2",
+			[]string{`text/html;title="a, b"`, " application/*;q=0.2"},
+		},
 		{
 			"\"text/html, application/*;q=0.2, image/jpeg;q=0.8\"",
-			[]string{`"text/html, application/*;q=0.2, image/jpeg;q=0.8"`},
+			[]string{`"text/html`, " application/*;q=0.2", ` image/jpeg;q=0.8"`},
 		},
 	}
 	for _, tt := range tests {
@@ -388,7 +1258,11 @@ func TestSplitParameters(t *testing.T) {
 		},
 		{
 			"\"application/*;q=0.2",
-			[]string{"\"application/*;q=0.2"},
+			[]string{"\"application/*", "q=0.2"},
+		},
+		{
+			"title=\"a;b\";q=0.2",
+			[]string{"title=\"a;b\"", "q=0.2"},
 		},
 	}
 	for _, tt := range tests {
@@ -411,3 +1285,236 @@ func acceptMediaTypeEquals(a, b acceptMediaTypes) bool {
 
 	return true
 }
+
+func BenchmarkParseAcceptMediaType(b *testing.B) {
+	accept := "text/html,application/xhtml+xml,application/xml;q=0.9,image/avif,image/webp,*/*;q=0.8"
+	b.Run("Parse", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			parseAcceptMediaType(accept)
+		}
+	})
+	b.Run("Preferred", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			PreferredMediaTypes(accept, "application/json", "text/html")
+		}
+	})
+}
+
+func TestPreferredMediaTypes_EqualPreferenceKeepsProvidedOrder(t *testing.T) {
+	provided := []string{"image/png", "application/json", "text/plain", "application/xml"}
+	reversed := []string{"application/xml", "text/plain", "application/json", "image/png"}
+	tests := []string{"*/*", "*/*;q=0.5", "text/html, */*;q=0.8", "text/html;q=0, */*"}
+	for _, accept := range tests {
+		if got := PreferredMediaTypes(accept, provided...); !reflect.DeepEqual(got, provided) {
+			t.Errorf("%q: "+testErrorFormat, accept, got, provided)
+		}
+		if got := PreferredMediaTypes(accept, reversed...); !reflect.DeepEqual(got, reversed) {
+			t.Errorf("%q: "+testErrorFormat, accept, got, reversed)
+		}
+		if got := CompileMediaTypes(provided...).Negotiate(accept); !reflect.DeepEqual(got, provided) {
+			t.Errorf("%q compiled: "+testErrorFormat, accept, got, provided)
+		}
+	}
+}
+
+func TestNegotiatedContentType(t *testing.T) {
+	tests := []struct {
+		accept   string
+		offers   []string
+		expected string
+		ok       bool
+	}{
+		{"application/json", []string{"text/html", "application/json;charset=utf-8;version=2"}, "application/json; charset=utf-8; version=2", true},
+		{"*/*", []string{" application/json ; version = 2 ;charset=utf-8 "}, "application/json; version=2; charset=utf-8", true},
+		{"text/*", []string{`text/plain;format="a; b";charset=UTF-8`}, `text/plain; format="a; b"; charset=UTF-8`, true},
+		{"text/html", []string{"text/html;;level=1;"}, "text/html; level=1", true},
+		{"text/html", []string{"TEXT/HTML"}, "TEXT/HTML", true},
+		{"image/png", []string{"text/html"}, "", false},
+		{"*/*", nil, "", false},
+	}
+	for _, tt := range tests {
+		got, ok := NegotiatedContentType(tt.accept, tt.offers...)
+		if got != tt.expected || ok != tt.ok {
+			t.Errorf("%q: "+testErrorFormat, tt.accept, got, tt.expected)
+		}
+	}
+}
+
+func TestPreferredMediaTypes_DuplicateRanges(t *testing.T) {
+	tests := []struct {
+		accept   string
+		provided []string
+		expected []string
+	}{
+		{"application/json;q=0.5, application/json, text/html;q=0.8", nil, []string{"application/json", "text/html"}},
+		{"application/json;q=0.5, Application/JSON;q=0.9", nil, []string{"Application/JSON"}},
+		{"text/html;level=1;q=0.2, text/html;LEVEL=1, text/html;q=0.5", nil, []string{"text/html", "text/html"}},
+		{"application/json;q=0, application/json;q=0.5", nil, []string{"application/json"}},
+		{"application/json;q=0.5, application/json", []string{"text/html", "application/json"}, []string{"application/json"}},
+		{"text/*;q=0.5, application/json;q=0.5, text/*", []string{"application/json", "text/html"}, []string{"text/html", "application/json"}},
+	}
+	for _, tt := range tests {
+		if got := PreferredMediaTypes(tt.accept, tt.provided...); !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("%q: "+testErrorFormat, tt.accept, got, tt.expected)
+		}
+		if got := PreferredMediaTypesStrict(tt.accept, tt.provided...); !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("%q strict: "+testErrorFormat, tt.accept, got, tt.expected)
+		}
+	}
+
+	if got := PreferredMediaRanges("application/json;q=0.5, application/json;v=2, application/json"); !reflect.DeepEqual(got, []string{"application/json;v=2", "application/json"}) {
+		t.Errorf(testErrorFormat, got, []string{"application/json;v=2", "application/json"})
+	}
+}
+
+func TestDedupeMediaRanges(t *testing.T) {
+	members := []string{"text/html;q=0.1", "TEXT/HTML;q=0.7", "application/json", "text/html;q=0.7"}
+	for i := 0; i < 2*dedupePairwiseThreshold; i++ {
+		members = append(members, "image/png;v="+strconv.Itoa(i%5)+";q=0."+strconv.Itoa(i%10))
+	}
+	for _, n := range []int{4, 12, len(members)} {
+		acs, _ := parseAcceptMediaTypeErrors(strings.Join(members[:n], ","), false)
+		got := dedupeMediaRanges(acs, mediaTypeFilter{})
+		seen := make(map[string]quality)
+		for j, ac := range got {
+			key := mediaRangeKey(&ac, mediaTypeFilter{})
+			if _, ok := seen[key]; ok {
+				t.Errorf("%d: duplicate range %q", n, key)
+			}
+			seen[key] = ac.q
+			if j > 0 && got[j-1].i >= ac.i {
+				t.Errorf("%d: ranges out of header order: %d, %d", n, got[j-1].i, ac.i)
+			}
+		}
+		for _, ac := range acs {
+			if q, ok := seen[mediaRangeKey(&ac, mediaTypeFilter{})]; !ok || q < ac.q {
+				t.Errorf("%d: range %q kept with q %d below %d", n, mediaRangeKey(&ac, mediaTypeFilter{}), q, ac.q)
+			}
+		}
+		if n == 4 && (len(got) != 2 || got[0].i != 1) {
+			t.Errorf(testErrorFormat, got, "TEXT/HTML;q=0.7 and application/json")
+		}
+	}
+}
+
+func TestFilterAcceptableMediaTypes(t *testing.T) {
+	for _, tt := range preferredMediaTypeTestObjs {
+		if len(tt.provided) == 0 {
+			continue
+		}
+		got := FilterAcceptableMediaTypes(tt.accept, tt.provided...)
+		acceptable := make(map[string]int)
+		for _, v := range tt.expected {
+			acceptable[v]++
+		}
+		for _, v := range got {
+			acceptable[v]--
+		}
+		for _, n := range acceptable {
+			if n != 0 {
+				t.Errorf("FilterAcceptableMediaTypes(%q, %q) = %q, expect the media types of %q", tt.accept, tt.provided, got, tt.expected)
+				break
+			}
+		}
+	}
+
+	tests := []struct {
+		accept   string
+		provided []string
+		expected []string
+	}{
+		{"application/json, text/html;q=0.9", []string{"text/html", "application/json", "image/png"}, []string{"text/html", "application/json"}},
+		{"*/*, text/html;q=0", []string{"text/html", " image/png ", "", "application/json"}, []string{"image/png", "application/json"}},
+		{"application/json;version=2", []string{"application/json", "application/json;version=2"}, []string{"application/json;version=2"}},
+		{"image/png", []string{"text/html"}, []string{}},
+		{"*/*", nil, []string{}},
+	}
+	for _, tt := range tests {
+		if got := FilterAcceptableMediaTypes(tt.accept, tt.provided...); !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("%q: "+testErrorFormat, tt.accept, got, tt.expected)
+		}
+	}
+}
+
+func TestPreferredMediaTypes_AnyMediaRange(t *testing.T) {
+	accepts := []string{"*/*", " */* ", "*/*;q=0.5", "*/* ; Q = 0.5", "*/*;q=0", "*/*;level=1", "*/*, text/html"}
+	provided := [][]string{
+		{"text/html", "application/json"},
+		{" application/json ", "", "text/html"},
+		{"text/html;level=1", "text/html", "application/json"},
+		{"text/html", "application/json", "text/html"},
+		{"invalid", "text/html"},
+		{"text/html", "text/*"},
+		{"text/html", "*/*", "application/json"},
+		{"text/*", "text/html"},
+		{"*/json", "application/json"},
+		{},
+	}
+	for _, accept := range accepts {
+		for _, p := range provided {
+			expected := preferredMediaTypes(parseAcceptMediaType(accept), p)
+			if got := PreferredMediaTypes(accept, p...); !reflect.DeepEqual(got, expected) {
+				t.Errorf("%q %q: "+testErrorFormat, accept, p, got, expected)
+			}
+			if len(p) > 0 {
+				if got := CompileMediaTypes(p...).Negotiate(accept); !reflect.DeepEqual(got, expected) {
+					t.Errorf("%q %q compiled: "+testErrorFormat, accept, p, got, expected)
+				}
+				if i := PreferredMediaTypeIndex(accept, p...); i == -1 && len(expected) > 0 || i != -1 && trimOffer(p[i]) != getMostPreferred(expected) {
+					t.Errorf("%q %q index: %d, expect the index of %q", accept, p, i, getMostPreferred(expected))
+				}
+			}
+			n := New(map[string][]string{HeaderAccept: {accept}})
+			if got := n.MediaTypes(p...); !reflect.DeepEqual(got, expected) {
+				t.Errorf("%q %q MediaTypes: "+testErrorFormat, accept, p, got, expected)
+			}
+			if got := n.MediaType(p...); got != getMostPreferred(expected) {
+				t.Errorf("%q %q MediaType: "+testErrorFormat, accept, p, got, getMostPreferred(expected))
+			}
+		}
+	}
+}
+
+func TestIsAnyMediaRange(t *testing.T) {
+	tests := []struct {
+		accept   string
+		expected bool
+	}{
+		{"*/*", true},
+		{"\t*/* ", true},
+		{"*/*;q=0.8", true},
+		{"*/* ;Q=1", true},
+		{"*/*;q=0", false},
+		{"*/*;q=abc", false},
+		{"*/*;level=1", false},
+		{"*/*;", false},
+		{"*/*, text/html", false},
+		{"*/*;q=1, text/html", false},
+		{"*/json", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := isAnyMediaRange(tt.accept); got != tt.expected {
+			t.Errorf("%q: "+testErrorFormat, tt.accept, got, tt.expected)
+		}
+	}
+}
+
+func BenchmarkPreferredMediaTypesAnyMediaRange(b *testing.B) {
+	provided := []string{"application/json", "text/html", "application/xml"}
+	b.Run("PreferredMediaTypes", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			PreferredMediaTypes("*/*", provided...)
+		}
+	})
+	b.Run("MediaType", func(b *testing.B) {
+		n := New(map[string][]string{HeaderAccept: {"*/*"}})
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			n.MediaType(provided...)
+		}
+	})
+}
@@ -0,0 +1,411 @@
+// Copyright 2020 Guoyao Wu. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package negotiator
+
+import (
+	"path"
+	"strings"
+	"sync"
+)
+
+// The curated web-relevant media types and their extensions, the first
+// extension of a type is its primary one. It's embedded instead of relying on
+// mime.TypeByExtension, which reads the platform's mime.types files and so
+// differs across machines.
+var builtinMediaTypes = []struct {
+	mediaType  string
+	extensions []string
+}{
+	// text
+	{"text/html", []string{"html", "htm", "shtml"}},
+	{"text/plain", []string{"txt", "text", "conf", "def", "list", "log", "in", "ini"}},
+	{"text/css", []string{"css"}},
+	{"text/csv", []string{"csv"}},
+	{"text/tab-separated-values", []string{"tsv"}},
+	{"text/markdown", []string{"md", "markdown"}},
+	{"text/calendar", []string{"ics", "ifb"}},
+	{"text/vcard", []string{"vcard"}},
+	{"text/vtt", []string{"vtt"}},
+	{"text/xml", []string{"xsl"}},
+	{"text/yaml", []string{"yaml", "yml"}},
+	{"text/event-stream", []string{"event-stream"}},
+	{"text/javascript", []string{"js", "mjs"}},
+	{"text/jsx", []string{"jsx"}},
+	{"text/richtext", []string{"rtx"}},
+	{"text/rtf", []string{"rtf"}},
+	{"text/x-vcard", []string{"vcf"}},
+	// application
+	{"application/json", []string{"json", "map"}},
+	{"application/ld+json", []string{"jsonld"}},
+	{"application/manifest+json", []string{"webmanifest"}},
+	{"application/geo+json", []string{"geojson"}},
+	{"application/problem+json", []string{}},
+	{"application/json-patch+json", []string{}},
+	{"application/merge-patch+json", []string{}},
+	{"application/x-ndjson", []string{"ndjson"}},
+	{"application/xml", []string{"xml", "xsd"}},
+	{"application/xhtml+xml", []string{"xhtml", "xht"}},
+	{"application/atom+xml", []string{"atom"}},
+	{"application/rss+xml", []string{"rss"}},
+	{"application/rdf+xml", []string{"rdf"}},
+	{"application/problem+xml", []string{}},
+	{"application/soap+xml", []string{}},
+	{"application/xslt+xml", []string{"xslt"}},
+	{"application/javascript", []string{}},
+	{"application/wasm", []string{"wasm"}},
+	{"application/pdf", []string{"pdf"}},
+	{"application/zip", []string{"zip"}},
+	{"application/gzip", []string{"gz"}},
+	{"application/x-tar", []string{"tar"}},
+	{"application/x-bzip2", []string{"bz2"}},
+	{"application/x-7z-compressed", []string{"7z"}},
+	{"application/x-rar-compressed", []string{"rar"}},
+	{"application/zstd", []string{"zst"}},
+	{"application/octet-stream", []string{"bin", "exe", "dll", "deb", "dmg", "iso", "img", "msi"}},
+	{"application/x-www-form-urlencoded", []string{}},
+	{"application/graphql", []string{"graphql"}},
+	{"application/sql", []string{"sql"}},
+	{"application/toml", []string{"toml"}},
+	{"application/yaml", []string{}},
+	{"application/msgpack", []string{"msgpack"}},
+	{"application/cbor", []string{"cbor"}},
+	{"application/x-protobuf", []string{"proto", "pb"}},
+	{"application/vnd.api+json", []string{}},
+	{"application/hal+json", []string{}},
+	{"application/vnd.ms-excel", []string{"xls"}},
+	{"application/vnd.ms-powerpoint", []string{"ppt"}},
+	{"application/msword", []string{"doc", "dot"}},
+	{"application/vnd.openxmlformats-officedocument.wordprocessingml.document", []string{"docx"}},
+	{"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", []string{"xlsx"}},
+	{"application/vnd.openxmlformats-officedocument.presentationml.presentation", []string{"pptx"}},
+	{"application/vnd.oasis.opendocument.text", []string{"odt"}},
+	{"application/vnd.oasis.opendocument.spreadsheet", []string{"ods"}},
+	{"application/vnd.oasis.opendocument.presentation", []string{"odp"}},
+	{"application/epub+zip", []string{"epub"}},
+	{"application/java-archive", []string{"jar"}},
+	{"application/x-sh", []string{"sh"}},
+	{"application/x-shockwave-flash", []string{"swf"}},
+	{"application/x-font-ttf", []string{}},
+	{"application/pkcs7-mime", []string{"p7m"}},
+	{"application/x-x509-ca-cert", []string{"der", "crt", "pem"}},
+	// image
+	{"image/png", []string{"png"}},
+	{"image/jpeg", []string{"jpg", "jpeg", "jpe"}},
+	{"image/gif", []string{"gif"}},
+	{"image/webp", []string{"webp"}},
+	{"image/avif", []string{"avif"}},
+	{"image/apng", []string{"apng"}},
+	{"image/svg+xml", []string{"svg", "svgz"}},
+	{"image/x-icon", []string{"ico"}},
+	{"image/bmp", []string{"bmp"}},
+	{"image/tiff", []string{"tif", "tiff"}},
+	{"image/heic", []string{"heic"}},
+	{"image/heif", []string{"heif"}},
+	{"image/jxl", []string{"jxl"}},
+	// audio
+	{"audio/mpeg", []string{"mp3", "mpga", "mp2", "m2a", "m3a"}},
+	{"audio/ogg", []string{"oga", "ogg", "spx", "opus"}},
+	{"audio/wav", []string{"wav"}},
+	{"audio/webm", []string{"weba"}},
+	{"audio/aac", []string{"aac"}},
+	{"audio/flac", []string{"flac"}},
+	{"audio/mp4", []string{"m4a", "mp4a"}},
+	{"audio/midi", []string{"mid", "midi", "kar", "rmi"}},
+	// video
+	{"video/mp4", []string{"mp4", "mp4v", "mpg4"}},
+	{"video/webm", []string{"webm"}},
+	{"video/ogg", []string{"ogv"}},
+	{"video/mpeg", []string{"mpeg", "mpg", "mpe", "m1v", "m2v"}},
+	{"video/quicktime", []string{"mov", "qt"}},
+	{"video/x-msvideo", []string{"avi"}},
+	{"video/x-matroska", []string{"mkv"}},
+	{"video/mp2t", []string{"ts", "m2ts", "mts"}},
+	{"application/vnd.apple.mpegurl", []string{"m3u8"}},
+	{"application/dash+xml", []string{"mpd"}},
+	// font
+	{"font/woff", []string{"woff"}},
+	{"font/woff2", []string{"woff2"}},
+	{"font/ttf", []string{"ttf"}},
+	{"font/otf", []string{"otf"}},
+	{"font/collection", []string{"ttc"}},
+	{"application/vnd.ms-fontobject", []string{"eot"}},
+	// multipart and message
+	{"multipart/form-data", []string{}},
+	{"multipart/mixed", []string{}},
+	{"multipart/byteranges", []string{}},
+	{"message/rfc822", []string{"eml", "mime"}},
+}
+
+type mimeRegistry struct {
+	mu         sync.RWMutex
+	types      map[string]string
+	extensions map[string][]string
+	aliases    map[string]string
+}
+
+var registry = newMimeRegistry()
+
+func newMimeRegistry() *mimeRegistry {
+	r := &mimeRegistry{
+		types:      make(map[string]string),
+		extensions: make(map[string][]string),
+		aliases:    make(map[string]string),
+	}
+	for _, v := range builtinMediaTypes {
+		r.register(v.mediaType, v.extensions...)
+	}
+	return r
+}
+
+// Register a media type with its extensions, an extension already registered
+// for another media type is moved to this one.
+func (r *mimeRegistry) register(mediaType string, extensions ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	mediaType = normalizeMediaTypeKey(mediaType)
+	delete(r.aliases, mediaType)
+	if _, ok := r.extensions[mediaType]; !ok {
+		r.extensions[mediaType] = []string{}
+	}
+	for _, ext := range extensions {
+		ext = normalizeExtension(ext)
+		if ext == "" {
+			continue
+		}
+		if old, ok := r.types[ext]; ok && old != mediaType {
+			r.extensions[old] = removeString(r.extensions[old], ext)
+		}
+		r.types[ext] = mediaType
+		r.extensions[mediaType] = append(removeString(r.extensions[mediaType], ext), ext)
+	}
+}
+
+// Register an alias of a canonical media type, the extensions of the alias
+// are moved to the canonical media type.
+func (r *mimeRegistry) registerAlias(alias, canonical string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	alias, canonical = normalizeMediaTypeKey(alias), normalizeMediaTypeKey(canonical)
+	if alias == canonical {
+		return
+	}
+	if target, ok := r.aliases[canonical]; ok {
+		canonical = target
+	}
+	for k, v := range r.aliases {
+		if v == alias {
+			r.aliases[k] = canonical
+		}
+	}
+	r.aliases[alias] = canonical
+	if _, ok := r.extensions[canonical]; !ok {
+		r.extensions[canonical] = []string{}
+	}
+	for _, ext := range r.extensions[alias] {
+		r.types[ext] = canonical
+		r.extensions[canonical] = append(removeString(r.extensions[canonical], ext), ext)
+	}
+	delete(r.extensions, alias)
+}
+
+func (r *mimeRegistry) snapshot() *mimeRegistry {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	s := &mimeRegistry{
+		types:      make(map[string]string, len(r.types)),
+		extensions: make(map[string][]string, len(r.extensions)),
+		aliases:    make(map[string]string, len(r.aliases)),
+	}
+	for k, v := range r.types {
+		s.types[k] = v
+	}
+	for k, v := range r.extensions {
+		s.extensions[k] = append([]string{}, v...)
+	}
+	for k, v := range r.aliases {
+		s.aliases[k] = v
+	}
+	return s
+}
+
+func (r *mimeRegistry) restore(s *mimeRegistry) {
+	s = s.snapshot()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.types, r.extensions, r.aliases = s.types, s.extensions, s.aliases
+}
+
+func (r *mimeRegistry) typeByExtension(ext string) string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.types[normalizeExtension(ext)]
+}
+
+func (r *mimeRegistry) extensionsByType(mediaType string) []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	mediaType = normalizeMediaTypeKey(mediaType)
+	if canonical, ok := r.aliases[mediaType]; ok {
+		mediaType = canonical
+	}
+	extensions := r.extensions[mediaType]
+	if len(extensions) == 0 {
+		return nil
+	}
+	results := make([]string, len(extensions), len(extensions))
+	for i, ext := range extensions {
+		results[i] = "." + ext
+	}
+	return results
+}
+
+// RegisterType registers a media type with its extensions in the package's
+// registry, which feeds TypeByExtension, ExtensionsByType and every helper
+// resolving extensions. The last registration wins: an extension registered
+// for another media type is moved to this one, and registering a media type
+// registered as an alias removes the alias. It's safe for concurrent use.
+func RegisterType(mediaType string, extensions ...string) {
+	registry.register(mediaType, extensions...)
+}
+
+// RegisterAlias registers alias as another name of the canonical media type,
+// lookups of the alias resolve to the canonical media type and the extensions
+// registered for the alias are moved to it. The last registration of an alias
+// wins. It's safe for concurrent use.
+func RegisterAlias(alias, canonical string) {
+	registry.registerAlias(alias, canonical)
+}
+
+// SnapshotRegistry takes a snapshot of the package's registry and returns a
+// function restoring it, so tests registering types can undo them:
+//
+//	defer negotiator.SnapshotRegistry()()
+func SnapshotRegistry() func() {
+	s := registry.snapshot()
+	return func() {
+		registry.restore(s)
+	}
+}
+
+// TypeByExtension returns the media type associated with the file extension
+// ext, with or without the leading dot, from the package's registry. The
+// extension is compared case-insensitively and an empty string is returned for
+// unknown extensions.
+func TypeByExtension(ext string) string {
+	return registry.typeByExtension(ext)
+}
+
+// ExtensionsByType returns the extensions, with the leading dot, associated
+// with the media type from the package's registry, primary extension first.
+// The parameters of the media type are ignored.
+func ExtensionsByType(mediaType string) []string {
+	return registry.extensionsByType(mediaType)
+}
+
+// Extension gets the most preferred extension from a list of available
+// extensions, the media types of the extensions are resolved through the
+// package's registry and negotiated with the Accept header. Extensions which
+// aren't registered are ignored.
+func (n *Negotiator) Extension(availableExts ...string) string {
+	return n.preferredShorthand(availableExts, TypeByExtension)
+}
+
+// PreferredExtension gets the most preferred of the extensions, or of the file
+// names, e.g. foo.avif, foo.webp and foo.jpg, from an Accept header. Their
+// media types are resolved through the package's registry, see RegisterType
+// and RegisterExtension, and the extensions which aren't registered are
+// ignored. The winner is returned as given, or "" if none is acceptable.
+//
+// Unless the header names the media type of the winner, e.g. with "*/*" or
+// "image/*", the last one is returned if it's acceptable, so list the most
+// compatible one last.
+func PreferredExtension(accept string, extensions ...string) string {
+	mediaTypes, kept := make([]string, 0, len(extensions)), make([]string, 0, len(extensions))
+	for _, v := range extensions {
+		ext := path.Ext(v)
+		if ext == "" {
+			ext = v
+		}
+		if mediaType := TypeByExtension(ext); mediaType != "" {
+			mediaTypes, kept = append(mediaTypes, mediaType), append(kept, v)
+		}
+	}
+
+	i, kind := bestMediaTypeIndex(accept, mediaTypes, mediaTypeFilter{})
+	if i == -1 {
+		return ""
+	}
+	if last := len(kept) - 1; kind != MatchExact && AcceptsMediaType(accept, mediaTypes[last]) {
+		return kept[last]
+	}
+	return kept[i]
+}
+
+// RegisterExtension registers the media type of an extension in the package's
+// registry for PreferredExtension, like RegisterType(mediaType, ext). It's
+// safe for concurrent use.
+func RegisterExtension(ext, mediaType string) {
+	RegisterType(mediaType, ext)
+}
+
+// Accepts gets the most preferred of the shorthands like req.accepts of
+// Express, e.g. Accepts("json", "html"). The extensions are resolved to their
+// media types through the package's registry, see RegisterType, and the full
+// media types, e.g. application/json, are negotiated as is. The winning
+// shorthand is returned as given, extensions which aren't registered are
+// ignored.
+func (n *Negotiator) Accepts(shorthands ...string) string {
+	return n.preferredShorthand(shorthands, func(shorthand string) string {
+		if strings.Contains(shorthand, "/") {
+			return trimOffer(shorthand)
+		}
+		return TypeByExtension(shorthand)
+	})
+}
+
+// Negotiate the media types of the shorthands and get the first shorthand of
+// the most preferred one.
+func (n *Negotiator) preferredShorthand(shorthands []string, resolve func(shorthand string) string) string {
+	mediaTypes, kept := make([]string, 0, len(shorthands)), make([]string, 0, len(shorthands))
+	for _, shorthand := range shorthands {
+		if mediaType := resolve(shorthand); mediaType != "" {
+			mediaTypes, kept = append(mediaTypes, mediaType), append(kept, shorthand)
+		}
+	}
+	if len(mediaTypes) == 0 {
+		return ""
+	}
+
+	mediaType := n.MediaType(mediaTypes...)
+	for i, v := range mediaTypes {
+		if v == mediaType {
+			return kept[i]
+		}
+	}
+	return ""
+}
+
+func normalizeExtension(ext string) string {
+	return strings.ToLower(strings.TrimPrefix(strings.TrimSpace(ext), "."))
+}
+
+func normalizeMediaTypeKey(mediaType string) string {
+	if index := strings.Index(mediaType, ";"); index >= 0 {
+		mediaType = mediaType[:index]
+	}
+	return strings.ToLower(strings.TrimSpace(mediaType))
+}
+
+func removeString(arr []string, s string) []string {
+	result := arr[:0]
+	for _, v := range arr {
+		if v != s {
+			result = append(result, v)
+		}
+	}
+	return result
+}
@@ -0,0 +1,285 @@
+// Copyright 2020 Guoyao Wu. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package negotiator
+
+import (
+	"net/http"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestTypeByExtension(t *testing.T) {
+	tests := []struct {
+		ext      string
+		expected string
+	}{
+		{"json", "application/json"},
+		{".json", "application/json"},
+		{".HTML", "text/html"},
+		{"htm", "text/html"},
+		{" txt ", "text/plain"},
+		{"svg", "image/svg+xml"},
+		{"woff2", "font/woff2"},
+		{"unknown", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := TypeByExtension(tt.ext); got != tt.expected {
+			t.Errorf(testErrorFormat, got, tt.expected)
+		}
+	}
+}
+
+func TestExtensionsByType(t *testing.T) {
+	tests := []struct {
+		mediaType string
+		expected  []string
+	}{
+		{"application/json", []string{".json", ".map"}},
+		{"Text/HTML; charset=utf-8", []string{".html", ".htm", ".shtml"}},
+		{"image/png", []string{".png"}},
+		{"application/problem+json", nil},
+		{"application/x-unknown", nil},
+	}
+	for _, tt := range tests {
+		if got := ExtensionsByType(tt.mediaType); !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf(testErrorFormat, got, tt.expected)
+		}
+	}
+}
+
+func TestMimeRegistry(t *testing.T) {
+	r := newMimeRegistry()
+	r.register("application/x-custom", ".cst", "CUSTOM")
+	r.register("application/x-other", "cst")
+	tests := []struct {
+		got      interface{}
+		expected interface{}
+	}{
+		{r.typeByExtension("custom"), "application/x-custom"},
+		{r.typeByExtension("cst"), "application/x-other"},
+		{r.extensionsByType("application/x-custom"), []string{".custom"}},
+		{r.extensionsByType("application/x-other"), []string{".cst"}},
+	}
+	for _, tt := range tests {
+		if !reflect.DeepEqual(tt.got, tt.expected) {
+			t.Errorf(testErrorFormat, tt.got, tt.expected)
+		}
+	}
+}
+
+func TestMimeRegistry_Concurrency(t *testing.T) {
+	r := newMimeRegistry()
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				r.register("application/x-concurrent", "conc")
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				r.typeByExtension("conc")
+				r.extensionsByType("application/x-concurrent")
+			}
+		}()
+	}
+	wg.Wait()
+	if got := r.typeByExtension("conc"); got != "application/x-concurrent" {
+		t.Errorf(testErrorFormat, got, "application/x-concurrent")
+	}
+}
+
+func TestRegisterType(t *testing.T) {
+	defer SnapshotRegistry()()
+
+	RegisterType("application/vnd.acme.report+json", ".acmer")
+	RegisterType("application/x-acme", "acme", "json")
+	tests := []struct {
+		got      interface{}
+		expected interface{}
+	}{
+		{TypeByExtension("acmer"), "application/vnd.acme.report+json"},
+		{ExtensionsByType("application/vnd.acme.report+json"), []string{".acmer"}},
+		{TypeByExtension(".json"), "application/x-acme"},
+		{ExtensionsByType("application/json"), []string{".map"}},
+		{ExtensionsByType("application/x-acme"), []string{".acme", ".json"}},
+	}
+	for _, tt := range tests {
+		if !reflect.DeepEqual(tt.got, tt.expected) {
+			t.Errorf(testErrorFormat, tt.got, tt.expected)
+		}
+	}
+
+	n := New(http.Header{HeaderAccept: {"application/vnd.acme.report+json, text/html;q=0.5"}})
+	if got := n.Extension("html", "acmer"); got != "acmer" {
+		t.Errorf(testErrorFormat, got, "acmer")
+	}
+}
+
+func TestRegisterAlias(t *testing.T) {
+	defer SnapshotRegistry()()
+
+	RegisterType("application/x-report", "rpt")
+	RegisterAlias("application/x-report", "application/vnd.acme.report+json")
+	RegisterAlias("text/x-json", "application/json")
+	tests := []struct {
+		got      interface{}
+		expected interface{}
+	}{
+		{TypeByExtension("rpt"), "application/vnd.acme.report+json"},
+		{ExtensionsByType("application/x-report"), []string{".rpt"}},
+		{ExtensionsByType("application/vnd.acme.report+json"), []string{".rpt"}},
+		{ExtensionsByType("text/x-json"), []string{".json", ".map"}},
+	}
+	for _, tt := range tests {
+		if !reflect.DeepEqual(tt.got, tt.expected) {
+			t.Errorf(testErrorFormat, tt.got, tt.expected)
+		}
+	}
+
+	// last registration wins
+	RegisterAlias("text/x-json", "text/plain")
+	RegisterType("application/x-report", "report")
+	if got := ExtensionsByType("text/x-json"); !reflect.DeepEqual(got, ExtensionsByType("text/plain")) {
+		t.Errorf(testErrorFormat, got, ExtensionsByType("text/plain"))
+	}
+	if got := ExtensionsByType("application/x-report"); !reflect.DeepEqual(got, []string{".report"}) {
+		t.Errorf(testErrorFormat, got, []string{".report"})
+	}
+}
+
+func TestSnapshotRegistry(t *testing.T) {
+	restore := SnapshotRegistry()
+	RegisterType("application/x-snapshot", "snap")
+	RegisterType("application/x-snapshot", "json")
+	RegisterAlias("application/x-snap", "application/x-snapshot")
+	restore()
+
+	if got := TypeByExtension("snap"); got != "" {
+		t.Errorf(testErrorFormat, got, "")
+	}
+	if got := TypeByExtension("json"); got != "application/json" {
+		t.Errorf(testErrorFormat, got, "application/json")
+	}
+	if got := ExtensionsByType("application/x-snap"); got != nil {
+		t.Errorf(testErrorFormat, got, nil)
+	}
+}
+
+func TestRegisterType_Concurrency(t *testing.T) {
+	defer SnapshotRegistry()()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				RegisterType("application/x-concurrent", "conc")
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				RegisterAlias("application/x-conc", "application/x-concurrent")
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			n := New(http.Header{HeaderAccept: {"application/x-concurrent"}})
+			for j := 0; j < 100; j++ {
+				TypeByExtension("conc")
+				ExtensionsByType("application/x-conc")
+				n.Extension("conc", "json")
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestNegotiator_Extension(t *testing.T) {
+	tests := []struct {
+		accept    string
+		available []string
+		expected  string
+	}{
+		{"application/json", []string{"html", "json"}, "json"},
+		{"text/html, application/json;q=0.5", []string{"json", ".html"}, ".html"},
+		{"text/*", []string{"png", "txt", "html"}, "txt"},
+		{"image/webp, image/png;q=0.8", []string{"png", "webp", "avif"}, "webp"},
+		{"image/png", []string{"jpg", "unknown"}, ""},
+		{"*/*", []string{"unknown", "xml"}, "xml"},
+		{"*/*", []string{}, ""},
+	}
+	for _, tt := range tests {
+		n := New(http.Header{HeaderAccept: {tt.accept}})
+		if got := n.Extension(tt.available...); got != tt.expected {
+			t.Errorf(testErrorFormat, got, tt.expected)
+		}
+	}
+}
+
+func TestPreferredExtension(t *testing.T) {
+	defer SnapshotRegistry()()
+	RegisterType("image/x-custom", "cst")
+	RegisterExtension(".jxl", "image/jxl")
+
+	files := []string{"foo.avif", "foo.webp", "foo.jpg"}
+	tests := []struct {
+		accept     string
+		extensions []string
+		expected   string
+	}{
+		{"image/avif,image/webp,image/apng,image/svg+xml,image/*,*/*;q=0.8", files, "foo.avif"},
+		{"image/webp,*/*", files, "foo.webp"},
+		{"*/*", files, "foo.jpg"},
+		{"image/*", files, "foo.jpg"},
+		{"image/*, image/jpeg;q=0", files, "foo.avif"},
+		{"image/png", files, ""},
+		{"", files, ""},
+		{"image/webp;q=0.5, image/jpeg", []string{"webp", ".jpg"}, ".jpg"},
+		{"image/x-custom", []string{"a.CST", "b.png"}, "a.CST"},
+		{"image/jxl, image/*;q=0.5", []string{"foo.jxl", "foo.jpg"}, "foo.jxl"},
+		{"*/*", []string{"foo.unknown", "png", "bar"}, "png"},
+		{"*/*", []string{}, ""},
+	}
+	for _, tt := range tests {
+		if got := PreferredExtension(tt.accept, tt.extensions...); got != tt.expected {
+			t.Errorf("%q: "+testErrorFormat, tt.accept, got, tt.expected)
+		}
+	}
+}
+
+func TestNegotiator_Accepts(t *testing.T) {
+	defer SnapshotRegistry()()
+	RegisterType("application/vnd.myapi+json", "myapi")
+
+	tests := []struct {
+		accept     string
+		shorthands []string
+		expected   string
+	}{
+		{"application/json", []string{"html", "json"}, "json"},
+		{"text/html, application/json;q=0.5", []string{"json", "html"}, "html"},
+		{"text/html;q=0.5, application/json", []string{"text/html", "json"}, "json"},
+		{"text/html, application/json;q=0.5", []string{"json", " text/html "}, " text/html "},
+		{"application/vnd.myapi+json, */*;q=0.1", []string{"json", "myapi"}, "myapi"},
+		{"application/*", []string{"txt", "application/x-custom"}, "application/x-custom"},
+		{"image/png", []string{"txt", "unknown"}, ""},
+		{"*/*", []string{"unknown", "xml"}, "xml"},
+		{"*/*", []string{}, ""},
+	}
+	for _, tt := range tests {
+		n := New(http.Header{HeaderAccept: {tt.accept}})
+		if got := n.Accepts(tt.shorthands...); got != tt.expected {
+			t.Errorf(testErrorFormat, got, tt.expected)
+		}
+	}
+}
@@ -7,7 +7,9 @@ package negotiator
 import (
 	"net/http"
 	"net/textproto"
+	"sort"
 	"strings"
+	"sync/atomic"
 )
 
 // HeaderAcceptCharset is `Accept-Charset`
@@ -22,62 +24,425 @@ var HeaderAcceptLanguage = textproto.CanonicalMIMEHeaderKey("Accept-Language")
 // HeaderAccept is `Accept`
 var HeaderAccept = textproto.CanonicalMIMEHeaderKey("Accept")
 
+// The maximum number of members of an Accept header which are parsed, see
+// SetMaxAcceptRanges.
+var maxAcceptRanges int64 = 64
+
+// The maximum number of parameters of a media range which are kept, see
+// SetMaxMediaRangeParameters.
+var maxMediaRangeParameters int64 = 16
+
+// SetMaxAcceptRanges sets the maximum number of members of an Accept header
+// which are parsed, 64 by default. The members past it are ignored, so that a
+// header with a huge number of ranges can't make the negotiation allocate and
+// compare without bound. The members are counted whether they're valid or
+// not, the number isn't limited if n isn't positive. The parsed values cached
+// before are dropped, and the ones being parsed concurrently aren't cached, so
+// that every header is parsed with the new limit afterwards.
+func SetMaxAcceptRanges(n int) {
+	atomic.StoreInt64(&maxAcceptRanges, int64(n))
+	resetParseCaches()
+}
+
+// SetMaxMediaRangeParameters sets the maximum number of parameters of a media
+// range, or of a provided media type, which are kept, 16 by default. The
+// parameters past it are ignored, so that a range with a huge number of
+// parameters can't make the negotiation allocate and compare without bound.
+// The q parameter is still found past the limit, so a range refused with q=0
+// stays refused, and the empty parameters aren't counted. The number isn't
+// limited if n isn't positive. The parsed values cached before are dropped
+// like with SetMaxAcceptRanges.
+func SetMaxMediaRangeParameters(n int) {
+	atomic.StoreInt64(&maxMediaRangeParameters, int64(n))
+	resetParseCaches()
+}
+
+// Get the maximum number of members of an Accept header which are parsed.
+func getMaxAcceptRanges() int {
+	return int(atomic.LoadInt64(&maxAcceptRanges))
+}
+
+// Get the maximum number of parameters of a media range which are kept.
+func getMaxMediaRangeParameters() int {
+	return int(atomic.LoadInt64(&maxMediaRangeParameters))
+}
+
 // Negotiator gets the negotiation info from http header
 type Negotiator struct {
 	Header http.Header
+
+	forced   *Result
+	matchers map[string]Matcher
+	opts     *options
 }
 
-// New creates a Negotiator instance from a header object.
-func New(header http.Header) *Negotiator {
-	return &Negotiator{header}
+// New creates a Negotiator instance from a header object. The options change
+// how its methods match the offers, e.g. WithPrefixDirection, and are the
+// defaults of Negotiate.
+func New(header http.Header, opts ...Option) *Negotiator {
+	n := &Negotiator{Header: header}
+	if len(opts) > 0 {
+		n.opts = newOptions(opts)
+	}
+	return n
+}
+
+// Get the options of New, overridden by opts.
+func (n *Negotiator) withOptions(opts []Option) *options {
+	o := &options{}
+	if n.opts != nil {
+		*o = *n.opts
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// Get how the language ranges match the offered languages, see
+// WithPrefixDirection and WithExtendedFiltering.
+func (n *Negotiator) languageFilter() languageFilter {
+	if n.opts == nil {
+		return languageFilter{}
+	}
+	return n.opts.languageFilter()
+}
+
+// Get how the ranges match the offered media types, see
+// WithCaseSensitiveParameters and WithSuffixFallback.
+func (n *Negotiator) mediaTypeFilter() mediaTypeFilter {
+	if n.opts == nil {
+		return mediaTypeFilter{}
+	}
+	return n.opts.mediaTypeFilter()
+}
+
+// Get the minimum quality of the offers, see WithMinimumQuality.
+func (n *Negotiator) minQuality() float64 {
+	if n.opts == nil {
+		return 0
+	}
+	return n.opts.minQuality
 }
 
 // Charset gets the most preferred charset from a list of available charsets.
+//
+// With up to 4 available charsets and a header as sent by common browsers, it
+// doesn't allocate once the header and the charsets have been seen, parsed
+// values are cached.
 func (n *Negotiator) Charset(available ...string) string {
-	return getMostPreferred(n.Charsets(available...))
+	if len(available) == 0 {
+		return getMostPreferred(n.Charsets(available...))
+	}
+	v, _ := n.negotiateCharset(available)
+	return v
+}
+
+// Negotiate the most preferred charset and how it matched, a forced result takes
+// precedence.
+func (n *Negotiator) negotiateCharset(available []string) (string, MatchKind) {
+	if n.forced != nil && n.forced.Charset != "" {
+		return n.forced.Charset, n.forced.CharsetMatch
+	}
+	available = normalizeOffers(available)
+	if n.ranked(HeaderAcceptCharset) {
+		return n.negotiateRegistered(HeaderAcceptCharset, available)
+	}
+	return bestCharset(getAccept(n.Header, HeaderAcceptCharset, "*"), available)
 }
 
 // Charsets gets an array of preferred charsets ordered by priority from a list
-// of available charsets.
+// of available charsets. A result forced with ForceResult takes
+// precedence.
 func (n *Negotiator) Charsets(available ...string) []string {
-	// RFC 2616 sec 14.2: no header = *
-	return PreferredCharsets(getAccept(n.Header, HeaderAcceptCharset, "*"), available...)
+	if n.forced != nil && n.forced.Charset != "" {
+		return []string{n.forced.Charset}
+	}
+	return n.Preferred(HeaderAcceptCharset, available...)
 }
 
 // Encoding gets the most preferred encoding from a list of available encodings.
+//
+// With up to 4 available encodings and a header as sent by common browsers, it
+// doesn't allocate once the header and the encodings have been seen, parsed
+// values are cached.
 func (n *Negotiator) Encoding(available ...string) string {
-	return getMostPreferred(n.Encodings(available...))
+	if len(available) == 0 {
+		return getMostPreferred(n.Encodings(available...))
+	}
+	v, _ := n.negotiateEncoding(available)
+	return v
+}
+
+// Negotiate the most preferred encoding and how it matched, a forced result takes
+// precedence.
+func (n *Negotiator) negotiateEncoding(available []string) (string, MatchKind) {
+	if n.forced != nil && n.forced.Encoding != "" {
+		return n.forced.Encoding, n.forced.EncodingMatch
+	}
+	available = normalizeOffers(available)
+	if n.ranked(HeaderAcceptEncoding) {
+		return n.negotiateRegistered(HeaderAcceptEncoding, available)
+	}
+	return bestEncoding(getAccept(n.Header, HeaderAcceptEncoding, "*"), available)
 }
 
 // Encodings gets an array of preferred encodings ordered by priority from
-// a list of available encodings.
+// a list of available encodings. A result forced with ForceResult takes
+// precedence.
 func (n *Negotiator) Encodings(available ...string) []string {
-	// RFC 2616 sec 14.2: no header = *
-	return PreferredEncodings(getAccept(n.Header, HeaderAcceptEncoding, "*"), available...)
+	if n.forced != nil && n.forced.Encoding != "" {
+		return []string{n.forced.Encoding}
+	}
+	return n.Preferred(HeaderAcceptEncoding, available...)
 }
 
 // Language gets the most preferred language from a list of available languages.
+//
+// With up to 4 available languages and a header as sent by common browsers, it
+// doesn't allocate once the header and the languages have been seen, parsed
+// values are cached.
 func (n *Negotiator) Language(available ...string) string {
-	return getMostPreferred(n.Languages(available...))
+	if len(available) == 0 {
+		return getMostPreferred(n.Languages(available...))
+	}
+	v, _ := n.negotiateLanguage(available)
+	return v
+}
+
+// LanguageOK gets the most preferred language like Language, ok is false if
+// none is acceptable, so that the caller doesn't send an empty
+// Content-Language header.
+func (n *Negotiator) LanguageOK(available ...string) (language string, ok bool) {
+	language = n.Language(available...)
+	return language, language != ""
+}
+
+// LanguageOrDefault gets the most preferred language like Language, or def if
+// none is acceptable, e.g. with "Accept-Language: de" and the available
+// languages en and fr. A header refusing def with q=0, e.g. "*;q=0" or
+// "en;q=0" for en, still yields "", use Negotiate with WithDefaultLanguage and
+// WithDefaultLanguageOnRefusal to serve def anyway.
+func (n *Negotiator) LanguageOrDefault(def string, available ...string) string {
+	if v := n.Language(available...); v != "" {
+		return v
+	}
+	if n.refusesLanguage(def) {
+		return ""
+	}
+	return def
+}
+
+// LanguageIndex gets the index in available of the most preferred language, or
+// -1 if none is acceptable, see PreferredLanguageIndex. With a forced result,
+// it's the index of the first available language equal to the forced one,
+// ignoring case.
+func (n *Negotiator) LanguageIndex(available ...string) int {
+	language := ""
+	switch {
+	case n.forced != nil && n.forced.Language != "":
+		language = n.forced.Language
+	case n.ranked(HeaderAcceptLanguage):
+		language, _ = n.negotiateRegistered(HeaderAcceptLanguage, normalizeOffers(available))
+	default:
+		i, _ := bestLanguageIndex(getAccept(n.Header, HeaderAcceptLanguage, "*"), available, n.languageFilter())
+		return i
+	}
+	for i, v := range available {
+		if language != "" && strings.EqualFold(trimOffer(v), language) {
+			return i
+		}
+	}
+	return -1
+}
+
+// Reports whether the Accept-Language header refuses a language explicitly,
+// i.e. the range governing it has q=0.
+func (n *Negotiator) refusesLanguage(language string) bool {
+	acs := cachedAcceptLanguage(getAccept(n.Header, HeaderAcceptLanguage, "*"))
+	spec := getParsedLanguagePriority(cachedLanguageOffer(trimOffer(language)), acs, nil, 0, n.languageFilter())
+	return spec.o != -1 && spec.q == 0
+}
+
+// Negotiate the most preferred language and how it matched, a forced result takes
+// precedence.
+func (n *Negotiator) negotiateLanguage(available []string) (string, MatchKind) {
+	if n.forced != nil && n.forced.Language != "" {
+		return n.forced.Language, n.forced.LanguageMatch
+	}
+	available = normalizeOffers(available)
+	if n.ranked(HeaderAcceptLanguage) {
+		return n.negotiateRegistered(HeaderAcceptLanguage, available)
+	}
+	return bestLanguage(getAccept(n.Header, HeaderAcceptLanguage, "*"), available, n.languageFilter())
 }
 
 // Languages gets an array of preferred languages ordered by priority from a list
-// of available languages.
+// of available languages. A result forced with ForceResult takes
+// precedence.
 func (n *Negotiator) Languages(available ...string) []string {
-	// RFC 2616 sec 14.2: no header = *
-	return PreferredLanguages(getAccept(n.Header, HeaderAcceptLanguage, "*"), available...)
+	if n.forced != nil && n.forced.Language != "" {
+		return []string{n.forced.Language}
+	}
+	return n.Preferred(HeaderAcceptLanguage, available...)
+}
+
+// LanguagesMinQuality gets the preferred languages like Languages, but drops
+// the languages whose quality is below minQuality, see
+// PreferredLanguagesMinQuality. A result forced with ForceResult takes
+// precedence.
+func (n *Negotiator) LanguagesMinQuality(minQuality float64, available ...string) []string {
+	if n.forced != nil && n.forced.Language != "" {
+		return []string{n.forced.Language}
+	}
+	weighted := n.Weighted(HeaderAcceptLanguage, available...)
+	results := make([]string, 0, len(weighted))
+	for _, w := range weighted {
+		if w.Quality >= minQuality {
+			results = append(results, w.Value)
+		}
+	}
+	return results
 }
 
 // MediaType gets the most preferred media type from a list of available media types.
+//
+// With up to 4 available media types and a header as sent by common browsers, it
+// doesn't allocate once the header and the media types have been seen, parsed
+// values are cached.
 func (n *Negotiator) MediaType(available ...string) string {
-	return getMostPreferred(n.MediaTypes(available...))
+	if len(available) == 0 {
+		return getMostPreferred(n.MediaTypes(available...))
+	}
+	v, _ := n.negotiateMediaType(available)
+	return v
+}
+
+// MediaTypeOK gets the most preferred media type like MediaType, ok is false
+// if none is acceptable, so that the caller can respond with 406 Not
+// Acceptable without comparing against "".
+func (n *Negotiator) MediaTypeOK(available ...string) (mediaType string, ok bool) {
+	mediaType = n.MediaType(available...)
+	return mediaType, mediaType != ""
+}
+
+// MediaTypeMatch gets the most preferred media type like MediaType, and how
+// the Accept header asked for it, see PreferredMediaTypeMatch. A forced result
+// takes precedence with its match kind.
+func (n *Negotiator) MediaTypeMatch(available ...string) (string, MatchKind) {
+	return n.negotiateMediaType(available)
+}
+
+// MediaTypeIndex gets the index in available of the most preferred media type,
+// or -1 if none is acceptable, see PreferredMediaTypeIndex. With a forced
+// result, it's the index of the first available media type equal to the forced
+// one, ignoring case.
+func (n *Negotiator) MediaTypeIndex(available ...string) int {
+	mediaType := ""
+	switch {
+	case n.forcedMediaType():
+		mediaType = n.forced.MediaType
+	case n.ranked(HeaderAccept):
+		mediaType, _ = n.negotiateRegistered(HeaderAccept, available)
+	default:
+		i, _ := bestMediaTypeIndex(getAccept(n.Header, HeaderAccept, "*/*"), available, n.mediaTypeFilter())
+		return i
+	}
+	for i, v := range available {
+		if mediaType != "" && strings.EqualFold(trimOffer(v), mediaType) {
+			return i
+		}
+	}
+	return -1
+}
+
+// MediaTypeOrDefault gets the most preferred media type like MediaType, or def
+// if none is acceptable and the Accept header has no valid range, i.e. it's
+// empty or malformed. A header with valid ranges refusing the available media
+// types, e.g. "application/json;q=0", still yields "", so that the caller can
+// respond with 406 Not Acceptable.
+func (n *Negotiator) MediaTypeOrDefault(def string, available ...string) string {
+	if v := n.MediaType(available...); v != "" {
+		return v
+	}
+	if n.rangeCount(HeaderAccept) == 0 {
+		return def
+	}
+	return ""
+}
+
+// Negotiate the most preferred media type and how it matched, a forced result takes
+// precedence.
+func (n *Negotiator) negotiateMediaType(available []string) (string, MatchKind) {
+	if n.forced != nil && n.forced.MediaType != "" {
+		return n.forced.MediaType, n.forced.MediaTypeMatch
+	}
+	available = normalizeOffers(available)
+	if n.ranked(HeaderAccept) {
+		return n.negotiateRegistered(HeaderAccept, available)
+	}
+	return bestMediaType(getAccept(n.Header, HeaderAccept, "*/*"), available, n.mediaTypeFilter())
 }
 
 // MediaTypes gets an array of preferred mediaTypes ordered by priority from a list
-// of available media types.
+// of available media types. A result forced with ForceResult takes precedence.
 func (n *Negotiator) MediaTypes(available ...string) []string {
-	// RFC 2616 sec 14.2: no header = */*
-	return PreferredMediaTypes(getAccept(n.Header, HeaderAccept, "*/*"), available...)
+	if n.forced != nil && n.forced.MediaType != "" {
+		return []string{n.forced.MediaType}
+	}
+	if !n.ranked(HeaderAccept) && isAnyMediaRange(getAccept(n.Header, HeaderAccept, "*/*")) {
+		if offers, ok := anyMediaRangeOffers(normalizeOffers(available)); ok {
+			return offers
+		}
+	}
+	return n.Preferred(HeaderAccept, available...)
+}
+
+// Normalize the offers of an entry point, each offer is trimmed of OWS and the
+// empty ones are dropped, so padded offers, e.g. read from a config file,
+// negotiate the same everywhere. offers is returned as is if it's normalized
+// already, which doesn't allocate.
+func normalizeOffers(offers []string) []string {
+	for i, offer := range offers {
+		if offer != "" && !isOWS(offer[0]) && !isOWS(offer[len(offer)-1]) {
+			continue
+		}
+		results := make([]string, i, len(offers))
+		copy(results, offers[:i])
+		for _, offer := range offers[i:] {
+			if offer = trimOffer(offer); offer != "" {
+				results = append(results, offer)
+			}
+		}
+		return results
+	}
+	return offers
+}
+
+// Split an Accept header into at most the maximum number of members, see
+// SetMaxAcceptRanges, the commas within quoted parameter values don't separate
+// members.
+func splitMembers(accept string) []string {
+	members, _ := splitQuotedN(accept, ',', getMaxAcceptRanges())
+	return members
+}
+
+// Trim an offer of OWS.
+func trimOffer(offer string) string {
+	return strings.Trim(offer, " \t")
+}
+
+func isOWS(c byte) bool {
+	return c == ' ' || c == '\t'
+}
+
+// AcceptsMediaType reports whether the request accepts the media type, with the
+// matcher registered for the Accept header if any, see AcceptsMediaType.
+func (n *Negotiator) AcceptsMediaType(mediaType string) bool {
+	if n.ranked(HeaderAccept) {
+		return len(n.Weighted(HeaderAccept, mediaType)) > 0
+	}
+	return acceptsMediaType(getAccept(n.Header, HeaderAccept, "*/*"), mediaType, n.mediaTypeFilter())
 }
 
 func getMostPreferred(accepts []string) string {
@@ -90,15 +455,57 @@ func getMostPreferred(accepts []string) string {
 func getAccept(h http.Header, key, defaultValue string) string {
 	accept, values := defaultValue, getHeaderValues(h, key)
 	if values != nil {
-		accept = strings.Join(values, ",")
+		accept = joinHeaderValues(values)
 	}
 	return accept
 }
 
-// The patch of http.Header.Values for go version lower than 1.4
+// Joins the field lines of a header into one comma-separated value. Each line
+// is trimmed of OWS and surrounding commas first, so a header split across
+// several lines, as HTTP/2 stacks may deliver it, negotiates exactly like the
+// same header on a single line.
+func joinHeaderValues(values []string) string {
+	if len(values) == 1 {
+		return strings.Trim(values[0], " \t,")
+	}
+	lines := make([]string, 0, len(values))
+	for _, v := range values {
+		if v = strings.Trim(v, " \t,"); v != "" {
+			lines = append(lines, v)
+		}
+	}
+	return strings.Join(lines, ",")
+}
+
+// The patch of http.Header.Values for go version lower than 1.4, a header
+// stored under a non-canonical name, e.g. the lowercase names of HTTP/2, is
+// also found. The values of a header stored under several non-canonical names
+// are joined in the order of the sorted names, so that the result doesn't
+// depend on the order of the map.
 func getHeaderValues(h http.Header, key string) []string {
 	if h == nil {
 		return nil
 	}
-	return h[textproto.CanonicalMIMEHeaderKey(key)]
+	key = textproto.CanonicalMIMEHeaderKey(key)
+	if values, ok := h[key]; ok {
+		return values
+	}
+	var keys []string
+	for k := range h {
+		if strings.EqualFold(k, key) {
+			keys = append(keys, k)
+		}
+	}
+	switch len(keys) {
+	case 0:
+		return nil
+	case 1:
+		return h[keys[0]]
+	}
+	sort.Strings(keys)
+	var values []string
+	for _, k := range keys {
+		values = append(values, h[k]...)
+	}
+	return values
 }
@@ -8,6 +8,8 @@ import (
 	"net/http"
 	"reflect"
 	"regexp"
+	"strconv"
+	"strings"
 	"testing"
 )
 
@@ -102,6 +104,252 @@ func TestNegotiator_MediaTypes(t *testing.T) {
 	}
 }
 
+func TestNegotiator_MediaTypeOrDefault(t *testing.T) {
+	available := []string{"text/html", "application/json"}
+	tests := []struct {
+		header   http.Header
+		expected string
+	}{
+		{http.Header{}, "text/html"},
+		{http.Header{HeaderAccept: {"application/json"}}, "application/json"},
+		{http.Header{HeaderAccept: {""}}, "text/plain"},
+		{http.Header{HeaderAccept: {"bogus, text"}}, "text/plain"},
+		{http.Header{HeaderAccept: {"text/html;q=x"}}, "text/plain"},
+		{http.Header{HeaderAccept: {"application/json;q=0"}}, ""},
+		{http.Header{HeaderAccept: {"image/png"}}, ""},
+		{http.Header{HeaderAccept: {"bogus, image/png"}}, ""},
+	}
+	for _, tt := range tests {
+		if got := New(tt.header).MediaTypeOrDefault("text/plain", available...); got != tt.expected {
+			t.Errorf(testErrorFormat, got, tt.expected)
+		}
+	}
+}
+
+func TestNegotiator_LanguageOK(t *testing.T) {
+	tests := []struct {
+		header    http.Header
+		available []string
+		expected  string
+		ok        bool
+	}{
+		{http.Header{}, []string{"fr", "de"}, "fr", true},
+		{http.Header{HeaderAcceptLanguage: {"de"}}, []string{"fr", "de"}, "de", true},
+		{http.Header{HeaderAcceptLanguage: {""}}, []string{"fr", "de"}, "", false},
+		{http.Header{HeaderAcceptLanguage: {"fr;q=0, de;q=0"}}, []string{"fr", "de"}, "", false},
+		{http.Header{HeaderAcceptLanguage: {"*;q=0"}}, []string{"fr", "de"}, "", false},
+		{http.Header{HeaderAcceptLanguage: {"ja"}}, []string{"fr", "de"}, "", false},
+		{http.Header{HeaderAcceptLanguage: {"fr"}}, []string{}, "fr", true},
+		{http.Header{HeaderAcceptLanguage: {"fr"}}, []string{" ", ""}, "", false},
+		{http.Header{HeaderAcceptLanguage: {"fr;q=0"}}, nil, "", false},
+	}
+	for _, tt := range tests {
+		got, ok := New(tt.header).LanguageOK(tt.available...)
+		if got != tt.expected || ok != tt.ok {
+			t.Errorf(testErrorFormat, []interface{}{got, ok}, []interface{}{tt.expected, tt.ok})
+		}
+	}
+}
+
+func TestNegotiator_LanguageOrDefault(t *testing.T) {
+	available := []string{"fr", "de"}
+	tests := []struct {
+		header   http.Header
+		expected string
+	}{
+		{http.Header{}, "fr"},
+		{http.Header{HeaderAcceptLanguage: {"de"}}, "de"},
+		{http.Header{HeaderAcceptLanguage: {"ja"}}, "en"},
+		{http.Header{HeaderAcceptLanguage: {""}}, "en"},
+		{http.Header{HeaderAcceptLanguage: {"en-US;q=0, ja"}}, "en"},
+		{http.Header{HeaderAcceptLanguage: {"fr;q=0, de;q=0"}}, "en"},
+		{http.Header{HeaderAcceptLanguage: {"en;q=0"}}, ""},
+		{http.Header{HeaderAcceptLanguage: {"ja, *;q=0"}}, ""},
+	}
+	for _, tt := range tests {
+		if got := New(tt.header).LanguageOrDefault("en", available...); got != tt.expected {
+			t.Errorf(testErrorFormat, got, tt.expected)
+		}
+	}
+}
+
+func TestNegotiator_LanguagesMinQuality(t *testing.T) {
+	header := http.Header{HeaderAcceptLanguage: {"en-US, en;q=0.9, fr;q=0.5, de;q=0.2"}}
+	tests := []struct {
+		minQuality float64
+		available  []string
+		expected   []string
+	}{
+		{0, []string{"de", "fr", "en"}, []string{"en", "fr", "de"}},
+		{0.5, []string{"de", "fr", "en"}, []string{"en", "fr"}},
+		{0.5, []string{"de"}, []string{}},
+		{0.9, nil, []string{"en-US", "en"}},
+	}
+	for _, tt := range tests {
+		if got := New(header).LanguagesMinQuality(tt.minQuality, tt.available...); !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf(testErrorFormat, got, tt.expected)
+		}
+	}
+
+	n := New(header)
+	ForceResult(n, Result{Language: "ja"})
+	if got, expected := n.LanguagesMinQuality(0.5, "de"), []string{"ja"}; !reflect.DeepEqual(got, expected) {
+		t.Errorf(testErrorFormat, got, expected)
+	}
+}
+
+func TestNegotiator_MinimumQuality(t *testing.T) {
+	header := http.Header{
+		HeaderAccept:         {"text/html;q=0.05, */*;q=0.5"},
+		HeaderAcceptLanguage: {"fr;q=0.05, *;q=0.1"},
+		HeaderAcceptCharset:  {"utf-8;q=0.05"},
+		HeaderAcceptEncoding: {"gzip;q=0.05, br"},
+	}
+	n := New(header, WithMinimumQuality(0.1))
+	if got, expected := n.MediaType("text/html", "application/json"), "application/json"; got != expected {
+		t.Errorf(testErrorFormat, got, expected)
+	}
+	if got, expected := n.MediaTypeIndex("text/html", "application/json"), 1; got != expected {
+		t.Errorf(testErrorFormat, got, expected)
+	}
+	if got, expected := n.MediaTypes("text/html", "application/json"), []string{"application/json"}; !reflect.DeepEqual(got, expected) {
+		t.Errorf(testErrorFormat, got, expected)
+	}
+	if got, expected := n.MediaTypes(), []string{"*/*", "text/html"}; !reflect.DeepEqual(got, expected) {
+		t.Errorf(testErrorFormat, got, expected)
+	}
+	if got, expected := n.Language("fr", "de"), "de"; got != expected {
+		t.Errorf(testErrorFormat, got, expected)
+	}
+	if got, expected := n.LanguageIndex("fr"), -1; got != expected {
+		t.Errorf(testErrorFormat, got, expected)
+	}
+	if got, expected := n.Charset("utf-8"), ""; got != expected {
+		t.Errorf(testErrorFormat, got, expected)
+	}
+	if got, expected := n.Encodings("gzip", "br"), []string{"br"}; !reflect.DeepEqual(got, expected) {
+		t.Errorf(testErrorFormat, got, expected)
+	}
+	if n.AcceptsMediaType("text/html") {
+		t.Errorf(testErrorFormat, true, false)
+	}
+	if got, expected := New(header).MediaType("text/html"), "text/html"; got != expected {
+		t.Errorf(testErrorFormat, got, expected)
+	}
+}
+
+func TestNegotiator_MediaTypeOK(t *testing.T) {
+	tests := []struct {
+		header    http.Header
+		available []string
+		expected  string
+		ok        bool
+	}{
+		{http.Header{}, []string{"text/html", "application/json"}, "text/html", true},
+		{http.Header{HeaderAccept: {"application/json"}}, []string{"text/html", "application/json"}, "application/json", true},
+		{http.Header{HeaderAccept: {"application/json;q=0"}}, []string{"application/json"}, "", false},
+		{http.Header{HeaderAccept: {"image/png"}}, []string{"text/html"}, "", false},
+		{http.Header{HeaderAccept: {""}}, []string{"text/html"}, "", false},
+		{http.Header{HeaderAccept: {"text/html"}}, []string{" ", ""}, "", false},
+		{http.Header{HeaderAccept: {"text/html, application/json;q=0.5"}}, nil, "text/html", true},
+	}
+	for _, tt := range tests {
+		got, ok := New(tt.header).MediaTypeOK(tt.available...)
+		if got != tt.expected || ok != tt.ok {
+			t.Errorf(testErrorFormat, []interface{}{got, ok}, []interface{}{tt.expected, tt.ok})
+		}
+	}
+}
+
+func TestNegotiator_MediaTypeMatch(t *testing.T) {
+	tests := []struct {
+		accept    string
+		available []string
+		expected  string
+		kind      MatchKind
+	}{
+		{"application/json, */*;q=0.1", []string{"text/html", "application/json"}, "application/json", MatchExact},
+		{"application/*, */*;q=0.1", []string{"application/json"}, "application/json", MatchSubtypeWildcard},
+		{"application/*+json", []string{"application/ld+json"}, "application/ld+json", MatchSubtypeWildcard},
+		{"*/*", []string{"application/json"}, "application/json", MatchFullWildcard},
+		{"text/html, */*;q=0.1", []string{"application/json"}, "application/json", MatchFullWildcard},
+		{"image/png", []string{"application/json"}, "", MatchNone},
+		{"*/*", []string{" "}, "", MatchNone},
+	}
+	for _, tt := range tests {
+		if got, kind := PreferredMediaTypeMatch(tt.accept, tt.available...); got != tt.expected || kind != tt.kind {
+			t.Errorf("%q: "+testErrorFormat, tt.accept, []interface{}{got, kind}, []interface{}{tt.expected, tt.kind})
+		}
+		n := New(http.Header{HeaderAccept: {tt.accept}})
+		if got, kind := n.MediaTypeMatch(tt.available...); got != tt.expected || kind != tt.kind {
+			t.Errorf("%q: "+testErrorFormat, tt.accept, []interface{}{got, kind}, []interface{}{tt.expected, tt.kind})
+		}
+	}
+
+	n := New(http.Header{})
+	if got, kind := n.MediaTypeMatch("application/json"); got != "application/json" || kind != MatchFullWildcard {
+		t.Errorf(testErrorFormat, []interface{}{got, kind}, []interface{}{"application/json", MatchFullWildcard})
+	}
+	ForceResult(n, Result{MediaType: "text/html", MediaTypeMatch: MatchExact})
+	if got, kind := n.MediaTypeMatch("application/json"); got != "text/html" || kind != MatchExact {
+		t.Errorf(testErrorFormat, []interface{}{got, kind}, []interface{}{"text/html", MatchExact})
+	}
+}
+
+func TestPreferred_WhitespaceAroundEquals(t *testing.T) {
+	tests := []struct {
+		preferred func(accept string, provided ...string) []string
+		accept    string
+		provided  []string
+		expected  []string
+	}{
+		{PreferredCharsets, "utf-8;q =0.8, iso-8859-1;q=0.9", []string{"utf-8", "iso-8859-1"}, []string{"iso-8859-1", "utf-8"}},
+		{PreferredEncodings, "gzip;q= 0.8, br;q=0.9", []string{"gzip", "br"}, []string{"br", "gzip"}},
+		{PreferredLanguages, "en; q = 0.8, fr;q=0.9", []string{"en", "fr"}, []string{"fr", "en"}},
+		{PreferredMediaTypes, "text/html; q = 0.8, application/json;q=0.9", []string{"text/html", "application/json"}, []string{"application/json", "text/html"}},
+		{PreferredMediaTypes, "text/html;level = 1, text/html;q=0.5", []string{"text/html", "text/html;level=1"}, []string{"text/html;level=1", "text/html"}},
+		{PreferredMediaTypes, "text/html;q= 0", []string{"text/html"}, []string{}},
+	}
+	for _, tt := range tests {
+		if got := tt.preferred(tt.accept, tt.provided...); !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf(testErrorFormat, got, tt.expected)
+		}
+	}
+}
+
+func TestPreferred_EmptyMembersAndParameters(t *testing.T) {
+	tests := []struct {
+		preferred func(accept string, provided ...string) []string
+		accept    string
+		provided  []string
+		expected  []string
+	}{
+		{PreferredCharsets, "utf-8;,, ,iso-8859-1;;q=0.5,", []string{"iso-8859-1", "utf-8"}, []string{"utf-8", "iso-8859-1"}},
+		{PreferredCharsets, ",;, ", nil, []string{}},
+		{PreferredEncodings, "gzip;,,br;;q=0.5,", []string{"br", "gzip"}, []string{"gzip", "br"}},
+		{PreferredLanguages, "en;,, ,fr; ;q=0.5,", []string{"fr", "en"}, []string{"en", "fr"}},
+		{PreferredMediaTypes, "text/html,,application/json,", []string{"application/json", "text/html"}, []string{"text/html", "application/json"}},
+		{PreferredMediaTypes, "text/html;, application/json;;q=0.5", []string{"application/json", "text/html"}, []string{"text/html", "application/json"}},
+		{PreferredMediaTypes, "text/html; ;level=1", []string{"text/html", "text/html;level=1"}, []string{"text/html;level=1"}},
+	}
+	for _, tt := range tests {
+		if got := tt.preferred(tt.accept, tt.provided...); !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("%q: "+testErrorFormat, tt.accept, got, tt.expected)
+		}
+	}
+
+	ranges, expected := PreferredMediaRanges("text/html; ;, , text/plain;;level=1;"), []string{"text/html", "text/plain;level=1"}
+	if !reflect.DeepEqual(ranges, expected) {
+		t.Errorf(testErrorFormat, ranges, expected)
+	}
+
+	for _, d := range []AcceptDescription{DescribeAccept("text/html;, ,"), DescribeAcceptCharset("utf-8;, ,"), DescribeAcceptEncoding("gzip;, ,"), DescribeAcceptLanguage("en;, ,")} {
+		if len(d.Ranges) != 1 || d.Ranges[0].Params != nil || len(d.Dropped) != 0 {
+			t.Errorf("%s %q: got %v", d.Header, d.Value, d)
+		}
+	}
+}
+
 func TestGetHeaderValues(t *testing.T) {
 	charsets := []string{"utf-8", "iso-8859-1;q=0.8"}
 	header := http.Header{HeaderAcceptCharset: charsets}
@@ -117,6 +365,8 @@ func TestGetHeaderValues(t *testing.T) {
 		{header, "accept-charset", charsets},
 		{header, "ACCEPT-CHARSET", charsets},
 		{header, "ACCEPT-CHARSET", charsets},
+		{http.Header{"accept-charset": charsets}, "Accept-Charset", charsets},
+		{http.Header{"accept-charset": charsets}, "Accept-Language", nil},
 	}
 	for _, tt := range tests {
 		if got := getHeaderValues(tt.h, tt.k); !reflect.DeepEqual(got, tt.expected) {
@@ -125,6 +375,123 @@ func TestGetHeaderValues(t *testing.T) {
 	}
 }
 
+func TestNegotiator_HeaderLines(t *testing.T) {
+	tests := []struct {
+		key       string
+		joined    string
+		lines     []string
+		available []string
+	}{
+		{
+			HeaderAccept,
+			"text/html;q=0.5, application/json;q=0.8, */*;q=0.1",
+			[]string{" text/html;q=0.5\t", "\tapplication/json;q=0.8 ,", "*/*;q=0.1,"},
+			[]string{"text/plain", "text/html", "application/json"},
+		},
+		{
+			HeaderAcceptCharset,
+			"utf-8;q=0.5, iso-8859-1",
+			[]string{"utf-8;q=0.5\t", " iso-8859-1 ,"},
+			[]string{"utf-8", "iso-8859-1"},
+		},
+		{
+			HeaderAcceptEncoding,
+			"gzip;q=0.5, br;q=0.8",
+			[]string{"gzip;q=0.5\t,", "", "br;q=0.8 "},
+			[]string{"gzip", "br", "identity"},
+		},
+		{
+			HeaderAcceptLanguage,
+			"en;q=0.5, fr-CH, de;q=0.7",
+			[]string{",en;q=0.5 ", "fr-CH,", "\tde;q=0.7\t"},
+			[]string{"en", "de", "fr-CH"},
+		},
+	}
+	for _, tt := range tests {
+		forms := []http.Header{
+			{tt.key: {tt.joined}},
+			{tt.key: tt.lines},
+			{strings.ToLower(tt.key): tt.lines},
+		}
+		expected := New(forms[0]).Preferred(tt.key, tt.available...)
+		for _, h := range forms[1:] {
+			if got := New(h).Preferred(tt.key, tt.available...); !reflect.DeepEqual(got, expected) {
+				t.Errorf(testErrorFormat, got, expected)
+			}
+		}
+	}
+
+	// the values of several non-canonical names are joined in name order
+	h := http.Header{"accept-language": {"fr"}, "ACCEPT-LANGUAGE": {"de;q=0.5"}, "Accept-language": {"en;q=0.1"}}
+	expected := []string{"de;q=0.5", "en;q=0.1", "fr"}
+	for i := 0; i < 20; i++ {
+		if got := getHeaderValues(h, HeaderAcceptLanguage); !reflect.DeepEqual(got, expected) {
+			t.Fatalf(testErrorFormat, got, expected)
+		}
+	}
+	if got := New(h).Languages("en", "de", "fr"); !reflect.DeepEqual(got, []string{"fr", "de", "en"}) {
+		t.Errorf(testErrorFormat, got, []string{"fr", "de", "en"})
+	}
+}
+
+func TestJoinHeaderValues(t *testing.T) {
+	tests := []struct {
+		values   []string
+		expected string
+	}{
+		{[]string{""}, ""},
+		{[]string{" , "}, ""},
+		{[]string{"en"}, "en"},
+		{[]string{" en,", "\tfr ", ""}, "en,fr"},
+	}
+	for _, tt := range tests {
+		if got := joinHeaderValues(tt.values); got != tt.expected {
+			t.Errorf(testErrorFormat, got, tt.expected)
+		}
+	}
+}
+
+func TestNegotiator_QuotedCommasAcrossLines(t *testing.T) {
+	tests := []struct {
+		header   http.Header
+		get      func(n *Negotiator, available ...string) []string
+		offers   []string
+		expected []string
+	}{
+		{http.Header{HeaderAccept: {`text/html;title="a,b";q=0.5`, "application/json"}}, (*Negotiator).MediaTypes, []string{`text/html;title="a,b"`, "application/json"}, []string{"application/json", `text/html;title="a,b"`}},
+		{http.Header{HeaderAccept: {`text/html;title="a`, `b";q=0.5, application/json`}}, (*Negotiator).MediaTypes, []string{`text/html;title="a,b"`, "application/json"}, []string{"application/json", `text/html;title="a,b"`}},
+		{http.Header{HeaderAcceptCharset: {`utf-8;x="a,b";q=0.5`, "iso-8859-1"}}, (*Negotiator).Charsets, []string{"utf-8", "iso-8859-1"}, []string{"iso-8859-1", "utf-8"}},
+		{http.Header{HeaderAcceptEncoding: {`gzip;x="a,b;q=1";q=0.5`, "br"}}, (*Negotiator).Encodings, []string{"gzip", "br"}, []string{"br", "gzip"}},
+		{http.Header{HeaderAcceptLanguage: {`en;x="a,b";q=0.5`, "fr"}}, (*Negotiator).Languages, []string{"en", "fr"}, []string{"fr", "en"}},
+	}
+	for _, tt := range tests {
+		if got := tt.get(New(tt.header), tt.offers...); !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("%v: "+testErrorFormat, tt.header, got, tt.expected)
+		}
+	}
+}
+
+func TestSplitMembers(t *testing.T) {
+	defer SetMaxAcceptRanges(getMaxAcceptRanges())
+	tests := []struct {
+		max      int
+		accept   string
+		expected []string
+	}{
+		{64, "a,b", []string{"a", "b"}},
+		{64, `a;x="1,2",b`, []string{`a;x="1,2"`, "b"}},
+		{64, `a;x="1,2,b`, []string{`a;x="1`, "2", "b"}},
+		{2, "a,b,c", []string{"a", "b"}},
+		{0, "a,b,c", []string{"a", "b", "c"}},
+	}
+	for _, tt := range tests {
+		SetMaxAcceptRanges(tt.max)
+		if got := splitMembers(tt.accept); !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf(testErrorFormat, got, tt.expected)
+		}
+	}
+}
+
 func newNegotiatorTestObjs(arr []testObj, k string) []negotiatorTestObj {
 	results := make([]negotiatorTestObj, len(arr)+1, len(arr)+1)
 	for i, obj := range arr {
@@ -138,3 +505,202 @@ func newNegotiatorTestObjs(arr []testObj, k string) []negotiatorTestObj {
 	}
 	return results
 }
+
+func TestNegotiator_ZeroAllocs(t *testing.T) {
+	n := New(http.Header{
+		HeaderAccept: {"text/html,application/xhtml+xml,application/xml;q=0.9,image/avif,image/webp," +
+			"image/apng,*/*;q=0.8,application/signed-exchange;v=b3;q=0.7"},
+		HeaderAcceptCharset:  {"utf-8, iso-8859-1;q=0.5"},
+		HeaderAcceptEncoding: {"gzip, deflate, br"},
+		HeaderAcceptLanguage: {"en-US,en;q=0.9,fr;q=0.8"},
+	})
+	tests := []struct {
+		name      string
+		best      func(available ...string) string
+		preferred func(available ...string) []string
+		available []string
+	}{
+		{"Charset", n.Charset, n.Charsets, []string{"iso-8859-1", "utf-8"}},
+		{"Encoding", n.Encoding, n.Encodings, []string{"identity", "gzip", "br", "zstd"}},
+		{"Language", n.Language, n.Languages, []string{"fr", "en", "de", "en-GB"}},
+		{"MediaType", n.MediaType, n.MediaTypes, []string{"application/json", "text/html"}},
+	}
+	for _, tt := range tests {
+		expected := getMostPreferred(tt.preferred(tt.available...))
+		if got := tt.best(tt.available...); got != expected || got == "" {
+			t.Errorf(testErrorFormat, got, expected)
+		}
+		if allocs := testing.AllocsPerRun(100, func() { tt.best(tt.available...) }); allocs != 0 {
+			t.Errorf("%s allocates %v times per call, expect 0", tt.name, allocs)
+		}
+	}
+}
+
+func TestParseCache(t *testing.T) {
+	c := parseCache{}
+	for i := 0; i < parseCacheSize+1; i++ {
+		c.store(strings.Repeat("x", i), i, 0)
+	}
+	if len(c.entries) > parseCacheSize {
+		t.Errorf(testErrorFormat, len(c.entries), parseCacheSize)
+	}
+	if v, ok := c.load(strings.Repeat("x", parseCacheSize)); !ok || v != parseCacheSize {
+		t.Errorf(testErrorFormat, v, parseCacheSize)
+	}
+
+	huge := strings.Repeat("x", parseCacheMaxKeyLength+1)
+	c.store(huge, 0, 0)
+	if _, ok := c.load(huge); ok {
+		t.Errorf("a key of %d bytes is cached", len(huge))
+	}
+
+	gen := c.gen()
+	c.reset()
+	c.store("stale", 0, gen)
+	if _, ok := c.load("stale"); ok {
+		t.Errorf("a value parsed before a reset is cached")
+	}
+	c.store("fresh", 0, c.gen())
+	if _, ok := c.load("fresh"); !ok {
+		t.Errorf("a value parsed after a reset isn't cached")
+	}
+
+	accept := "text/html" + strings.Repeat(", text/html", parseCacheMaxKeyLength/11)
+	if got := PreferredMediaTypeIndex(accept, "text/html"); got != 0 {
+		t.Errorf(testErrorFormat, got, 0)
+	}
+	if _, ok := mediaTypeCache.load(accept); ok {
+		t.Errorf("an Accept header of %d bytes is cached", len(accept))
+	}
+}
+
+func TestPaddedOffers(t *testing.T) {
+	header := http.Header{
+		HeaderAccept:         {"application/json, text/html;q=0.5"},
+		HeaderAcceptLanguage: {"de, en;q=0.5"},
+		HeaderAcceptCharset:  {"utf-8, iso-8859-1;q=0.5"},
+		HeaderAcceptEncoding: {"gzip, identity;q=0.5"},
+	}
+	tests := []struct {
+		header    string
+		padded    []string
+		expected  []string
+		preferred func(accept string, provided ...string) []string
+	}{
+		{HeaderAccept, []string{" text/html", "\tApplication/JSON ", "  "}, []string{"Application/JSON", "text/html"}, PreferredMediaTypes},
+		{HeaderAcceptLanguage, []string{"en ", " de\t", ""}, []string{"de", "en"}, PreferredLanguages},
+		{HeaderAcceptCharset, []string{" iso-8859-1", "UTF-8 "}, []string{"UTF-8", "iso-8859-1"}, PreferredCharsets},
+		{HeaderAcceptEncoding, []string{"\tidentity", " gzip "}, []string{"gzip", "identity"}, PreferredEncodings},
+	}
+
+	for _, test := range tests {
+		n := New(header)
+		accept := header.Get(test.header)
+		results := map[string][]string{
+			"Preferred":  test.preferred(accept, test.padded...),
+			"Weighted":   weightedValues(n.Weighted(test.header, test.padded...)),
+			"Negotiator": n.Preferred(test.header, test.padded...),
+		}
+		for name, got := range results {
+			if !reflect.DeepEqual(got, test.expected) {
+				t.Errorf("%s(%s, %q) = %q, expected %q", name, test.header, test.padded, got, test.expected)
+			}
+		}
+	}
+
+	n := New(header)
+	offers := Offers{
+		MediaTypes: tests[0].padded,
+		Languages:  tests[1].padded,
+		Charsets:   tests[2].padded,
+		Encodings:  tests[3].padded,
+	}
+	res := n.Negotiate(offers)
+	got := []string{res.MediaType, res.Language, res.Charset, res.Encoding}
+	single := []string{n.MediaType(offers.MediaTypes...), n.Language(offers.Languages...), n.Charset(offers.Charsets...), n.Encoding(offers.Encodings...)}
+	for i, test := range tests {
+		if got[i] != test.expected[0] || single[i] != test.expected[0] {
+			t.Errorf("Negotiate and the single value methods got %q and %q for %s, expected %q", got[i], single[i], test.header, test.expected[0])
+		}
+	}
+
+	vs := CompileVariants(map[Variant][]byte{{" text/html", "de ", "", ""}: []byte("hallo")})
+	if v, ok := vs.Choose(n); !ok || v != (Variant{"text/html", "de", "", ""}) {
+		t.Errorf("Choose of a padded variant = %v, %v, expected the trimmed variant", v, ok)
+	}
+}
+
+func TestPaddedOffersAllBlank(t *testing.T) {
+	n := New(http.Header{HeaderAccept: {"text/html"}})
+	if got := n.Preferred(HeaderAccept, " ", ""); len(got) != 0 {
+		t.Errorf("Preferred of blank offers = %q, expected none", got)
+	}
+	if got := PreferredMediaTypes("text/html", " "); len(got) != 0 {
+		t.Errorf("PreferredMediaTypes of blank offers = %q, expected none", got)
+	}
+}
+
+func weightedValues(weighted []Weighted) []string {
+	values := make([]string, len(weighted), len(weighted))
+	for i, w := range weighted {
+		values[i] = w.Value
+	}
+	return values
+}
+
+func TestMaxAcceptRanges(t *testing.T) {
+	members := make([]string, 100000)
+	for i := range members {
+		members[i] = "x/y" + strconv.Itoa(i) + ";q=0.5"
+	}
+	huge := strings.Join(members, ", ")
+	kept := strings.Join(members[:getMaxAcceptRanges()], ", ")
+
+	if got := len(ParseAccept(huge)); got != getMaxAcceptRanges() {
+		t.Errorf(testErrorFormat, got, getMaxAcceptRanges())
+	}
+	if got := PreferredMediaTypes(huge+", text/html", "text/html"); len(got) != 0 {
+		t.Errorf(testErrorFormat, got, []string{})
+	}
+	if got := PreferredMediaTypes("text/html, "+huge, "text/html"); len(got) != 1 {
+		t.Errorf(testErrorFormat, got, []string{"text/html"})
+	}
+	hugeAllocs := testing.AllocsPerRun(10, func() { parseAcceptMediaType(huge) })
+	keptAllocs := testing.AllocsPerRun(10, func() { parseAcceptMediaType(kept) })
+	if hugeAllocs > keptAllocs {
+		t.Errorf("parsing 100000 media ranges allocates %v times, expect at most %v", hugeAllocs, keptAllocs)
+	}
+
+	languages := strings.Repeat("x-y, ", 100000) + "en"
+	if got := len(parseAcceptLanguage(languages)); got != getMaxAcceptRanges() {
+		t.Errorf(testErrorFormat, got, getMaxAcceptRanges())
+	}
+	if got := len(parseAcceptCharset(languages)); got != getMaxAcceptRanges() {
+		t.Errorf(testErrorFormat, got, getMaxAcceptRanges())
+	}
+	if got := PreferredEncodings(strings.Repeat("x, ", 100000)+"gzip", "gzip"); len(got) != 0 {
+		t.Errorf(testErrorFormat, got, []string{})
+	}
+
+	defer SetMaxAcceptRanges(getMaxAcceptRanges())
+	SetMaxAcceptRanges(0)
+	if got := len(ParseAccept(huge)); got != len(members) {
+		t.Errorf(testErrorFormat, got, len(members))
+	}
+
+	// the headers parsed with the previous limit aren't served from the caches
+	accept := "text/plain, application/json, text/html"
+	if got := PreferredMediaTypes(accept, "text/html"); !reflect.DeepEqual(got, []string{"text/html"}) {
+		t.Errorf(testErrorFormat, got, []string{"text/html"})
+	}
+	if got := New(http.Header{HeaderAcceptLanguage: {"fr, de, en"}}).Language("en"); got != "en" {
+		t.Errorf(testErrorFormat, got, "en")
+	}
+	SetMaxAcceptRanges(2)
+	if got := PreferredMediaTypes(accept, "text/html"); !reflect.DeepEqual(got, []string{}) {
+		t.Errorf(testErrorFormat, got, []string{})
+	}
+	if got := New(http.Header{HeaderAcceptLanguage: {"fr, de, en"}}).Language("en"); got != "" {
+		t.Errorf(testErrorFormat, got, "")
+	}
+}
@@ -0,0 +1,254 @@
+// Copyright 2020 Guoyao Wu. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package negotiator
+
+import "strings"
+
+// Option configures the optional behaviors of the negotiation helpers.
+type Option func(*options)
+
+// FallbackPolicy decides what a helper does when no offer is acceptable.
+type FallbackPolicy int
+
+const (
+	// FallbackFirst serves the first offer in order of server preference.
+	FallbackFirst FallbackPolicy = iota
+	// FallbackNotAcceptable responds with 406 Not Acceptable.
+	FallbackNotAcceptable
+)
+
+type options struct {
+	defaultVariant *Variant
+	fallback       FallbackPolicy
+	headerFirst    bool
+	budget         int
+	serverOrder    bool
+	lowercase      bool
+	canonical      bool
+	minQuality     float64
+
+	defaultLanguage  string
+	defaultOnRefusal bool
+	prefixDirection  PrefixDirection
+	extended         bool
+
+	caseSensitiveParameters map[string]bool
+	suffixFallback          bool
+	wildcardDefaults        map[string]string
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// WithDefaultVariant sets the variant served when no variant is acceptable,
+// the helpers respond with 406 Not Acceptable if it's not set.
+func WithDefaultVariant(v Variant) Option {
+	return func(o *options) {
+		o.defaultVariant = &v
+	}
+}
+
+// WithFallback sets the policy of WriteNegotiated when no offer is acceptable,
+// the default is FallbackFirst.
+func WithFallback(p FallbackPolicy) Option {
+	return func(o *options) {
+		o.fallback = p
+	}
+}
+
+// WithHeaderPrecedence makes the Accept header take precedence over the path
+// extension in NegotiatePathExtension, the extension is then only used when
+// the header yields no acceptable media type.
+func WithHeaderPrecedence() Option {
+	return func(o *options) {
+		o.headerFirst = true
+	}
+}
+
+// WithWorkBudget limits the work of Negotiator.Negotiate per dimension to a
+// number of comparisons of an offer with a range of the header. The offers
+// which don't fit in the budget aren't scored, the result is then the best of
+// the leading offers and is flagged Truncated. The budget is unlimited if it's
+// not positive, which is the default.
+func WithWorkBudget(comparisons int) Option {
+	return func(o *options) {
+		o.budget = comparisons
+	}
+}
+
+// WithServerPreferenceOrder makes Negotiator.Negotiate break the ties of
+// quality by the order of the offers instead of the order of the header, e.g.
+// with "Accept: text/html, application/json" and the offers application/json
+// and text/html, application/json is chosen. An offer matched by a more
+// specific range doesn't win over one of equal quality offered before it.
+func WithServerPreferenceOrder() Option {
+	return func(o *options) {
+		o.serverOrder = true
+	}
+}
+
+// WithLowercaseMediaTypes makes Negotiator.Negotiate and
+// NegotiatePathExtension lowercase the type and the subtype of the media type
+// they return, see LowercaseMediaType. By default the offer is returned as is.
+func WithLowercaseMediaTypes() Option {
+	return func(o *options) {
+		o.lowercase = true
+	}
+}
+
+// WithCanonicalLanguageTags makes Negotiator.Negotiate format the language it
+// returns with the casing conventions of BCP 47, see CanonicalLanguageTag. By
+// default the offer is returned as is.
+func WithCanonicalLanguageTags() Option {
+	return func(o *options) {
+		o.canonical = true
+	}
+}
+
+// WithDefaultLanguage sets the language chosen by Negotiator.Negotiate when no
+// offered language is acceptable, including when WithMinimumQuality refuses
+// them, see Negotiator.LanguageOrDefault. It's matched with MatchNone. A
+// header refusing it with q=0 still yields no language, unless
+// WithDefaultLanguageOnRefusal is set too.
+func WithDefaultLanguage(language string) Option {
+	return func(o *options) {
+		o.defaultLanguage = language
+	}
+}
+
+// WithDefaultLanguageOnRefusal makes the language of WithDefaultLanguage
+// chosen even if the Accept-Language header refuses it with q=0, e.g. when
+// the content exists in no other language.
+func WithDefaultLanguageOnRefusal() Option {
+	return func(o *options) {
+		o.defaultOnRefusal = true
+	}
+}
+
+// WithPrefixDirection sets which of the prefix matches between a language
+// range and an offered language are allowed, PrefixBoth by default, see
+// PrefixDirection. It applies to the language methods of a Negotiator created
+// with it, e.g. Language and Languages, and to Negotiate.
+func WithPrefixDirection(d PrefixDirection) Option {
+	return func(o *options) {
+		o.prefixDirection = d
+	}
+}
+
+// WithExtendedFiltering enables the extended filtering of RFC 4647 section
+// 3.3.2, which is disabled by default. With the extended filtering, a range may
+// have wildcards in place of subtags, and its subtags other than the first one
+// may be separated by other subtags in the language, e.g. de-*-DE matches
+// de-DE, de-Latn-DE and de-Latn-DE-1996 but not de-x-DE, and de-DE matches
+// de-Latn-DE too. Such a match is as specific as a prefix. A language which is
+// a prefix of the range, e.g. de, still matches it unless the direction is
+// PrefixAcceptOnly, see WithPrefixDirection. Otherwise, the basic filtering
+// only matches the languages a range is a prefix of. It applies like
+// WithPrefixDirection.
+func WithExtendedFiltering() Option {
+	return func(o *options) {
+		o.extended = true
+	}
+}
+
+// WithCaseSensitiveParameters sets the names of the media type parameters
+// whose values are compared case-sensitively when matching a range with a
+// media type, e.g. profile or version. The values of the other parameters are
+// compared case-insensitively, which is the default for every parameter. It
+// applies to the media type methods of a Negotiator created with it, e.g.
+// MediaType and MediaTypes, and to Negotiate.
+func WithCaseSensitiveParameters(names ...string) Option {
+	return func(o *options) {
+		o.caseSensitiveParameters = make(map[string]bool, len(names))
+		for _, name := range names {
+			o.caseSensitiveParameters[strings.ToLower(strings.TrimSpace(name))] = true
+		}
+	}
+}
+
+// WithSuffixFallback enables the suffix fallback, which is disabled by
+// default. With the fallback, a media type with a structured syntax suffix,
+// e.g. application/vnd.api+json, satisfies the range of the same type named by
+// its suffix, e.g. application/json, as it's JSON on the wire. Such a match is
+// as specific as a match of application/*+json, so it ranks below the exact
+// matches. It applies like WithCaseSensitiveParameters.
+func WithSuffixFallback() Option {
+	return func(o *options) {
+		o.suffixFallback = true
+	}
+}
+
+// WithMinimumQuality makes the negotiation refuse the offers whose quality is
+// below q, e.g. with WithMinimumQuality(0.1) and "Accept-Language: fr;q=0.05,
+// en;q=0.1", en is chosen over fr, and fr alone has no match. Passed to New,
+// it applies to the methods of the Negotiator ranking offers too, e.g.
+// MediaType, Languages or Weighted. The ranges listed without offers aren't
+// dropped. A forced result isn't refused.
+func WithMinimumQuality(q float64) Option {
+	return func(o *options) {
+		o.minQuality = q
+	}
+}
+
+// WithWildcardDefaults sets the concrete media types replacing the wildcard
+// media types, e.g. text/* or */*, offered to Negotiator.Negotiate and
+// NegotiatePathExtension when they win, so that the result can be used as a
+// Content-Type. The keys are compared ignoring case and parameters, a winning
+// wildcard without a default is returned as is.
+func WithWildcardDefaults(defaults map[string]string) Option {
+	return func(o *options) {
+		o.wildcardDefaults = make(map[string]string, len(defaults))
+		for k, v := range defaults {
+			o.wildcardDefaults[normalizeMediaTypeKey(k)] = v
+		}
+	}
+}
+
+// Get the media type returned for a winning media type: the default of a
+// wildcard, lowercased with WithLowercaseMediaTypes.
+func (o *options) resultMediaType(mediaType string) string {
+	if mediaType = o.resolveWildcard(mediaType); o.lowercase {
+		return LowercaseMediaType(mediaType)
+	}
+	return mediaType
+}
+
+// Get how the language ranges match the offered languages.
+func (o *options) languageFilter() languageFilter {
+	return languageFilter{o.prefixDirection, o.extended}
+}
+
+// Get how the ranges match the offered media types.
+func (o *options) mediaTypeFilter() mediaTypeFilter {
+	return mediaTypeFilter{o.caseSensitiveParameters, o.suffixFallback}
+}
+
+// Get the language returned for a winning language, canonical-cased with
+// WithCanonicalLanguageTags.
+func (o *options) resultLanguage(language string) string {
+	if o.canonical {
+		return CanonicalLanguageTag(language)
+	}
+	return language
+}
+
+// Get the default of a wildcard media type, or the media type itself.
+func (o *options) resolveWildcard(mediaType string) string {
+	if len(o.wildcardDefaults) == 0 {
+		return mediaType
+	}
+	if p := cachedMediaTypeOffer(mediaType); p == nil || p.mainType != "*" && p.subtype != "*" {
+		return mediaType
+	}
+	if v, ok := o.wildcardDefaults[normalizeMediaTypeKey(mediaType)]; ok {
+		return v
+	}
+	return mediaType
+}
@@ -0,0 +1,59 @@
+// Copyright 2020 Guoyao Wu. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package negotiator
+
+import (
+	"net/http"
+	"path"
+)
+
+// NegotiatePathExtension negotiates the media type of a request whose path may
+// carry a format extension such as `/resource.json`. The extension is resolved
+// through the package's registry, extensions which aren't registered (e.g. the
+// `.2` of `/v1.2`) are not treated as format hints.
+//
+// By default the extension takes precedence over the Accept header: the media
+// type of a registered extension is matched against the available ones, and
+// an empty media type is returned if it isn't available. WithHeaderPrecedence
+// reverses the precedence. A winning wildcard media type is replaced with its
+// default, see WithWildcardDefaults. The path is returned with the resolved
+// extension stripped, so that routers can match the bare resource.
+func NegotiatePathExtension(r *http.Request, available []string, opts ...Option) (mediaType, strippedPath string) {
+	o := newOptions(opts)
+	strippedPath = r.URL.Path
+
+	extMediaType, hasExt := "", false
+	if ext := path.Ext(strippedPath); ext != "" {
+		if t := TypeByExtension(ext); t != "" {
+			hasExt = true
+			strippedPath = strippedPath[:len(strippedPath)-len(ext)]
+			extMediaType = getMostPreferred(PreferredMediaTypes(t, available...))
+		}
+	}
+
+	if hasExt && !o.headerFirst {
+		return o.resultMediaType(extMediaType), strippedPath
+	}
+
+	if mediaType = negotiatorFor(r).MediaType(available...); mediaType == "" {
+		mediaType = extMediaType
+	}
+	return o.resultMediaType(mediaType), strippedPath
+}
+
+// NegotiateContentType gets the most preferred of the offers for the Accept
+// header of a request, a missing header being */*, or defaultOffer if none is
+// acceptable. It's a drop-in for the helpers of the same signature, and
+// negotiates like Negotiator.MediaType, with the Negotiator carried by r if
+// any, see WithNegotiator.
+func NegotiateContentType(r *http.Request, offers []string, defaultOffer string) string {
+	if len(offers) == 0 {
+		return defaultOffer
+	}
+	if mediaType := negotiatorFor(r).MediaType(offers...); mediaType != "" {
+		return mediaType
+	}
+	return defaultOffer
+}
@@ -0,0 +1,97 @@
+// Copyright 2020 Guoyao Wu. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package negotiator
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNegotiatePathExtension(t *testing.T) {
+	available := []string{"application/json", "application/xml", "text/html"}
+	tests := []struct {
+		target    string
+		accept    string
+		available []string
+		opts      []Option
+		mediaType string
+		path      string
+	}{
+		{"/users.json", "text/html", available, nil, "application/json", "/users"},
+		{"/users.XML", "*/*", available, nil, "application/xml", "/users"},
+		{"/users", "text/html", available, nil, "text/html", "/users"},
+		{"/users", "", available, nil, "application/json", "/users"},
+		{"/v1.2/users", "application/xml", available, nil, "application/xml", "/v1.2/users"},
+		{"/users/v1.2", "application/xml", available, nil, "application/xml", "/users/v1.2"},
+		{"/users.png", "application/json", available, nil, "", "/users"},
+		{"/users.json", "text/html", []string{"text/html"}, nil, "", "/users"},
+		{"/users.json", "", nil, nil, "application/json", "/users"},
+		{"/users.json", "text/html", available, []Option{WithHeaderPrecedence()}, "text/html", "/users"},
+		{"/users.json", "image/png", available, []Option{WithHeaderPrecedence()}, "application/json", "/users"},
+		{"/users.png", "image/gif", available, []Option{WithHeaderPrecedence()}, "", "/users"},
+		{"/users", "text/*", []string{"application/json", "text/*"}, []Option{WithWildcardDefaults(map[string]string{"text/*": "text/plain"})}, "text/plain", "/users"},
+		{"/users.png", "text/*", []string{"application/json", "text/*"}, []Option{WithHeaderPrecedence(), WithWildcardDefaults(map[string]string{"TEXT/*": "text/plain"})}, "text/plain", "/users"},
+		{"/users", "text/*", []string{"application/json", "text/*"}, nil, "text/*", "/users"},
+		{"/users.json", "", []string{"Application/JSON", "text/html"}, []Option{WithLowercaseMediaTypes()}, "application/json", "/users"},
+		{"/users", "text/html", []string{"Application/JSON", "Text/HTML"}, []Option{WithLowercaseMediaTypes()}, "text/html", "/users"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, tt.target, nil)
+		if tt.accept != "" {
+			r.Header.Set(HeaderAccept, tt.accept)
+		}
+		mediaType, path := NegotiatePathExtension(r, tt.available, tt.opts...)
+		if mediaType != tt.mediaType || path != tt.path {
+			t.Errorf(testErrorFormat, []string{mediaType, path}, []string{tt.mediaType, tt.path})
+		}
+	}
+}
+
+func TestNegotiateContentType(t *testing.T) {
+	for _, tt := range preferredMediaTypeTestObjs {
+		if len(tt.provided) == 0 {
+			continue
+		}
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set(HeaderAccept, tt.accept)
+		expected := New(r.Header).MediaType(tt.provided...)
+		if expected == "" {
+			expected = "default/type"
+		}
+		if got := NegotiateContentType(r, tt.provided, "default/type"); got != expected {
+			t.Errorf("%q: "+testErrorFormat, tt.accept, got, expected)
+		}
+	}
+
+	tests := []struct {
+		accept       []string
+		offers       []string
+		defaultOffer string
+		expected     string
+	}{
+		{nil, []string{"application/json", "text/html"}, "text/plain", "application/json"},
+		{[]string{"text/html;q=0.5, application/json"}, []string{"text/html", "application/json"}, "text/plain", "application/json"},
+		{[]string{"image/png"}, []string{"text/html"}, "text/plain", "text/plain"},
+		{[]string{"text/html"}, nil, "text/plain", "text/plain"},
+		{[]string{"text/html"}, []string{" "}, "", ""},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		for _, v := range tt.accept {
+			r.Header.Add(HeaderAccept, v)
+		}
+		if got := NegotiateContentType(r, tt.offers, tt.defaultOffer); got != tt.expected {
+			t.Errorf("%q: "+testErrorFormat, tt.accept, got, tt.expected)
+		}
+	}
+
+	n := New(http.Header{})
+	ForceResult(n, Result{MediaType: "text/html"})
+	r := WithNegotiator(httptest.NewRequest(http.MethodGet, "/", nil), n)
+	if got := NegotiateContentType(r, []string{"application/json"}, "text/plain"); got != "text/html" {
+		t.Errorf(testErrorFormat, got, "text/html")
+	}
+}
@@ -0,0 +1,38 @@
+// Copyright 2020 Guoyao Wu. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package negotiator
+
+// ProvidedMediaTypes is a compiled list of provided media types, they're
+// parsed once so that negotiating them per request only parses the Accept
+// header, which is cached too. It's safe for concurrent use.
+type ProvidedMediaTypes struct {
+	values []string
+	offers []*acceptMediaType
+	keys   []mediaTypeTieKey
+	ranges bool
+}
+
+// CompileMediaTypes compiles the provided media types into a
+// ProvidedMediaTypes, the media types are trimmed of OWS and the empty ones are
+// dropped.
+func CompileMediaTypes(mediaTypes ...string) *ProvidedMediaTypes {
+	values := normalizeOffers(append([]string(nil), mediaTypes...))
+	offers := make([]*acceptMediaType, len(values), len(values))
+	for i, v := range values {
+		offers[i] = parseMediaType(v, 0)
+	}
+	return &ProvidedMediaTypes{values, offers, getMediaTypeTieKeys(offers), len(mediaTypes) == 0}
+}
+
+// Negotiate gets the preferred media types from an Accept header, it's the same
+// as PreferredMediaTypes(accept, mediaTypes...) with the compiled media types.
+func (p *ProvidedMediaTypes) Negotiate(accept string) []string {
+	acs := cachedAcceptMediaType(accept)
+	if p.ranges {
+		return sortAcceptMediaTypes(acs).toMediaTypes()
+	}
+	priorities := getParsedMediaTypeSpecificities(p.offers, acs, mediaTypeFilter{})
+	return priorities.sortedBy(mediaTypeSpecsBy(p.keys)).values(p.values)
+}
@@ -0,0 +1,67 @@
+// Copyright 2020 Guoyao Wu. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package negotiator
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestProvidedMediaTypes_Negotiate(t *testing.T) {
+	for _, tt := range preferredMediaTypeTestObjs {
+		p := CompileMediaTypes(tt.provided...)
+		for n := 0; n < 2; n++ {
+			if got := p.Negotiate(tt.accept); !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("%q %q: "+testErrorFormat, tt.accept, tt.provided, got, tt.expected)
+			}
+		}
+	}
+
+	tests := []struct {
+		accept   string
+		provided []string
+		expected []string
+	}{
+		{"text/html, application/json;q=0.5", []string{" application/json ", "", "text/html"}, []string{"text/html", "application/json"}},
+		{"text/html", []string{" ", ""}, []string{}},
+		{"text/html", []string{"bogus", "text/html"}, []string{"text/html"}},
+		{"text/html", []string{"text/html;level=1", "text/html"}, []string{"text/html", "text/html;level=1"}},
+	}
+	for _, tt := range tests {
+		if got := CompileMediaTypes(tt.provided...).Negotiate(tt.accept); !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf(testErrorFormat, got, tt.expected)
+		}
+		if got := PreferredMediaTypes(tt.accept, tt.provided...); !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf(testErrorFormat, got, tt.expected)
+		}
+	}
+}
+
+func TestCompileMediaTypes_CopiesProvided(t *testing.T) {
+	provided := []string{"text/html", "application/json"}
+	p := CompileMediaTypes(provided...)
+	provided[0] = "image/png"
+	if got, expected := p.Negotiate("text/html"), []string{"text/html"}; !reflect.DeepEqual(got, expected) {
+		t.Errorf(testErrorFormat, got, expected)
+	}
+}
+
+func BenchmarkProvidedMediaTypes(b *testing.B) {
+	accept := "text/html,application/xhtml+xml,application/xml;q=0.9,image/avif,image/webp,*/*;q=0.8"
+	provided := []string{"application/json", "text/html", "application/xml"}
+	b.Run("Compiled", func(b *testing.B) {
+		b.ReportAllocs()
+		p := CompileMediaTypes(provided...)
+		for i := 0; i < b.N; i++ {
+			p.Negotiate(accept)
+		}
+	})
+	b.Run("Preferred", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			PreferredMediaTypes(accept, provided...)
+		}
+	})
+}
@@ -0,0 +1,81 @@
+// Copyright 2020 Guoyao Wu. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package negotiator
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+// quality is a quality value in thousandths, e.g. 800 for q=0.8. Valid
+// qualities range from 0 to 1000, and as they have at most 3 decimal digits,
+// they compare exactly whatever the platform, unlike binary floating points.
+type quality int
+
+// maxQuality is the quality of a range without a q parameter.
+const maxQuality quality = 1000
+
+// Parse a q parameter, rounded to the nearest thousandth. Out of range values
+// are clamped to 0 or 1, e.g. q=2 is 1, before the conversion which would
+// overflow otherwise, e.g. for q=1e300.
+func parseQuality(s string) (quality, error) {
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0, ErrInvalidQuality
+	}
+	return quality(math.Round(math.Max(0, math.Min(f, 1)) * 1000)), nil
+}
+
+// Parse a q parameter strictly as a qvalue of RFC 9110 section 12.4.2, 0 to 1
+// with at most 3 decimal digits, excess digits are truncated. Out of range
+// values, e.g. q=2 or q=-1, and other notations, e.g. q=1e-3, are invalid.
+func parseStrictQuality(s string) (quality, error) {
+	if s == "" || s[0] != '0' && s[0] != '1' || len(s) > 1 && s[1] != '.' {
+		return 0, ErrInvalidQuality
+	}
+	q := quality(s[0]-'0') * maxQuality
+	for i, scale := 2, quality(100); i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return 0, ErrInvalidQuality
+		}
+		q += quality(s[i]-'0') * scale
+		scale /= 10
+	}
+	if q > maxQuality {
+		return 0, ErrInvalidQuality
+	}
+	return q, nil
+}
+
+// Get the quality of the q parameter among the parameters of a range, e.g.
+// "level=1;q=0.5", with parse, or maxQuality without a q parameter. Only the
+// first q parameter counts.
+func parseQualityParameter(params string, parse func(string) (quality, error)) (quality, error) {
+	if params == "" {
+		return maxQuality, nil
+	}
+	for _, param := range splitParameters(params) {
+		p := splitKeyValuePair(strings.Trim(param, " "))
+		if strings.EqualFold(p[0], "q") {
+			return parse(p[1])
+		}
+	}
+	return maxQuality, nil
+}
+
+// Write the q parameter of a formatted range, e.g. ";q=0.5", nothing when the
+// quality is 1. The quality has the fewest digits which parse back to it.
+func writeQualityParameter(b *strings.Builder, q float64) {
+	if q != 1 {
+		b.WriteString(";q=")
+		b.WriteString(strconv.FormatFloat(q, 'f', -1, 64))
+	}
+}
+
+// Get the quality as exposed by the API, e.g. 0.8 for 800.
+func (q quality) float() float64 {
+	return float64(q) / 1000
+}
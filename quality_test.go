@@ -0,0 +1,141 @@
+// Copyright 2020 Guoyao Wu. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package negotiator
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseQuality(t *testing.T) {
+	tests := []struct {
+		s        string
+		expected quality
+		err      error
+	}{
+		{"1", 1000, nil},
+		{"0", 0, nil},
+		{".8", 800, nil},
+		{"0.3", 300, nil},
+		{"0.30", 300, nil},
+		{"0.29", 290, nil},
+		{"0.57", 570, nil},
+		{"0.7", 700, nil},
+		{"0.001", 1, nil},
+		{"0.0004", 0, nil},
+		{"2", 1000, nil},
+		{"1e300", 1000, nil},
+		{"1.0000001", 1000, nil},
+		{"-5", 0, nil},
+		{"-1e300", 0, nil},
+		{"x", 0, ErrInvalidQuality},
+		{"NaN", 0, ErrInvalidQuality},
+		{"Inf", 0, ErrInvalidQuality},
+	}
+	for _, tt := range tests {
+		got, err := parseQuality(tt.s)
+		if got != tt.expected || err != tt.err {
+			t.Errorf(testErrorFormat, got, tt.expected)
+		}
+	}
+}
+
+func TestParseStrictQuality(t *testing.T) {
+	tests := []struct {
+		s        string
+		expected quality
+		err      error
+	}{
+		{"1", 1000, nil},
+		{"1.", 1000, nil},
+		{"1.000", 1000, nil},
+		{"1.0000", 1000, nil},
+		{"0", 0, nil},
+		{"0.8", 800, nil},
+		{"0.87654", 876, nil},
+		{"0.0009", 0, nil},
+		{"0.001", 1, nil},
+		{"1.001", 0, ErrInvalidQuality},
+		{"2", 0, ErrInvalidQuality},
+		{"5", 0, ErrInvalidQuality},
+		{"9999", 0, ErrInvalidQuality},
+		{"-1", 0, ErrInvalidQuality},
+		{".8", 0, ErrInvalidQuality},
+		{"1e-3", 0, ErrInvalidQuality},
+		{"0.8x", 0, ErrInvalidQuality},
+		{"", 0, ErrInvalidQuality},
+	}
+	for _, tt := range tests {
+		got, err := parseStrictQuality(tt.s)
+		if got != tt.expected || err != tt.err {
+			t.Errorf(testErrorFormat, got, tt.expected)
+		}
+	}
+}
+
+func TestParseQualityParameter(t *testing.T) {
+	tests := []struct {
+		params   string
+		parse    func(string) (quality, error)
+		expected quality
+		err      error
+	}{
+		{"", parseQuality, 1000, nil},
+		{"q=0.5", parseQuality, 500, nil},
+		{" Q = 0.5 ", parseQuality, 500, nil},
+		{"level=1;q=0.2;q=0.9", parseQuality, 200, nil},
+		{"level=1", parseQuality, 1000, nil},
+		{"q", parseQuality, 0, ErrInvalidQuality},
+		{"q=.5", parseQuality, 500, nil},
+		{"q=.5", parseStrictQuality, 0, ErrInvalidQuality},
+	}
+	for _, tt := range tests {
+		got, err := parseQualityParameter(tt.params, tt.parse)
+		if got != tt.expected || err != tt.err {
+			t.Errorf("%q: "+testErrorFormat, tt.params, got, tt.expected)
+		}
+	}
+}
+
+func TestQualityFloat(t *testing.T) {
+	tests := []struct {
+		q        quality
+		expected float64
+	}{
+		{1000, 1},
+		{0, 0},
+		{300, .3},
+		{100 + 200, .3},
+		{570, .57},
+		{1, .001},
+	}
+	for _, tt := range tests {
+		if got := tt.q.float(); got != tt.expected {
+			t.Errorf(testErrorFormat, got, tt.expected)
+		}
+	}
+}
+
+func TestPreferred_ExactQuality(t *testing.T) {
+	tests := []struct {
+		preferred func(accept string, provided ...string) []string
+		accept    string
+		provided  []string
+		expected  []string
+	}{
+		{PreferredCharsets, "utf-7;q=0.30, utf-8;q=0.3", []string{"utf-8", "utf-7"}, []string{"utf-7", "utf-8"}},
+		{PreferredEncodings, "br;q=0.300, gzip;q=.3", []string{"gzip", "br"}, []string{"br", "gzip"}},
+		{PreferredLanguages, "fr;q=0.57, en;q=0.570", []string{"en", "fr"}, []string{"fr", "en"}},
+		{PreferredMediaTypes, "text/plain;q=0.29, text/html;q=.290", []string{"text/html", "text/plain"}, []string{"text/plain", "text/html"}},
+		{PreferredLanguages, "fr;q=1e300, en;q=1.0000001", []string{"en", "fr"}, []string{"fr", "en"}},
+		{PreferredCharsets, "utf-8;q=-5, *;q=0.1", []string{"utf-8", "utf-7"}, []string{"utf-7"}},
+		{PreferredEncodings, "br;q=1e300, gzip", []string{"gzip", "br"}, []string{"br", "gzip"}},
+	}
+	for _, tt := range tests {
+		if got := tt.preferred(tt.accept, tt.provided...); !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf(testErrorFormat, got, tt.expected)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+// Copyright 2020 Guoyao Wu. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package negotiator
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Source reports where a resolved value came from.
+type Source int
+
+const (
+	// SourceDefault means that neither the override nor the header produced a
+	// servable value, so the default was used.
+	SourceDefault Source = iota
+	// SourceHeader means that the value was negotiated from the request header.
+	SourceHeader
+	// SourceOverride means that the explicit override was used.
+	SourceOverride
+)
+
+// String returns the name of the source.
+func (s Source) String() string {
+	switch s {
+	case SourceHeader:
+		return "header"
+	case SourceOverride:
+		return "override"
+	default:
+		return "default"
+	}
+}
+
+// ResolveOptions carries the inputs of ResolveLanguage.
+type ResolveOptions struct {
+	// Negotiator negotiates the Accept-Language header, it takes precedence
+	// over Header when both are set.
+	Negotiator *Negotiator
+	// Header is used to create a Negotiator when Negotiator is nil.
+	Header http.Header
+	// Override is an explicit language choice, e.g. from a user profile.
+	Override string
+	// Offers is the list of available languages.
+	Offers []string
+	// Default is returned when nothing else can be served.
+	Default string
+}
+
+// ResolveLanguage resolves the language to serve with the precedence
+// override > Accept-Language header > default. The override must be a single
+// well-formed language tag, e.g. not * nor a list, it's matched against the
+// offers with the same rules as a range of the header, an override which isn't
+// a tag or can't be served, including when there are no offers, falls through
+// to the header negotiation. Without an Accept-Language header, the default is
+// served, unless a result is forced, see ForceResult.
+func ResolveLanguage(opts ResolveOptions) (string, Source) {
+	if override := strings.TrimSpace(opts.Override); isWellFormedLanguageTag(override) && len(opts.Offers) > 0 {
+		if language := getMostPreferred(PreferredLanguages(override, opts.Offers...)); language != "" {
+			return language, SourceOverride
+		}
+	}
+
+	n := opts.Negotiator
+	if n == nil {
+		n = New(opts.Header)
+	}
+	if getHeaderValues(n.Header, HeaderAcceptLanguage) == nil && (n.forced == nil || n.forced.Language == "") {
+		return opts.Default, SourceDefault
+	}
+	if language := n.Language(opts.Offers...); language != "" {
+		return language, SourceHeader
+	}
+
+	return opts.Default, SourceDefault
+}
@@ -0,0 +1,67 @@
+// Copyright 2020 Guoyao Wu. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package negotiator
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestResolveLanguage(t *testing.T) {
+	header := http.Header{HeaderAcceptLanguage: {"fr, en;q=0.8"}}
+	offers := []string{"en", "de", "fr-FR"}
+	tests := []struct {
+		opts     ResolveOptions
+		expected string
+		source   Source
+	}{
+		{ResolveOptions{Header: header, Override: "de", Offers: offers, Default: "en"}, "de", SourceOverride},
+		{ResolveOptions{Negotiator: New(header), Override: "DE", Offers: offers, Default: "en"}, "de", SourceOverride},
+		{ResolveOptions{Header: header, Override: "fr", Offers: offers, Default: "en"}, "fr-FR", SourceOverride},
+		{ResolveOptions{Header: header, Override: "ja", Offers: offers, Default: "en"}, "fr-FR", SourceHeader},
+		{ResolveOptions{Header: header, Offers: offers, Default: "en"}, "fr-FR", SourceHeader},
+		{ResolveOptions{Negotiator: New(header), Header: http.Header{}, Offers: offers}, "fr-FR", SourceHeader},
+		{ResolveOptions{Header: header, Override: "ja", Offers: []string{"de"}, Default: "en"}, "en", SourceDefault},
+		{ResolveOptions{Header: http.Header{HeaderAcceptLanguage: {"ja"}}, Offers: offers, Default: "en"}, "en", SourceDefault},
+		{ResolveOptions{Header: header, Override: " de ", Offers: offers, Default: "en"}, "de", SourceOverride},
+		{ResolveOptions{Header: header, Override: "*", Offers: offers, Default: "en"}, "fr-FR", SourceHeader},
+		{ResolveOptions{Header: header, Override: "fr;q=0, *", Offers: offers, Default: "en"}, "fr-FR", SourceHeader},
+		{ResolveOptions{Header: header, Override: "de, en", Offers: offers, Default: "en"}, "fr-FR", SourceHeader},
+		{ResolveOptions{Header: header, Override: "de;q=0.5", Offers: offers, Default: "en"}, "fr-FR", SourceHeader},
+		{ResolveOptions{Header: header, Override: "ja"}, "fr", SourceHeader},
+		{ResolveOptions{Header: header, Override: "*"}, "fr", SourceHeader},
+		{ResolveOptions{Offers: offers, Default: "de"}, "de", SourceDefault},
+		{ResolveOptions{Header: http.Header{}, Override: "ja", Offers: offers, Default: "de"}, "de", SourceDefault},
+		{ResolveOptions{Override: "ja"}, "", SourceDefault},
+	}
+	for _, tt := range tests {
+		got, source := ResolveLanguage(tt.opts)
+		if got != tt.expected || source != tt.source {
+			t.Errorf(testErrorFormat, []interface{}{got, source}, []interface{}{tt.expected, tt.source})
+		}
+	}
+
+	n := New(http.Header{})
+	ForceResult(n, Result{Language: "de"})
+	if got, source := ResolveLanguage(ResolveOptions{Negotiator: n, Offers: offers, Default: "en"}); got != "de" || source != SourceHeader {
+		t.Errorf(testErrorFormat, []interface{}{got, source}, []interface{}{"de", SourceHeader})
+	}
+}
+
+func TestSource_String(t *testing.T) {
+	tests := []struct {
+		s        Source
+		expected string
+	}{
+		{SourceDefault, "default"},
+		{SourceHeader, "header"},
+		{SourceOverride, "override"},
+	}
+	for _, tt := range tests {
+		if got := tt.s.String(); got != tt.expected {
+			t.Errorf(testErrorFormat, got, tt.expected)
+		}
+	}
+}
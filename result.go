@@ -0,0 +1,466 @@
+// Copyright 2020 Guoyao Wu. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package negotiator
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Offers are the available values of each dimension, a dimension without
+// offers isn't negotiated.
+type Offers struct {
+	MediaTypes []string
+	Languages  []string
+	Charsets   []string
+	Encodings  []string
+}
+
+// Result is the outcome of a negotiation across all dimensions, an empty field
+// means that the dimension wasn't negotiated or nothing was acceptable.
+//
+// A Result encodes to JSON as an object and to text as a URL query, e.g.
+// `charset=utf-8&mediaType=text%2Fhtml`, so it can be recorded and replayed.
+//
+// The match kinds tell how the negotiated values matched the headers.
+//
+// When both media types and charsets are negotiated, the charset parameter of
+// the Accept range the media type matched takes precedence over the
+// Accept-Charset header, which takes precedence over the order of the offered
+// charsets. CharsetFallback records why the charset of the range wasn't used.
+type Result struct {
+	MediaType string `json:"mediaType,omitempty"`
+	Language  string `json:"language,omitempty"`
+	Charset   string `json:"charset,omitempty"`
+	Encoding  string `json:"encoding,omitempty"`
+
+	MediaTypeMatch MatchKind `json:"mediaTypeMatch,omitempty"`
+	LanguageMatch  MatchKind `json:"languageMatch,omitempty"`
+	CharsetMatch   MatchKind `json:"charsetMatch,omitempty"`
+	EncodingMatch  MatchKind `json:"encodingMatch,omitempty"`
+
+	CharsetFallback string `json:"charsetFallback,omitempty"`
+
+	// Truncated reports whether some offers weren't scored because of the work
+	// budget, the values are then the best of the offers which were scored.
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// The JSON form of Result, without the text marshaling methods.
+type jsonResult Result
+
+// MarshalJSON encodes the result as a JSON object.
+func (res Result) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonResult(res))
+}
+
+// UnmarshalJSON decodes the result from a JSON object.
+func (res *Result) UnmarshalJSON(data []byte) error {
+	return json.Unmarshal(data, (*jsonResult)(res))
+}
+
+// MarshalText encodes the result as a URL query with sorted keys.
+func (res Result) MarshalText() ([]byte, error) {
+	values := url.Values{}
+	for _, f := range res.fields() {
+		if *f.value != "" {
+			values.Set(f.key, *f.value)
+		}
+	}
+	for _, f := range res.kinds() {
+		if *f.kind != MatchNone {
+			values.Set(f.key, f.kind.String())
+		}
+	}
+	if res.Truncated {
+		values.Set("truncated", "true")
+	}
+	return []byte(values.Encode()), nil
+}
+
+// UnmarshalText decodes the result from a URL query, unknown keys are an
+// error.
+func (res *Result) UnmarshalText(text []byte) error {
+	values, err := url.ParseQuery(string(text))
+	if err != nil {
+		return fmt.Errorf("negotiator: invalid result %q: %v", text, err)
+	}
+	r := Result{}
+	fields, kinds := r.fields(), r.kinds()
+	for k := range values {
+		known := false
+		for _, f := range fields {
+			if f.key == k {
+				*f.value, known = values.Get(k), true
+				break
+			}
+		}
+		for _, f := range kinds {
+			if f.key == k {
+				if err := f.kind.UnmarshalText([]byte(values.Get(k))); err != nil {
+					return fmt.Errorf("negotiator: invalid result %q: %v", text, err)
+				}
+				known = true
+				break
+			}
+		}
+		if k == "truncated" {
+			if r.Truncated, err = strconv.ParseBool(values.Get(k)); err != nil {
+				return fmt.Errorf("negotiator: invalid result %q: %v", text, err)
+			}
+			known = true
+		}
+		if !known {
+			return fmt.Errorf("negotiator: invalid result %q: unknown key %q", text, k)
+		}
+	}
+	*res = r
+	return nil
+}
+
+// String returns the text form of the result.
+func (res Result) String() string {
+	text, _ := res.MarshalText()
+	return string(text)
+}
+
+// ContentType gets the Content-Type header value of the result.
+func (res Result) ContentType() string {
+	return res.variant().ContentType()
+}
+
+// Validate reports whether each negotiated dimension of the result is
+// acceptable to the request headers of n, with its matchers and options. A
+// forced result is validated against the headers as well, it returns an error
+// wrapping ErrNotAcceptable naming the first unacceptable dimension.
+func (res Result) Validate(n *Negotiator) error {
+	checks := []struct {
+		header, value string
+	}{
+		{HeaderAccept, res.MediaType},
+		{HeaderAcceptLanguage, res.Language},
+		{HeaderAcceptCharset, res.Charset},
+		{HeaderAcceptEncoding, res.Encoding},
+	}
+	for _, c := range checks {
+		if c.value == "" {
+			continue
+		}
+		if len(n.Weighted(c.header, c.value)) == 0 {
+			return fmt.Errorf("%w: %s \"%s\"", ErrNotAcceptable, c.header, SanitizeHeaderForLog(c.value))
+		}
+	}
+	return nil
+}
+
+func (res *Result) fields() []struct {
+	key   string
+	value *string
+} {
+	return []struct {
+		key   string
+		value *string
+	}{
+		{"charset", &res.Charset},
+		{"charsetFallback", &res.CharsetFallback},
+		{"encoding", &res.Encoding},
+		{"language", &res.Language},
+		{"mediaType", &res.MediaType},
+	}
+}
+
+func (res *Result) kinds() []struct {
+	key  string
+	kind *MatchKind
+} {
+	return []struct {
+		key  string
+		kind *MatchKind
+	}{
+		{"charsetMatch", &res.CharsetMatch},
+		{"encodingMatch", &res.EncodingMatch},
+		{"languageMatch", &res.LanguageMatch},
+		{"mediaTypeMatch", &res.MediaTypeMatch},
+	}
+}
+
+// Get the representation of the result.
+func (res Result) variant() Variant {
+	return Variant{res.MediaType, res.Language, res.Charset, res.Encoding}
+}
+
+// Negotiate negotiates each dimension which has offers. With a work budget,
+// see WithWorkBudget, the offers of a dimension which don't fit in the budget
+// aren't scored, and the result is flagged Truncated. A winning wildcard media
+// type is replaced with its default, see WithWildcardDefaults. With
+// WithServerPreferenceOrder, ties of quality are broken by the order of the
+// offers, and the charset parameter of the Accept range isn't considered. With
+// WithMinimumQuality, the offers below the threshold are refused, so the most
+// preferred offer reaching it wins. With WithDefaultLanguage, the default language is chosen when no
+// offered language is acceptable.
+func (n *Negotiator) Negotiate(offers Offers, opts ...Option) Result {
+	o := n.withOptions(opts)
+	c := *n
+	c.opts, n = o, &c
+	res, rangeCharset, budget := Result{}, "", o.budget
+	mediaTypes := n.scoredOffers(HeaderAccept, offers.MediaTypes, budget, &res)
+	languages := n.scoredOffers(HeaderAcceptLanguage, offers.Languages, budget, &res)
+	charsets := n.scoredOffers(HeaderAcceptCharset, offers.Charsets, budget, &res)
+	encodings := n.scoredOffers(HeaderAcceptEncoding, offers.Encodings, budget, &res)
+
+	if len(mediaTypes) > 0 {
+		if len(charsets) > 0 && !o.serverOrder && !n.forcedMediaType() && !n.overridden(HeaderAccept) {
+			accept := getAccept(n.Header, HeaderAccept, "*/*")
+			res.MediaType, res.MediaTypeMatch, rangeCharset = preferredMediaTypeCharset(accept, n.reachingOffers(HeaderAccept, mediaTypes), n.mediaTypeFilter())
+		} else {
+			res.MediaType, res.MediaTypeMatch = n.negotiateWith(o, HeaderAccept, mediaTypes, n.negotiateMediaType)
+		}
+		res.MediaType = o.resultMediaType(res.MediaType)
+	}
+	if len(languages) > 0 {
+		res.Language, res.LanguageMatch = n.negotiateWith(o, HeaderAcceptLanguage, languages, n.negotiateLanguage)
+		if res.Language == "" && o.defaultLanguage != "" && (o.defaultOnRefusal || !n.refusesLanguage(o.defaultLanguage)) {
+			res.Language = o.defaultLanguage
+		}
+		res.Language = o.resultLanguage(res.Language)
+	}
+	if len(charsets) > 0 {
+		res.Charset, res.CharsetMatch = n.negotiateWith(o, HeaderAcceptCharset, charsets, n.negotiateCharset)
+		if rangeCharset != "" && rangeCharset != "*" && (n.forced == nil || n.forced.Charset == "") {
+			if charset := findOffer(n.reachingOffers(HeaderAcceptCharset, charsets), rangeCharset); charset != "" {
+				res.Charset, res.CharsetMatch = charset, MatchExact
+			} else {
+				res.CharsetFallback = fmt.Sprintf("charset \"%s\" of the Accept range isn't offered", SanitizeHeaderForLog(rangeCharset))
+			}
+		}
+	}
+	if len(encodings) > 0 {
+		res.Encoding, res.EncodingMatch = n.negotiateWith(o, HeaderAcceptEncoding, encodings, n.negotiateEncoding)
+	}
+	return res
+}
+
+// Negotiate the most preferred offer of a header dimension with negotiate, or
+// in the order of the offers at equal quality with WithServerPreferenceOrder.
+// A forced result takes precedence.
+func (n *Negotiator) negotiateWith(o *options, header string, offers []string, negotiate func([]string) (string, MatchKind)) (string, MatchKind) {
+	if !o.serverOrder || n.forcedValue(header) != "" {
+		return negotiate(offers)
+	}
+
+	weighted := n.Weighted(header, offers...)
+	if len(weighted) == 0 || weighted[0].Quality <= 0 {
+		return "", MatchNone
+	}
+	best, index := weighted[0], indexOfOffer(offers, weighted[0].Value)
+	for _, w := range weighted[1:] {
+		if w.Quality != best.Quality {
+			break
+		}
+		if i := indexOfOffer(offers, w.Value); i < index {
+			best, index = w, i
+		}
+	}
+	return best.Value, best.Match
+}
+
+// Get the offers of a header dimension whose quality reaches the minimum
+// quality of WithMinimumQuality, in the order of the offers.
+func (n *Negotiator) reachingOffers(header string, offers []string) []string {
+	if n.minQuality() <= 0 {
+		return offers
+	}
+	weighted := n.Weighted(header, offers...)
+	results := make([]string, 0, len(weighted))
+	for _, offer := range normalizeOffers(offers) {
+		for _, w := range weighted {
+			if w.Value == offer {
+				results = append(results, offer)
+				break
+			}
+		}
+	}
+	return results
+}
+
+// Get the index of the first offer equal to value, or len(offers) if there's
+// none.
+func indexOfOffer(offers []string, value string) int {
+	for i, v := range offers {
+		if v == value {
+			return i
+		}
+	}
+	return len(offers)
+}
+
+// ContentType negotiates a media type and a charset like Negotiate, and gets
+// the Content-Type header value of the outcome, e.g. "text/html; charset=utf-8".
+// The charset parameter is omitted for media types which don't take one, e.g.
+// application/json or image/png, see takesCharset. It returns false if no media
+// type is acceptable, or if the media type takes a charset, charsets are
+// offered and none is acceptable.
+func (n *Negotiator) ContentType(mediaTypes []string, charsets []string) (string, bool) {
+	res := n.Negotiate(Offers{MediaTypes: mediaTypes, Charsets: charsets})
+	if res.MediaType == "" {
+		return "", false
+	}
+	if !takesCharset(res.MediaType) {
+		return withoutCharsetParameter(res.MediaType), true
+	}
+	if res.Charset == "" && len(normalizeOffers(charsets)) > 0 {
+		return "", false
+	}
+	return res.ContentType(), true
+}
+
+// Reports whether the media type takes a charset parameter: the text types,
+// and the XML and JavaScript types.
+func takesCharset(mediaType string) bool {
+	p := cachedMediaTypeOffer(mediaType)
+	if p == nil {
+		return false
+	}
+	mainType, subtype := strings.ToLower(p.mainType), strings.ToLower(p.subtype)
+	switch {
+	case mainType == "text":
+		return true
+	case mainType != "application":
+		return false
+	}
+	switch subtype {
+	case "xml", "javascript", "ecmascript":
+		return true
+	}
+	return strings.HasSuffix(subtype, "+xml")
+}
+
+// Get the leading offers of a dimension which can be scored within the work
+// budget, every offer is compared with every range of the header. The result
+// is flagged Truncated if some offers are left out. A forced dimension isn't
+// scored, so its offers are kept. The offers are normalized first.
+func (n *Negotiator) scoredOffers(header string, offers []string, budget int, res *Result) []string {
+	offers = normalizeOffers(offers)
+	if budget <= 0 || len(offers) == 0 || n.forcedValue(header) != "" {
+		return offers
+	}
+	ranges := n.rangeCount(header)
+	if ranges == 0 || len(offers) <= budget/ranges {
+		return offers
+	}
+	res.Truncated = true
+	return offers[:budget/ranges]
+}
+
+// Get the number of ranges of the header, or of its members if the header is
+// matched by a registered matcher.
+func (n *Negotiator) rangeCount(header string) int {
+	if n.overridden(header) {
+		accept := getAccept(n.Header, header, "")
+		if d, ok := n.matcher(header).(AcceptDefaulter); ok {
+			accept = getAccept(n.Header, header, d.DefaultAccept())
+		}
+		members, _ := splitQuoted(accept, ',')
+		return len(members)
+	}
+
+	switch header {
+	case HeaderAccept:
+		return len(cachedAcceptMediaType(getAccept(n.Header, header, "*/*")))
+	case HeaderAcceptLanguage:
+		return len(cachedAcceptLanguage(getAccept(n.Header, header, "*")))
+	case HeaderAcceptCharset:
+		return len(cachedAcceptCharset(getAccept(n.Header, header, "*")))
+	case HeaderAcceptEncoding:
+		return len(cachedAcceptEncoding(getAccept(n.Header, header, "*")))
+	}
+	return 0
+}
+
+// Get the forced value of the dimension of the header, or "" if it's not
+// forced.
+func (n *Negotiator) forcedValue(header string) string {
+	if n.forced == nil {
+		return ""
+	}
+	switch header {
+	case HeaderAccept:
+		return n.forced.MediaType
+	case HeaderAcceptLanguage:
+		return n.forced.Language
+	case HeaderAcceptCharset:
+		return n.forced.Charset
+	case HeaderAcceptEncoding:
+		return n.forced.Encoding
+	}
+	return ""
+}
+
+// Reports whether the media type of n is forced.
+func (n *Negotiator) forcedMediaType() bool {
+	return n.forced != nil && n.forced.MediaType != ""
+}
+
+// Find the offer equal to value ignoring case, or "" if it isn't offered.
+func findOffer(offers []string, value string) string {
+	for _, offer := range offers {
+		if strings.EqualFold(offer, value) {
+			return offer
+		}
+	}
+	return ""
+}
+
+// ForceResult pins the outcome of n, the non-empty dimensions of res are
+// returned by the methods of n, and by the helpers using n, instead of being
+// negotiated. A forced result bypasses the acceptability checks, call
+// Result.Validate explicitly to check it against the request headers.
+func ForceResult(n *Negotiator, res Result) {
+	n.forced = &res
+}
+
+// ApplyResult sets the Content-Type, Content-Language and Content-Encoding
+// headers of the negotiated dimensions of res, and adds the request header
+// fields they depend on to Vary.
+func ApplyResult(w http.ResponseWriter, res Result) {
+	h := w.Header()
+	fields := make([]string, 0, 4)
+	if res.MediaType != "" {
+		fields = append(fields, HeaderAccept)
+	}
+	if res.Language != "" {
+		fields = append(fields, HeaderAcceptLanguage)
+	}
+	if res.Charset != "" {
+		fields = append(fields, HeaderAcceptCharset)
+	}
+	if res.Encoding != "" {
+		fields = append(fields, HeaderAcceptEncoding)
+	}
+	addVary(h, fields...)
+	setRepresentationHeaders(h, res.variant())
+}
+
+type negotiatorKey struct{}
+
+// WithNegotiator returns a shallow copy of r carrying n, the helpers taking a
+// request, e.g. Respond, negotiate with n instead of a Negotiator of the
+// request headers. Use it to make the helpers honor a forced result.
+func WithNegotiator(r *http.Request, n *Negotiator) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), negotiatorKey{}, n))
+}
+
+// Gets the Negotiator carried by r, or a new one of the request headers.
+func negotiatorFor(r *http.Request) *Negotiator {
+	if n, ok := r.Context().Value(negotiatorKey{}).(*Negotiator); ok && n != nil {
+		return n
+	}
+	return New(r.Header)
+}
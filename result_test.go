@@ -0,0 +1,490 @@
+// Copyright 2020 Guoyao Wu. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package negotiator
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestNegotiator_Negotiate(t *testing.T) {
+	header := http.Header{
+		HeaderAccept:         {"application/json, text/html;q=0.5"},
+		HeaderAcceptLanguage: {"fr, en;q=0.8"},
+		HeaderAcceptEncoding: {"br"},
+	}
+	offers := Offers{
+		MediaTypes: []string{"text/html", "application/json"},
+		Languages:  []string{"en", "fr"},
+		Encodings:  []string{"gzip", "br"},
+	}
+	expected := Result{
+		MediaType: "application/json", Language: "fr", Encoding: "br",
+		MediaTypeMatch: MatchExact, LanguageMatch: MatchExact, EncodingMatch: MatchExact,
+	}
+	if got := New(header).Negotiate(offers); got != expected {
+		t.Errorf(testErrorFormat, got, expected)
+	}
+}
+
+func TestNegotiator_Negotiate_RangeCharset(t *testing.T) {
+	offers := Offers{
+		MediaTypes: []string{"application/json", "application/xml"},
+		Charsets:   []string{"utf-8", "ISO-8859-1"},
+	}
+	tests := []struct {
+		accept        string
+		acceptCharset string
+		expected      Result
+	}{
+		{
+			"application/xml;charset=iso-8859-1",
+			"",
+			Result{
+				MediaType: "application/xml", Charset: "ISO-8859-1",
+				MediaTypeMatch: MatchExact, CharsetMatch: MatchExact,
+			},
+		},
+		{
+			"application/xml;charset=iso-8859-1",
+			"iso-8859-1",
+			Result{
+				MediaType: "application/xml", Charset: "ISO-8859-1",
+				MediaTypeMatch: MatchExact, CharsetMatch: MatchExact,
+			},
+		},
+		{
+			"application/xml;charset=iso-8859-1, application/json;q=0.5",
+			"utf-8, iso-8859-1;q=0.1",
+			Result{
+				MediaType: "application/xml", Charset: "ISO-8859-1",
+				MediaTypeMatch: MatchExact, CharsetMatch: MatchExact,
+			},
+		},
+		{
+			"application/json;q=0.5, application/xml;charset=iso-8859-1",
+			"utf-8",
+			Result{
+				MediaType: "application/xml", Charset: "ISO-8859-1",
+				MediaTypeMatch: MatchExact, CharsetMatch: MatchExact,
+			},
+		},
+		{
+			"application/xml;charset=utf-16",
+			"iso-8859-1",
+			Result{
+				MediaType: "application/xml", Charset: "ISO-8859-1",
+				MediaTypeMatch: MatchExact, CharsetMatch: MatchExact,
+				CharsetFallback: `charset "utf-16" of the Accept range isn't offered`,
+			},
+		},
+		{
+			"application/xml;charset=*",
+			"iso-8859-1",
+			Result{
+				MediaType: "application/xml", Charset: "ISO-8859-1",
+				MediaTypeMatch: MatchExact, CharsetMatch: MatchExact,
+			},
+		},
+		{
+			"application/json, application/xml;charset=iso-8859-1;q=0.5",
+			"",
+			Result{
+				MediaType: "application/json", Charset: "utf-8",
+				MediaTypeMatch: MatchExact, CharsetMatch: MatchFullWildcard,
+			},
+		},
+	}
+	for _, tt := range tests {
+		header := http.Header{HeaderAccept: {tt.accept}}
+		if tt.acceptCharset != "" {
+			header.Set(HeaderAcceptCharset, tt.acceptCharset)
+		}
+		got := New(header).Negotiate(offers)
+		if got != tt.expected {
+			t.Errorf(testErrorFormat, got, tt.expected)
+		}
+		if got.CharsetFallback == "" {
+			continue
+		}
+		var fromText Result
+		if err := fromText.UnmarshalText([]byte(got.String())); err != nil || fromText != got {
+			t.Errorf(testErrorFormat, fromText, got)
+		}
+	}
+}
+
+func TestResult_ContentType(t *testing.T) {
+	tests := []struct {
+		res      Result
+		expected string
+	}{
+		{Result{MediaType: "text/html"}, "text/html"},
+		{Result{MediaType: "text/html", Charset: "utf-8"}, "text/html; charset=utf-8"},
+		{Result{MediaType: "text/html;charset=iso-8859-1", Charset: "utf-8"}, "text/html; charset=utf-8"},
+		{Result{MediaType: "text/html; Charset=utf-8; level=1", Charset: "utf-8"}, "text/html; level=1; charset=utf-8"},
+		{Result{MediaType: `text/html;title="a;charset=b"`, Charset: "utf-8"}, `text/html;title="a;charset=b"; charset=utf-8`},
+	}
+	for _, tt := range tests {
+		if got := tt.res.ContentType(); got != tt.expected {
+			t.Errorf(testErrorFormat, got, tt.expected)
+		}
+	}
+}
+
+func TestNegotiator_ContentType(t *testing.T) {
+	mediaTypes := []string{"text/html", "application/json", "image/png", "application/atom+xml"}
+	charsets := []string{"utf-8", "iso-8859-1"}
+	tests := []struct {
+		header     http.Header
+		mediaTypes []string
+		charsets   []string
+		expected   string
+		ok         bool
+	}{
+		{http.Header{}, mediaTypes, charsets, "text/html; charset=utf-8", true},
+		{http.Header{HeaderAcceptCharset: {"iso-8859-1"}}, mediaTypes, charsets, "text/html; charset=iso-8859-1", true},
+		{http.Header{HeaderAccept: {"application/json"}}, mediaTypes, charsets, "application/json", true},
+		{http.Header{HeaderAccept: {"image/*"}, HeaderAcceptCharset: {"utf-16"}}, mediaTypes, charsets, "image/png", true},
+		{http.Header{HeaderAccept: {"application/atom+xml"}}, mediaTypes, charsets, "application/atom+xml; charset=utf-8", true},
+		{http.Header{HeaderAccept: {"text/html;charset=iso-8859-1"}}, mediaTypes, charsets, "text/html; charset=iso-8859-1", true},
+		{http.Header{HeaderAccept: {"application/json"}}, []string{"application/json;charset=utf-8"}, charsets, "application/json", true},
+		{http.Header{}, mediaTypes, nil, "text/html", true},
+		{http.Header{HeaderAcceptCharset: {"utf-16"}}, mediaTypes, charsets, "", false},
+		{http.Header{HeaderAccept: {"text/plain"}}, mediaTypes, charsets, "", false},
+	}
+	for _, tt := range tests {
+		got, ok := New(tt.header).ContentType(tt.mediaTypes, tt.charsets)
+		if got != tt.expected || ok != tt.ok {
+			t.Errorf(testErrorFormat, []interface{}{got, ok}, []interface{}{tt.expected, tt.ok})
+		}
+	}
+}
+
+func TestNegotiator_Negotiate_ServerPreferenceOrder(t *testing.T) {
+	header := http.Header{
+		HeaderAccept:         {"text/html, application/json, image/png;q=0.5"},
+		HeaderAcceptLanguage: {"fr, en"},
+		HeaderAcceptCharset:  {"utf-8, iso-8859-1;q=0"},
+		HeaderAcceptEncoding: {"gzip, br"},
+	}
+	offers := Offers{
+		MediaTypes: []string{"image/png", "application/json", "text/html"},
+		Languages:  []string{"en", "fr"},
+		Charsets:   []string{"iso-8859-1", "utf-8"},
+		Encodings:  []string{"br", "gzip"},
+	}
+	tests := []struct {
+		opts     []Option
+		expected Result
+	}{
+		{nil, Result{MediaType: "text/html", MediaTypeMatch: MatchExact, Language: "fr", LanguageMatch: MatchExact, Charset: "utf-8", CharsetMatch: MatchExact, Encoding: "gzip", EncodingMatch: MatchExact}},
+		{[]Option{WithServerPreferenceOrder()}, Result{MediaType: "application/json", MediaTypeMatch: MatchExact, Language: "en", LanguageMatch: MatchExact, Charset: "utf-8", CharsetMatch: MatchExact, Encoding: "br", EncodingMatch: MatchExact}},
+	}
+	for _, tt := range tests {
+		if got := New(header).Negotiate(offers, tt.opts...); !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf(testErrorFormat, got, tt.expected)
+		}
+	}
+
+	n := New(http.Header{HeaderAccept: {"text/html, */*"}})
+	if got := n.Negotiate(Offers{MediaTypes: []string{"application/json", "text/html"}}, WithServerPreferenceOrder()); got.MediaType != "application/json" || got.MediaTypeMatch != MatchFullWildcard {
+		t.Errorf(testErrorFormat, got, "application/json")
+	}
+	if got := n.Negotiate(Offers{MediaTypes: []string{"application/json", "text/html"}}); got.MediaType != "text/html" {
+		t.Errorf(testErrorFormat, got, "text/html")
+	}
+
+	n = New(http.Header{HeaderAccept: {"text/html;q=0"}})
+	if got := n.Negotiate(Offers{MediaTypes: []string{"text/html"}}, WithServerPreferenceOrder()); got.MediaType != "" {
+		t.Errorf(testErrorFormat, got.MediaType, "")
+	}
+
+	n = New(http.Header{HeaderAccept: {"text/html, application/json"}})
+	ForceResult(n, Result{MediaType: "text/html"})
+	if got := n.Negotiate(Offers{MediaTypes: []string{"application/json", "text/html"}}, WithServerPreferenceOrder()); got.MediaType != "text/html" {
+		t.Errorf(testErrorFormat, got.MediaType, "text/html")
+	}
+}
+
+func TestNegotiator_Negotiate_MinimumQuality(t *testing.T) {
+	header := http.Header{
+		HeaderAccept:         {"text/html;q=0.05, application/json;q=0.5"},
+		HeaderAcceptLanguage: {"fr;q=0.05, en;q=0.1"},
+		HeaderAcceptCharset:  {"utf-8;q=0.01"},
+		HeaderAcceptEncoding: {"gzip;q=0.09"},
+	}
+	tests := []struct {
+		offers   Offers
+		opts     []Option
+		expected Result
+	}{
+		{Offers{MediaTypes: []string{"text/html", "application/json"}}, []Option{WithMinimumQuality(0.1)}, Result{MediaType: "application/json", MediaTypeMatch: MatchExact}},
+		{Offers{MediaTypes: []string{"text/html"}}, []Option{WithMinimumQuality(0.1)}, Result{}},
+		{Offers{MediaTypes: []string{"text/html"}}, nil, Result{MediaType: "text/html", MediaTypeMatch: MatchExact}},
+		{Offers{Languages: []string{"fr", "en"}}, []Option{WithMinimumQuality(0.1)}, Result{Language: "en", LanguageMatch: MatchExact}},
+		{Offers{Languages: []string{"fr"}}, []Option{WithMinimumQuality(0.1)}, Result{}},
+		{Offers{Charsets: []string{"utf-8"}}, []Option{WithMinimumQuality(0.1)}, Result{}},
+		{Offers{Encodings: []string{"gzip"}}, []Option{WithMinimumQuality(0.1)}, Result{}},
+		{Offers{Encodings: []string{"gzip"}}, []Option{WithMinimumQuality(0.09)}, Result{Encoding: "gzip", EncodingMatch: MatchExact}},
+		{Offers{Languages: []string{"fr"}}, []Option{WithMinimumQuality(0)}, Result{Language: "fr", LanguageMatch: MatchExact}},
+		{Offers{MediaTypes: []string{"text/html", "application/json"}, Charsets: []string{"utf-8"}}, []Option{WithMinimumQuality(0.1)}, Result{MediaType: "application/json", MediaTypeMatch: MatchExact}},
+	}
+	for _, tt := range tests {
+		if got := New(header).Negotiate(tt.offers, tt.opts...); !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf(testErrorFormat, got, tt.expected)
+		}
+	}
+
+	n := New(header)
+	ForceResult(n, Result{Language: "fr"})
+	if got := n.Negotiate(Offers{Languages: []string{"fr"}}, WithMinimumQuality(0.1)); got.Language != "fr" {
+		t.Errorf(testErrorFormat, got.Language, "fr")
+	}
+}
+
+func TestNegotiator_Negotiate_LowercaseMediaTypes(t *testing.T) {
+	n := New(http.Header{HeaderAccept: {"application/json"}})
+	offers := Offers{MediaTypes: []string{"Text/HTML", "Application/JSON;Profile=X"}}
+	if got, expected := n.Negotiate(offers).MediaType, "Application/JSON;Profile=X"; got != expected {
+		t.Errorf(testErrorFormat, got, expected)
+	}
+	if got, expected := n.Negotiate(offers, WithLowercaseMediaTypes()).MediaType, "application/json;Profile=X"; got != expected {
+		t.Errorf(testErrorFormat, got, expected)
+	}
+	defaults := WithWildcardDefaults(map[string]string{"text/*": "Text/Plain"})
+	n = New(http.Header{HeaderAccept: {"text/*"}})
+	if got, expected := n.Negotiate(Offers{MediaTypes: []string{"TEXT/*"}}, defaults, WithLowercaseMediaTypes()).MediaType, "text/plain"; got != expected {
+		t.Errorf(testErrorFormat, got, expected)
+	}
+}
+
+func TestNegotiator_Negotiate_CanonicalLanguageTags(t *testing.T) {
+	tests := []struct {
+		accept    string
+		languages []string
+		opts      []Option
+		expected  string
+	}{
+		{"EN-us", []string{"fr", "en-us"}, nil, "en-us"},
+		{"EN-us", []string{"fr", "en-us"}, []Option{WithCanonicalLanguageTags()}, "en-US"},
+		{"zh-HANT", []string{"ZH-hant-tw"}, []Option{WithCanonicalLanguageTags()}, "zh-Hant-TW"},
+		{"de", []string{"fr"}, []Option{WithCanonicalLanguageTags()}, ""},
+	}
+	for _, tt := range tests {
+		n := New(http.Header{HeaderAcceptLanguage: {tt.accept}})
+		if got := n.Negotiate(Offers{Languages: tt.languages}, tt.opts...).Language; got != tt.expected {
+			t.Errorf(testErrorFormat, got, tt.expected)
+		}
+	}
+}
+
+func TestNegotiator_Negotiate_DefaultLanguage(t *testing.T) {
+	tests := []struct {
+		accept   string
+		opts     []Option
+		expected string
+		match    MatchKind
+	}{
+		{"fr", []Option{WithDefaultLanguage("en")}, "fr", MatchExact},
+		{"ja", nil, "", MatchNone},
+		{"ja", []Option{WithDefaultLanguage("en")}, "en", MatchNone},
+		{"fr;q=0.05", []Option{WithDefaultLanguage("en"), WithMinimumQuality(0.1)}, "en", MatchNone},
+		{"en-US;q=0.9, fr;q=0.2", []Option{WithDefaultLanguage("de"), WithMinimumQuality(0.5)}, "de", MatchNone},
+		{"*;q=0", []Option{WithDefaultLanguage("en")}, "", MatchNone},
+		{"*;q=0", []Option{WithDefaultLanguage("en"), WithDefaultLanguageOnRefusal()}, "en", MatchNone},
+		{"ja, en;q=0", []Option{WithDefaultLanguage("en-us"), WithCanonicalLanguageTags()}, "", MatchNone},
+		{"ja", []Option{WithDefaultLanguage("en-us"), WithCanonicalLanguageTags()}, "en-US", MatchNone},
+	}
+	for _, tt := range tests {
+		n := New(http.Header{HeaderAcceptLanguage: {tt.accept}})
+		got := n.Negotiate(Offers{Languages: []string{"fr", "de"}}, tt.opts...)
+		if got.Language != tt.expected || got.LanguageMatch != tt.match {
+			t.Errorf("%q: "+testErrorFormat, tt.accept, []interface{}{got.Language, got.LanguageMatch}, []interface{}{tt.expected, tt.match})
+		}
+	}
+	n := New(http.Header{HeaderAcceptLanguage: {"ja"}})
+	if got := n.Negotiate(Offers{}, WithDefaultLanguage("en")).Language; got != "" {
+		t.Errorf(testErrorFormat, got, "")
+	}
+}
+
+func TestNegotiator_Negotiate_WildcardDefaults(t *testing.T) {
+	defaults := WithWildcardDefaults(map[string]string{"text/*": "text/plain", "*/*": "application/octet-stream"})
+	tests := []struct {
+		accept     string
+		mediaTypes []string
+		opts       []Option
+		expected   string
+	}{
+		{"*/*, text/*", []string{"text/*"}, nil, "text/*"},
+		{"*/*, text/*", []string{"text/*"}, []Option{defaults}, "text/plain"},
+		{"*/*", []string{"*/*"}, []Option{defaults}, "application/octet-stream"},
+		{"*/*", []string{"image/*"}, []Option{defaults}, "image/*"},
+		{"text/html, */*;q=0.1", []string{"text/*;charset=utf-8", "text/html"}, []Option{defaults}, "text/html"},
+		{"text/*", []string{"text/*;charset=utf-8"}, []Option{defaults}, "text/plain"},
+		{"image/png", []string{"text/*"}, []Option{defaults}, ""},
+	}
+	for _, tt := range tests {
+		n := New(http.Header{HeaderAccept: {tt.accept}})
+		if got := n.Negotiate(Offers{MediaTypes: tt.mediaTypes}, tt.opts...).MediaType; got != tt.expected {
+			t.Errorf(testErrorFormat, got, tt.expected)
+		}
+	}
+}
+
+func TestNegotiator_Negotiate_WorkBudget(t *testing.T) {
+	accept := "*/*;q=0.1, application/*;q=0.4, text/plain;q=0.2, image/*;q=0.5, application/json;q=0.8"
+	mediaTypes := make([]string, 0, 40)
+	for i := 0; i < 10; i++ {
+		mediaTypes = append(mediaTypes, "text/x-"+strconv.Itoa(i), "image/x-"+strconv.Itoa(i), "application/x-"+strconv.Itoa(i))
+	}
+	mediaTypes = append(mediaTypes, "application/json")
+	offers := Offers{MediaTypes: mediaTypes, Languages: []string{"en", "fr"}}
+	n := New(http.Header{HeaderAccept: {accept}, HeaderAcceptLanguage: {"fr"}})
+
+	full := n.Negotiate(offers)
+	if full.MediaType != "application/json" || full.Truncated {
+		t.Fatalf(testErrorFormat, full, "application/json")
+	}
+	if got := n.Negotiate(offers, WithWorkBudget(len(mediaTypes)*5)); got != full {
+		t.Errorf(testErrorFormat, got, full)
+	}
+
+	for _, budget := range []int{4, 5, 23, 50, len(mediaTypes)*5 - 1} {
+		got := n.Negotiate(offers, WithWorkBudget(budget))
+		scored := mediaTypes[:budget/5]
+		expected := Result{Language: "fr", LanguageMatch: MatchExact, Truncated: true}
+		if w := WeightedMediaTypes(accept, scored...); len(scored) > 0 && len(w) > 0 {
+			expected.MediaType, expected.MediaTypeMatch = w[0].Value, w[0].Match
+		}
+		if got != expected {
+			t.Errorf(testErrorFormat, got, expected)
+		}
+		if again := n.Negotiate(offers, WithWorkBudget(budget)); again != got {
+			t.Errorf(testErrorFormat, again, got)
+		}
+		var fromText Result
+		if err := fromText.UnmarshalText([]byte(got.String())); err != nil || fromText != got {
+			t.Errorf(testErrorFormat, fromText, got)
+		}
+	}
+
+	ForceResult(n, Result{MediaType: "application/json"})
+	if got := n.Negotiate(offers, WithWorkBudget(1)); got.MediaType != "application/json" || !got.Truncated {
+		t.Errorf(testErrorFormat, got, "the forced media type and a truncated language")
+	}
+}
+
+func TestResult_Encoding(t *testing.T) {
+	tests := []struct {
+		res  Result
+		text string
+		json string
+	}{
+		{Result{}, "", "{}"},
+		{
+			Result{
+				MediaType: "text/html;level=1", Language: "en-US", Charset: "utf-8", Encoding: "gzip",
+				MediaTypeMatch: MatchSubtypeWildcard, EncodingMatch: MatchExact,
+			},
+			"charset=utf-8&encoding=gzip&encodingMatch=exact&language=en-US&mediaType=text%2Fhtml%3Blevel%3D1" +
+				"&mediaTypeMatch=partial",
+			`{"mediaType":"text/html;level=1","language":"en-US","charset":"utf-8","encoding":"gzip",` +
+				`"mediaTypeMatch":"partial","encodingMatch":"exact"}`,
+		},
+		{Result{Language: "fr"}, "language=fr", `{"language":"fr"}`},
+	}
+	for _, tt := range tests {
+		if got := tt.res.String(); got != tt.text {
+			t.Errorf(testErrorFormat, got, tt.text)
+		}
+		var fromText Result
+		if err := fromText.UnmarshalText([]byte(tt.text)); err != nil || fromText != tt.res {
+			t.Errorf(testErrorFormat, fromText, tt.res)
+		}
+
+		got, err := json.Marshal(tt.res)
+		if err != nil || string(got) != tt.json {
+			t.Errorf(testErrorFormat, string(got), tt.json)
+		}
+		var fromJSON Result
+		if err := json.Unmarshal(got, &fromJSON); err != nil || fromJSON != tt.res {
+			t.Errorf(testErrorFormat, fromJSON, tt.res)
+		}
+	}
+
+	var res Result
+	for _, text := range []string{"lang=en", "charset=%zz", "languageMatch=close"} {
+		if err := res.UnmarshalText([]byte(text)); err == nil {
+			t.Errorf("UnmarshalText(%q) expected an error", text)
+		}
+	}
+}
+
+func TestResult_Validate(t *testing.T) {
+	n := New(http.Header{
+		HeaderAccept:         {"text/html"},
+		HeaderAcceptLanguage: {"en"},
+	})
+	tests := []struct {
+		res Result
+		ok  bool
+	}{
+		{Result{}, true},
+		{Result{MediaType: "text/html", Language: "en-US", Charset: "utf-8"}, true},
+		{Result{MediaType: "application/json"}, false},
+		{Result{Language: "fr"}, false},
+	}
+	for _, tt := range tests {
+		err := tt.res.Validate(n)
+		if (err == nil) != tt.ok || err != nil && !errors.Is(err, ErrNotAcceptable) {
+			t.Errorf("Validate(%v) = %v", tt.res, err)
+		}
+	}
+}
+
+func TestForceResult(t *testing.T) {
+	n := New(http.Header{HeaderAccept: {"text/html"}, HeaderAcceptLanguage: {"en"}})
+	ForceResult(n, Result{MediaType: "application/json"})
+
+	if got := n.MediaTypes("text/html"); !reflect.DeepEqual(got, []string{"application/json"}) {
+		t.Errorf(testErrorFormat, got, []string{"application/json"})
+	}
+	if got := n.Language("en", "fr"); got != "en" {
+		t.Errorf(testErrorFormat, got, "en")
+	}
+	if err := (Result{MediaType: "application/json"}).Validate(n); !errors.Is(err, ErrNotAcceptable) {
+		t.Errorf(testErrorFormat, err, ErrNotAcceptable)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set(HeaderAccept, "text/html")
+	w := httptest.NewRecorder()
+	if err := Respond(w, WithNegotiator(r, n), http.StatusOK, map[string]int{"a": 1}); err != nil {
+		t.Fatal(err)
+	}
+	if got := w.Header().Get("Content-Type"); got != "application/json" {
+		t.Errorf(testErrorFormat, got, "application/json")
+	}
+}
+
+func TestApplyResult(t *testing.T) {
+	w := httptest.NewRecorder()
+	ApplyResult(w, Result{MediaType: "text/html", Language: "en", Charset: "utf-8", Encoding: "identity"})
+	expected := http.Header{
+		"Content-Type":     {"text/html; charset=utf-8"},
+		"Content-Language": {"en"},
+		HeaderVary:         {HeaderAccept, HeaderAcceptLanguage, HeaderAcceptCharset, HeaderAcceptEncoding},
+	}
+	if got := w.Header(); !reflect.DeepEqual(got, expected) {
+		t.Errorf(testErrorFormat, got, expected)
+	}
+}
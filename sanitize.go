@@ -0,0 +1,53 @@
+// Copyright 2020 Guoyao Wu. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package negotiator
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// MaxLoggedHeaderLength is the maximum length in bytes of the header content
+// kept by SanitizeHeaderForLog, it's not truncated if the length isn't
+// positive.
+var MaxLoggedHeaderLength = 256
+
+// truncationMarker ends the header content truncated by SanitizeHeaderForLog.
+const truncationMarker = "..."
+
+// SanitizeHeaderForLog makes client-controlled header content safe to embed in
+// a log line or an error message. Control characters, e.g. CR, LF and the ESC
+// of terminal escape sequences, are stripped, invalid UTF-8 is replaced with
+// U+FFFD, quotes and backslashes are escaped, and the result is truncated at a
+// rune boundary to MaxLoggedHeaderLength bytes followed by "...". The errors
+// of this package embed header content sanitized this way, between quotes.
+func SanitizeHeaderForLog(s string) string {
+	max := MaxLoggedHeaderLength
+	var b strings.Builder
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		i += size
+
+		var esc string
+		switch {
+		case r == utf8.RuneError && size == 1:
+			esc = string(utf8.RuneError)
+		case unicode.IsControl(r):
+			continue
+		case r == '"' || r == '\\':
+			esc = `\` + string(r)
+		default:
+			esc = s[i-size : i]
+		}
+
+		if max > 0 && b.Len()+len(esc) > max {
+			b.WriteString(truncationMarker)
+			break
+		}
+		b.WriteString(esc)
+	}
+	return b.String()
+}
@@ -0,0 +1,42 @@
+// Copyright 2020 Guoyao Wu. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package negotiator
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSanitizeHeaderForLog(t *testing.T) {
+	tests := []struct {
+		s        string
+		max      int
+		expected string
+	}{
+		{"", 256, ""},
+		{"text/html, application/json;q=0.9", 256, "text/html, application/json;q=0.9"},
+		{"text/html\r\nX-Injected: 1", 256, "text/htmlX-Injected: 1"},
+		{"text/html\n\n<script>", 256, "text/html<script>"},
+		{"\x1b[31mred\x1b[0m", 256, "[31mred[0m"},
+		{"text/*\x00\x7f\u0085\t", 256, "text/*"},
+		{`text/html;title="a\b"`, 256, `text/html;title=\"a\\b\"`},
+		{"en\xff", 256, "en�"},
+		{"fr-CA, zh-CN", 2, "fr..."},
+		{"text/html", 9, "text/html"},
+		{"text/html", 8, "text/htm..."},
+		{"日本語", 7, "日本..."},
+		{"日本語", 8, "日本..."},
+		{"日本語", 9, "日本語"},
+		{`a"b`, 2, "a..."},
+		{strings.Repeat("a", 1000), 0, strings.Repeat("a", 1000)},
+	}
+	defer func(max int) { MaxLoggedHeaderLength = max }(MaxLoggedHeaderLength)
+	for _, tt := range tests {
+		MaxLoggedHeaderLength = tt.max
+		if got := SanitizeHeaderForLog(tt.s); got != tt.expected {
+			t.Errorf(testErrorFormat, got, tt.expected)
+		}
+	}
+}
@@ -0,0 +1,272 @@
+// Copyright 2020 Guoyao Wu. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package negotiator
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// HeaderVary is `Vary`
+const HeaderVary = "Vary"
+
+// Variant is a representation of a resource, an empty field means that the
+// representation doesn't vary in that dimension.
+type Variant struct {
+	MediaType string
+	Language  string
+	Charset   string
+	Encoding  string
+}
+
+// ContentType gets the Content-Type header value of the variant, the charset
+// parameter is set once, replacing the one of the media type if any.
+func (v Variant) ContentType() string {
+	if v.Charset == "" {
+		return v.MediaType
+	}
+	return withoutCharsetParameter(v.MediaType) + "; charset=" + v.Charset
+}
+
+// Remove the charset parameter from a media type.
+func withoutCharsetParameter(mediaType string) string {
+	parts, _ := splitQuoted(mediaType, ';')
+	kept := parts[:1]
+	for _, part := range parts[1:] {
+		key := strings.TrimSpace(splitKeyValuePair(part)[0])
+		if !strings.EqualFold(key, "charset") {
+			kept = append(kept, part)
+		}
+	}
+	if len(kept) == len(parts) {
+		return mediaType
+	}
+	return strings.TrimRight(strings.Join(kept, ";"), " \t")
+}
+
+// VariantSet is a compiled set of pre-rendered variants, it indexes the
+// values of every dimension once so that choosing a variant per request
+// doesn't scan the whole set.
+type VariantSet struct {
+	bodies     map[Variant][]byte
+	mediaTypes []string
+	languages  []string
+	charsets   []string
+	encodings  []string
+	opts       *options
+}
+
+// CompileVariants compiles the variants into a VariantSet, the values of the
+// variants are trimmed of OWS.
+func CompileVariants(variants map[Variant][]byte, opts ...Option) *VariantSet {
+	vs := &VariantSet{bodies: make(map[Variant][]byte, len(variants)), opts: newOptions(opts)}
+	mediaTypes, languages := make(map[string]bool), make(map[string]bool)
+	charsets, encodings := make(map[string]bool), make(map[string]bool)
+	for v, body := range variants {
+		v = Variant{trimOffer(v.MediaType), trimOffer(v.Language), trimOffer(v.Charset), trimOffer(v.Encoding)}
+		vs.bodies[v] = body
+		mediaTypes[v.MediaType] = true
+		languages[v.Language] = true
+		charsets[v.Charset] = true
+		encodings[v.Encoding] = true
+	}
+	vs.mediaTypes, vs.languages = getSortedKeys(mediaTypes), getSortedKeys(languages)
+	vs.charsets, vs.encodings = getSortedKeys(charsets), getSortedKeys(encodings)
+	return vs
+}
+
+// Choose chooses the most preferred variant of the set. The dimensions are
+// negotiated independently and the acceptable combinations are ranked by media
+// type, then language, then encoding, then charset. A variant without a value
+// in a dimension is acceptable but ranked after the acceptable values.
+func (vs *VariantSet) Choose(n *Negotiator) (Variant, bool) {
+	mediaTypes := rankVariantValues(vs.mediaTypes, n.MediaTypes)
+	languages := rankVariantValues(vs.languages, n.Languages)
+	encodings := rankVariantValues(vs.encodings, n.Encodings)
+	charsets := rankVariantValues(vs.charsets, n.Charsets)
+
+	for _, mediaType := range mediaTypes {
+		for _, language := range languages {
+			for _, encoding := range encodings {
+				for _, charset := range charsets {
+					v := Variant{mediaType, language, charset, encoding}
+					if _, ok := vs.bodies[v]; ok {
+						return v, true
+					}
+				}
+			}
+		}
+	}
+
+	if d := vs.opts.defaultVariant; d != nil {
+		if _, ok := vs.bodies[*d]; ok {
+			return *d, true
+		}
+	}
+
+	return Variant{}, false
+}
+
+// Vary gets the request header fields the choice of a variant depends on,
+// the fields of the dimensions in which a variant has a value. A dimension
+// with a single value is listed too, as the header may refuse it, e.g. the
+// response to "Accept: image/png" is 406 Not Acceptable and not the only
+// variant.
+func (vs *VariantSet) Vary() []string {
+	fields := make([]string, 0, 4)
+	if hasVariantValue(vs.mediaTypes) {
+		fields = append(fields, HeaderAccept)
+	}
+	if hasVariantValue(vs.languages) {
+		fields = append(fields, HeaderAcceptLanguage)
+	}
+	if hasVariantValue(vs.charsets) {
+		fields = append(fields, HeaderAcceptCharset)
+	}
+	if hasVariantValue(vs.encodings) {
+		fields = append(fields, HeaderAcceptEncoding)
+	}
+	return fields
+}
+
+// Reports whether the sorted values of a dimension have a value other than
+// "", which sorts first.
+func hasVariantValue(values []string) bool {
+	return len(values) > 0 && values[len(values)-1] != ""
+}
+
+// Serve writes the most preferred variant of the set with its Content-Type,
+// Content-Language, Content-Encoding, Content-Length and Vary headers, or
+// responds with 406 Not Acceptable if no variant is acceptable.
+func (vs *VariantSet) Serve(w http.ResponseWriter, r *http.Request) {
+	h := w.Header()
+	addVary(h, vs.Vary()...)
+
+	v, ok := vs.Choose(negotiatorFor(r))
+	if !ok {
+		http.Error(w, http.StatusText(http.StatusNotAcceptable), http.StatusNotAcceptable)
+		return
+	}
+
+	body := vs.bodies[v]
+	setRepresentationHeaders(h, v)
+	h.Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
+
+// ServeVariantMap negotiates across the dimensions of the variants and
+// writes the most preferred one, see VariantSet.Serve. It compiles the
+// variants on every call, use CompileVariants to reuse the index.
+func ServeVariantMap(w http.ResponseWriter, r *http.Request, variants map[Variant][]byte, opts ...Option) {
+	CompileVariants(variants, opts...).Serve(w, r)
+}
+
+// WriteNegotiated negotiates the media type of the response among the keys of
+// variants and writes the chosen body with its Content-Type, Content-Length and
+// Vary headers. Since the order of a map is random, order defines the server
+// preference, keys missing from order are preferred last in lexical order.
+// When no key is acceptable the first one is written, or 406 Not Acceptable is
+// responded with ErrNotAcceptable returned under FallbackNotAcceptable. A media
+// type forced with ForceResult is written if it's a key, otherwise it's handled
+// like no key being acceptable.
+func WriteNegotiated(w http.ResponseWriter, r *http.Request, status int, variants map[string][]byte, order []string, opts ...Option) error {
+	o := newOptions(opts)
+	offers, listed := make([]string, 0, len(variants)), make(map[string]bool, len(variants))
+	for _, v := range order {
+		if _, ok := variants[v]; ok && !listed[v] {
+			listed[v] = true
+			offers = append(offers, v)
+		}
+	}
+	rest := make(map[string]bool)
+	for k := range variants {
+		if !listed[k] {
+			rest[k] = true
+		}
+	}
+	offers = append(offers, getSortedKeys(rest)...)
+
+	h := w.Header()
+	addVary(h, HeaderAccept)
+
+	i := -1
+	if len(offers) > 0 {
+		i = negotiatorFor(r).MediaTypeIndex(offers...)
+		if i == -1 && o.fallback == FallbackFirst {
+			i = 0
+		}
+	}
+	if i == -1 {
+		http.Error(w, http.StatusText(http.StatusNotAcceptable), http.StatusNotAcceptable)
+		return ErrNotAcceptable
+	}
+
+	body := variants[offers[i]]
+	h.Set("Content-Type", trimOffer(offers[i]))
+	h.Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(status)
+	_, err := w.Write(body)
+	return err
+}
+
+// Set the Content-Type, Content-Language and Content-Encoding headers of the
+// dimensions of v, the identity encoding isn't set.
+func setRepresentationHeaders(h http.Header, v Variant) {
+	if v.MediaType != "" {
+		h.Set("Content-Type", v.ContentType())
+	}
+	if v.Language != "" {
+		h.Set("Content-Language", v.Language)
+	}
+	if v.Encoding != "" && !strings.EqualFold(v.Encoding, "identity") {
+		h.Set("Content-Encoding", v.Encoding)
+	}
+}
+
+// Rank the distinct values of a dimension, the empty value is acceptable but
+// ranked last.
+func rankVariantValues(values []string, preferred func(available ...string) []string) []string {
+	if len(values) == 0 || len(values) == 1 && values[0] == "" {
+		return values
+	}
+
+	available := values
+	if values[0] == "" {
+		available = values[1:]
+	}
+	results := preferred(available...)
+	if values[0] == "" {
+		results = append(results, "")
+	}
+	return results
+}
+
+// Add fields to the Vary header unless they are listed already.
+func addVary(h http.Header, fields ...string) {
+	existing := make(map[string]bool)
+	for _, v := range h[HeaderVary] {
+		for _, field := range strings.Split(v, ",") {
+			existing[strings.ToLower(strings.TrimSpace(field))] = true
+		}
+	}
+	for _, field := range fields {
+		if !existing[strings.ToLower(field)] {
+			existing[strings.ToLower(field)] = true
+			h.Add(HeaderVary, field)
+		}
+	}
+}
+
+func getSortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
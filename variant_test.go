@@ -0,0 +1,307 @@
+// Copyright 2020 Guoyao Wu. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package negotiator
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+var testVariants = map[Variant][]byte{
+	{"application/json", "en", "", "identity"}: []byte(`{"hello":"world"}`),
+	{"application/json", "en", "", "gzip"}:     []byte("json-en-gzip"),
+	{"application/json", "de", "", "identity"}: []byte(`{"hallo":"welt"}`),
+	{"text/html", "en", "utf-8", "identity"}:   []byte("<p>hello</p>"),
+	{"text/html", "de", "utf-8", "identity"}:   []byte("<p>hallo</p>"),
+	{"text/html", "de", "utf-8", "gzip"}:       []byte("html-de-gzip"),
+}
+
+func TestVariantSet_Choose(t *testing.T) {
+	vs := CompileVariants(testVariants)
+	tests := []struct {
+		header   http.Header
+		expected Variant
+		ok       bool
+	}{
+		{
+			http.Header{},
+			Variant{"application/json", "de", "", "identity"},
+			true,
+		},
+		{
+			http.Header{HeaderAccept: {"text/html"}, HeaderAcceptLanguage: {"en"}},
+			Variant{"text/html", "en", "utf-8", "identity"},
+			true,
+		},
+		{
+			http.Header{HeaderAccept: {"text/html"}, HeaderAcceptLanguage: {"de"}, HeaderAcceptEncoding: {"gzip"}},
+			Variant{"text/html", "de", "utf-8", "gzip"},
+			true,
+		},
+		{
+			http.Header{HeaderAccept: {"application/json"}, HeaderAcceptLanguage: {"en, de;q=0.5"}, HeaderAcceptEncoding: {"gzip"}},
+			Variant{"application/json", "en", "", "gzip"},
+			true,
+		},
+		{
+			http.Header{HeaderAccept: {"application/json, text/html;q=0.5"}, HeaderAcceptLanguage: {"fr, en;q=0.1"}, HeaderAcceptEncoding: {"gzip;q=0.5, identity"}},
+			Variant{"application/json", "en", "", "identity"},
+			true,
+		},
+		{
+			http.Header{HeaderAccept: {"text/html"}, HeaderAcceptCharset: {"iso-8859-1"}},
+			Variant{},
+			false,
+		},
+		{
+			http.Header{HeaderAccept: {"image/png"}},
+			Variant{},
+			false,
+		},
+	}
+	for _, tt := range tests {
+		got, ok := vs.Choose(New(tt.header))
+		if got != tt.expected || ok != tt.ok {
+			t.Errorf(testErrorFormat, got, tt.expected)
+		}
+	}
+}
+
+func TestVariantSet_Vary(t *testing.T) {
+	tests := []struct {
+		variants map[Variant][]byte
+		expected []string
+	}{
+		{testVariants, []string{HeaderAccept, HeaderAcceptLanguage, HeaderAcceptCharset, HeaderAcceptEncoding}},
+		{map[Variant][]byte{{MediaType: "text/html"}: nil}, []string{HeaderAccept}},
+		{
+			map[Variant][]byte{{MediaType: "text/html", Language: "en"}: nil, {MediaType: "text/html", Language: "de"}: nil},
+			[]string{HeaderAccept, HeaderAcceptLanguage},
+		},
+		{
+			map[Variant][]byte{{MediaType: "text/html", Encoding: "gzip"}: nil, {MediaType: "text/html"}: nil},
+			[]string{HeaderAccept, HeaderAcceptEncoding},
+		},
+		{map[Variant][]byte{{}: nil}, []string{}},
+		{map[Variant][]byte{}, []string{}},
+	}
+	for _, tt := range tests {
+		if got := CompileVariants(tt.variants).Vary(); !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf(testErrorFormat, got, tt.expected)
+		}
+	}
+}
+
+func TestServeVariantMap(t *testing.T) {
+	tests := []struct {
+		header  http.Header
+		opts    []Option
+		status  int
+		headers map[string]string
+		body    string
+	}{
+		{
+			http.Header{HeaderAccept: {"text/html"}, HeaderAcceptLanguage: {"de"}, HeaderAcceptEncoding: {"gzip"}},
+			nil,
+			http.StatusOK,
+			map[string]string{
+				"Content-Type":     "text/html; charset=utf-8",
+				"Content-Language": "de",
+				"Content-Encoding": "gzip",
+				"Content-Length":   "12",
+			},
+			"html-de-gzip",
+		},
+		{
+			http.Header{HeaderAccept: {"application/json"}, HeaderAcceptLanguage: {"en"}, HeaderAcceptEncoding: {"br"}},
+			nil,
+			http.StatusOK,
+			map[string]string{
+				"Content-Type":     "application/json",
+				"Content-Language": "en",
+				"Content-Encoding": "",
+				"Content-Length":   "17",
+			},
+			`{"hello":"world"}`,
+		},
+		{
+			http.Header{HeaderAccept: {"image/png"}},
+			nil,
+			http.StatusNotAcceptable,
+			map[string]string{"Content-Language": ""},
+			"Not Acceptable\n",
+		},
+		{
+			http.Header{HeaderAccept: {"image/png"}},
+			[]Option{WithDefaultVariant(Variant{"text/html", "en", "utf-8", "identity"})},
+			http.StatusOK,
+			map[string]string{"Content-Type": "text/html; charset=utf-8", "Content-Language": "en"},
+			"<p>hello</p>",
+		},
+		{
+			http.Header{HeaderAccept: {"image/png"}},
+			[]Option{WithDefaultVariant(Variant{MediaType: "image/png"})},
+			http.StatusNotAcceptable,
+			map[string]string{},
+			"Not Acceptable\n",
+		},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header = tt.header
+		ServeVariantMap(w, r, testVariants, tt.opts...)
+		if w.Code != tt.status {
+			t.Errorf(testErrorFormat, w.Code, tt.status)
+		}
+		for k, v := range tt.headers {
+			if got := w.Header().Get(k); got != v {
+				t.Errorf(testErrorFormat, got, v)
+			}
+		}
+		vary := []string{HeaderAccept, HeaderAcceptLanguage, HeaderAcceptCharset, HeaderAcceptEncoding}
+		if got := w.Header()[HeaderVary]; !reflect.DeepEqual(got, vary) {
+			t.Errorf(testErrorFormat, got, vary)
+		}
+		if got := w.Body.String(); got != tt.body {
+			t.Errorf(testErrorFormat, got, tt.body)
+		}
+	}
+}
+
+func TestWriteNegotiated(t *testing.T) {
+	variants := map[string][]byte{
+		"application/json":          []byte(`{"error":"not found"}`),
+		"text/html":                 []byte("<h1>Not Found</h1>"),
+		"text/plain; charset=utf-8": []byte("Not Found"),
+	}
+	order := []string{"application/json", "text/html", "text/plain; charset=utf-8"}
+	tests := []struct {
+		accept      string
+		order       []string
+		opts        []Option
+		status      int
+		contentType string
+		body        string
+		err         error
+	}{
+		{"", order, nil, http.StatusNotFound, "application/json", `{"error":"not found"}`, nil},
+		{"text/html", order, nil, http.StatusNotFound, "text/html", "<h1>Not Found</h1>", nil},
+		{"text/*", order, nil, http.StatusNotFound, "text/html", "<h1>Not Found</h1>", nil},
+		{"text/plain", order, nil, http.StatusNotFound, "text/plain; charset=utf-8", "Not Found", nil},
+		{"*/*", []string{"text/html", "image/png"}, nil, http.StatusNotFound, "text/html", "<h1>Not Found</h1>", nil},
+		{"*/*", nil, nil, http.StatusNotFound, "application/json", `{"error":"not found"}`, nil},
+		{"image/png", order, nil, http.StatusNotFound, "application/json", `{"error":"not found"}`, nil},
+		{"image/png", []string{"text/html"}, nil, http.StatusNotFound, "text/html", "<h1>Not Found</h1>", nil},
+		{
+			"image/png",
+			order,
+			[]Option{WithFallback(FallbackNotAcceptable)},
+			http.StatusNotAcceptable,
+			"text/plain; charset=utf-8",
+			"Not Acceptable\n",
+			ErrNotAcceptable,
+		},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if tt.accept != "" {
+			r.Header.Set(HeaderAccept, tt.accept)
+		}
+		if err := WriteNegotiated(w, r, http.StatusNotFound, variants, tt.order, tt.opts...); err != tt.err {
+			t.Errorf(testErrorFormat, err, tt.err)
+		}
+		if w.Code != tt.status {
+			t.Errorf(testErrorFormat, w.Code, tt.status)
+		}
+		if got := w.Header().Get("Content-Type"); got != tt.contentType {
+			t.Errorf(testErrorFormat, got, tt.contentType)
+		}
+		if got := w.Header().Get(HeaderVary); got != HeaderAccept {
+			t.Errorf(testErrorFormat, got, HeaderAccept)
+		}
+		if got := w.Body.String(); got != tt.body {
+			t.Errorf(testErrorFormat, got, tt.body)
+		}
+		if tt.err == nil && w.Header().Get("Content-Length") != strconv.Itoa(len(tt.body)) {
+			t.Errorf(testErrorFormat, w.Header().Get("Content-Length"), len(tt.body))
+		}
+	}
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	if err := WriteNegotiated(w, r, http.StatusOK, nil, nil); err != ErrNotAcceptable {
+		t.Errorf(testErrorFormat, err, ErrNotAcceptable)
+	}
+	// a forced media type is only written if it's a key
+	forced := []struct {
+		mediaType   string
+		opts        []Option
+		status      int
+		contentType string
+		err         error
+	}{
+		{"text/HTML", nil, http.StatusOK, "text/html", nil},
+		{"application/xml", nil, http.StatusOK, "application/json", nil},
+		{"application/xml", []Option{WithFallback(FallbackNotAcceptable)}, http.StatusNotAcceptable, "text/plain; charset=utf-8", ErrNotAcceptable},
+	}
+	for _, tt := range forced {
+		n := New(http.Header{HeaderAccept: {"application/xml"}})
+		ForceResult(n, Result{MediaType: tt.mediaType, MediaTypeMatch: MatchExact})
+		w = httptest.NewRecorder()
+		r = WithNegotiator(httptest.NewRequest(http.MethodGet, "/", nil), n)
+		if err := WriteNegotiated(w, r, http.StatusOK, variants, order, tt.opts...); err != tt.err {
+			t.Errorf(testErrorFormat, err, tt.err)
+		}
+		if w.Code != tt.status {
+			t.Errorf(testErrorFormat, w.Code, tt.status)
+		}
+		if got := w.Header().Get("Content-Type"); got != tt.contentType {
+			t.Errorf(testErrorFormat, got, tt.contentType)
+		}
+		if w.Code == http.StatusOK && w.Body.String() != string(variants[tt.contentType]) {
+			t.Errorf(testErrorFormat, w.Body.String(), string(variants[tt.contentType]))
+		}
+	}
+
+	// the body of a padded key is found by the index of the winner
+	padded := map[string][]byte{" text/html": []byte("<p>hi</p>"), "application/json\t": []byte("{}")}
+	for accept, expected := range map[string]string{"text/html": "<p>hi</p>", "application/json": "{}"} {
+		w = httptest.NewRecorder()
+		r = httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set(HeaderAccept, accept)
+		if err := WriteNegotiated(w, r, http.StatusOK, padded, nil); err != nil {
+			t.Errorf(testErrorFormat, err, nil)
+		}
+		if got := w.Body.String(); got != expected {
+			t.Errorf(testErrorFormat, got, expected)
+		}
+		if got := w.Header().Get("Content-Type"); got != accept {
+			t.Errorf(testErrorFormat, got, accept)
+		}
+	}
+}
+
+func TestAddVary(t *testing.T) {
+	tests := []struct {
+		h        http.Header
+		fields   []string
+		expected []string
+	}{
+		{http.Header{}, []string{"Accept"}, []string{"Accept"}},
+		{http.Header{HeaderVary: {"accept, Origin"}}, []string{"Accept", "Accept-Language"}, []string{"accept, Origin", "Accept-Language"}},
+		{http.Header{}, []string{"Accept", "Accept"}, []string{"Accept"}},
+	}
+	for _, tt := range tests {
+		addVary(tt.h, tt.fields...)
+		if got := tt.h[HeaderVary]; !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf(testErrorFormat, got, tt.expected)
+		}
+	}
+}
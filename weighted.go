@@ -0,0 +1,164 @@
+// Copyright 2020 Guoyao Wu. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package negotiator
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// MatchKind tells how specifically the header asked for a value, whether the
+// client named it or a wildcard admitted it.
+type MatchKind int
+
+const (
+	// MatchNone means that the value wasn't matched against the header.
+	MatchNone MatchKind = iota
+	// MatchExact means that a range named the value, e.g. text/html or en-US.
+	MatchExact
+	// MatchPartial means that a range named part of the value, see
+	// MatchSubtypeWildcard and MatchPrefix.
+	MatchPartial
+	// MatchFullWildcard means that only a full wildcard, */* or *, admitted
+	// the value.
+	MatchFullWildcard
+)
+
+const (
+	// MatchSubtypeWildcard means that a media range like text/*, or a suffix
+	// range like application/*+json, admitted the media type.
+	MatchSubtypeWildcard = MatchPartial
+	// MatchPrefix means that a language range and the language are a prefix
+	// of each other, e.g. en and en-US.
+	MatchPrefix = MatchPartial
+)
+
+var matchKindNames = []string{"none", "exact", "partial", "wildcard"}
+
+func (k MatchKind) String() string {
+	if k < 0 || int(k) >= len(matchKindNames) {
+		return "MatchKind(" + strconv.Itoa(int(k)) + ")"
+	}
+	return matchKindNames[k]
+}
+
+// MarshalText encodes the kind by its name.
+func (k MatchKind) MarshalText() ([]byte, error) {
+	return []byte(k.String()), nil
+}
+
+// UnmarshalText decodes the kind from its name.
+func (k *MatchKind) UnmarshalText(text []byte) error {
+	for i, name := range matchKindNames {
+		if name == string(text) {
+			*k = MatchKind(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("negotiator: unknown match kind %q", text)
+}
+
+// Weighted is a preferred value with its quality and how it matched the
+// header. Implicit is set for a value admitted by a range which the client
+// didn't send, i.e. the identity encoding.
+//
+// The weighted results are meant for diagnostics and for callers which need
+// the qualities, they allocate per call, though the Append variants, e.g.
+// AppendWeightedMediaTypes, reuse the result slice. On hot paths, prefer the
+// single value methods of Negotiator, e.g. MediaType, which don't allocate
+// for common headers, and Negotiator.Negotiate.
+type Weighted struct {
+	Value    string    `json:"value"`
+	Quality  float64   `json:"q"`
+	Match    MatchKind `json:"match"`
+	Implicit bool      `json:"implicit,omitempty"`
+}
+
+// Get the match kind of the specificity bits of a media type: 8 for the type,
+// 4 for the subtype, 2 for the suffix and 1 for the parameters.
+func mediaTypeMatchKind(s int) MatchKind {
+	switch {
+	case s&12 == 12:
+		return MatchExact
+	case s&14 != 0:
+		return MatchSubtypeWildcard
+	}
+	return MatchFullWildcard
+}
+
+// Get the match kind of the specificity bits of a language: 4 for the full
+// tag, 2 or 1 if the range or the language is a prefix of the other.
+func languageMatchKind(s int) MatchKind {
+	switch {
+	case s&4 != 0:
+		return MatchExact
+	case s&3 != 0:
+		return MatchPrefix
+	}
+	return MatchFullWildcard
+}
+
+// Get the match kind of the specificity bits of a charset: 1 for the charset.
+func charsetMatchKind(s int) MatchKind {
+	if s&1 != 0 {
+		return MatchExact
+	}
+	return MatchFullWildcard
+}
+
+// Get the match kind of the specificity bits of an encoding: 1 for the
+// encoding.
+func encodingMatchKind(s int) MatchKind {
+	return charsetMatchKind(s)
+}
+
+// Sort the acceptable priorities by preference.
+func (ss specificities) sorted() specificities {
+	return ss.sortedBy(compareSpecs)
+}
+
+// Sort the acceptable priorities with a comparator.
+func (ss specificities) sortedBy(by specificityBy) specificities {
+	filtered := ss.filter(isSpecificityQuality)
+	by.sort(filtered)
+	return filtered
+}
+
+// Keep the priorities whose quality reaches minQuality.
+func (ss specificities) reaching(minQuality float64) specificities {
+	return ss.filter(func(s specificity) bool {
+		return s.q.float() >= minQuality
+	})
+}
+
+// Map the priorities to the provided values they were computed for.
+func (ss specificities) values(provided []string) []string {
+	results := make([]string, len(ss), len(ss))
+	for i, v := range ss {
+		results[i] = provided[v.i]
+	}
+	return results
+}
+
+// Append the provided values the priorities were computed for with their
+// quality and match kind to dst.
+func (ss specificities) appendWeighted(dst []Weighted, provided []string, kind func(s int) MatchKind) []Weighted {
+	dst = growWeighted(dst, len(ss))
+	for _, v := range ss {
+		dst = append(dst, Weighted{Value: provided[v.i], Quality: v.q.float(), Match: kind(v.s)})
+	}
+	return dst
+}
+
+// Grow dst to have room for n more values, so appending them allocates once
+// at most. The result isn't nil, even without values.
+func growWeighted(dst []Weighted, n int) []Weighted {
+	if dst != nil && cap(dst)-len(dst) >= n {
+		return dst
+	}
+	grown := make([]Weighted, len(dst), len(dst)+n)
+	copy(grown, dst)
+	return grown
+}
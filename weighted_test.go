@@ -0,0 +1,220 @@
+// Copyright 2020 Guoyao Wu. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package negotiator
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestMatchKindBits(t *testing.T) {
+	tests := []struct {
+		kind     func(s int) MatchKind
+		s        int
+		expected MatchKind
+	}{
+		{mediaTypeMatchKind, 13, MatchExact},
+		{mediaTypeMatchKind, 12, MatchExact},
+		{mediaTypeMatchKind, 11, MatchSubtypeWildcard},
+		{mediaTypeMatchKind, 10, MatchSubtypeWildcard},
+		{mediaTypeMatchKind, 9, MatchSubtypeWildcard},
+		{mediaTypeMatchKind, 8, MatchSubtypeWildcard},
+		{mediaTypeMatchKind, 4, MatchSubtypeWildcard},
+		{mediaTypeMatchKind, 2, MatchSubtypeWildcard},
+		{mediaTypeMatchKind, 1, MatchFullWildcard},
+		{mediaTypeMatchKind, 0, MatchFullWildcard},
+		{languageMatchKind, 4, MatchExact},
+		{languageMatchKind, 2, MatchPrefix},
+		{languageMatchKind, 1, MatchPrefix},
+		{languageMatchKind, 0, MatchFullWildcard},
+		{charsetMatchKind, 1, MatchExact},
+		{charsetMatchKind, 0, MatchFullWildcard},
+		{encodingMatchKind, 1, MatchExact},
+		{encodingMatchKind, 0, MatchFullWildcard},
+	}
+	for _, tt := range tests {
+		if got := tt.kind(tt.s); got != tt.expected {
+			t.Errorf(testErrorFormat, got, tt.expected)
+		}
+	}
+}
+
+func TestMatchKind_Text(t *testing.T) {
+	for _, k := range []MatchKind{MatchNone, MatchExact, MatchPartial, MatchFullWildcard} {
+		text, _ := k.MarshalText()
+		var got MatchKind
+		if err := got.UnmarshalText(text); err != nil || got != k {
+			t.Errorf(testErrorFormat, got, k)
+		}
+	}
+	if got := MatchKind(9).String(); got != "MatchKind(9)" {
+		t.Errorf(testErrorFormat, got, "MatchKind(9)")
+	}
+}
+
+func TestWeighted(t *testing.T) {
+	tests := []struct {
+		weighted func(accept string, provided ...string) []Weighted
+		accept   string
+		provided []string
+		expected []Weighted
+	}{
+		{
+			WeightedMediaTypes,
+			"*/*;q=0.1, text/*;q=0.8, text/html",
+			[]string{"image/png", "text/plain", "text/html"},
+			[]Weighted{{"text/html", 1, MatchExact, false}, {"text/plain", .8, MatchSubtypeWildcard, false}, {"image/png", .1, MatchFullWildcard, false}},
+		},
+		{
+			WeightedMediaTypes,
+			"text/html;q=0.5, application/json",
+			nil,
+			[]Weighted{{"application/json", 1, MatchNone, false}, {"text/html", .5, MatchNone, false}},
+		},
+		{
+			WeightedLanguages,
+			"*;q=0.1, fr;q=0.8, en-US",
+			[]string{"de", "fr-CA", "en"},
+			[]Weighted{{"en", 1, MatchPrefix, false}, {"fr-CA", .8, MatchPrefix, false}, {"de", .1, MatchFullWildcard, false}},
+		},
+		{
+			WeightedLanguages,
+			"en-US, *;q=0.2, de;q=0",
+			[]string{"ja", "de-CH", "en", "en-US-x-a"},
+			[]Weighted{{"en", 1, MatchPrefix, false}, {"en-US-x-a", 1, MatchPrefix, false}, {"ja", .2, MatchFullWildcard, false}},
+		},
+		{
+			PreferredLanguagesWithQuality,
+			"fr-CA, *;q=0.2",
+			[]string{"de", "fr"},
+			[]Weighted{{"fr", 1, MatchPrefix, false}, {"de", .2, MatchFullWildcard, false}},
+		},
+		{
+			WeightedCharsets,
+			"*;q=0.5, utf-8",
+			[]string{"iso-8859-1", "utf-8"},
+			[]Weighted{{"utf-8", 1, MatchExact, false}, {"iso-8859-1", .5, MatchFullWildcard, false}},
+		},
+		{
+			WeightedEncodings,
+			"gzip;q=0.8",
+			[]string{"gzip", "br", "identity"},
+			[]Weighted{{"gzip", .8, MatchExact, false}, {"identity", .8, MatchExact, true}},
+		},
+		{
+			WeightedEncodings,
+			"gzip, identity;q=0.5",
+			[]string{"identity", "gzip"},
+			[]Weighted{{"gzip", 1, MatchExact, false}, {"identity", .5, MatchExact, false}},
+		},
+		{
+			WeightedEncodings,
+			"br;q=0.5, , gzip",
+			nil,
+			[]Weighted{{"gzip", 1, MatchNone, false}, {"br", .5, MatchNone, false}, {"identity", .5, MatchNone, true}},
+		},
+	}
+	for _, tt := range tests {
+		if got := tt.weighted(tt.accept, tt.provided...); !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf(testErrorFormat, got, tt.expected)
+		}
+	}
+}
+
+func TestWeighted_JSON(t *testing.T) {
+	got, _ := json.Marshal(Weighted{"text/html", .5, MatchSubtypeWildcard, false})
+	expected := `{"value":"text/html","q":0.5,"match":"partial"}`
+	if string(got) != expected {
+		t.Errorf(testErrorFormat, string(got), expected)
+	}
+}
+
+func TestNegotiator_NegotiateMatch(t *testing.T) {
+	n := New(http.Header{HeaderAccept: {"text/*"}, HeaderAcceptLanguage: {"en"}})
+	expected := Result{
+		MediaType: "text/plain", Language: "en-GB", Encoding: "gzip",
+		MediaTypeMatch: MatchSubtypeWildcard, LanguageMatch: MatchPrefix, EncodingMatch: MatchFullWildcard,
+	}
+	got := n.Negotiate(Offers{
+		MediaTypes: []string{"application/json", "text/plain"},
+		Languages:  []string{"en-GB"},
+		Encodings:  []string{"gzip"},
+	})
+	if got != expected {
+		t.Errorf(testErrorFormat, got, expected)
+	}
+}
+
+func TestAppendWeighted(t *testing.T) {
+	tests := []struct {
+		append   func(dst []Weighted, accept string, provided ...string) []Weighted
+		weighted func(accept string, provided ...string) []Weighted
+		accept   string
+		provided []string
+	}{
+		{AppendWeightedMediaTypes, WeightedMediaTypes, "*/*;q=0.1, text/*;q=0.8, text/html", []string{"image/png", "text/plain", "text/html"}},
+		{AppendWeightedMediaTypes, WeightedMediaTypes, "text/html;q=0.5, application/json", nil},
+		{AppendWeightedLanguages, WeightedLanguages, "*;q=0.1, fr;q=0.8, en-US", []string{"de", "fr-CA", "en"}},
+		{AppendWeightedCharsets, WeightedCharsets, "*;q=0.5, utf-8", []string{"iso-8859-1", "utf-8"}},
+		{AppendWeightedEncodings, WeightedEncodings, "gzip;q=0.8", []string{"gzip", "br", "identity"}},
+		{AppendWeightedEncodings, WeightedEncodings, "br;q=0.5, gzip", nil},
+	}
+	for _, tt := range tests {
+		expected := tt.weighted(tt.accept, tt.provided...)
+		prefix := []Weighted{{"x", 1, MatchExact, false}}
+		dst := make([]Weighted, 1, 16)
+		copy(dst, prefix)
+		got := tt.append(dst, tt.accept, tt.provided...)
+		if !reflect.DeepEqual(got, append(prefix, expected...)) {
+			t.Errorf(testErrorFormat, got, append(prefix, expected...))
+		}
+		if &got[0] != &dst[0] {
+			t.Errorf("%q: the destination wasn't reused", tt.accept)
+		}
+	}
+}
+
+func BenchmarkWeightedMediaTypes(b *testing.B) {
+	accept := "text/html,application/xhtml+xml,application/xml;q=0.9,image/avif,image/webp,*/*;q=0.8"
+	for _, count := range []int{10, 50, 200} {
+		offers := manyMediaTypeOffers(count)
+		b.Run(strconv.Itoa(count)+"/Weighted", func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				WeightedMediaTypes(accept, offers...)
+			}
+		})
+		b.Run(strconv.Itoa(count)+"/Append", func(b *testing.B) {
+			b.ReportAllocs()
+			dst := make([]Weighted, 0, count)
+			for i := 0; i < b.N; i++ {
+				dst = AppendWeightedMediaTypes(dst[:0], accept, offers...)
+			}
+		})
+	}
+}
+
+func TestPreferred_DuplicateOffers(t *testing.T) {
+	tests := []struct {
+		preferred func(accept string, provided ...string) []string
+		accept    string
+		provided  []string
+		expected  []string
+	}{
+		{PreferredMediaTypes, "text/html", []string{"text/html", "text/html"}, []string{"text/html", "text/html"}},
+		{PreferredMediaTypes, "text/*, text/plain", []string{"text/html", "TEXT/html", "text/plain", "text/html"}, []string{"text/plain", "text/html", "TEXT/html", "text/html"}},
+		{PreferredCharsets, "*, iso-8859-1", []string{"utf-8", "iso-8859-1", "UTF-8", "utf-8"}, []string{"iso-8859-1", "utf-8", "UTF-8", "utf-8"}},
+		{PreferredLanguages, "en, de;q=0.5", []string{"de", "EN", "en-US", "en"}, []string{"EN", "en", "en-US", "de"}},
+		{PreferredEncodings, "gzip;q=0.5, br", []string{"gzip", "BR", "gzip", "br"}, []string{"BR", "br", "gzip", "gzip"}},
+	}
+	for _, tt := range tests {
+		if got := tt.preferred(tt.accept, tt.provided...); !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf(testErrorFormat, got, tt.expected)
+		}
+	}
+}